@@ -11,13 +11,16 @@ import (
 
 // Config holds all application configuration
 type Config struct {
-	Server   ServerConfig
-	Security SecurityConfig
-	Database DatabaseConfig
-	Logging  LoggingConfig
-	UI       UIConfig
-	Limits   LimitsConfig
-	Features FeaturesConfig
+	Server     ServerConfig
+	Security   SecurityConfig
+	Database   DatabaseConfig
+	Logging    LoggingConfig
+	UI         UIConfig
+	Limits     LimitsConfig
+	Features   FeaturesConfig
+	Supervisor SupervisorConfig
+	Ports      PortsConfig
+	Webhooks   WebhooksConfig
 }
 
 type ServerConfig struct {
@@ -30,14 +33,18 @@ type ServerConfig struct {
 type SecurityConfig struct {
 	SessionCookieName     string
 	SessionDurationHours  int
+	SessionIdleMinutes    int  // Sessions unused for this long expire before SessionDurationHours
+	PersistSessions       bool // Keep sessions in the database so they survive restarts
 	SecretKey             string
 	HTTPSEnabled          bool
 	CSRFEnabled           bool
+	RequireAdmin2FA       bool // Administrators must enroll in two-factor authentication
 }
 
 type DatabaseConfig struct {
-	Type     string
-	DataFile string
+	Type                 string
+	DataFile             string
+	ProviderDeletePolicy string // "restrict" or "cascade"
 }
 
 type LoggingConfig struct {
@@ -58,6 +65,28 @@ type LimitsConfig struct {
 	MaxUploadSizeMB       int
 }
 
+// SupervisorConfig configures the processes run for started channels
+type SupervisorConfig struct {
+	Command                string // Command run for each channel, with {placeholders} for its settings
+	LogDir                 string // Directory of the output logs of the channel processes
+	RestartDelaySeconds    int    // Delay before restarting a crashed process, doubled after each crash
+	MaxRestartDelaySeconds int    // Upper bound of the restart delay
+	StopTimeoutSeconds     int    // Time a process has to exit after SIGTERM before it is killed
+}
+
+// PortsConfig configures the range of the remux ports given to channels
+type PortsConfig struct {
+	RemuxPortMin int // First port of the range
+	RemuxPortMax int // Last port of the range, included
+}
+
+// WebhooksConfig configures the delivery of events to webhooks
+type WebhooksConfig struct {
+	TimeoutSeconds    int // Time a webhook has to answer each attempt
+	MaxAttempts       int // Attempts at delivering an event before giving up
+	RetryDelaySeconds int // Delay before the first retry, doubled before each of the next ones
+}
+
 type FeaturesConfig struct {
 	UserManagement     bool
 	ProviderManagement bool
@@ -80,13 +109,17 @@ func LoadConfig(filename string) (*Config, error) {
 		Security: SecurityConfig{
 			SessionCookieName:    "fuzzy_session",
 			SessionDurationHours: 24,
+			SessionIdleMinutes:   120,
+			PersistSessions:      true,
 			SecretKey:            "changeme_in_production",
 			HTTPSEnabled:         false,
 			CSRFEnabled:          true,
+			RequireAdmin2FA:      false,
 		},
 		Database: DatabaseConfig{
-			Type:     "memory",
-			DataFile: "data/fuzzy.db",
+			Type:                 "memory",
+			DataFile:             "data/fuzzy.db",
+			ProviderDeletePolicy: "restrict",
 		},
 		Logging: LoggingConfig{
 			Level:   "info",
@@ -108,6 +141,22 @@ func LoadConfig(filename string) (*Config, error) {
 			ProviderManagement: true,
 			ChannelManagement:  true,
 		},
+		Supervisor: SupervisorConfig{
+			Command:                "",
+			LogDir:                 "logs/channels",
+			RestartDelaySeconds:    1,
+			MaxRestartDelaySeconds: 60,
+			StopTimeoutSeconds:     10,
+		},
+		Ports: PortsConfig{
+			RemuxPortMin: 8000,
+			RemuxPortMax: 8999,
+		},
+		Webhooks: WebhooksConfig{
+			TimeoutSeconds:    10,
+			MaxAttempts:       5,
+			RetryDelaySeconds: 10,
+		},
 	}
 
 	// Check if config file exists
@@ -160,6 +209,10 @@ func LoadConfig(filename string) (*Config, error) {
 	if err := scanner.Err(); err != nil {
 		return nil, fmt.Errorf("error reading config file: %v", err)
 	}
+	if config.Ports.RemuxPortMin > config.Ports.RemuxPortMax {
+		return nil, fmt.Errorf("ports.remux_port_min (%d) is greater than ports.remux_port_max (%d)",
+			config.Ports.RemuxPortMin, config.Ports.RemuxPortMax)
+	}
 
 	return config, nil
 }
@@ -196,12 +249,27 @@ func SaveConfig(config *Config, filename string) error {
 	fmt.Fprintf(file, "session_cookie_name = %s\n", config.Security.SessionCookieName)
 	fmt.Fprintln(file, "# Durée de session en heures / Session duration in hours")
 	fmt.Fprintf(file, "session_duration_hours = %d\n", config.Security.SessionDurationHours)
+	fmt.Fprintln(file, "# Expiration après inactivité en minutes / Idle timeout in minutes")
+	fmt.Fprintf(file, "session_idle_minutes = %d\n", config.Security.SessionIdleMinutes)
+	fmt.Fprintln(file, "# Conserver les sessions en base / Keep sessions in the database across restarts (true/false)")
+	fmt.Fprintf(file, "persist_sessions = %t\n", config.Security.PersistSessions)
 	fmt.Fprintln(file, "# Clé secrète pour la sécurité / Secret key for security")
 	fmt.Fprintf(file, "secret_key = %s\n", config.Security.SecretKey)
 	fmt.Fprintln(file, "# HTTPS activé / HTTPS enabled (true/false)")
 	fmt.Fprintf(file, "https_enabled = %t\n", config.Security.HTTPSEnabled)
 	fmt.Fprintln(file, "# CSRF protection activé / CSRF protection enabled (true/false)")
 	fmt.Fprintf(file, "csrf_enabled = %t\n", config.Security.CSRFEnabled)
+	fmt.Fprintln(file, "# 2FA obligatoire pour les administrateurs / Two-factor authentication required for administrators (true/false)")
+	fmt.Fprintf(file, "require_admin_2fa = %t\n", config.Security.RequireAdmin2FA)
+	fmt.Fprintln(file, "")
+
+	// Supervisor section, so a fresh install shows how to make channels startable
+	fmt.Fprintln(file, "[supervisor]")
+	fmt.Fprintln(file, "# Commande lancée pour chaque chaîne démarrée / Command run for each started channel")
+	fmt.Fprintln(file, "# Vide = démarrage impossible / Empty = channels cannot be started; see config.example.cfg for the placeholders")
+	fmt.Fprintln(file, "# Flux non chiffrés avec ffmpeg / Unencrypted streams with ffmpeg:")
+	fmt.Fprintln(file, "# command = ffmpeg -hide_banner -loglevel warning -i {manifest} -c copy -f mpegts -listen 1 http://127.0.0.1:{port}")
+	fmt.Fprintf(file, "command = %s\n", config.Supervisor.Command)
 	fmt.Fprintln(file, "")
 
 	// Add other sections...
@@ -225,6 +293,12 @@ func setConfigValue(config *Config, section, key, value string) error {
 		return setLimitsConfig(&config.Limits, key, value)
 	case "features":
 		return setFeaturesConfig(&config.Features, key, value)
+	case "supervisor":
+		return setSupervisorConfig(&config.Supervisor, key, value)
+	case "ports":
+		return setPortsConfig(&config.Ports, key, value)
+	case "webhooks":
+		return setWebhooksConfig(&config.Webhooks, key, value)
 	}
 	return nil
 }
@@ -261,6 +335,18 @@ func setSecurityConfig(config *SecurityConfig, key, value string) error {
 			return err
 		}
 		config.SessionDurationHours = hours
+	case "session_idle_minutes":
+		minutes, err := strconv.Atoi(value)
+		if err != nil {
+			return err
+		}
+		config.SessionIdleMinutes = minutes
+	case "persist_sessions":
+		enabled, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		config.PersistSessions = enabled
 	case "secret_key":
 		config.SecretKey = value
 	case "https_enabled":
@@ -275,6 +361,12 @@ func setSecurityConfig(config *SecurityConfig, key, value string) error {
 			return err
 		}
 		config.CSRFEnabled = enabled
+	case "require_admin_2fa":
+		required, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		config.RequireAdmin2FA = required
 	}
 	return nil
 }
@@ -285,6 +377,11 @@ func setDatabaseConfig(config *DatabaseConfig, key, value string) error {
 		config.Type = value
 	case "data_file":
 		config.DataFile = value
+	case "provider_delete_policy":
+		if value != "restrict" && value != "cascade" {
+			return fmt.Errorf("must be restrict or cascade")
+		}
+		config.ProviderDeletePolicy = value
 	}
 	return nil
 }
@@ -369,11 +466,115 @@ func setFeaturesConfig(config *FeaturesConfig, key, value string) error {
 	return nil
 }
 
+func setSupervisorConfig(config *SupervisorConfig, key, value string) error {
+	switch key {
+	case "command":
+		config.Command = value
+	case "log_dir":
+		config.LogDir = value
+	case "restart_delay_seconds":
+		seconds, err := positiveInt(value)
+		if err != nil {
+			return err
+		}
+		config.RestartDelaySeconds = seconds
+	case "max_restart_delay_seconds":
+		seconds, err := positiveInt(value)
+		if err != nil {
+			return err
+		}
+		config.MaxRestartDelaySeconds = seconds
+	case "stop_timeout_seconds":
+		seconds, err := positiveInt(value)
+		if err != nil {
+			return err
+		}
+		config.StopTimeoutSeconds = seconds
+	}
+	return nil
+}
+
+func setPortsConfig(config *PortsConfig, key, value string) error {
+	switch key {
+	case "remux_port_min":
+		port, err := portNumber(value)
+		if err != nil {
+			return err
+		}
+		config.RemuxPortMin = port
+	case "remux_port_max":
+		port, err := portNumber(value)
+		if err != nil {
+			return err
+		}
+		config.RemuxPortMax = port
+	}
+	return nil
+}
+
+func setWebhooksConfig(config *WebhooksConfig, key, value string) error {
+	switch key {
+	case "timeout_seconds":
+		seconds, err := positiveInt(value)
+		if err != nil {
+			return err
+		}
+		config.TimeoutSeconds = seconds
+	case "max_attempts":
+		attempts, err := positiveInt(value)
+		if err != nil {
+			return err
+		}
+		config.MaxAttempts = attempts
+	case "retry_delay_seconds":
+		seconds, err := positiveInt(value)
+		if err != nil {
+			return err
+		}
+		config.RetryDelaySeconds = seconds
+	}
+	return nil
+}
+
+// portNumber parses a setting that must be a TCP port
+func portNumber(value string) (int, error) {
+	port, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, err
+	}
+	if port < 1 || port > 65535 {
+		return 0, fmt.Errorf("must be a port between 1 and 65535")
+	}
+	return port, nil
+}
+
+// positiveInt parses a setting that must be a positive integer
+func positiveInt(value string) (int, error) {
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, err
+	}
+	if n <= 0 {
+		return 0, fmt.Errorf("must be positive")
+	}
+	return n, nil
+}
+
 // GetSessionDuration returns the session duration as time.Duration
 func (c *Config) GetSessionDuration() time.Duration {
 	return time.Duration(c.Security.SessionDurationHours) * time.Hour
 }
 
+// GetSessionIdleTimeout returns how long a session may stay unused, never
+// longer than the session duration
+func (c *Config) GetSessionIdleTimeout() time.Duration {
+	idle := time.Duration(c.Security.SessionIdleMinutes) * time.Minute
+	if idle <= 0 || idle > c.GetSessionDuration() {
+		return c.GetSessionDuration()
+	}
+	return idle
+}
+
 // GetServerAddress returns the full server address
 func (c *Config) GetServerAddress() string {
 	return fmt.Sprintf(":%d", c.Server.Port)
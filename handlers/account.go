@@ -0,0 +1,178 @@
+package handlers
+
+import (
+	"errors"
+	"html/template"
+	"log"
+	"net/http"
+
+	"fuzzy/config"
+	"fuzzy/models"
+)
+
+// AccountHandler handles the account page, where users manage their own
+// two-factor authentication
+func (h *Handler) AccountHandler(w http.ResponseWriter, r *http.Request) {
+	if rejectAPIToken(w, r) {
+		return
+	}
+
+	var data models.AccountPageData
+	data.Title = "Fuzzy - Account"
+
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		h.handlePostAccount(r, &data)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Show the user as saved by the action, if any
+	user, _ := currentUser(r)
+	if saved, exists := h.Store.GetUser(user.ID); exists {
+		user = saved
+	}
+	data.User = user
+	data.TwoFactorRequired = needsTwoFactorEnrollment(user)
+	if user.TOTPSecret != "" && !user.TOTPEnabled {
+		data.TOTPURI = template.URL(user.TOTPURI(config.AppConfig.Server.AppName))
+	}
+	renderAccountTemplate(w, r, &data)
+}
+
+func (h *Handler) handlePostAccount(r *http.Request, data *models.AccountPageData) {
+	if err := r.ParseForm(); err != nil {
+		data.Error = "Error parsing form data"
+		return
+	}
+
+	user, _ := currentUser(r)
+
+	switch r.FormValue("action") {
+	case "totp_begin":
+		h.handleBeginTOTP(user, data)
+	case "totp_confirm":
+		h.handleConfirmTOTP(r, user, data)
+	case "totp_disable":
+		h.handleDisableTOTP(r, user, data)
+	case "recovery_regenerate":
+		h.handleRegenerateRecoveryCodes(r, user, data)
+	default:
+		data.Error = "Invalid action"
+	}
+}
+
+// handleBeginTOTP generates a new secret for the user to add to their
+// authenticator app. It only takes effect once confirmed with a code.
+func (h *Handler) handleBeginTOTP(user models.User, data *models.AccountPageData) {
+	if user.TOTPEnabled {
+		data.Error = "Two-factor authentication is already enabled"
+		return
+	}
+
+	secret, err := models.NewTOTPSecret()
+	if err != nil {
+		log.Printf("Error generating TOTP secret: %v", err)
+		data.Error = "Failed to start two-factor authentication setup"
+		return
+	}
+	user.TOTPSecret = secret
+	h.saveAccount(user, data, "Add the key to your authenticator app, then enter the code it shows")
+}
+
+// handleConfirmTOTP enables two-factor authentication once the user proves
+// their authenticator app generates the right codes
+func (h *Handler) handleConfirmTOTP(r *http.Request, user models.User, data *models.AccountPageData) {
+	if user.TOTPEnabled || user.TOTPSecret == "" {
+		data.Error = "Start the two-factor authentication setup first"
+		return
+	}
+	if err := h.checkTwoFactorCode(user, r.FormValue("code"), false); err != nil {
+		data.Error = "Invalid authentication code. Check the time of your device and try again."
+		return
+	}
+
+	codes, err := user.SetRecoveryCodes()
+	if err != nil {
+		log.Printf("Error generating recovery codes: %v", err)
+		data.Error = "Failed to generate recovery codes"
+		return
+	}
+	user.TOTPEnabled = true
+	if h.saveAccount(user, data, "Two-factor authentication is enabled") {
+		data.RecoveryCodes = codes
+	}
+}
+
+// handleDisableTOTP turns two-factor authentication off after checking the
+// user's password
+func (h *Handler) handleDisableTOTP(r *http.Request, user models.User, data *models.AccountPageData) {
+	if !user.CheckPassword(r.FormValue("password")) {
+		data.Error = "Incorrect password"
+		return
+	}
+	user.ClearTOTP()
+	if needsTwoFactorEnrollment(user) {
+		data.Error = "Two-factor authentication is required for administrators"
+		return
+	}
+	h.saveAccount(user, data, "Two-factor authentication is disabled")
+}
+
+// handleRegenerateRecoveryCodes replaces the recovery codes after checking
+// the user's password
+func (h *Handler) handleRegenerateRecoveryCodes(r *http.Request, user models.User, data *models.AccountPageData) {
+	if !user.TOTPEnabled {
+		data.Error = "Two-factor authentication is not enabled"
+		return
+	}
+	if !user.CheckPassword(r.FormValue("password")) {
+		data.Error = "Incorrect password"
+		return
+	}
+
+	codes, err := user.SetRecoveryCodes()
+	if err != nil {
+		log.Printf("Error generating recovery codes: %v", err)
+		data.Error = "Failed to generate recovery codes"
+		return
+	}
+	if h.saveAccount(user, data, "New recovery codes generated; the previous ones no longer work") {
+		data.RecoveryCodes = codes
+	}
+}
+
+// saveAccount stores the user's changes and reports whether they were saved
+func (h *Handler) saveAccount(user models.User, data *models.AccountPageData, message string) bool {
+	err := h.Store.As(models.UserActor(user)).UpdateUser(user)
+	if err == nil {
+		data.Message = message
+		return true
+	}
+
+	var conflictErr *models.ConflictError
+	if errors.As(err, &conflictErr) {
+		data.Error = "Your account was changed in the meantime. Please try again."
+	} else {
+		log.Printf("Error updating account of user %d: %v", user.ID, err)
+		data.Error = "Failed to update your account"
+	}
+	return false
+}
+
+func renderAccountTemplate(w http.ResponseWriter, r *http.Request, data *models.AccountPageData) {
+	t, err := parseTemplate(w, r, "account.html")
+	if err != nil {
+		log.Printf("Error parsing account template: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := t.Execute(w, data); err != nil {
+		log.Printf("Error executing account template: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
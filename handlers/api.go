@@ -0,0 +1,315 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"fuzzy/models"
+	"fuzzy/validation"
+)
+
+// apiPrefix is the path below which version 1 of the JSON API is served
+const apiPrefix = "/api/v1"
+
+// apiMaxBodyBytes limits the size of JSON request bodies
+const apiMaxBodyBytes = 1 << 20
+
+// Codes of the JSON API errors, stable for clients to switch on
+const (
+	apiCodeInvalidRequest   = "invalid_request"        // Malformed body, unknown field or bad path parameter
+	apiCodeUnsupportedMedia = "unsupported_media_type" // Body is not JSON
+	apiCodeValidation       = "validation_failed"      // A field has an invalid value
+	apiCodeUnauthorized     = "unauthorized"           // Missing or invalid credentials
+	apiCodeForbidden        = "forbidden"              // Credentials lack the permission
+	apiCodeTwoFactor        = "two_factor_required"    // The account must enable two-factor authentication first
+	apiCodeSetupRequired    = "setup_required"         // No user exists yet
+	apiCodeNotFound         = "not_found"              // Unknown endpoint or entity
+	apiCodeMethodNotAllowed = "method_not_allowed"     // Endpoint does not support the method
+	apiCodeVersionConflict  = "version_conflict"       // Update based on an outdated version
+	apiCodeInvalidReference = "invalid_reference"      // Referenced entity does not exist
+	apiCodeInUse            = "in_use"                 // Entity is still referenced by others
+	apiCodeStartFailed      = "start_failed"           // The process of a channel could not be started
+	apiCodeInvalidState     = "invalid_state"          // The state of the channel does not allow the start or stop
+	apiCodeInternal         = "internal_error"
+)
+
+// apiError is the body of every JSON API error response, inside an "error" object
+type apiError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	Version int    `json:"version,omitempty"` // Current version of the entity, for version_conflict
+
+	Fields validation.Errors `json:"fields,omitempty"` // Invalid fields, for validation_failed
+}
+
+// apiRoute is one endpoint of the JSON API
+type apiRoute struct {
+	Method     string
+	Path       string            // ServeMux pattern relative to apiPrefix
+	Permission models.Permission // Required to call the endpoint
+	Handler    http.HandlerFunc
+}
+
+// apiRoutes lists every endpoint of the JSON API
+func (h *Handler) apiRoutes() []apiRoute {
+	return []apiRoute{
+		{http.MethodGet, "/channels", models.PermChannelsView, h.apiListChannels},
+		{http.MethodPost, "/channels", models.PermChannelsEdit, h.apiCreateChannel},
+		{http.MethodGet, "/channels/{id}", models.PermChannelsView, h.apiGetChannel},
+		{http.MethodPut, "/channels/{id}", models.PermChannelsEdit, h.apiUpdateChannel},
+		{http.MethodPatch, "/channels/{id}", models.PermChannelsEdit, h.apiUpdateChannel},
+		{http.MethodDelete, "/channels/{id}", models.PermChannelsEdit, h.apiDeleteChannel},
+		{http.MethodPost, "/channels/{id}/start", models.PermChannelsControl, h.apiStartChannel},
+		{http.MethodPost, "/channels/{id}/stop", models.PermChannelsControl, h.apiStopChannel},
+
+		{http.MethodGet, "/profiles", models.PermChannelsView, h.apiListProfiles},
+		{http.MethodPost, "/profiles", models.PermChannelsEdit, h.apiCreateProfile},
+		{http.MethodGet, "/profiles/{id}", models.PermChannelsView, h.apiGetProfile},
+		{http.MethodPut, "/profiles/{id}", models.PermChannelsEdit, h.apiUpdateProfile},
+		{http.MethodPatch, "/profiles/{id}", models.PermChannelsEdit, h.apiUpdateProfile},
+		{http.MethodDelete, "/profiles/{id}", models.PermChannelsEdit, h.apiDeleteProfile},
+
+		{http.MethodGet, "/providers", models.PermProvidersView, h.apiListProviders},
+		{http.MethodPost, "/providers", models.PermProvidersEdit, h.apiCreateProvider},
+		{http.MethodGet, "/providers/{id}", models.PermProvidersView, h.apiGetProvider},
+		{http.MethodPut, "/providers/{id}", models.PermProvidersEdit, h.apiUpdateProvider},
+		{http.MethodPatch, "/providers/{id}", models.PermProvidersEdit, h.apiUpdateProvider},
+		{http.MethodDelete, "/providers/{id}", models.PermProvidersEdit, h.apiDeleteProvider},
+
+		{http.MethodGet, "/bouquets", models.PermProvidersView, h.apiListBouquets},
+		{http.MethodPost, "/bouquets", models.PermProvidersEdit, h.apiCreateBouquet},
+		{http.MethodGet, "/bouquets/{id}", models.PermProvidersView, h.apiGetBouquet},
+		{http.MethodPut, "/bouquets/{id}", models.PermProvidersEdit, h.apiUpdateBouquet},
+		{http.MethodPatch, "/bouquets/{id}", models.PermProvidersEdit, h.apiUpdateBouquet},
+		{http.MethodDelete, "/bouquets/{id}", models.PermProvidersEdit, h.apiDeleteBouquet},
+		{http.MethodPut, "/bouquets/{id}/channels/{channel_id}", models.PermProvidersEdit, h.apiAddBouquetChannel},
+		{http.MethodDelete, "/bouquets/{id}/channels/{channel_id}", models.PermProvidersEdit, h.apiRemoveBouquetChannel},
+
+		{http.MethodGet, "/users", models.PermUsersManage, h.apiListUsers},
+		{http.MethodPost, "/users", models.PermUsersManage, h.apiCreateUser},
+		{http.MethodGet, "/users/{id}", models.PermUsersManage, h.apiGetUser},
+		{http.MethodPut, "/users/{id}", models.PermUsersManage, h.apiUpdateUser},
+		{http.MethodPatch, "/users/{id}", models.PermUsersManage, h.apiUpdateUser},
+		{http.MethodDelete, "/users/{id}", models.PermUsersManage, h.apiDeleteUser},
+	}
+}
+
+// APIHandler returns the handler of the JSON API and its OpenAPI document,
+// to be mounted at /api/. API requests authenticate with an API token or the
+// session cookie; errors are JSON objects, never redirects.
+func (h *Handler) APIHandler() http.Handler {
+	mux := http.NewServeMux()
+
+	// Group the routes by path, so a known path with another method gets a 405
+	var paths []string
+	byPath := make(map[string][]apiRoute)
+	for _, route := range h.apiRoutes() {
+		if _, seen := byPath[route.Path]; !seen {
+			paths = append(paths, route.Path)
+		}
+		byPath[route.Path] = append(byPath[route.Path], route)
+	}
+	for _, path := range paths {
+		mux.HandleFunc(apiPrefix+path, h.requireAPIAuth(apiDispatch(byPath[path])))
+	}
+
+	// The API description is public, so clients can fetch it before signing in
+	mux.HandleFunc("/api/openapi.json", h.OpenAPIHandler)
+
+	mux.HandleFunc("/api/", func(w http.ResponseWriter, r *http.Request) {
+		writeAPIError(w, http.StatusNotFound, apiError{Code: apiCodeNotFound, Message: "Unknown API endpoint"})
+	})
+	return mux
+}
+
+// requireAPIAuth is the JSON API counterpart of RequireSetupOrAuth
+func (h *Handler) requireAPIAuth(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !h.Store.HasUsers() {
+			writeAPIError(w, http.StatusServiceUnavailable, apiError{Code: apiCodeSetupRequired, Message: "Fuzzy has not been set up yet"})
+			return
+		}
+
+		var user models.User
+		if value := bearerToken(r); value != "" {
+			tokenUser, token, ok := h.authenticateAPIToken(value)
+			if !ok {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="fuzzy"`)
+				writeAPIError(w, http.StatusUnauthorized, apiError{Code: apiCodeUnauthorized, Message: "Invalid or expired API token"})
+				return
+			}
+			user = tokenUser
+			r = withAPIToken(r, token)
+		} else if sessionUser, authenticated := h.GetCurrentUser(r); authenticated {
+			user = sessionUser
+		} else {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="fuzzy"`)
+			writeAPIError(w, http.StatusUnauthorized, apiError{Code: apiCodeUnauthorized, Message: "Authentication required"})
+			return
+		}
+
+		if needsTwoFactorEnrollment(user) {
+			writeAPIError(w, http.StatusForbidden, apiError{Code: apiCodeTwoFactor, Message: "Two-factor authentication must be enabled for this account"})
+			return
+		}
+		next(w, withCurrentUser(r, user))
+	}
+}
+
+// apiDispatch calls the route of routes, which share a path, that matches the
+// request method, after checking its permission
+func apiDispatch(routes []apiRoute) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		allowed := make([]string, 0, len(routes))
+		for _, route := range routes {
+			if route.Method != r.Method {
+				allowed = append(allowed, route.Method)
+				continue
+			}
+			if !can(r, route.Permission) {
+				user, _ := currentUser(r)
+				log.Printf("Denied %s %s to user %d: missing permission %s", r.Method, r.URL.Path, user.ID, route.Permission)
+				writeAPIError(w, http.StatusForbidden, apiError{Code: apiCodeForbidden, Message: "Missing permission " + string(route.Permission)})
+				return
+			}
+			route.Handler(w, r)
+			return
+		}
+
+		w.Header().Set("Allow", strings.Join(allowed, ", "))
+		writeAPIError(w, http.StatusMethodNotAllowed, apiError{Code: apiCodeMethodNotAllowed, Message: "Method not allowed"})
+	}
+}
+
+// writeJSON sends v as the JSON response body
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("Error encoding API response: %v", err)
+	}
+}
+
+// writeAPIError sends a JSON API error response
+func writeAPIError(w http.ResponseWriter, status int, apiErr apiError) {
+	writeJSON(w, status, map[string]apiError{"error": apiErr})
+}
+
+// writeAPIValidationError answers 400 with the invalid fields of the body,
+// found by the validation shared with the forms
+func writeAPIValidationError(w http.ResponseWriter, errs validation.Errors) {
+	writeAPIError(w, http.StatusBadRequest, apiError{Code: apiCodeValidation, Message: errs.Error(), Fields: errs})
+}
+
+// writeAPIStoreError answers with the status and code matching an error
+// returned by the store for the given kind of entity
+func writeAPIStoreError(w http.ResponseWriter, err error, entity string) {
+	var conflictErr *models.ConflictError
+	var refErr *models.ReferenceError
+	var inUseErr *models.InUseError
+	var transitionErr *models.TransitionError
+	switch {
+	case errors.Is(err, models.ErrNotFound):
+		writeAPIError(w, http.StatusNotFound, apiError{Code: apiCodeNotFound, Message: fmt.Sprintf("%s not found", capitalize(entity))})
+	case errors.As(err, &conflictErr):
+		writeAPIError(w, http.StatusConflict, apiError{
+			Code:    apiCodeVersionConflict,
+			Message: fmt.Sprintf("This %s was changed by someone else; fetch it again and retry", conflictErr.Entity),
+			Version: conflictErr.Version,
+		})
+	case errors.As(err, &refErr):
+		msg, _ := integrityMessage(err)
+		writeAPIError(w, http.StatusUnprocessableEntity, apiError{Code: apiCodeInvalidReference, Message: msg})
+	case errors.As(err, &inUseErr):
+		msg, _ := integrityMessage(err)
+		writeAPIError(w, http.StatusConflict, apiError{Code: apiCodeInUse, Message: msg})
+	case errors.As(err, &transitionErr):
+		msg, _ := transitionMessage(err)
+		writeAPIError(w, http.StatusConflict, apiError{Code: apiCodeInvalidState, Message: msg})
+	default:
+		log.Printf("API error on %s: %v", entity, err)
+		writeAPIError(w, http.StatusInternalServerError, apiError{Code: apiCodeInternal, Message: "Internal server error"})
+	}
+}
+
+// writeAPINotFound answers 404 for a missing entity of the given kind
+func writeAPINotFound(w http.ResponseWriter, entity string) {
+	writeAPIStoreError(w, models.ErrNotFound, entity)
+}
+
+// capitalize upper-cases the first letter of an entity kind for messages
+func capitalize(s string) string {
+	if s == "" {
+		return s
+	}
+	return strings.ToUpper(s[:1]) + s[1:]
+}
+
+// apiPathID parses the integer path parameter name, answering 400 when it is
+// not a number
+func apiPathID(w http.ResponseWriter, r *http.Request, name string) (int, bool) {
+	id, err := strconv.Atoi(r.PathValue(name))
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, apiError{Code: apiCodeInvalidRequest, Message: "Invalid " + strings.ReplaceAll(name, "_", " ")})
+		return 0, false
+	}
+	return id, true
+}
+
+// decodeAPIBody decodes the JSON request body into v, rejecting unknown
+// fields. It answers the error itself and reports whether decoding worked.
+func decodeAPIBody(w http.ResponseWriter, r *http.Request, v any) bool {
+	if contentType := r.Header.Get("Content-Type"); contentType != "" {
+		mediaType, _, err := mime.ParseMediaType(contentType)
+		if err != nil || mediaType != "application/json" {
+			writeAPIError(w, http.StatusUnsupportedMediaType, apiError{Code: apiCodeUnsupportedMedia, Message: "Request body must be JSON"})
+			return false
+		}
+	}
+
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, apiMaxBodyBytes))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(v); err != nil {
+		writeAPIError(w, http.StatusBadRequest, apiError{Code: apiCodeInvalidRequest, Message: "Invalid JSON body: " + err.Error()})
+		return false
+	}
+	if _, err := decoder.Token(); err != io.EOF {
+		writeAPIError(w, http.StatusBadRequest, apiError{Code: apiCodeInvalidRequest, Message: "Invalid JSON body: unexpected data after the object"})
+		return false
+	}
+	return true
+}
+
+// decodeAPIUpdate decodes the body of a PUT or PATCH request into entity,
+// whose version is reset first so a body without one is rejected: updates
+// must name the version they are based on. PATCH changes only the fields in
+// the body, so entity must hold the stored values; PUT replaces every
+// editable field, so entity must be empty.
+func decodeAPIUpdate(w http.ResponseWriter, r *http.Request, entity any, version *int) bool {
+	*version = 0
+	if !decodeAPIBody(w, r, entity) {
+		return false
+	}
+	if *version <= 0 {
+		var errs validation.Errors
+		errs.Add("version", "The version the update is based on is required")
+		writeAPIValidationError(w, errs)
+		return false
+	}
+	return true
+}
+
+// nonNil returns an empty slice instead of nil, so lists encode as []
+func nonNil[T any](items []T) []T {
+	if items == nil {
+		return []T{}
+	}
+	return items
+}
@@ -0,0 +1,143 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"fuzzy/models"
+	"fuzzy/validation"
+)
+
+func (h *Handler) apiListChannels(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, nonNil(h.Store.GetAllChannels()))
+}
+
+func (h *Handler) apiGetChannel(w http.ResponseWriter, r *http.Request) {
+	id, ok := apiPathID(w, r, "id")
+	if !ok {
+		return
+	}
+	channel, exists := h.Store.GetChannel(id)
+	if !exists {
+		writeAPINotFound(w, "channel")
+		return
+	}
+	writeJSON(w, http.StatusOK, channel)
+}
+
+func (h *Handler) apiCreateChannel(w http.ResponseWriter, r *http.Request) {
+	var channel models.Channel
+	if !decodeAPIBody(w, r, &channel) {
+		return
+	}
+	if errs := validation.Channel(&channel, models.Channel{}); len(errs) > 0 {
+		writeAPIValidationError(w, errs)
+		return
+	}
+
+	// Channels are created stopped; use the start endpoint to run them
+	channel.State = models.ChannelStopped
+	channel.Running = false
+	channel.RemuxPort = 0
+	channel.PID = 0
+	channel.LastError = ""
+	channel.StartedAt = time.Time{}
+	channel.StoppedAt = time.Time{}
+
+	created, err := h.storeFor(r).CreateChannel(channel)
+	if err != nil {
+		writeAPIStoreError(w, err, "channel")
+		return
+	}
+	w.Header().Set("Location", apiPrefix+"/channels/"+strconv.Itoa(created.ID))
+	writeJSON(w, http.StatusCreated, created)
+}
+
+// apiUpdateChannel handles PUT and PATCH. Like the form, encoding settings
+// left empty keep their current value, unless the channel uses a profile.
+func (h *Handler) apiUpdateChannel(w http.ResponseWriter, r *http.Request) {
+	id, ok := apiPathID(w, r, "id")
+	if !ok {
+		return
+	}
+	existing, exists := h.Store.GetChannel(id)
+	if !exists {
+		writeAPINotFound(w, "channel")
+		return
+	}
+
+	channel := existing
+	if r.Method == http.MethodPut {
+		channel = models.Channel{}
+	}
+	if !decodeAPIUpdate(w, r, &channel, &channel.Version) {
+		return
+	}
+	current := existing
+	current.MediaSettings = models.ResolveSettings(h.Store, existing)
+	if errs := validation.Channel(&channel, current); len(errs) > 0 {
+		writeAPIValidationError(w, errs)
+		return
+	}
+
+	// The state of the channel only changes through start and stop
+	channel.ID = id
+	channel.Running = existing.Running
+	channel.RemuxPort = existing.RemuxPort
+	channel.CreatedAt = existing.CreatedAt
+
+	if err := h.storeFor(r).UpdateChannel(channel); err != nil {
+		writeAPIStoreError(w, err, "channel")
+		return
+	}
+	h.apiGetChannel(w, r)
+}
+
+func (h *Handler) apiDeleteChannel(w http.ResponseWriter, r *http.Request) {
+	id, ok := apiPathID(w, r, "id")
+	if !ok {
+		return
+	}
+	h.stopBeforeDelete(r, id)
+	if err := h.storeFor(r).DeleteChannel(id); err != nil {
+		writeAPIStoreError(w, err, "channel")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) apiStartChannel(w http.ResponseWriter, r *http.Request) {
+	id, ok := apiPathID(w, r, "id")
+	if !ok {
+		return
+	}
+	port, err := h.Supervisor.Start(id, actorOf(r))
+	var transitionErr *models.TransitionError
+	if errors.Is(err, models.ErrNotFound) || errors.As(err, &transitionErr) {
+		writeAPIStoreError(w, err, "channel")
+		return
+	}
+	if err != nil {
+		log.Printf("Error starting channel %d: %v", id, err)
+		writeAPIError(w, http.StatusServiceUnavailable, apiError{Code: apiCodeStartFailed, Message: startFailure(err)})
+		return
+	}
+	log.Printf("Channel %d started on port %d", id, port)
+	h.apiGetChannel(w, r)
+}
+
+func (h *Handler) apiStopChannel(w http.ResponseWriter, r *http.Request) {
+	id, ok := apiPathID(w, r, "id")
+	if !ok {
+		return
+	}
+	if err := h.Supervisor.Stop(id, actorOf(r)); err != nil {
+		writeAPIStoreError(w, err, "channel")
+		return
+	}
+	log.Printf("Channel %d stopped", id)
+	h.apiGetChannel(w, r)
+}
@@ -0,0 +1,49 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"fuzzy/models"
+	"fuzzy/supervisor"
+)
+
+// TestStartWithoutCommand checks that starting a channel without a configured
+// command says so, from the API and from the page form, and leaves it stopped
+func TestStartWithoutCommand(t *testing.T) {
+	f := newAuthFixture(t)
+	f.h.Supervisor = supervisor.New(f.h.Store, supervisor.Options{})
+	t.Cleanup(f.h.Supervisor.Close)
+	channel, err := f.h.Store.CreateChannel(models.Channel{Name: "BBC One", Manifest: "https://example.com/one.mpd", KeyKid: "key:kid", State: models.ChannelStopped})
+	if err != nil {
+		t.Fatalf("CreateChannel: %v", err)
+	}
+
+	rec := httptest.NewRecorder()
+	f.h.APIHandler().ServeHTTP(rec, f.request(http.MethodPost, "/api/v1/channels/1/start", "", "full"))
+	var body struct {
+		Error apiError `json:"error"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("decoding %s: %v", rec.Body, err)
+	}
+	if rec.Code != http.StatusServiceUnavailable || body.Error.Code != apiCodeStartFailed ||
+		!strings.HasPrefix(body.Error.Message, "Remux command not configured") {
+		t.Errorf("API start: status %d, error %+v", rec.Code, body.Error)
+	}
+
+	rec = httptest.NewRecorder()
+	form := f.request(http.MethodPost, "/channel/start", models.RoleOperator, "")
+	form.Form = map[string][]string{"channel_id": {"1"}}
+	f.h.ChannelStartHandler(rec, form)
+	if rec.Code != http.StatusServiceUnavailable || !strings.HasPrefix(rec.Body.String(), "Remux command not configured") {
+		t.Errorf("page start: status %d, %q", rec.Code, rec.Body)
+	}
+
+	if stored, _ := f.h.Store.GetChannel(channel.ID); stored.State != models.ChannelStopped {
+		t.Errorf("channel %s, want stopped", stored.State)
+	}
+}
@@ -0,0 +1,91 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+
+	"fuzzy/models"
+	"fuzzy/validation"
+)
+
+func (h *Handler) apiListProfiles(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, nonNil(h.Store.GetAllProfiles()))
+}
+
+func (h *Handler) apiGetProfile(w http.ResponseWriter, r *http.Request) {
+	id, ok := apiPathID(w, r, "id")
+	if !ok {
+		return
+	}
+	profile, exists := h.Store.GetProfile(id)
+	if !exists {
+		writeAPINotFound(w, "profile")
+		return
+	}
+	writeJSON(w, http.StatusOK, profile)
+}
+
+func (h *Handler) apiCreateProfile(w http.ResponseWriter, r *http.Request) {
+	var profile models.EncodingProfile
+	if !decodeAPIBody(w, r, &profile) {
+		return
+	}
+	if errs := validation.Profile(&profile, models.EncodingProfile{}); len(errs) > 0 {
+		writeAPIValidationError(w, errs)
+		return
+	}
+
+	created, err := h.storeFor(r).CreateProfile(profile)
+	if err != nil {
+		writeAPIStoreError(w, err, "profile")
+		return
+	}
+	w.Header().Set("Location", apiPrefix+"/profiles/"+strconv.Itoa(created.ID))
+	writeJSON(w, http.StatusCreated, created)
+}
+
+// apiUpdateProfile handles PUT and PATCH. Encoding settings left empty keep
+// their current value; the change applies to every channel using the profile.
+func (h *Handler) apiUpdateProfile(w http.ResponseWriter, r *http.Request) {
+	id, ok := apiPathID(w, r, "id")
+	if !ok {
+		return
+	}
+	existing, exists := h.Store.GetProfile(id)
+	if !exists {
+		writeAPINotFound(w, "profile")
+		return
+	}
+
+	profile := existing
+	if r.Method == http.MethodPut {
+		profile = models.EncodingProfile{}
+	}
+	if !decodeAPIUpdate(w, r, &profile, &profile.Version) {
+		return
+	}
+	if errs := validation.Profile(&profile, existing); len(errs) > 0 {
+		writeAPIValidationError(w, errs)
+		return
+	}
+	profile.ID = id
+	profile.CreatedAt = existing.CreatedAt
+
+	if err := h.storeFor(r).UpdateProfile(profile); err != nil {
+		writeAPIStoreError(w, err, "profile")
+		return
+	}
+	h.apiGetProfile(w, r)
+}
+
+func (h *Handler) apiDeleteProfile(w http.ResponseWriter, r *http.Request) {
+	id, ok := apiPathID(w, r, "id")
+	if !ok {
+		return
+	}
+	if err := h.storeFor(r).DeleteProfile(id); err != nil {
+		writeAPIStoreError(w, err, "profile")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,63 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+
+	"fuzzy/models"
+)
+
+// TestAPIProfiles checks creating, updating and deleting encoding profiles,
+// and that a profile used by a channel cannot be deleted
+func TestAPIProfiles(t *testing.T) {
+	f := newAuthFixture(t)
+
+	rec := f.apiCall(http.MethodPost, "/profiles", "full", `{"name": "HD", "video_codec": "x265", "resolution": "1080p", "video_bitrate": "8000k"}`)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("POST /profiles: status %d, %s", rec.Code, rec.Body)
+	}
+	var profile models.EncodingProfile
+	decodeAPIResponse(t, rec, &profile)
+	if profile.VideoCodec != models.VideoCodecH265 || profile.Resolution != models.Resolution1080p || profile.AudioCodec == "" || profile.AudioBitrate == 0 {
+		t.Errorf("created profile %+v, want the given settings and defaults for the others", profile.MediaSettings)
+	}
+	path := fmt.Sprintf("/profiles/%d", profile.ID)
+
+	// Settings left out of a PATCH keep their value
+	rec = f.apiCall(http.MethodPatch, path, "full", fmt.Sprintf(`{"resolution": "720p", "version": %d}`, profile.Version))
+	var updated models.EncodingProfile
+	decodeAPIResponse(t, rec, &updated)
+	if rec.Code != http.StatusOK || updated.Resolution != models.Resolution720p || updated.VideoCodec != models.VideoCodecH265 || updated.VideoBitrate != 8000*models.Kbps {
+		t.Errorf("PATCH %s: status %d, settings %+v", path, rec.Code, updated.MediaSettings)
+	}
+
+	// Channels use the profile for the settings they leave unset
+	rec = f.apiCall(http.MethodPost, "/channels", "full", fmt.Sprintf(`{"name": "BBC One", "manifest": "https://example.com/one.mpd", "key_kid": "key:kid", "profile_id": %d, "audio_codec": "AC3"}`, profile.ID))
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("POST /channels: status %d, %s", rec.Code, rec.Body)
+	}
+	var channel models.Channel
+	decodeAPIResponse(t, rec, &channel)
+	if settings := models.ResolveSettings(f.h.Store, channel); settings.AudioCodec != models.AudioCodecAC3 || settings.Resolution != models.Resolution720p {
+		t.Errorf("channel settings %+v, want AC3 over the profile", settings)
+	}
+	if rec := f.apiCall(http.MethodPost, "/channels", "full", `{"name": "BBC Two", "manifest": "https://example.com/two.mpd", "key_kid": "key:kid", "profile_id": 99}`); rec.Code != http.StatusUnprocessableEntity {
+		t.Errorf("channel with a missing profile: status %d, want %d", rec.Code, http.StatusUnprocessableEntity)
+	}
+
+	var body struct {
+		Error apiError `json:"error"`
+	}
+	rec = f.apiCall(http.MethodDelete, path, "full", "")
+	decodeAPIResponse(t, rec, &body)
+	if rec.Code != http.StatusConflict || body.Error.Code != apiCodeInUse {
+		t.Errorf("deleting a profile in use: status %d, code %q", rec.Code, body.Error.Code)
+	}
+	if rec := f.apiCall(http.MethodDelete, fmt.Sprintf("/channels/%d", channel.ID), "full", ""); rec.Code != http.StatusNoContent {
+		t.Fatalf("DELETE channel: status %d", rec.Code)
+	}
+	if rec := f.apiCall(http.MethodDelete, path, "full", ""); rec.Code != http.StatusNoContent {
+		t.Errorf("deleting an unused profile: status %d, %s", rec.Code, rec.Body)
+	}
+}
@@ -0,0 +1,217 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+
+	"fuzzy/models"
+	"fuzzy/validation"
+)
+
+func (h *Handler) apiListProviders(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, nonNil(h.Store.GetAllProviders()))
+}
+
+func (h *Handler) apiGetProvider(w http.ResponseWriter, r *http.Request) {
+	id, ok := apiPathID(w, r, "id")
+	if !ok {
+		return
+	}
+	provider, exists := h.Store.GetProvider(id)
+	if !exists {
+		writeAPINotFound(w, "provider")
+		return
+	}
+	writeJSON(w, http.StatusOK, provider)
+}
+
+func (h *Handler) apiCreateProvider(w http.ResponseWriter, r *http.Request) {
+	var provider models.Provider
+	if !decodeAPIBody(w, r, &provider) {
+		return
+	}
+	if errs := validation.Provider(&provider); len(errs) > 0 {
+		writeAPIValidationError(w, errs)
+		return
+	}
+
+	created, err := h.storeFor(r).CreateProvider(provider)
+	if err != nil {
+		writeAPIStoreError(w, err, "provider")
+		return
+	}
+	w.Header().Set("Location", apiPrefix+"/providers/"+strconv.Itoa(created.ID))
+	writeJSON(w, http.StatusCreated, created)
+}
+
+// apiUpdateProvider handles PUT and PATCH
+func (h *Handler) apiUpdateProvider(w http.ResponseWriter, r *http.Request) {
+	id, ok := apiPathID(w, r, "id")
+	if !ok {
+		return
+	}
+	existing, exists := h.Store.GetProvider(id)
+	if !exists {
+		writeAPINotFound(w, "provider")
+		return
+	}
+
+	provider := existing
+	if r.Method == http.MethodPut {
+		provider = models.Provider{}
+	}
+	if !decodeAPIUpdate(w, r, &provider, &provider.Version) {
+		return
+	}
+	if errs := validation.Provider(&provider); len(errs) > 0 {
+		writeAPIValidationError(w, errs)
+		return
+	}
+	provider.ID = id
+	provider.CreatedAt = existing.CreatedAt
+
+	if err := h.storeFor(r).UpdateProvider(provider); err != nil {
+		writeAPIStoreError(w, err, "provider")
+		return
+	}
+	h.apiGetProvider(w, r)
+}
+
+func (h *Handler) apiDeleteProvider(w http.ResponseWriter, r *http.Request) {
+	id, ok := apiPathID(w, r, "id")
+	if !ok {
+		return
+	}
+	if err := h.storeFor(r).DeleteProvider(id); err != nil {
+		writeAPIStoreError(w, err, "provider")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// apiListBouquets lists every bouquet, or those of one provider when the
+// provider_id query parameter is set
+func (h *Handler) apiListBouquets(w http.ResponseWriter, r *http.Request) {
+	providerIDStr := r.URL.Query().Get("provider_id")
+	if providerIDStr == "" {
+		writeJSON(w, http.StatusOK, nonNil(h.Store.GetAllBouquets()))
+		return
+	}
+
+	providerID, err := strconv.Atoi(providerIDStr)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, apiError{Code: apiCodeInvalidRequest, Message: "Invalid provider ID"})
+		return
+	}
+	writeJSON(w, http.StatusOK, nonNil(h.Store.GetBouquetsByProvider(providerID)))
+}
+
+func (h *Handler) apiGetBouquet(w http.ResponseWriter, r *http.Request) {
+	id, ok := apiPathID(w, r, "id")
+	if !ok {
+		return
+	}
+	bouquet, exists := h.Store.GetBouquet(id)
+	if !exists {
+		writeAPINotFound(w, "bouquet")
+		return
+	}
+	writeJSON(w, http.StatusOK, bouquet)
+}
+
+func (h *Handler) apiCreateBouquet(w http.ResponseWriter, r *http.Request) {
+	var bouquet models.Bouquet
+	if !decodeAPIBody(w, r, &bouquet) {
+		return
+	}
+	if errs := validation.Bouquet(&bouquet); len(errs) > 0 {
+		writeAPIValidationError(w, errs)
+		return
+	}
+
+	created, err := h.storeFor(r).CreateBouquet(bouquet)
+	if err != nil {
+		writeAPIStoreError(w, err, "bouquet")
+		return
+	}
+	w.Header().Set("Location", apiPrefix+"/bouquets/"+strconv.Itoa(created.ID))
+	writeJSON(w, http.StatusCreated, created)
+}
+
+// apiUpdateBouquet handles PUT and PATCH. Unlike the form, it can also move
+// the bouquet to another provider and replace its channel_ids.
+func (h *Handler) apiUpdateBouquet(w http.ResponseWriter, r *http.Request) {
+	id, ok := apiPathID(w, r, "id")
+	if !ok {
+		return
+	}
+	existing, exists := h.Store.GetBouquet(id)
+	if !exists {
+		writeAPINotFound(w, "bouquet")
+		return
+	}
+
+	bouquet := existing
+	if r.Method == http.MethodPut {
+		bouquet = models.Bouquet{}
+	}
+	if !decodeAPIUpdate(w, r, &bouquet, &bouquet.Version) {
+		return
+	}
+	if errs := validation.Bouquet(&bouquet); len(errs) > 0 {
+		writeAPIValidationError(w, errs)
+		return
+	}
+	bouquet.ID = id
+	bouquet.CreatedAt = existing.CreatedAt
+
+	if err := h.storeFor(r).UpdateBouquet(bouquet); err != nil {
+		writeAPIStoreError(w, err, "bouquet")
+		return
+	}
+	h.apiGetBouquet(w, r)
+}
+
+func (h *Handler) apiDeleteBouquet(w http.ResponseWriter, r *http.Request) {
+	id, ok := apiPathID(w, r, "id")
+	if !ok {
+		return
+	}
+	if err := h.storeFor(r).DeleteBouquet(id); err != nil {
+		writeAPIStoreError(w, err, "bouquet")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) apiAddBouquetChannel(w http.ResponseWriter, r *http.Request) {
+	id, ok := apiPathID(w, r, "id")
+	if !ok {
+		return
+	}
+	channelID, ok := apiPathID(w, r, "channel_id")
+	if !ok {
+		return
+	}
+	if err := h.storeFor(r).AddChannelToBouquet(id, channelID); err != nil {
+		writeAPIStoreError(w, err, "bouquet")
+		return
+	}
+	h.apiGetBouquet(w, r)
+}
+
+func (h *Handler) apiRemoveBouquetChannel(w http.ResponseWriter, r *http.Request) {
+	id, ok := apiPathID(w, r, "id")
+	if !ok {
+		return
+	}
+	channelID, ok := apiPathID(w, r, "channel_id")
+	if !ok {
+		return
+	}
+	if err := h.storeFor(r).RemoveChannelFromBouquet(id, channelID); err != nil {
+		writeAPIStoreError(w, err, "bouquet channel")
+		return
+	}
+	h.apiGetBouquet(w, r)
+}
@@ -0,0 +1,170 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"fuzzy/models"
+)
+
+// apiCall sends a request to the JSON API with the named API token of the
+// fixture, and a JSON body unless body is empty
+func (f *authFixture) apiCall(method, path, token, body string) *httptest.ResponseRecorder {
+	r := f.request(method, apiPrefix+path, "", token)
+	if body != "" {
+		r = httptest.NewRequest(method, apiPrefix+path, strings.NewReader(body))
+		r.Header.Set("Content-Type", "application/json")
+		r.Header.Set("Authorization", "Bearer "+f.tokens[token])
+	}
+	rec := httptest.NewRecorder()
+	f.h.APIHandler().ServeHTTP(rec, r)
+	return rec
+}
+
+// decodeAPIResponse decodes the JSON body of rec into v
+func decodeAPIResponse(t *testing.T, rec *httptest.ResponseRecorder, v any) {
+	t.Helper()
+	if err := json.Unmarshal(rec.Body.Bytes(), v); err != nil {
+		t.Fatalf("decoding %s: %v", rec.Body, err)
+	}
+}
+
+// apiEntity holds the fields shared by every entity of the API
+type apiEntity struct {
+	ID      int    `json:"id"`
+	Name    string `json:"name"`
+	Version int    `json:"version"`
+}
+
+// TestAPICRUD checks creating, reading, updating, listing and deleting each
+// kind of entity
+func TestAPICRUD(t *testing.T) {
+	f := newAuthFixture(t)
+	provider, err := f.h.Store.CreateProvider(models.Provider{Name: "BBC", Active: true})
+	if err != nil {
+		t.Fatalf("CreateProvider: %v", err)
+	}
+
+	tests := []struct {
+		path   string
+		create string
+		update string // PATCH body, formatted with the version
+		name   string // Name after the update; users are named by username
+	}{
+		{"/channels", `{"name": "BBC One", "manifest": "https://example.com/one.mpd", "key_kid": "key:kid"}`, `{"name": "BBC One HD", "version": %d}`, "BBC One HD"},
+		{"/providers", `{"name": "ITV", "url": "https://example.com"}`, `{"name": "ITV plc", "version": %d}`, "ITV plc"},
+		{"/bouquets", fmt.Sprintf(`{"name": "News", "provider_id": %d}`, provider.ID), `{"name": "News HD", "version": %d}`, "News HD"},
+		{"/users", `{"username": "alice", "password": "secret123", "role": "viewer", "active": true}`, `{"role": "operator", "version": %d}`, ""},
+	}
+	for _, test := range tests {
+		rec := f.apiCall(http.MethodPost, test.path, "full", test.create)
+		if rec.Code != http.StatusCreated {
+			t.Fatalf("POST %s: status %d, %s", test.path, rec.Code, rec.Body)
+		}
+		var created apiEntity
+		decodeAPIResponse(t, rec, &created)
+		path := fmt.Sprintf("%s/%d", test.path, created.ID)
+		if location := rec.Header().Get("Location"); location != apiPrefix+path {
+			t.Errorf("POST %s: Location %q, want %q", test.path, location, apiPrefix+path)
+		}
+
+		if rec := f.apiCall(http.MethodGet, path, "full", ""); rec.Code != http.StatusOK {
+			t.Errorf("GET %s: status %d", path, rec.Code)
+		}
+		rec = f.apiCall(http.MethodPatch, path, "full", fmt.Sprintf(test.update, created.Version))
+		var updated apiEntity
+		decodeAPIResponse(t, rec, &updated)
+		if rec.Code != http.StatusOK || updated.Name != test.name || updated.Version != created.Version+1 {
+			t.Errorf("PATCH %s: status %d, %+v; want name %q, version %d", path, rec.Code, updated, test.name, created.Version+1)
+		}
+
+		var listed []apiEntity
+		decodeAPIResponse(t, f.apiCall(http.MethodGet, test.path, "full", ""), &listed)
+		found := false
+		for _, entity := range listed {
+			found = found || entity.ID == created.ID
+		}
+		if !found {
+			t.Errorf("GET %s: %d not listed", test.path, created.ID)
+		}
+
+		if rec := f.apiCall(http.MethodDelete, path, "full", ""); rec.Code != http.StatusNoContent {
+			t.Errorf("DELETE %s: status %d, %s", path, rec.Code, rec.Body)
+		}
+		if rec := f.apiCall(http.MethodGet, path, "full", ""); rec.Code != http.StatusNotFound {
+			t.Errorf("GET %s after deleting it: status %d", path, rec.Code)
+		}
+	}
+}
+
+// TestAPIErrors checks the status and code of each kind of API error
+func TestAPIErrors(t *testing.T) {
+	f := newAuthFixture(t)
+	provider, err := f.h.Store.CreateProvider(models.Provider{Name: "BBC", Active: true})
+	if err != nil {
+		t.Fatalf("CreateProvider: %v", err)
+	}
+	if _, err := f.h.Store.CreateBouquet(models.Bouquet{Name: "News", ProviderID: provider.ID}); err != nil {
+		t.Fatalf("CreateBouquet: %v", err)
+	}
+	stale := provider.Version
+	provider.Name = "BBC Studios"
+	if err := f.h.Store.UpdateProvider(provider); err != nil {
+		t.Fatalf("UpdateProvider: %v", err)
+	}
+	providerPath := fmt.Sprintf("/providers/%d", provider.ID)
+
+	tests := []struct {
+		name         string
+		method, path string
+		token, body  string
+		status       int
+		code         string
+		field        string // Invalid field, for validation_failed
+	}{
+		{"unknown entity", http.MethodGet, "/channels/999", "full", "", http.StatusNotFound, apiCodeNotFound, ""},
+		{"deleting an unknown entity", http.MethodDelete, "/bouquets/999", "full", "", http.StatusNotFound, apiCodeNotFound, ""},
+		{"unknown endpoint", http.MethodGet, "/nothing", "full", "", http.StatusNotFound, apiCodeNotFound, ""},
+		{"invalid ID", http.MethodGet, "/channels/one", "full", "", http.StatusBadRequest, apiCodeInvalidRequest, ""},
+		{"unknown field", http.MethodPost, "/providers", "full", `{"name": "ITV", "owner": "ITV plc"}`, http.StatusBadRequest, apiCodeInvalidRequest, ""},
+		{"data after the object", http.MethodPost, "/providers", "full", `{"name": "ITV"} {}`, http.StatusBadRequest, apiCodeInvalidRequest, ""},
+		{"missing field", http.MethodPost, "/channels", "full", `{"manifest": "https://example.com/one.mpd", "key_kid": "key:kid"}`, http.StatusBadRequest, apiCodeValidation, "name"},
+		{"update without version", http.MethodPatch, providerPath, "full", `{"name": "ITV"}`, http.StatusBadRequest, apiCodeValidation, "version"},
+		{"stale version", http.MethodPatch, providerPath, "full", fmt.Sprintf(`{"name": "ITV", "version": %d}`, stale), http.StatusConflict, apiCodeVersionConflict, ""},
+		{"dangling reference", http.MethodPost, "/bouquets", "full", `{"name": "Sport", "provider_id": 999}`, http.StatusUnprocessableEntity, apiCodeInvalidReference, ""},
+		{"referenced entity", http.MethodDelete, providerPath, "full", "", http.StatusConflict, apiCodeInUse, ""},
+		{"wrong method", http.MethodPost, "/channels/1", "full", "", http.StatusMethodNotAllowed, apiCodeMethodNotAllowed, ""},
+		{"no credentials", http.MethodGet, "/channels", "", "", http.StatusUnauthorized, apiCodeUnauthorized, ""},
+		{"missing scope", http.MethodPost, "/providers", "view only", `{"name": "ITV"}`, http.StatusForbidden, apiCodeForbidden, ""},
+	}
+	for _, test := range tests {
+		rec := f.apiCall(test.method, test.path, test.token, test.body)
+		var body struct {
+			Error apiError `json:"error"`
+		}
+		decodeAPIResponse(t, rec, &body)
+		if rec.Code != test.status || body.Error.Code != test.code {
+			t.Errorf("%s: status %d, code %q; want %d, %q", test.name, rec.Code, body.Error.Code, test.status, test.code)
+		}
+		if _, invalid := body.Error.Fields.Map()[test.field]; test.field != "" && !invalid {
+			t.Errorf("%s: fields %v, want %s", test.name, body.Error.Fields, test.field)
+		}
+	}
+
+	// A conflict reports the current version, to fetch and retry
+	rec := f.apiCall(http.MethodPatch, providerPath, "full", fmt.Sprintf(`{"name": "ITV", "version": %d}`, stale))
+	var conflict struct {
+		Error apiError `json:"error"`
+	}
+	decodeAPIResponse(t, rec, &conflict)
+	if current, _ := f.h.Store.GetProvider(provider.ID); conflict.Error.Version != current.Version || current.Name != "BBC Studios" {
+		t.Errorf("conflict: version %d, stored %+v", conflict.Error.Version, current)
+	}
+	if allow := f.apiCall(http.MethodPost, "/channels/1", "full", "").Header().Get("Allow"); !strings.Contains(allow, http.MethodGet) {
+		t.Errorf("405 with Allow %q", allow)
+	}
+}
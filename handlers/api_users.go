@@ -0,0 +1,139 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+
+	"fuzzy/models"
+)
+
+// apiUserBody is a user in a request body. Unlike responses, it may carry a
+// new password.
+type apiUserBody struct {
+	models.User
+	Password string `json:"password"`
+}
+
+func (h *Handler) apiListUsers(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, nonNil(h.Store.GetAllUsers()))
+}
+
+func (h *Handler) apiGetUser(w http.ResponseWriter, r *http.Request) {
+	id, ok := apiPathID(w, r, "id")
+	if !ok {
+		return
+	}
+	user, exists := h.Store.GetUser(id)
+	if !exists {
+		writeAPINotFound(w, "user")
+		return
+	}
+	writeJSON(w, http.StatusOK, user)
+}
+
+func (h *Handler) apiCreateUser(w http.ResponseWriter, r *http.Request) {
+	var body apiUserBody
+	if !decodeAPIBody(w, r, &body) {
+		return
+	}
+
+	// Two-factor authentication is set up by the user on the account page
+	user := body.User
+	user.TOTPEnabled = false
+	errs := h.checkUser(&user)
+	if body.Password == "" {
+		errs.Add("password", "Password is required")
+	}
+	if len(errs) > 0 {
+		writeAPIValidationError(w, errs)
+		return
+	}
+	if err := user.SetPassword(body.Password); err != nil {
+		log.Printf("Error hashing password: %v", err)
+		writeAPIError(w, http.StatusInternalServerError, apiError{Code: apiCodeInternal, Message: "Error encrypting password"})
+		return
+	}
+
+	created, err := h.storeFor(r).CreateUser(user)
+	if err != nil {
+		writeAPIStoreError(w, err, "user")
+		return
+	}
+	w.Header().Set("Location", apiPrefix+"/users/"+strconv.Itoa(created.ID))
+	writeJSON(w, http.StatusCreated, created)
+}
+
+// apiUpdateUser handles PUT and PATCH. The password only changes when the
+// body sets one.
+func (h *Handler) apiUpdateUser(w http.ResponseWriter, r *http.Request) {
+	id, ok := apiPathID(w, r, "id")
+	if !ok {
+		return
+	}
+	existing, exists := h.Store.GetUser(id)
+	if !exists {
+		writeAPINotFound(w, "user")
+		return
+	}
+
+	body := apiUserBody{User: existing}
+	if r.Method == http.MethodPut {
+		body.User = models.User{}
+	}
+	if !decodeAPIUpdate(w, r, &body, &body.Version) {
+		return
+	}
+
+	// Credentials other than the password are not editable here
+	user := body.User
+	user.ID = id
+	user.Password = existing.Password
+	user.TOTPSecret = existing.TOTPSecret
+	user.TOTPEnabled = existing.TOTPEnabled
+	user.RecoveryCodes = existing.RecoveryCodes
+	user.CreatedAt = existing.CreatedAt
+
+	if errs := h.checkUser(&user); len(errs) > 0 {
+		writeAPIValidationError(w, errs)
+		return
+	}
+	if msg := checkSelfUpdate(r, user); msg != "" {
+		writeAPIError(w, http.StatusForbidden, apiError{Code: apiCodeForbidden, Message: msg})
+		return
+	}
+	if body.Password != "" {
+		if err := user.SetPassword(body.Password); err != nil {
+			log.Printf("Error hashing password: %v", err)
+			writeAPIError(w, http.StatusInternalServerError, apiError{Code: apiCodeInternal, Message: "Error encrypting password"})
+			return
+		}
+	}
+
+	if err := h.storeFor(r).UpdateUser(user); err != nil {
+		writeAPIStoreError(w, err, "user")
+		return
+	}
+	if (!user.Active && existing.Active) || body.Password != "" {
+		// A deactivated user, or one whose password was reset, is logged out everywhere
+		h.Sessions.DeleteUser(id)
+	}
+	h.apiGetUser(w, r)
+}
+
+func (h *Handler) apiDeleteUser(w http.ResponseWriter, r *http.Request) {
+	id, ok := apiPathID(w, r, "id")
+	if !ok {
+		return
+	}
+	if self, ok := currentUser(r); ok && self.ID == id {
+		writeAPIError(w, http.StatusForbidden, apiError{Code: apiCodeForbidden, Message: "You cannot delete your own account"})
+		return
+	}
+	if err := h.storeFor(r).DeleteUser(id); err != nil {
+		writeAPIStoreError(w, err, "user")
+		return
+	}
+	h.Sessions.DeleteUser(id)
+	w.WriteHeader(http.StatusNoContent)
+}
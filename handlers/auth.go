@@ -4,10 +4,8 @@ import (
 	"crypto/rand"
 	"encoding/hex"
 	"fmt"
-	"html/template"
 	"log"
 	"net/http"
-	"path/filepath"
 	"strings"
 	"time"
 
@@ -15,39 +13,60 @@ import (
 	"fuzzy/models"
 )
 
-// Session management constants and variables
+// Rate limiting variables
 var (
-	sessions = make(map[string]int) // sessionID -> userID
 	loginAttempts = make(map[string][]time.Time) // IP -> attempt times
 )
 
 // LoginHandler handles the login page and authentication
-func LoginHandler(w http.ResponseWriter, r *http.Request) {
+func (h *Handler) LoginHandler(w http.ResponseWriter, r *http.Request) {
 	switch r.Method {
 	case http.MethodGet:
-		handleGetLogin(w, r)
+		h.handleGetLogin(w, r)
 	case http.MethodPost:
-		handlePostLogin(w, r)
+		h.handlePostLogin(w, r)
 	default:
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 	}
 }
 
 // LogoutHandler handles user logout
-func LogoutHandler(w http.ResponseWriter, r *http.Request) {
+func (h *Handler) LogoutHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
-	// Get session cookie
+	// Remove session from store
+	if sessionID := currentSessionID(r); sessionID != "" {
+		h.Sessions.Delete(sessionID)
+	}
+
+	// Clear cookie and redirect to login
+	clearSessionCookie(w)
+	http.Redirect(w, r, "/login", http.StatusSeeOther)
+}
+
+// sessionCookieValue returns the session token sent by the browser, or "" if none
+func sessionCookieValue(r *http.Request) string {
 	cookie, err := r.Cookie(config.AppConfig.Security.SessionCookieName)
-	if err == nil {
-		// Remove session from store
-		delete(sessions, cookie.Value)
+	if err != nil {
+		return ""
 	}
+	return cookie.Value
+}
+
+// currentSessionID returns the ID of the session whose cookie the browser
+// sent, or "" if none
+func currentSessionID(r *http.Request) string {
+	if token := sessionCookieValue(r); token != "" {
+		return sessionIDFor(token)
+	}
+	return ""
+}
 
-	// Clear cookie
+// clearSessionCookie removes the session cookie from the browser
+func clearSessionCookie(w http.ResponseWriter) {
 	http.SetCookie(w, &http.Cookie{
 		Name:     config.AppConfig.Security.SessionCookieName,
 		Value:    "",
@@ -57,44 +76,41 @@ func LogoutHandler(w http.ResponseWriter, r *http.Request) {
 		Secure:   config.AppConfig.Security.HTTPSEnabled,
 		SameSite: http.SameSiteStrictMode,
 	})
-
-	// Redirect to login
-	http.Redirect(w, r, "/login", http.StatusSeeOther)
 }
 
 // SetupHandler handles the first-time setup page
-func SetupHandler(w http.ResponseWriter, r *http.Request) {
+func (h *Handler) SetupHandler(w http.ResponseWriter, r *http.Request) {
 	// If users already exist, redirect to login
-	if models.GlobalStore.HasUsers() {
+	if h.Store.HasUsers() {
 		http.Redirect(w, r, "/login", http.StatusSeeOther)
 		return
 	}
 
 	switch r.Method {
 	case http.MethodGet:
-		handleGetSetup(w, r)
+		h.handleGetSetup(w, r)
 	case http.MethodPost:
-		handlePostSetup(w, r)
+		h.handlePostSetup(w, r)
 	default:
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 	}
 }
 
-func handleGetLogin(w http.ResponseWriter, r *http.Request) {
+func (h *Handler) handleGetLogin(w http.ResponseWriter, r *http.Request) {
 	data := models.LoginPageData{
 		Title: "Fuzzy - Login",
 	}
 
-	renderLoginTemplate(w, &data)
+	renderLoginTemplate(w, r, &data)
 }
 
-func handlePostLogin(w http.ResponseWriter, r *http.Request) {
+func (h *Handler) handlePostLogin(w http.ResponseWriter, r *http.Request) {
 	if err := r.ParseForm(); err != nil {
 		data := models.LoginPageData{
 			Title: "Fuzzy - Login",
 			Error: "Error parsing form data",
 		}
-		renderLoginTemplate(w, &data)
+		renderLoginTemplate(w, r, &data)
 		return
 	}
 
@@ -105,7 +121,13 @@ func handlePostLogin(w http.ResponseWriter, r *http.Request) {
 			Title: "Fuzzy - Login",
 			Error: "Too many login attempts. Please wait before trying again.",
 		}
-		renderLoginTemplate(w, &data)
+		renderLoginTemplate(w, r, &data)
+		return
+	}
+
+	// Second step of a login with two-factor authentication
+	if token := r.FormValue("two_factor_token"); token != "" {
+		h.handleTwoFactorLogin(w, r, clientIP, token)
 		return
 	}
 
@@ -119,19 +141,19 @@ func handlePostLogin(w http.ResponseWriter, r *http.Request) {
 			Title: "Fuzzy - Login",
 			Error: "Username and password are required",
 		}
-		renderLoginTemplate(w, &data)
+		renderLoginTemplate(w, r, &data)
 		return
 	}
 
 	// Check user credentials
-	user, exists := models.GlobalStore.GetUserByUsername(username)
+	user, exists := h.Store.GetUserByUsername(username)
 	if !exists || !user.CheckPassword(password) {
 		recordLoginAttempt(clientIP)
 		data := models.LoginPageData{
 			Title: "Fuzzy - Login",
 			Error: "Invalid username or password",
 		}
-		renderLoginTemplate(w, &data)
+		renderLoginTemplate(w, r, &data)
 		return
 	}
 
@@ -142,21 +164,48 @@ func handlePostLogin(w http.ResponseWriter, r *http.Request) {
 			Title: "Fuzzy - Login",
 			Error: "Account is disabled",
 		}
-		renderLoginTemplate(w, &data)
+		renderLoginTemplate(w, r, &data)
+		return
+	}
+
+	// Ask for the second factor before starting the session
+	if user.TOTPEnabled {
+		data := models.LoginPageData{
+			Title:          "Fuzzy - Login",
+			TwoFactorToken: h.twoFactor.begin(user.ID),
+		}
+		renderLoginTemplate(w, r, &data)
 		return
 	}
 
 	// Successful login - clear attempts
 	clearLoginAttempts(clientIP)
+	h.startSession(w, r, user, clientIP)
+}
+
+// startSession logs the user in on this browser and redirects to the home page
+func (h *Handler) startSession(w http.ResponseWriter, r *http.Request, user models.User, clientIP string) {
+	// Replace any previous session of this browser
+	if sessionID := currentSessionID(r); sessionID != "" {
+		h.Sessions.Delete(sessionID)
+	}
 
 	// Create session
-	sessionID := generateSessionID()
-	sessions[sessionID] = user.ID
+	_, token, err := h.Sessions.Create(user.ID, clientIP, r.UserAgent())
+	if err != nil {
+		log.Printf("Error creating session for user %d: %v", user.ID, err)
+		data := models.LoginPageData{
+			Title: "Fuzzy - Login",
+			Error: "Unable to start a session. Please try again.",
+		}
+		renderLoginTemplate(w, r, &data)
+		return
+	}
 
 	// Set session cookie
 	http.SetCookie(w, &http.Cookie{
 		Name:     config.AppConfig.Security.SessionCookieName,
-		Value:    sessionID,
+		Value:    token,
 		Path:     "/",
 		MaxAge:   int(config.AppConfig.GetSessionDuration().Seconds()),
 		HttpOnly: true,
@@ -168,21 +217,21 @@ func handlePostLogin(w http.ResponseWriter, r *http.Request) {
 	http.Redirect(w, r, "/", http.StatusSeeOther)
 }
 
-func handleGetSetup(w http.ResponseWriter, r *http.Request) {
+func (h *Handler) handleGetSetup(w http.ResponseWriter, r *http.Request) {
 	data := models.SetupPageData{
 		Title: "Fuzzy - First Time Setup",
 	}
 
-	renderSetupTemplate(w, &data)
+	renderSetupTemplate(w, r, &data)
 }
 
-func handlePostSetup(w http.ResponseWriter, r *http.Request) {
+func (h *Handler) handlePostSetup(w http.ResponseWriter, r *http.Request) {
 	if err := r.ParseForm(); err != nil {
 		data := models.SetupPageData{
 			Title: "Fuzzy - First Time Setup",
 			Error: "Error parsing form data",
 		}
-		renderSetupTemplate(w, &data)
+		renderSetupTemplate(w, r, &data)
 		return
 	}
 
@@ -197,7 +246,7 @@ func handlePostSetup(w http.ResponseWriter, r *http.Request) {
 			Title: "Fuzzy - First Time Setup",
 			Error: "Username is required",
 		}
-		renderSetupTemplate(w, &data)
+		renderSetupTemplate(w, r, &data)
 		return
 	}
 
@@ -206,7 +255,7 @@ func handlePostSetup(w http.ResponseWriter, r *http.Request) {
 			Title: "Fuzzy - First Time Setup",
 			Error: "Password is required",
 		}
-		renderSetupTemplate(w, &data)
+		renderSetupTemplate(w, r, &data)
 		return
 	}
 
@@ -216,7 +265,7 @@ func handlePostSetup(w http.ResponseWriter, r *http.Request) {
 			Title: "Fuzzy - First Time Setup",
 			Error: err.Error(),
 		}
-		renderSetupTemplate(w, &data)
+		renderSetupTemplate(w, r, &data)
 		return
 	}
 
@@ -225,7 +274,7 @@ func handlePostSetup(w http.ResponseWriter, r *http.Request) {
 			Title: "Fuzzy - First Time Setup",
 			Error: "Passwords do not match",
 		}
-		renderSetupTemplate(w, &data)
+		renderSetupTemplate(w, r, &data)
 		return
 	}
 
@@ -235,7 +284,7 @@ func handlePostSetup(w http.ResponseWriter, r *http.Request) {
 		Email:     email,
 		FirstName: "",
 		LastName:  "",
-		Role:      "Administrator",
+		Role:      models.RoleAdmin,
 		Active:    true,
 	}
 
@@ -244,20 +293,27 @@ func handlePostSetup(w http.ResponseWriter, r *http.Request) {
 			Title: "Fuzzy - First Time Setup",
 			Error: "Error encrypting password",
 		}
-		renderSetupTemplate(w, &data)
+		renderSetupTemplate(w, r, &data)
 		return
 	}
 
 	// Save user
-	models.GlobalStore.CreateUser(user)
+	if _, err := h.Store.CreateUser(user); err != nil {
+		log.Printf("Error creating administrator account: %v", err)
+		data := models.SetupPageData{
+			Title: "Fuzzy - First Time Setup",
+			Error: "Error saving administrator account",
+		}
+		renderSetupTemplate(w, r, &data)
+		return
+	}
 
 	// Redirect to login with success message
 	http.Redirect(w, r, "/login?setup=complete", http.StatusSeeOther)
 }
 
-func renderLoginTemplate(w http.ResponseWriter, data *models.LoginPageData) {
-	tmplPath := filepath.Join("templates", "login.html")
-	t, err := template.ParseFiles(tmplPath)
+func renderLoginTemplate(w http.ResponseWriter, r *http.Request, data *models.LoginPageData) {
+	t, err := parseTemplate(w, r, "login.html")
 	if err != nil {
 		log.Printf("Error parsing login template: %v", err)
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
@@ -271,9 +327,8 @@ func renderLoginTemplate(w http.ResponseWriter, data *models.LoginPageData) {
 	}
 }
 
-func renderSetupTemplate(w http.ResponseWriter, data *models.SetupPageData) {
-	tmplPath := filepath.Join("templates", "setup.html")
-	t, err := template.ParseFiles(tmplPath)
+func renderSetupTemplate(w http.ResponseWriter, r *http.Request, data *models.SetupPageData) {
+	t, err := parseTemplate(w, r, "setup.html")
 	if err != nil {
 		log.Printf("Error parsing setup template: %v", err)
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
@@ -298,18 +353,21 @@ func generateSessionID() string {
 }
 
 // GetCurrentUser returns the current authenticated user
-func GetCurrentUser(r *http.Request) (models.User, bool) {
-	cookie, err := r.Cookie(config.AppConfig.Security.SessionCookieName)
-	if err != nil {
+func (h *Handler) GetCurrentUser(r *http.Request) (models.User, bool) {
+	sessionID := currentSessionID(r)
+	if sessionID == "" {
 		return models.User{}, false
 	}
 
-	userID, exists := sessions[cookie.Value]
+	session, exists := h.Sessions.Get(sessionID)
 	if !exists {
 		return models.User{}, false
 	}
 
-	user, exists := models.GlobalStore.GetUser(userID)
+	user, exists := h.Store.GetUser(session.UserID)
+	if !exists {
+		h.Sessions.Delete(session.ID) // The user was deleted
+	}
 	return user, exists
 }
 
@@ -388,7 +446,7 @@ func validatePasswordStrength(password string) error {
 		return fmt.Errorf("le mot de passe doit contenir au moins un chiffre")
 	}
 	if !hasSpecial {
-		return fmt.Errorf("le mot de passe doit contenir au moins un caractère spécial (!@#$%^&*)")
+		return fmt.Errorf("le mot de passe doit contenir au moins un caractère spécial (!@#$%%^&*)")
 	}
 
 	return nil
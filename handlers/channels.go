@@ -1,138 +1,95 @@
 package handlers
 
 import (
-	"html/template"
+	"errors"
 	"log"
 	"net/http"
-	"path/filepath"
 	"strconv"
 	"strings"
 
 	"fuzzy/models"
+	"fuzzy/validation"
 )
 
 // ChannelsHandler handles requests to the channels page for managing individual channels
-func ChannelsHandler(w http.ResponseWriter, r *http.Request) {
+func (h *Handler) ChannelsHandler(w http.ResponseWriter, r *http.Request) {
 	var data models.ChannelsPageData
 	data.Title = "Fuzzy - Channel Management"
 
 	switch r.Method {
 	case http.MethodGet:
-		handleGetChannels(w, r, &data)
+		h.handleGetChannels(w, r, &data)
 	case http.MethodPost:
-		handlePostChannels(w, r, &data)
+		if !authorize(w, r, models.PermChannelsEdit) {
+			return
+		}
+		h.handlePostChannels(w, r, &data)
 	default:
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 }
 
-func handleGetChannels(w http.ResponseWriter, r *http.Request, data *models.ChannelsPageData) {
-	// Check if this is a delete request
-	if deleteID := r.URL.Query().Get("delete"); deleteID != "" {
-		id, err := strconv.Atoi(deleteID)
-		if err != nil {
-			data.Error = "Invalid channel ID"
-		} else if models.GlobalStore.DeleteChannel(id) {
-			data.Message = "Channel deleted successfully"
-		} else {
-			data.Error = "Channel not found"
-		}
-	}
-
+func (h *Handler) handleGetChannels(w http.ResponseWriter, r *http.Request, data *models.ChannelsPageData) {
 	// Get all channels
-	data.Channels = models.GlobalStore.GetAllChannels()
+	data.Channels = h.Store.GetAllChannels()
+	data.Profiles = h.Store.GetAllProfiles()
 
 	// Render template
-	renderChannelsTemplate(w, data)
+	renderChannelsTemplate(w, r, data)
 }
 
-func handlePostChannels(w http.ResponseWriter, r *http.Request, data *models.ChannelsPageData) {
+func (h *Handler) handlePostChannels(w http.ResponseWriter, r *http.Request, data *models.ChannelsPageData) {
 	if err := r.ParseForm(); err != nil {
 		data.Error = "Failed to parse form data"
-		data.Channels = models.GlobalStore.GetAllChannels()
-		renderChannelsTemplate(w, data)
+		data.Channels = h.Store.GetAllChannels()
+		data.Profiles = h.Store.GetAllProfiles()
+		renderChannelsTemplate(w, r, data)
 		return
 	}
 
 	action := r.FormValue("action")
 	switch action {
 	case "create":
-		handleCreateChannel(r, data)
+		h.handleCreateChannel(r, data)
 	case "update":
-		handleUpdateChannel(r, data)
+		h.handleUpdateChannel(r, data)
+	case "delete":
+		h.handleDeleteChannel(r, data)
 	default:
 		data.Error = "Invalid action"
 	}
 
 	// Get updated channels list
-	data.Channels = models.GlobalStore.GetAllChannels()
-	renderChannelsTemplate(w, data)
+	data.Channels = h.Store.GetAllChannels()
+	data.Profiles = h.Store.GetAllProfiles()
+	renderChannelsTemplate(w, r, data)
 }
 
-func handleCreateChannel(r *http.Request, data *models.ChannelsPageData) {
-	name := strings.TrimSpace(r.FormValue("name"))
-	manifest := strings.TrimSpace(r.FormValue("manifest"))
-	keyKid := strings.TrimSpace(r.FormValue("key_kid"))
-	videoCodec := strings.TrimSpace(r.FormValue("video_codec"))
-	audioCodec := strings.TrimSpace(r.FormValue("audio_codec"))
-	resolution := strings.TrimSpace(r.FormValue("resolution"))
-	videoBitrate := strings.TrimSpace(r.FormValue("video_bitrate"))
-	audioBitrate := strings.TrimSpace(r.FormValue("audio_bitrate"))
-	quality := strings.TrimSpace(r.FormValue("quality"))
-	
-	// Validate input
-	if name == "" {
-		data.Error = "Channel name is required"
-		return
-	}
-	if manifest == "" {
-		data.Error = "Channel manifest URL is required"
-		return
-	}
-	if keyKid == "" {
-		data.Error = "Channel Key:Kid is required"
+func (h *Handler) handleCreateChannel(r *http.Request, data *models.ChannelsPageData) {
+	channel, errs := channelFromForm(r)
+	errs = append(errs, validation.Channel(&channel, models.Channel{})...)
+	if len(errs) > 0 {
+		data.Error = errs.Error()
+		data.FieldErrors = formErrors("create", 0, errs)
+		data.Form = channel
 		return
 	}
 
-	// Set defaults for optional fields
-	if videoCodec == "" {
-		videoCodec = "x264"
-	}
-	if audioCodec == "" {
-		audioCodec = "AAC"
-	}
-	if resolution == "" {
-		resolution = "1080p"
-	}
-	if videoBitrate == "" {
-		videoBitrate = "5000k"
-	}
-	if audioBitrate == "" {
-		audioBitrate = "128k"
-	}
-	if quality == "" {
-		quality = "Medium"
+	if _, err := h.storeFor(r).CreateChannel(channel); err != nil {
+		if msg, ok := integrityMessage(err); ok {
+			data.Error = msg
+			data.Form = channel
+			return
+		}
+		log.Printf("Error creating channel: %v", err)
+		data.Error = "Failed to create channel"
+		return
 	}
-
-	// Create channel
-	channel := models.Channel{
-		Name:         name,
-		Manifest:     manifest,
-		KeyKid:       keyKid,
-		VideoCodec:   videoCodec,
-		AudioCodec:   audioCodec,
-		Resolution:   resolution,
-		VideoBitrate: videoBitrate,
-		AudioBitrate: audioBitrate,
-		Quality:      quality,
-	}
-
-	models.GlobalStore.CreateChannel(channel)
 	data.Message = "Channel created successfully"
 }
 
-func handleUpdateChannel(r *http.Request, data *models.ChannelsPageData) {
+func (h *Handler) handleUpdateChannel(r *http.Request, data *models.ChannelsPageData) {
 	idStr := strings.TrimSpace(r.FormValue("id"))
 	id, err := strconv.Atoi(idStr)
 	if err != nil {
@@ -140,97 +97,106 @@ func handleUpdateChannel(r *http.Request, data *models.ChannelsPageData) {
 		return
 	}
 
+	version, err := strconv.Atoi(r.FormValue("version"))
+	if err != nil {
+		data.Error = "Invalid channel version"
+		return
+	}
+
 	// Get existing channel
-	existing, exists := models.GlobalStore.GetChannel(id)
+	existing, exists := h.Store.GetChannel(id)
 	if !exists {
 		data.Error = "Channel not found"
 		return
 	}
 
-	name := strings.TrimSpace(r.FormValue("name"))
-	manifest := strings.TrimSpace(r.FormValue("manifest"))
-	keyKid := strings.TrimSpace(r.FormValue("key_kid"))
-	videoCodec := strings.TrimSpace(r.FormValue("video_codec"))
-	audioCodec := strings.TrimSpace(r.FormValue("audio_codec"))
-	resolution := strings.TrimSpace(r.FormValue("resolution"))
-	videoBitrate := strings.TrimSpace(r.FormValue("video_bitrate"))
-	audioBitrate := strings.TrimSpace(r.FormValue("audio_bitrate"))
-	quality := strings.TrimSpace(r.FormValue("quality"))
-
-	// Validate input
-	if name == "" {
-		data.Error = "Channel name is required"
-		return
-	}
-	if manifest == "" {
-		data.Error = "Channel manifest URL is required"
+	// Update channel, keeping the encoding settings left empty unless they
+	// come from a profile. A channel leaving its profile keeps the settings
+	// it had from it.
+	form, errs := channelFromForm(r)
+	current := existing
+	current.MediaSettings = models.ResolveSettings(h.Store, existing)
+	errs = append(errs, validation.Channel(&form, current)...)
+	if len(errs) > 0 {
+		data.Error = errs.Error()
+		data.FieldErrors = formErrors("update", id, errs)
 		return
 	}
-	if keyKid == "" {
-		data.Error = "Channel Key:Kid is required"
-		return
+	updated := existing
+	updated.Name = form.Name
+	updated.Manifest = form.Manifest
+	updated.KeyKid = form.KeyKid
+	updated.ProfileID = form.ProfileID
+	updated.MediaSettings = form.MediaSettings
+	updated.Quality = form.Quality
+	updated.Version = version
+
+	if err := h.storeFor(r).UpdateChannel(updated); err == nil {
+		data.Message = "Channel updated successfully"
+	} else if msg, ok := conflictMessage(err); ok {
+		data.Error = msg
+		data.ConflictID = id
+	} else if msg, ok := integrityMessage(err); ok {
+		data.Error = msg
+	} else {
+		log.Printf("Error updating channel %d: %v", id, err)
+		data.Error = "Failed to update channel"
 	}
+}
 
-	// Set defaults for optional fields if empty
-	if videoCodec == "" {
-		videoCodec = existing.VideoCodec
-		if videoCodec == "" {
-			videoCodec = "x264"
-		}
-	}
-	if audioCodec == "" {
-		audioCodec = existing.AudioCodec
-		if audioCodec == "" {
-			audioCodec = "AAC"
-		}
-	}
-	if resolution == "" {
-		resolution = existing.Resolution
-		if resolution == "" {
-			resolution = "1080p"
-		}
-	}
-	if videoBitrate == "" {
-		videoBitrate = existing.VideoBitrate
-		if videoBitrate == "" {
-			videoBitrate = "5000k"
-		}
-	}
-	if audioBitrate == "" {
-		audioBitrate = existing.AudioBitrate
-		if audioBitrate == "" {
-			audioBitrate = "128k"
+// channelFromForm reads the editable fields of a channel from the channel
+// form, reporting the settings that do not parse
+func channelFromForm(r *http.Request) (models.Channel, validation.Errors) {
+	channel := models.Channel{
+		Name:     r.FormValue("name"),
+		Manifest: r.FormValue("manifest"),
+		KeyKid:   r.FormValue("key_kid"),
+		Quality:  r.FormValue("quality"),
+	}
+	settings, errs := mediaSettingsFromForm(r, "")
+	channel.MediaSettings = settings
+	if profileID := strings.TrimSpace(r.FormValue("profile_id")); profileID != "" {
+		id, err := strconv.Atoi(profileID)
+		if err != nil {
+			errs.Add("profile_id", "Invalid encoding profile")
 		}
+		channel.ProfileID = id
 	}
-	if quality == "" {
-		quality = existing.Quality
-		if quality == "" {
-			quality = "Medium"
-		}
+	return channel, errs
+}
+
+// mediaSettingsFromForm reads the encoding settings of a channel or profile
+// form, whose field names start with prefix, reporting those that do not parse
+func mediaSettingsFromForm(r *http.Request, prefix string) (models.MediaSettings, validation.Errors) {
+	settings := models.MediaSettings{
+		VideoCodec: models.VideoCodec(r.FormValue(prefix + "video_codec")),
+		AudioCodec: models.AudioCodec(r.FormValue(prefix + "audio_codec")),
 	}
+	errs := validation.Settings(&settings, r.FormValue(prefix+"resolution"),
+		r.FormValue(prefix+"video_bitrate"), r.FormValue(prefix+"audio_bitrate"))
+	return settings, errs
+}
 
-	// Update channel
-	updated := existing
-	updated.Name = name
-	updated.Manifest = manifest
-	updated.KeyKid = keyKid
-	updated.VideoCodec = videoCodec
-	updated.AudioCodec = audioCodec
-	updated.Resolution = resolution
-	updated.VideoBitrate = videoBitrate
-	updated.AudioBitrate = audioBitrate
-	updated.Quality = quality
-
-	if models.GlobalStore.UpdateChannel(updated) {
-		data.Message = "Channel updated successfully"
+// handleDeleteChannel deletes a channel, which also removes it from every bouquet
+func (h *Handler) handleDeleteChannel(r *http.Request, data *models.ChannelsPageData) {
+	id, err := strconv.Atoi(strings.TrimSpace(r.FormValue("id")))
+	if err != nil {
+		data.Error = "Invalid channel ID"
+		return
+	}
+	h.stopBeforeDelete(r, id)
+	if err := h.storeFor(r).DeleteChannel(id); err == nil {
+		data.Message = "Channel deleted successfully"
+	} else if errors.Is(err, models.ErrNotFound) {
+		data.Error = "Channel not found"
 	} else {
-		data.Error = "Failed to update channel"
+		log.Printf("Error deleting channel %d: %v", id, err)
+		data.Error = "Failed to delete channel"
 	}
 }
 
-func renderChannelsTemplate(w http.ResponseWriter, data *models.ChannelsPageData) {
-	tmplPath := filepath.Join("templates", "channels.html")
-	t, err := template.ParseFiles(tmplPath)
+func renderChannelsTemplate(w http.ResponseWriter, r *http.Request, data *models.ChannelsPageData) {
+	t, err := parseTemplate(w, r, "channels.html")
 	if err != nil {
 		log.Printf("Error parsing template: %v", err)
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
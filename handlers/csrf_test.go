@@ -0,0 +1,73 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"fuzzy/config"
+	"fuzzy/models"
+)
+
+// TestCSRFProtect checks which state-changing requests need a CSRF token
+func TestCSRFProtect(t *testing.T) {
+	f := newAuthFixture(t)
+	reached := false
+	protected := CSRFProtect(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { reached = true }))
+
+	session := f.sessions[models.RoleAdmin]
+	sessionToken := csrfTokenFor(session)
+	form := func(token string) *http.Request {
+		r := httptest.NewRequest(http.MethodPost, "/channels", strings.NewReader(url.Values{csrfFieldName: {token}}.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		r.AddCookie(&http.Cookie{Name: config.AppConfig.Security.SessionCookieName, Value: session})
+		return r
+	}
+	withHeader := func(r *http.Request, name, value string) *http.Request {
+		r.Header.Set(name, value)
+		return r
+	}
+
+	tests := []struct {
+		name    string
+		request *http.Request
+		allowed bool
+	}{
+		{"GET without token", f.request(http.MethodGet, "/channels", models.RoleAdmin, ""), true},
+		{"POST without token", f.request(http.MethodPost, "/channels", models.RoleAdmin, ""), false},
+		{"POST with form token", form(sessionToken), true},
+		{"POST with header token", withHeader(f.request(http.MethodPost, "/channels", models.RoleAdmin, ""), csrfHeaderName, sessionToken), true},
+		{"POST with wrong token", form(csrfTokenFor("another session")), false},
+		{"DELETE without token", f.request(http.MethodDelete, "/api/channels/1", models.RoleAdmin, ""), false},
+		{"POST with API token", f.request(http.MethodPost, "/api/channels", "", "full"), true},
+		{"POST with API token and session cookie", f.request(http.MethodPost, "/api/channels", models.RoleAdmin, "full"), true},
+		{"POST with Basic authorization", withHeader(f.request(http.MethodPost, "/channels", models.RoleAdmin, ""), "Authorization", "Basic YWRtaW46YWRtaW4="), false},
+		{"anonymous POST with token", func() *http.Request {
+			r := httptest.NewRequest(http.MethodPost, "/login", nil)
+			r.AddCookie(&http.Cookie{Name: csrfCookieName(), Value: "visitor"})
+			r.Header.Set(csrfHeaderName, csrfTokenFor("anonymous:visitor"))
+			return r
+		}(), true},
+		{"anonymous POST without cookie", withHeader(httptest.NewRequest(http.MethodPost, "/login", nil), csrfHeaderName, csrfTokenFor("")), false},
+	}
+	for _, test := range tests {
+		reached = false
+		rec := httptest.NewRecorder()
+		protected.ServeHTTP(rec, test.request)
+		if reached != test.allowed {
+			t.Errorf("%s: allowed %v, want %v (status %d)", test.name, reached, test.allowed, rec.Code)
+		}
+		if !test.allowed && rec.Code != http.StatusForbidden {
+			t.Errorf("%s: status %d, want %d", test.name, rec.Code, http.StatusForbidden)
+		}
+	}
+
+	config.AppConfig.Security.CSRFEnabled = false // Put back by newOpenAPITestHandler
+	reached = false
+	protected.ServeHTTP(httptest.NewRecorder(), f.request(http.MethodPost, "/channels", models.RoleAdmin, ""))
+	if !reached {
+		t.Errorf("POST without token refused with csrf_enabled off")
+	}
+}
@@ -0,0 +1,151 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"fuzzy/models"
+)
+
+// eventsKeepAlive is how often an idle event stream sends a comment, so
+// proxies do not close it, and checks that its credential is still valid.
+// Tests shorten it.
+var eventsKeepAlive = 30 * time.Second
+
+// eventsBuffer is how many events a stream may fall behind before it is
+// closed, for the page to reconnect and catch up
+const eventsBuffer = 64
+
+// streamedEntities are the entities whose changes the event stream reports
+var streamedEntities = map[models.EntityKind]bool{
+	models.EntityChannel:  true,
+	models.EntityProfile:  true,
+	models.EntityProvider: true,
+	models.EntityBouquet:  true,
+}
+
+// catalogChange is a change to the catalog as pages are told about it. The
+// entity itself is left out: pages reload to see it.
+type catalogChange struct {
+	Entity models.EntityKind  `json:"entity"`
+	Action models.EventAction `json:"action"`
+	ID     int                `json:"id"`
+}
+
+// channelStatus is the runtime state of a channel as pages show it
+type channelStatus struct {
+	ID        int                 `json:"id"`
+	State     models.ChannelState `json:"state"`
+	Label     string              `json:"label"`
+	CanStart  bool                `json:"can_start"`
+	CanStop   bool                `json:"can_stop"`
+	RemuxPort int                 `json:"remux_port"` // 0 unless the channel is started
+	PID       int                 `json:"pid"`
+	LastError string              `json:"last_error"`
+	StartedAt string              `json:"started_at"` // Formatted like the pages, empty when never
+	StoppedAt string              `json:"stopped_at"`
+}
+
+func newChannelStatus(channel models.Channel) channelStatus {
+	status := channelStatus{
+		ID:        channel.ID,
+		State:     channel.State,
+		Label:     channel.State.Label(),
+		CanStart:  channel.CanStart(),
+		CanStop:   channel.CanStop(),
+		PID:       channel.PID,
+		LastError: channel.LastError,
+		StartedAt: formatEventTime(channel.StartedAt),
+		StoppedAt: formatEventTime(channel.StoppedAt),
+	}
+	if channel.Running {
+		status.RemuxPort = channel.RemuxPort
+	}
+	return status
+}
+
+func formatEventTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format("2006-01-02 15:04:05")
+}
+
+// EventsHandler streams changes to the store as Server-Sent Events, for the
+// channels and providers pages to update in place. It first sends the status
+// of every channel as "channel" events, then a "channel" event each time a
+// channel changes state and a "change" event for every other change.
+func (h *Handler) EventsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	events, unsubscribe := h.Store.Subscribe(eventsBuffer)
+	defer unsubscribe()
+
+	// The stream outlives any write timeout of the server
+	rc := http.NewResponseController(w)
+	rc.SetWriteDeadline(time.Time{})
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no") // Disable proxy buffering
+	w.WriteHeader(http.StatusOK)
+
+	// Reconnect quickly after the stream is closed
+	fmt.Fprint(w, "retry: 3000\n\n")
+	for _, channel := range h.Store.GetAllChannels() {
+		writeEvent(w, "channel", newChannelStatus(channel))
+	}
+	if err := rc.Flush(); err != nil {
+		log.Printf("Error streaming events: %v", err)
+		return
+	}
+
+	keepAlive := time.NewTicker(eventsKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-h.streamsDone:
+			return
+		case <-keepAlive.C:
+			// Checking the credential does not count as using it, so an open
+			// page does not keep an idle session alive
+			if !h.stillAuthenticated(r) {
+				return
+			}
+			fmt.Fprint(w, ": keep-alive\n\n")
+		case event, open := <-events:
+			if !open {
+				return // Fell behind; the page reconnects and gets the current state
+			}
+			if !streamedEntities[event.Entity] {
+				continue
+			}
+			if channel, ok := event.After.(models.Channel); ok && event.Action == models.EventStateChanged {
+				writeEvent(w, "channel", newChannelStatus(channel))
+			} else {
+				writeEvent(w, "change", catalogChange{Entity: event.Entity, Action: event.Action, ID: event.ID})
+			}
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+// writeEvent writes an event of the given type with data encoded in JSON
+func writeEvent(w http.ResponseWriter, name string, data any) {
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		log.Printf("Error encoding %s event: %v", name, err)
+		return
+	}
+	fmt.Fprintf(w, "event: %s\ndata: %s\n\n", name, encoded)
+}
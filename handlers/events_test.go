@@ -0,0 +1,250 @@
+package handlers
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"fuzzy/models"
+)
+
+// pipeResponse is a response streamed through a pipe, whose reader blocks
+// the handler until it reads, like a slow client
+type pipeResponse struct {
+	header http.Header
+	writer *io.PipeWriter
+}
+
+func (p *pipeResponse) Header() http.Header         { return p.header }
+func (p *pipeResponse) Write(b []byte) (int, error) { return p.writer.Write(b) }
+func (p *pipeResponse) WriteHeader(int)             {}
+func (p *pipeResponse) Flush()                      {}
+
+// streamedEvent is an event of a stream, or a comment when name is empty
+type streamedEvent struct {
+	name, data string
+}
+
+// eventStream reads the events a handler streams
+type eventStream struct {
+	events chan streamedEvent // Closed when the handler returns
+}
+
+// openEventStream serves r with the authenticated event stream handler, in
+// the background
+func openEventStream(h *Handler, r *http.Request) *eventStream {
+	reader, writer := io.Pipe()
+	go func() {
+		h.RequireAuth(h.EventsHandler)(&pipeResponse{header: make(http.Header), writer: writer}, r)
+		writer.Close()
+	}()
+
+	stream := &eventStream{events: make(chan streamedEvent)}
+	go func() {
+		defer close(stream.events)
+		scanner := bufio.NewScanner(reader)
+		var event streamedEvent
+		for scanner.Scan() {
+			line := scanner.Text()
+			switch {
+			case line == "":
+				stream.events <- event
+				event = streamedEvent{}
+			case strings.HasPrefix(line, "event: "):
+				event.name = strings.TrimPrefix(line, "event: ")
+			case strings.HasPrefix(line, "data: "):
+				event.data = strings.TrimPrefix(line, "data: ")
+			case strings.HasPrefix(line, ":"):
+				event.data = strings.TrimSpace(strings.TrimPrefix(line, ":"))
+			}
+		}
+	}()
+	return stream
+}
+
+// next returns the next event or comment of the stream, skipping the retry
+// delay. It reports false once the stream has ended.
+func (s *eventStream) next(t *testing.T) (streamedEvent, bool) {
+	t.Helper()
+	for {
+		select {
+		case event, open := <-s.events:
+			if open && event == (streamedEvent{}) {
+				continue // The reconnection delay
+			}
+			return event, open
+		case <-time.After(5 * time.Second):
+			t.Fatalf("no event within 5s")
+		}
+	}
+}
+
+// nextEvent returns the next event, skipping keep-alive comments
+func (s *eventStream) nextEvent(t *testing.T) streamedEvent {
+	t.Helper()
+	for {
+		event, open := s.next(t)
+		if !open {
+			t.Fatalf("stream ended while waiting for an event")
+		}
+		if event.name != "" {
+			return event
+		}
+	}
+}
+
+// waitEnd fails unless the stream ends within 5s, for the given reason
+func (s *eventStream) waitEnd(t *testing.T, why string) {
+	t.Helper()
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case _, open := <-s.events:
+			if !open {
+				return
+			}
+		case <-timeout:
+			t.Fatalf("stream still open 5s after %s", why)
+		}
+	}
+}
+
+// newEventsFixture returns a fixture whose handler can close its streams,
+// with two channels
+func newEventsFixture(t *testing.T) (*authFixture, []models.Channel) {
+	t.Helper()
+	f := newAuthFixture(t)
+	f.h.streamsDone = make(chan struct{})
+	var channels []models.Channel
+	for _, name := range []string{"BBC One", "BBC Two"} {
+		channel, err := f.h.Store.CreateChannel(models.Channel{Name: name, Manifest: "https://example.com/" + name + ".mpd", KeyKid: "key:kid", State: models.ChannelStopped})
+		if err != nil {
+			t.Fatalf("CreateChannel: %v", err)
+		}
+		channels = append(channels, channel)
+	}
+	return f, channels
+}
+
+// TestEventsStream checks that a stream starts with the status of every
+// channel, then reports the changes to the catalog only, until shutdown
+func TestEventsStream(t *testing.T) {
+	f, channels := newEventsFixture(t)
+	stream := openEventStream(f.h, f.request(http.MethodGet, "/events", models.RoleViewer, ""))
+
+	snapshot := make(map[int]channelStatus)
+	for range channels {
+		event := stream.nextEvent(t)
+		var status channelStatus
+		if err := json.Unmarshal([]byte(event.data), &status); err != nil || event.name != "channel" {
+			t.Fatalf("snapshot event %s %s: %v", event.name, event.data, err)
+		}
+		snapshot[status.ID] = status
+	}
+	for _, channel := range channels {
+		if status := snapshot[channel.ID]; status.State != models.ChannelStopped || !status.CanStart {
+			t.Errorf("snapshot of channel %d: %+v", channel.ID, status)
+		}
+	}
+
+	// Users and webhooks are not streamed; the provider created after them is
+	if _, err := f.h.Store.CreateUser(models.User{Username: "alice", Password: "hash", Role: models.RoleViewer, Active: true}); err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	if _, err := f.h.Store.CreateWebhook(models.Webhook{Name: "ops", URL: "https://example.com/hook", Secret: "secret", Active: true}); err != nil {
+		t.Fatalf("CreateWebhook: %v", err)
+	}
+	provider, err := f.h.Store.CreateProvider(models.Provider{Name: "BBC"})
+	if err != nil {
+		t.Fatalf("CreateProvider: %v", err)
+	}
+	want := `{"entity":"provider","action":"created","id":` + strconv.Itoa(provider.ID) + `}`
+	if event := stream.nextEvent(t); event.name != "change" || event.data != want {
+		t.Errorf("event %s %s, want change %s", event.name, event.data, want)
+	}
+
+	if err := f.h.Store.StartChannel(channels[1].ID, 20000); err != nil {
+		t.Fatalf("StartChannel: %v", err)
+	}
+	stream.nextEvent(t)
+	if err := f.h.Store.SetChannelState(channels[1].ID, models.ChannelFailed, 0, "no signal"); err != nil {
+		t.Fatalf("SetChannelState: %v", err)
+	}
+	event := stream.nextEvent(t)
+	var status channelStatus
+	if err := json.Unmarshal([]byte(event.data), &status); err != nil || event.name != "channel" {
+		t.Fatalf("state event %s %s: %v", event.name, event.data, err)
+	}
+	if status.ID != channels[1].ID || status.State != models.ChannelFailed || status.LastError != "no signal" {
+		t.Errorf("state event: %+v", status)
+	}
+
+	f.h.CloseStreams()
+	stream.waitEnd(t, "shutdown")
+}
+
+// TestEventsKeepAlive checks that a stream keeps checking the session or API
+// token it was opened with, and ends once it is no longer valid
+func TestEventsKeepAlive(t *testing.T) {
+	previous := eventsKeepAlive
+	eventsKeepAlive = 10 * time.Millisecond
+	t.Cleanup(func() { eventsKeepAlive = previous })
+	f, _ := newEventsFixture(t)
+
+	tests := []struct {
+		name, role, token string
+		revoke            func()
+	}{
+		{"session", models.RoleOperator, "", func() {
+			f.h.Sessions.Delete(sessionIDFor(f.sessions[models.RoleOperator]))
+		}},
+		{"API token", "", "view only", func() {
+			token, _ := f.h.Store.GetAPITokenByHash(hashAPIToken(f.tokens["view only"]))
+			if err := f.h.Store.DeleteAPIToken(token.ID); err != nil {
+				t.Fatalf("DeleteAPIToken: %v", err)
+			}
+		}},
+	}
+	for _, test := range tests {
+		stream := openEventStream(f.h, f.request(http.MethodGet, "/events", test.role, test.token))
+		for kept := 0; kept < 3; {
+			event, open := stream.next(t)
+			if !open {
+				t.Fatalf("%s: stream ended while its credential is valid", test.name)
+			}
+			if event.name == "" && event.data == "keep-alive" {
+				kept++
+			}
+		}
+		test.revoke()
+		stream.waitEnd(t, test.name+" revoked")
+	}
+}
+
+// TestEventsSlowConsumer checks that a stream falling too far behind is
+// closed, for the page to reconnect and read the current state
+func TestEventsSlowConsumer(t *testing.T) {
+	f, channels := newEventsFixture(t)
+	stream := openEventStream(f.h, f.request(http.MethodGet, "/events", models.RoleViewer, ""))
+	stream.nextEvent(t) // The stream is subscribed once it sends its snapshot
+
+	// Not reading blocks the stream on its first event, while the others queue
+	if err := f.h.Store.StartChannel(channels[0].ID, 20000); err != nil {
+		t.Fatalf("StartChannel: %v", err)
+	}
+	for i := range eventsBuffer + 2 {
+		state := models.ChannelRunning
+		if i%2 == 1 {
+			state = models.ChannelRestarting
+		}
+		if err := f.h.Store.SetChannelState(channels[0].ID, state, 0, ""); err != nil {
+			t.Fatalf("SetChannelState: %v", err)
+		}
+	}
+	stream.waitEnd(t, "falling behind")
+}
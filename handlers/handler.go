@@ -0,0 +1,186 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"fuzzy/config"
+	"fuzzy/models"
+	"fuzzy/ports"
+	"fuzzy/supervisor"
+	"fuzzy/validation"
+	"fuzzy/webhooks"
+)
+
+// Handler holds the dependencies shared by all HTTP handlers
+type Handler struct {
+	Store      models.Store
+	Sessions   *SessionManager
+	Supervisor *supervisor.Supervisor // Runs the processes of started channels
+	Webhooks   *webhooks.Dispatcher   // Delivers the channel and catalog events to the webhooks
+
+	twoFactor   *twoFactorState
+	streamsDone chan struct{} // Closed to end the event streams
+	endStreams  sync.Once
+}
+
+// New creates a Handler backed by the given store. Sessions are kept in the
+// store too when the security.persist_sessions setting is enabled.
+func New(store models.Store) *Handler {
+	var sessionStore models.Repository
+	if config.AppConfig.Security.PersistSessions {
+		sessionStore = store
+	}
+	supervisorConfig := config.AppConfig.Supervisor
+	portsConfig := config.AppConfig.Ports
+	webhooksConfig := config.AppConfig.Webhooks
+	return &Handler{
+		Store:    store,
+		Sessions: NewSessionManager(sessionStore, config.AppConfig.GetSessionDuration(), config.AppConfig.GetSessionIdleTimeout()),
+		Supervisor: supervisor.New(store, supervisor.Options{
+			Command:         supervisorConfig.Command,
+			LogDir:          supervisorConfig.LogDir,
+			RestartDelay:    time.Duration(supervisorConfig.RestartDelaySeconds) * time.Second,
+			MaxRestartDelay: time.Duration(supervisorConfig.MaxRestartDelaySeconds) * time.Second,
+			StopTimeout:     time.Duration(supervisorConfig.StopTimeoutSeconds) * time.Second,
+			// The panel's own port may fall within the remux range
+			Ports: ports.New(portsConfig.RemuxPortMin, portsConfig.RemuxPortMax, config.AppConfig.Server.Port),
+		}),
+		Webhooks: webhooks.New(store, webhooks.Options{
+			Timeout:     time.Duration(webhooksConfig.TimeoutSeconds) * time.Second,
+			MaxAttempts: webhooksConfig.MaxAttempts,
+			RetryDelay:  time.Duration(webhooksConfig.RetryDelaySeconds) * time.Second,
+		}),
+		twoFactor:   newTwoFactorState(),
+		streamsDone: make(chan struct{}),
+	}
+}
+
+// Close stops the background work of the handler, including the processes
+// of the running channels. Webhooks are closed after the supervisor, so the
+// channels it stops are still reported to them.
+func (h *Handler) Close() {
+	h.CloseStreams()
+	h.Supervisor.Close()
+	h.Webhooks.Close()
+	h.Sessions.Close()
+}
+
+// CloseStreams ends the event streams, which would otherwise keep a graceful
+// shutdown of the server waiting
+func (h *Handler) CloseStreams() {
+	h.endStreams.Do(func() { close(h.streamsDone) })
+}
+
+// stopBeforeDelete stops the process of a running channel about to be
+// deleted, so it does not outlive the channel
+func (h *Handler) stopBeforeDelete(r *http.Request, channelID int) {
+	if channel, exists := h.Store.GetChannel(channelID); exists && channel.Running {
+		if err := h.Supervisor.Stop(channelID, actorOf(r)); err != nil {
+			log.Printf("Error stopping channel %d before deleting it: %v", channelID, err)
+		}
+	}
+}
+
+// integrityMessage returns a user-facing message when err is a referential
+// integrity error from the store, and false for any other error
+func integrityMessage(err error) (string, bool) {
+	var refErr *models.ReferenceError
+	if errors.As(err, &refErr) {
+		return fmt.Sprintf("The selected %s does not exist (ID %d)", refErr.Entity, refErr.ID), true
+	}
+	var inUseErr *models.InUseError
+	if errors.As(err, &inUseErr) {
+		return fmt.Sprintf("This %s cannot be deleted while %d %s(s) still use it", inUseErr.Entity, inUseErr.Count, inUseErr.By), true
+	}
+	return "", false
+}
+
+// conflictMessage returns a user-facing message when err reports an edit based
+// on an outdated version of an entity, and false for any other error
+func conflictMessage(err error) (string, bool) {
+	var conflictErr *models.ConflictError
+	if errors.As(err, &conflictErr) {
+		return fmt.Sprintf("This %s was changed by someone else while you were editing it. Its current values are shown below; review them and save again.", conflictErr.Entity), true
+	}
+	return "", false
+}
+
+// transitionMessage returns a user-facing message when err reports a start or
+// stop that the state of the channel does not allow, and false for any other
+// error
+func transitionMessage(err error) (string, bool) {
+	var transitionErr *models.TransitionError
+	if errors.As(err, &transitionErr) {
+		action := "stop"
+		if transitionErr.To == models.ChannelStarting {
+			action = "start"
+		}
+		return fmt.Sprintf("Cannot %s channel %d: it is %s", action, transitionErr.ID, transitionErr.From), true
+	}
+	return "", false
+}
+
+// startFailure returns the user-facing message of a channel that could not be
+// started. A missing command is reported as such, since it is a setting to
+// fill in rather than a failure of the channel.
+func startFailure(err error) string {
+	if errors.Is(err, supervisor.ErrNoCommand) {
+		return "Remux command not configured: set command in the [supervisor] section of config/config.cfg"
+	}
+	return "Failed to start channel: " + err.Error()
+}
+
+// redirectBack redirects to the page named by the return_to form field, or to
+// fallback when there is none. Only paths on this server are followed.
+func redirectBack(w http.ResponseWriter, r *http.Request, fallback string) {
+	target := r.FormValue("return_to")
+	if !strings.HasPrefix(target, "/") || strings.HasPrefix(target, "//") || strings.HasPrefix(target, "/\\") {
+		target = fallback
+	}
+	http.Redirect(w, r, target, http.StatusSeeOther)
+}
+
+// formErrors returns the field errors of the form for action and id, for the
+// page to show next to the fields
+func formErrors(action string, id int, errs validation.Errors) models.FormErrors {
+	return models.FormErrors{Action: action, ID: id, Fields: errs.Map()}
+}
+
+// Routes registers every application route and wraps them with the
+// middleware that applies to all requests
+func (h *Handler) Routes() http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/", h.HomeHandler)
+	mux.HandleFunc("/health", HealthHandler)
+	mux.HandleFunc("/login", h.LoginHandler)
+	mux.HandleFunc("/logout", h.LogoutHandler)
+	mux.HandleFunc("/setup", h.SetupHandler)
+
+	// Static files
+	mux.Handle("/static/", http.StripPrefix("/static/", http.FileServer(http.Dir("static/"))))
+
+	// Protected routes; POST actions on the pages check their own edit permission
+	mux.HandleFunc("/providers", h.RequireSetupOrAuth(h.RequirePermission(models.PermProvidersView, h.ProvidersHandler)))
+	mux.HandleFunc("/channels", h.RequireSetupOrAuth(h.RequirePermission(models.PermChannelsView, h.ChannelsHandler)))
+	mux.HandleFunc("/profiles", h.RequireSetupOrAuth(h.RequirePermission(models.PermChannelsView, h.ProfilesHandler)))
+	mux.HandleFunc("/users", h.RequireSetupOrAuth(h.RequirePermission(models.PermUsersManage, h.UsersHandler)))
+	mux.HandleFunc("/webhooks", h.RequireSetupOrAuth(h.RequirePermission(models.PermWebhooksManage, h.WebhooksHandler)))
+	mux.HandleFunc("/sessions", h.RequireSetupOrAuth(h.SessionsHandler))
+	mux.HandleFunc("/account", h.RequireSetupOrAuth(h.AccountHandler))
+	mux.HandleFunc("/tokens", h.RequireSetupOrAuth(h.TokensHandler))
+	mux.HandleFunc("/channel/start", h.RequireSetupOrAuth(h.RequirePermission(models.PermChannelsControl, h.ChannelStartHandler)))
+	mux.HandleFunc("/channel/stop", h.RequireSetupOrAuth(h.RequirePermission(models.PermChannelsControl, h.ChannelStopHandler)))
+	mux.HandleFunc("/events", h.RequireSetupOrAuth(h.RequirePermission(models.PermChannelsView, h.EventsHandler)))
+
+	// JSON API; it authenticates and authorizes each request itself
+	mux.Handle("/api/", h.APIHandler())
+
+	return SecurityMiddleware(CSRFProtect(mux))
+}
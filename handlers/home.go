@@ -1,17 +1,15 @@
 package handlers
 
 import (
-	"html/template"
 	"log"
 	"net/http"
-	"path/filepath"
 	"time"
 
 	"fuzzy/models"
 )
 
 // HomeHandler handles requests to the home page
-func HomeHandler(w http.ResponseWriter, r *http.Request) {
+func (h *Handler) HomeHandler(w http.ResponseWriter, r *http.Request) {
 	// Only allow GET requests to the home page
 	if r.Method != http.MethodGet {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
@@ -19,17 +17,21 @@ func HomeHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// If no users exist, redirect to setup
-	if !models.GlobalStore.HasUsers() {
+	if !h.Store.HasUsers() {
 		http.Redirect(w, r, "/setup", http.StatusSeeOther)
 		return
 	}
 
 	// Check if user is authenticated
-	user, authenticated := GetCurrentUser(r)
+	user, authenticated := h.GetCurrentUser(r)
 	if !authenticated {
 		http.Redirect(w, r, "/login", http.StatusSeeOther)
 		return
 	}
+	if needsTwoFactorEnrollment(user) {
+		http.Redirect(w, r, "/account", http.StatusSeeOther)
+		return
+	}
 
 	// Prepare data for the home page template
 	welcomeMsg := "Welcome to Fuzzy!"
@@ -43,9 +45,8 @@ func HomeHandler(w http.ResponseWriter, r *http.Request) {
 		CurrentTime: time.Now().Format("2006-01-02 15:04:05"),
 	}
 
-	// Parse the template file
-	tmplPath := filepath.Join("templates", "home.html")
-	t, err := template.ParseFiles(tmplPath)
+	// Parse the template file; the navigation only shows pages the user may open
+	t, err := parseTemplate(w, withCurrentUser(r, user), "home.html")
 	if err != nil {
 		log.Printf("Error parsing template: %v", err)
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
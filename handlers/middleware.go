@@ -1,46 +1,154 @@
 package handlers
 
 import (
+	"context"
+	"log"
 	"net/http"
-	
+	"time"
+
 	"fuzzy/models"
 )
 
-// RequireAuth is middleware that requires user authentication
-func RequireAuth(next http.HandlerFunc) http.HandlerFunc {
+// currentUserKey is the request context key of the authenticated user
+type currentUserKey struct{}
+
+// withCurrentUser returns a copy of r carrying the authenticated user
+func withCurrentUser(r *http.Request, user models.User) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), currentUserKey{}, user))
+}
+
+// currentUser returns the user stored in the request by RequireAuth
+func currentUser(r *http.Request) (models.User, bool) {
+	user, ok := r.Context().Value(currentUserKey{}).(models.User)
+	return user, ok
+}
+
+// actorOf returns who the changes made while serving r are attributed to: the
+// authenticated user, or the server itself before anyone signs in
+func actorOf(r *http.Request) models.Actor {
+	if user, ok := currentUser(r); ok {
+		return models.UserActor(user)
+	}
+	return models.Actor{}
+}
+
+// storeFor returns the store with the changes made while serving r attributed
+// to the authenticated user
+func (h *Handler) storeFor(r *http.Request) models.Store {
+	return h.Store.As(actorOf(r))
+}
+
+// RequireAuth is middleware that requires user authentication, either with
+// the session cookie or with an "Authorization: Bearer" API token
+func (h *Handler) RequireAuth(next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		// Scripts authenticate with an API token and get errors, not redirects
+		if value := bearerToken(r); value != "" {
+			user, token, ok := h.authenticateAPIToken(value)
+			if !ok {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="fuzzy"`)
+				http.Error(w, "Invalid or expired API token", http.StatusUnauthorized)
+				return
+			}
+			if needsTwoFactorEnrollment(user) {
+				http.Error(w, "Two-factor authentication must be enabled for this account", http.StatusForbidden)
+				return
+			}
+			next(w, withAPIToken(withCurrentUser(r, user), token))
+			return
+		}
+
 		// Check if user is authenticated
-		_, authenticated := GetCurrentUser(r)
+		user, authenticated := h.GetCurrentUser(r)
 		if !authenticated {
 			// Redirect to login page
 			http.Redirect(w, r, "/login", http.StatusSeeOther)
 			return
 		}
 
+		// Users who must enable two-factor authentication can only do that
+		if needsTwoFactorEnrollment(user) && r.URL.Path != "/account" {
+			http.Redirect(w, r, "/account", http.StatusSeeOther)
+			return
+		}
+
 		// User is authenticated, proceed to next handler
+		next(w, withCurrentUser(r, user))
+	}
+}
+
+// stillAuthenticated reports whether the session or API token a request was
+// authenticated with by RequireAuth is still valid, for responses that
+// outlive the check, such as event streams. It does not record a use.
+func (h *Handler) stillAuthenticated(r *http.Request) bool {
+	token, viaToken := requestAPIToken(r)
+	if !viaToken {
+		return h.Sessions.Active(currentSessionID(r))
+	}
+	// The ID of a revoked token may be given to a new one
+	current, exists := h.Store.GetAPIToken(token.ID)
+	if !exists || current.Hash != token.Hash || current.Expired(time.Now()) {
+		return false
+	}
+	user, exists := h.Store.GetUser(current.UserID)
+	return exists && user.Active
+}
+
+// RequirePermission is middleware that only lets through users whose role
+// grants the permission. It must run after RequireAuth.
+func (h *Handler) RequirePermission(permission models.Permission, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !authorize(w, r, permission) {
+			return
+		}
 		next(w, r)
 	}
 }
 
+// can reports whether the current user has the permission and, for requests
+// authenticated with an API token, whether the token's scopes include it
+func can(r *http.Request, permission models.Permission) bool {
+	user, ok := currentUser(r)
+	if !ok || !user.Can(permission) {
+		return false
+	}
+	if token, viaToken := requestAPIToken(r); viaToken && !token.Allows(permission) {
+		return false
+	}
+	return true
+}
+
+// authorize checks that the current user has the permission and answers
+// 403 Forbidden otherwise. It reports whether the request may proceed.
+func authorize(w http.ResponseWriter, r *http.Request, permission models.Permission) bool {
+	if !can(r, permission) {
+		user, _ := currentUser(r)
+		log.Printf("Denied %s %s to user %d: missing permission %s", r.Method, r.URL.Path, user.ID, permission)
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
 // RequireSetupOrAuth redirects to setup if no users exist, otherwise requires auth
-func RequireSetupOrAuth(next http.HandlerFunc) http.HandlerFunc {
+func (h *Handler) RequireSetupOrAuth(next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		// If no users exist, redirect to setup
-		if !models.GlobalStore.HasUsers() {
+		if !h.Store.HasUsers() {
 			http.Redirect(w, r, "/setup", http.StatusSeeOther)
 			return
 		}
 
 		// Users exist, require authentication
-		RequireAuth(next)(w, r)
+		h.RequireAuth(next)(w, r)
 	}
 }
 
 // RedirectIfAuthenticated redirects authenticated users away from login/setup pages
-func RedirectIfAuthenticated(next http.HandlerFunc) http.HandlerFunc {
+func (h *Handler) RedirectIfAuthenticated(next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		// Check if user is authenticated
-		_, authenticated := GetCurrentUser(r)
+		_, authenticated := h.GetCurrentUser(r)
 		if authenticated {
 			// User is already logged in, redirect to home
 			http.Redirect(w, r, "/", http.StatusSeeOther)
@@ -0,0 +1,124 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"fuzzy/config"
+	"fuzzy/models"
+)
+
+// authFixture is a handler with a user of each role, each signed in with a
+// session, and API tokens of the administrator
+type authFixture struct {
+	h        *Handler
+	sessions map[string]string // Session cookie by role
+	tokens   map[string]string // API token by name
+}
+
+// newAuthFixture returns a handler with the default configuration and a
+// signed-in user of each role
+func newAuthFixture(t *testing.T) *authFixture {
+	t.Helper()
+	h := newOpenAPITestHandler(t)
+	h.Sessions = NewSessionManager(nil, config.AppConfig.GetSessionDuration(), config.AppConfig.GetSessionIdleTimeout())
+	h.twoFactor = newTwoFactorState()
+	t.Cleanup(h.Sessions.Close)
+
+	f := &authFixture{h: h, sessions: make(map[string]string), tokens: make(map[string]string)}
+	var admin models.User
+	for _, role := range []string{models.RoleAdmin, models.RoleOperator, models.RoleViewer} {
+		user, err := h.Store.CreateUser(models.User{Username: role, Password: "hash", Role: role, Active: true})
+		if err != nil {
+			t.Fatalf("CreateUser: %v", err)
+		}
+		_, token, err := h.Sessions.Create(user.ID, "192.0.2.1", "test")
+		if err != nil {
+			t.Fatalf("creating session: %v", err)
+		}
+		f.sessions[role] = token
+		if role == models.RoleAdmin {
+			admin = user
+		}
+	}
+
+	scopes := map[string][]models.Permission{
+		"full":      nil,
+		"view only": {models.PermChannelsView},
+		"control":   {models.PermChannelsView, models.PermChannelsControl},
+	}
+	for name, scope := range scopes {
+		value, err := newAPIToken()
+		if err != nil {
+			t.Fatalf("newAPIToken: %v", err)
+		}
+		if _, err := h.Store.CreateAPIToken(models.APIToken{UserID: admin.ID, Name: name, Hash: hashAPIToken(value), Scopes: scope}); err != nil {
+			t.Fatalf("CreateAPIToken: %v", err)
+		}
+		f.tokens[name] = value
+	}
+	return f
+}
+
+// request returns a request signed in with the session of role, if any, and
+// authenticated with the named API token, if any
+func (f *authFixture) request(method, target, role, token string) *http.Request {
+	r := httptest.NewRequest(method, target, nil)
+	if role != "" {
+		r.AddCookie(&http.Cookie{Name: config.AppConfig.Security.SessionCookieName, Value: f.sessions[role]})
+	}
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+f.tokens[token])
+	}
+	return r
+}
+
+// TestRequireAuth checks how requests without valid credentials are turned
+// away, and that the others reach the page as their user
+func TestRequireAuth(t *testing.T) {
+	f := newAuthFixture(t)
+	var reached string
+	page := f.h.RequireAuth(func(w http.ResponseWriter, r *http.Request) {
+		user, _ := currentUser(r)
+		reached = user.Username
+	})
+
+	tests := []struct {
+		name     string
+		request  *http.Request
+		status   int
+		location string // Redirect target
+		user     string // User the page is served to
+	}{
+		{"no credentials", f.request(http.MethodGet, "/channels", "", ""), http.StatusSeeOther, "/login", ""},
+		{"session", f.request(http.MethodGet, "/channels", models.RoleViewer, ""), http.StatusOK, "", models.RoleViewer},
+		{"unknown session", func() *http.Request {
+			r := httptest.NewRequest(http.MethodGet, "/channels", nil)
+			r.AddCookie(&http.Cookie{Name: config.AppConfig.Security.SessionCookieName, Value: "forged"})
+			return r
+		}(), http.StatusSeeOther, "/login", ""},
+	}
+	for _, test := range tests {
+		reached = ""
+		rec := httptest.NewRecorder()
+		page(rec, test.request)
+		if rec.Code != test.status || rec.Header().Get("Location") != test.location {
+			t.Errorf("%s: status %d, location %q; want %d, %q", test.name, rec.Code, rec.Header().Get("Location"), test.status, test.location)
+		}
+		if reached != test.user {
+			t.Errorf("%s: page served to %q, want %q", test.name, reached, test.user)
+		}
+	}
+
+	// Deleting the user ends their sessions
+	viewer, _ := f.h.Store.GetUserByUsername(models.RoleViewer)
+	if err := f.h.Store.DeleteUser(viewer.ID); err != nil {
+		t.Fatalf("DeleteUser: %v", err)
+	}
+	rec := httptest.NewRecorder()
+	page(rec, f.request(http.MethodGet, "/channels", models.RoleViewer, ""))
+	if rec.Code != http.StatusSeeOther {
+		t.Errorf("session of a deleted user: status %d, want %d", rec.Code, http.StatusSeeOther)
+	}
+}
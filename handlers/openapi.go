@@ -0,0 +1,361 @@
+package handlers
+
+import (
+	"encoding"
+	"net/http"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"fuzzy/config"
+	"fuzzy/models"
+	"fuzzy/validation"
+)
+
+// apiOperation documents one endpoint of the JSON API in the OpenAPI document
+type apiOperation struct {
+	Summary  string
+	Request  string            // Schema of the request body, or ""
+	Response string            // Schema of the response body, "[]" prefixed for lists, or "" for none
+	Status   int               // Success status code
+	Query    map[string]string // Optional query parameters and their description
+}
+
+// apiOperations documents every route of apiRoutes, keyed by method and path
+var apiOperations = map[string]apiOperation{
+	"GET /channels":         {Summary: "List channels", Response: "[]Channel", Status: http.StatusOK},
+	"POST /channels":        {Summary: "Create a channel; empty encoding settings come from its profile, or get their defaults without one", Request: "Channel", Response: "Channel", Status: http.StatusCreated},
+	"GET /channels/{id}":    {Summary: "Get a channel", Response: "Channel", Status: http.StatusOK},
+	"PUT /channels/{id}":    {Summary: "Replace a channel; empty encoding settings come from its profile, or keep their value without one", Request: "Channel", Response: "Channel", Status: http.StatusOK},
+	"PATCH /channels/{id}":  {Summary: "Change the fields of a channel present in the body", Request: "Channel", Response: "Channel", Status: http.StatusOK},
+	"DELETE /channels/{id}": {Summary: "Delete a channel and remove it from every bouquet", Status: http.StatusNoContent},
+
+	"POST /channels/{id}/start": {Summary: "Start a stopped or failed channel and its process; answers 409 invalid_state when it is already started, and 503 start_failed when the process cannot be started", Response: "Channel", Status: http.StatusOK},
+	"POST /channels/{id}/stop":  {Summary: "Stop the process of a channel, then the channel, or clear a failed channel; answers 409 invalid_state when it is already stopped or stopping", Response: "Channel", Status: http.StatusOK},
+
+	"GET /profiles":         {Summary: "List encoding profiles with the channels using them", Response: "[]Profile", Status: http.StatusOK},
+	"POST /profiles":        {Summary: "Create an encoding profile; empty encoding settings get their defaults", Request: "Profile", Response: "Profile", Status: http.StatusCreated},
+	"GET /profiles/{id}":    {Summary: "Get an encoding profile", Response: "Profile", Status: http.StatusOK},
+	"PUT /profiles/{id}":    {Summary: "Replace an encoding profile; the change applies to every channel using it", Request: "Profile", Response: "Profile", Status: http.StatusOK},
+	"PATCH /profiles/{id}":  {Summary: "Change the fields of an encoding profile present in the body", Request: "Profile", Response: "Profile", Status: http.StatusOK},
+	"DELETE /profiles/{id}": {Summary: "Delete an encoding profile that no channel uses", Status: http.StatusNoContent},
+
+	"GET /providers":         {Summary: "List providers", Response: "[]Provider", Status: http.StatusOK},
+	"POST /providers":        {Summary: "Create a provider", Request: "Provider", Response: "Provider", Status: http.StatusCreated},
+	"GET /providers/{id}":    {Summary: "Get a provider", Response: "Provider", Status: http.StatusOK},
+	"PUT /providers/{id}":    {Summary: "Replace a provider", Request: "Provider", Response: "Provider", Status: http.StatusOK},
+	"PATCH /providers/{id}":  {Summary: "Change the fields of a provider present in the body", Request: "Provider", Response: "Provider", Status: http.StatusOK},
+	"DELETE /providers/{id}": {Summary: "Delete a provider, subject to the provider delete policy", Status: http.StatusNoContent},
+
+	"GET /bouquets": {
+		Summary:  "List bouquets",
+		Response: "[]Bouquet",
+		Status:   http.StatusOK,
+		Query:    map[string]string{"provider_id": "Only list the bouquets of this provider"},
+	},
+	"POST /bouquets":        {Summary: "Create a bouquet", Request: "Bouquet", Response: "Bouquet", Status: http.StatusCreated},
+	"GET /bouquets/{id}":    {Summary: "Get a bouquet with its channels", Response: "Bouquet", Status: http.StatusOK},
+	"PUT /bouquets/{id}":    {Summary: "Replace a bouquet, including its channel_ids", Request: "Bouquet", Response: "Bouquet", Status: http.StatusOK},
+	"PATCH /bouquets/{id}":  {Summary: "Change the fields of a bouquet present in the body", Request: "Bouquet", Response: "Bouquet", Status: http.StatusOK},
+	"DELETE /bouquets/{id}": {Summary: "Delete a bouquet", Status: http.StatusNoContent},
+
+	"PUT /bouquets/{id}/channels/{channel_id}":    {Summary: "Add a channel to a bouquet", Response: "Bouquet", Status: http.StatusOK},
+	"DELETE /bouquets/{id}/channels/{channel_id}": {Summary: "Remove a channel from a bouquet", Response: "Bouquet", Status: http.StatusOK},
+
+	"GET /users":         {Summary: "List users", Response: "[]User", Status: http.StatusOK},
+	"POST /users":        {Summary: "Create a user; password is required", Request: "User", Response: "User", Status: http.StatusCreated},
+	"GET /users/{id}":    {Summary: "Get a user", Response: "User", Status: http.StatusOK},
+	"PUT /users/{id}":    {Summary: "Replace a user; the password only changes when set", Request: "User", Response: "User", Status: http.StatusOK},
+	"PATCH /users/{id}":  {Summary: "Change the fields of a user present in the body", Request: "User", Response: "User", Status: http.StatusOK},
+	"DELETE /users/{id}": {Summary: "Delete a user", Status: http.StatusNoContent},
+}
+
+// openAPISchemas lists the entity schemas of the OpenAPI document with the
+// Go types they are generated from
+var openAPISchemas = map[string]reflect.Type{
+	"Channel":  reflect.TypeFor[models.Channel](),
+	"Profile":  reflect.TypeFor[models.EncodingProfile](),
+	"Provider": reflect.TypeFor[models.Provider](),
+	"Bouquet":  reflect.TypeFor[models.Bouquet](),
+	"User":     reflect.TypeFor[models.User](),
+}
+
+// openAPIReadOnly lists the fields that requests cannot change
+var openAPIReadOnly = map[string]bool{
+	"id":           true,
+	"created_at":   true,
+	"updated_at":   true,
+	"state":        true, // Changed by the start and stop endpoints
+	"running":      true,
+	"remux_port":   true,
+	"pid":          true, // Set by the supervisor of the channel processes
+	"last_error":   true,
+	"started_at":   true,
+	"stopped_at":   true,
+	"channels":     true, // Resolved from channel_ids
+	"used_by":      true, // Resolved from the profile_id of channels
+	"totp_enabled": true, // Managed by the user on the account page
+}
+
+// openAPIEnums lists the allowed values of the fields that have a fixed set
+var openAPIEnums = map[string][]string{
+	"video_codec": openAPIEnumValues(models.VideoCodecs),
+	"audio_codec": openAPIEnumValues(models.AudioCodecs),
+	"quality":     validation.Qualities,
+	"state":       openAPIEnumValues(models.ChannelStates),
+	"role":        models.Roles,
+}
+
+// openAPIFormats describes the fields whose JSON string has a format of its own
+var openAPIFormats = map[string]string{
+	"resolution":    `A preset ("720p", "1080p", "1440p", "2160p") or a size such as "1920x1080"`,
+	"video_bitrate": `Bits per second as a number, or a string in kbit/s or Mbit/s such as "5000k" or "2.5M"; returned in kbit/s`,
+	"audio_bitrate": `Bits per second as a number, or a string in kbit/s or Mbit/s such as "128k"; returned in kbit/s`,
+}
+
+// openAPIEnumValues converts the values of a string enum type
+func openAPIEnumValues[T ~string](values []T) []string {
+	names := make([]string, len(values))
+	for i, value := range values {
+		names[i] = string(value)
+	}
+	return names
+}
+
+// pathParamRe matches the wildcards of a ServeMux pattern
+var pathParamRe = regexp.MustCompile(`\{(\w+)\}`)
+
+// operationIDReplacer turns a route path into the end of its operation ID,
+// e.g. "/channels/{id}/start" into "_channels_id_start"
+var operationIDReplacer = strings.NewReplacer("/", "_", "{", "", "}", "")
+
+// OpenAPIHandler serves the OpenAPI 3 document describing the JSON API
+func (h *Handler) OpenAPIHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		writeAPIError(w, http.StatusMethodNotAllowed, apiError{Code: apiCodeMethodNotAllowed, Message: "Method not allowed"})
+		return
+	}
+	writeJSON(w, http.StatusOK, h.openAPISpec())
+}
+
+// openAPISpec builds the OpenAPI document from apiRoutes, apiOperations and
+// the models, so it follows the code
+func (h *Handler) openAPISpec() map[string]any {
+	paths := make(map[string]map[string]any)
+	for _, route := range h.apiRoutes() {
+		operation, documented := apiOperations[route.Method+" "+route.Path]
+		if !documented {
+			// Caught by the tests; still list the endpoint
+			operation.Summary = "Undocumented"
+		}
+		if paths[route.Path] == nil {
+			paths[route.Path] = make(map[string]any)
+		}
+		paths[route.Path][strings.ToLower(route.Method)] = openAPIOperation(route, operation)
+	}
+
+	schemas := map[string]any{
+		"Error": map[string]any{
+			"type":     "object",
+			"required": []string{"error"},
+			"properties": map[string]any{
+				"error": map[string]any{
+					"type":     "object",
+					"required": []string{"code", "message"},
+					"properties": map[string]any{
+						"code": map[string]any{"type": "string", "enum": []string{
+							apiCodeInvalidRequest, apiCodeUnsupportedMedia, apiCodeValidation,
+							apiCodeUnauthorized, apiCodeForbidden, apiCodeTwoFactor, apiCodeSetupRequired,
+							apiCodeNotFound, apiCodeMethodNotAllowed, apiCodeVersionConflict,
+							apiCodeInvalidReference, apiCodeInUse, apiCodeStartFailed, apiCodeInvalidState, apiCodeInternal,
+						}},
+						"message": map[string]any{"type": "string"},
+						"version": map[string]any{"type": "integer", "description": "Current version of the entity, for version_conflict"},
+						"fields": map[string]any{
+							"type":        "array",
+							"description": "Invalid fields, for validation_failed",
+							"items": map[string]any{
+								"type":     "object",
+								"required": []string{"field", "message"},
+								"properties": map[string]any{
+									"field":   map[string]any{"type": "string"},
+									"message": map[string]any{"type": "string"},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+	for name, t := range openAPISchemas {
+		schemas[name] = openAPITypeSchema(t, true)
+	}
+
+	// Passwords are accepted in requests but never returned
+	user := schemas["User"].(map[string]any)
+	user["properties"].(map[string]any)["password"] = map[string]any{"type": "string", "writeOnly": true}
+
+	return map[string]any{
+		"openapi": "3.0.3",
+		"info": map[string]any{
+			"title":       config.AppConfig.Server.AppName + " API",
+			"version":     "1",
+			"description": "Manage the channels, providers, bouquets and users of " + config.AppConfig.Server.AppName + ". Updates must include the version they are based on.",
+		},
+		"servers": []map[string]any{{"url": apiPrefix}},
+		"security": []map[string]any{
+			{"bearerAuth": []string{}},
+			{"sessionCookie": []string{}},
+		},
+		"paths": paths,
+		"components": map[string]any{
+			"schemas": schemas,
+			"securitySchemes": map[string]any{
+				"bearerAuth": map[string]any{
+					"type":        "http",
+					"scheme":      "bearer",
+					"description": "Personal API token created on the API Tokens page",
+				},
+				"sessionCookie": map[string]any{
+					"type":        "apiKey",
+					"in":          "cookie",
+					"name":        config.AppConfig.Security.SessionCookieName,
+					"description": "Session of a signed-in user; state-changing requests also need the X-CSRF-Token header",
+				},
+			},
+		},
+	}
+}
+
+// openAPIOperation describes one route
+func openAPIOperation(route apiRoute, operation apiOperation) map[string]any {
+	var parameters []map[string]any
+	for _, match := range pathParamRe.FindAllStringSubmatch(route.Path, -1) {
+		parameters = append(parameters, map[string]any{
+			"name":     match[1],
+			"in":       "path",
+			"required": true,
+			"schema":   map[string]any{"type": "integer"},
+		})
+	}
+	for name, description := range operation.Query {
+		parameters = append(parameters, map[string]any{
+			"name":        name,
+			"in":          "query",
+			"description": description,
+			"schema":      map[string]any{"type": "integer"},
+		})
+	}
+
+	success := map[string]any{"description": http.StatusText(operation.Status)}
+	if operation.Response != "" {
+		success["content"] = openAPIContent(operation.Response)
+	}
+	result := map[string]any{
+		"summary":            operation.Summary,
+		"operationId":        strings.ToLower(route.Method) + operationIDReplacer.Replace(route.Path),
+		"x-fuzzy-permission": string(route.Permission),
+		"responses": map[string]any{
+			strconv.Itoa(operation.Status): success,
+			"default": map[string]any{
+				"description": "Error",
+				"content":     openAPIContent("Error"),
+			},
+		},
+	}
+	if parameters != nil {
+		result["parameters"] = parameters
+	}
+	if operation.Request != "" {
+		result["requestBody"] = map[string]any{
+			"required": true,
+			"content":  openAPIContent(operation.Request),
+		}
+	}
+	return result
+}
+
+// openAPIContent returns the JSON content of a body of the named schema,
+// which is a list when prefixed with "[]"
+func openAPIContent(schema string) map[string]any {
+	var body map[string]any
+	if name, isList := strings.CutPrefix(schema, "[]"); isList {
+		body = map[string]any{"type": "array", "items": openAPIRef(name)}
+	} else {
+		body = openAPIRef(schema)
+	}
+	return map[string]any{"application/json": map[string]any{"schema": body}}
+}
+
+func openAPIRef(name string) map[string]any {
+	return map[string]any{"$ref": "#/components/schemas/" + name}
+}
+
+// openAPITypeSchema generates the schema of a Go type from its JSON
+// encoding. Other entities are referenced rather than inlined, except for
+// the entity being described, top.
+func openAPITypeSchema(t reflect.Type, top bool) map[string]any {
+	if t == reflect.TypeFor[time.Time]() {
+		return map[string]any{"type": "string", "format": "date-time"}
+	}
+	if t.Implements(reflect.TypeFor[encoding.TextMarshaler]()) {
+		// Encoded as its text form, like the media settings of channels
+		return map[string]any{"type": "string"}
+	}
+	if !top {
+		for name, schemaType := range openAPISchemas {
+			if t == schemaType {
+				return openAPIRef(name)
+			}
+		}
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int64:
+		return map[string]any{"type": "integer"}
+	case reflect.Slice:
+		return map[string]any{"type": "array", "items": openAPITypeSchema(t.Elem(), false)}
+	case reflect.Struct:
+		properties := make(map[string]any)
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+			if !field.IsExported() || name == "-" {
+				continue
+			}
+			if name == "" && field.Anonymous && field.Type.Kind() == reflect.Struct {
+				// Embedded fields are encoded inline, like the media settings
+				for embedded, schema := range openAPITypeSchema(field.Type, true)["properties"].(map[string]any) {
+					properties[embedded] = schema
+				}
+				continue
+			}
+			if name == "" {
+				name = field.Name
+			}
+			schema := openAPITypeSchema(field.Type, false)
+			if openAPIReadOnly[name] {
+				if _, isRef := schema["$ref"]; !isRef {
+					schema["readOnly"] = true
+				}
+			}
+			if values, isEnum := openAPIEnums[name]; isEnum {
+				schema["enum"] = values
+			}
+			if format, hasFormat := openAPIFormats[name]; hasFormat {
+				schema["description"] = format
+			}
+			properties[name] = schema
+		}
+		return map[string]any{"type": "object", "properties": properties}
+	}
+	return map[string]any{}
+}
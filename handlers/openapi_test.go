@@ -0,0 +1,150 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"fuzzy/config"
+	"fuzzy/models"
+)
+
+// openAPIDocument holds the parts of the OpenAPI document the tests check
+type openAPIDocument struct {
+	OpenAPI string `json:"openapi"`
+	Paths   map[string]map[string]struct {
+		Summary    string `json:"summary"`
+		Parameters []struct {
+			Name string `json:"name"`
+			In   string `json:"in"`
+		} `json:"parameters"`
+		Responses map[string]json.RawMessage `json:"responses"`
+	} `json:"paths"`
+	Components struct {
+		Schemas map[string]json.RawMessage `json:"schemas"`
+	} `json:"components"`
+}
+
+// newOpenAPITestHandler returns a handler without users, with the default
+// configuration. The configuration the test started with is put back when it
+// ends, so tests may change this one freely.
+func newOpenAPITestHandler(t *testing.T) *Handler {
+	t.Helper()
+	cfg, err := config.LoadConfig(filepath.Join(t.TempDir(), "config.cfg"))
+	if err != nil {
+		t.Fatalf("loading default configuration: %v", err)
+	}
+	previous := config.AppConfig
+	t.Cleanup(func() { config.AppConfig = previous })
+	config.AppConfig = cfg
+	return &Handler{Store: models.NewMemoryStore()}
+}
+
+// fetchOpenAPI requests the document the way clients do
+func fetchOpenAPI(t *testing.T, api http.Handler) (openAPIDocument, []byte) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	api.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/openapi.json", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("GET /api/openapi.json: status %d", rec.Code)
+	}
+
+	var doc openAPIDocument
+	if err := json.Unmarshal(rec.Body.Bytes(), &doc); err != nil {
+		t.Fatalf("decoding the OpenAPI document: %v", err)
+	}
+	if !strings.HasPrefix(doc.OpenAPI, "3.") {
+		t.Fatalf("openapi version %q, want 3.x", doc.OpenAPI)
+	}
+	return doc, rec.Body.Bytes()
+}
+
+// TestOpenAPIDescribesEveryRoute checks that every registered API route is
+// served and documented, with its path parameters
+func TestOpenAPIDescribesEveryRoute(t *testing.T) {
+	h := newOpenAPITestHandler(t)
+	api := h.APIHandler()
+	doc, _ := fetchOpenAPI(t, api)
+
+	for _, route := range h.apiRoutes() {
+		name := route.Method + " " + route.Path
+
+		// Without users, a registered route answers setup_required rather than 404 or 405
+		rec := httptest.NewRecorder()
+		api.ServeHTTP(rec, httptest.NewRequest(route.Method, apiPrefix+pathParamRe.ReplaceAllString(route.Path, "1"), nil))
+		if rec.Code != http.StatusServiceUnavailable {
+			t.Errorf("%s: status %d, want %d: route not registered", name, rec.Code, http.StatusServiceUnavailable)
+		}
+
+		operation, described := doc.Paths[route.Path][strings.ToLower(route.Method)]
+		if !described {
+			t.Errorf("%s is not described in the OpenAPI document", name)
+			continue
+		}
+		if _, documented := apiOperations[name]; !documented || operation.Summary == "" {
+			t.Errorf("%s has no entry in apiOperations", name)
+		}
+		if len(operation.Responses) == 0 {
+			t.Errorf("%s has no responses", name)
+		}
+
+		for _, match := range pathParamRe.FindAllStringSubmatch(route.Path, -1) {
+			found := false
+			for _, param := range operation.Parameters {
+				found = found || (param.In == "path" && param.Name == match[1])
+			}
+			if !found {
+				t.Errorf("%s does not describe path parameter %s", name, match[1])
+			}
+		}
+	}
+}
+
+// TestOpenAPIOperationsMatchRoutes checks that apiOperations documents no
+// route that does not exist
+func TestOpenAPIOperationsMatchRoutes(t *testing.T) {
+	h := newOpenAPITestHandler(t)
+	routes := make(map[string]bool)
+	for _, route := range h.apiRoutes() {
+		routes[route.Method+" "+route.Path] = true
+	}
+	for name := range apiOperations {
+		if !routes[name] {
+			t.Errorf("apiOperations documents %s, which is not a route", name)
+		}
+	}
+}
+
+// TestOpenAPISchemas checks that the entity schemas exist and that every
+// reference points to a schema of the document
+func TestOpenAPISchemas(t *testing.T) {
+	doc, raw := fetchOpenAPI(t, newOpenAPITestHandler(t).APIHandler())
+
+	for _, name := range []string{"Channel", "Provider", "Bouquet", "User", "Error"} {
+		if _, exists := doc.Components.Schemas[name]; !exists {
+			t.Errorf("schema %s is missing", name)
+		}
+	}
+
+	var user struct {
+		Properties map[string]json.RawMessage `json:"properties"`
+	}
+	if err := json.Unmarshal(doc.Components.Schemas["User"], &user); err != nil {
+		t.Fatalf("decoding the User schema: %v", err)
+	}
+	for _, secret := range []string{"Password", "TOTPSecret", "RecoveryCodes"} {
+		if _, exposed := user.Properties[secret]; exposed {
+			t.Errorf("User schema exposes %s", secret)
+		}
+	}
+
+	for _, match := range regexp.MustCompile(`"\$ref":"#/components/schemas/(\w+)"`).FindAllSubmatch(raw, -1) {
+		if _, exists := doc.Components.Schemas[string(match[1])]; !exists {
+			t.Errorf("reference to missing schema %s", match[1])
+		}
+	}
+}
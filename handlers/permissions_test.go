@@ -0,0 +1,40 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"fuzzy/models"
+)
+
+// TestRequirePermission checks the roles allowed to start channels
+func TestRequirePermission(t *testing.T) {
+	f := newAuthFixture(t)
+	page := f.h.RequireAuth(f.h.RequirePermission(models.PermChannelsControl, func(w http.ResponseWriter, r *http.Request) {
+		if !can(r, models.PermChannelsControl) {
+			t.Errorf("%s: page served without the permission", r.URL.Path)
+		}
+	}))
+
+	tests := []struct {
+		role   string
+		status int
+	}{
+		{models.RoleAdmin, http.StatusOK},
+		{models.RoleOperator, http.StatusOK},
+		{models.RoleViewer, http.StatusForbidden},
+	}
+	for _, test := range tests {
+		rec := httptest.NewRecorder()
+		page(rec, f.request(http.MethodPost, "/channel/start", test.role, ""))
+		if rec.Code != test.status {
+			t.Errorf("role %q: status %d, want %d", test.role, rec.Code, test.status)
+		}
+	}
+
+	// Without RequireAuth there is no user to grant anything to
+	if can(httptest.NewRequest(http.MethodGet, "/", nil), models.PermChannelsView) {
+		t.Errorf("can: permission granted to an anonymous request")
+	}
+}
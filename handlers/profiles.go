@@ -0,0 +1,174 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"fuzzy/models"
+	"fuzzy/validation"
+)
+
+// ProfilesHandler handles requests to the encoding profiles page, where
+// profiles shared by several channels are managed
+func (h *Handler) ProfilesHandler(w http.ResponseWriter, r *http.Request) {
+	var data models.ProfilesPageData
+	data.Title = "Fuzzy - Encoding Profiles"
+
+	switch r.Method {
+	case http.MethodGet:
+		h.handleGetProfiles(w, r, &data)
+	case http.MethodPost:
+		if !authorize(w, r, models.PermChannelsEdit) {
+			return
+		}
+		h.handlePostProfiles(w, r, &data)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+}
+
+func (h *Handler) handleGetProfiles(w http.ResponseWriter, r *http.Request, data *models.ProfilesPageData) {
+	data.Profiles = h.Store.GetAllProfiles()
+	data.Channels = h.Store.GetAllChannels()
+	renderProfilesTemplate(w, r, data)
+}
+
+func (h *Handler) handlePostProfiles(w http.ResponseWriter, r *http.Request, data *models.ProfilesPageData) {
+	if err := r.ParseForm(); err != nil {
+		data.Error = "Failed to parse form data"
+		data.Profiles = h.Store.GetAllProfiles()
+		data.Channels = h.Store.GetAllChannels()
+		renderProfilesTemplate(w, r, data)
+		return
+	}
+
+	action := r.FormValue("action")
+	switch action {
+	case "create":
+		h.handleCreateProfile(r, data)
+	case "update":
+		h.handleUpdateProfile(r, data)
+	case "delete":
+		h.handleDeleteProfile(r, data)
+	default:
+		data.Error = "Invalid action"
+	}
+
+	// Get updated profiles list
+	data.Profiles = h.Store.GetAllProfiles()
+	data.Channels = h.Store.GetAllChannels()
+	renderProfilesTemplate(w, r, data)
+}
+
+func (h *Handler) handleCreateProfile(r *http.Request, data *models.ProfilesPageData) {
+	profile, errs := profileFromForm(r)
+	errs = append(errs, validation.Profile(&profile, models.EncodingProfile{})...)
+	if len(errs) > 0 {
+		data.Error = errs.Error()
+		data.FieldErrors = formErrors("create", 0, errs)
+		data.Form = profile
+		return
+	}
+
+	if _, err := h.storeFor(r).CreateProfile(profile); err != nil {
+		log.Printf("Error creating encoding profile: %v", err)
+		data.Error = "Failed to create encoding profile"
+		return
+	}
+	data.Message = "Encoding profile created successfully"
+}
+
+func (h *Handler) handleUpdateProfile(r *http.Request, data *models.ProfilesPageData) {
+	id, err := strconv.Atoi(strings.TrimSpace(r.FormValue("id")))
+	if err != nil {
+		data.Error = "Invalid encoding profile ID"
+		return
+	}
+
+	version, err := strconv.Atoi(r.FormValue("version"))
+	if err != nil {
+		data.Error = "Invalid encoding profile version"
+		return
+	}
+
+	existing, exists := h.Store.GetProfile(id)
+	if !exists {
+		data.Error = "Encoding profile not found"
+		return
+	}
+
+	// Update profile, keeping the encoding settings left empty; the change
+	// applies to every channel using it
+	form, errs := profileFromForm(r)
+	errs = append(errs, validation.Profile(&form, existing)...)
+	if len(errs) > 0 {
+		data.Error = errs.Error()
+		data.FieldErrors = formErrors("update", id, errs)
+		return
+	}
+	updated := existing
+	updated.Name = form.Name
+	updated.Description = form.Description
+	updated.MediaSettings = form.MediaSettings
+	updated.Version = version
+
+	if err := h.storeFor(r).UpdateProfile(updated); err == nil {
+		data.Message = "Encoding profile updated successfully"
+	} else if msg, ok := conflictMessage(err); ok {
+		data.Error = msg
+		data.ConflictID = id
+	} else {
+		log.Printf("Error updating encoding profile %d: %v", id, err)
+		data.Error = "Failed to update encoding profile"
+	}
+}
+
+// profileFromForm reads the editable fields of an encoding profile from the
+// profile form, reporting the settings that do not parse
+func profileFromForm(r *http.Request) (models.EncodingProfile, validation.Errors) {
+	settings, errs := mediaSettingsFromForm(r, "")
+	profile := models.EncodingProfile{
+		Name:          r.FormValue("name"),
+		Description:   r.FormValue("description"),
+		MediaSettings: settings,
+	}
+	return profile, errs
+}
+
+// handleDeleteProfile deletes an encoding profile, which channels must no
+// longer use
+func (h *Handler) handleDeleteProfile(r *http.Request, data *models.ProfilesPageData) {
+	id, err := strconv.Atoi(strings.TrimSpace(r.FormValue("id")))
+	if err != nil {
+		data.Error = "Invalid encoding profile ID"
+	} else if err := h.storeFor(r).DeleteProfile(id); err == nil {
+		data.Message = "Encoding profile deleted successfully"
+	} else if errors.Is(err, models.ErrNotFound) {
+		data.Error = "Encoding profile not found"
+	} else if msg, ok := integrityMessage(err); ok {
+		data.Error = msg
+	} else {
+		log.Printf("Error deleting encoding profile %d: %v", id, err)
+		data.Error = "Failed to delete encoding profile"
+	}
+}
+
+func renderProfilesTemplate(w http.ResponseWriter, r *http.Request, data *models.ProfilesPageData) {
+	t, err := parseTemplate(w, r, "profiles.html")
+	if err != nil {
+		log.Printf("Error parsing template: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := t.Execute(w, data); err != nil {
+		log.Printf("Error executing template: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+}
@@ -1,118 +1,98 @@
 package handlers
 
 import (
-	"html/template"
+	"errors"
 	"log"
 	"net/http"
-	"path/filepath"
 	"strconv"
 	"strings"
 
 	"fuzzy/models"
+	"fuzzy/validation"
 )
 
 // ProvidersHandler handles requests to the main providers page with integrated bouquets
-func ProvidersHandler(w http.ResponseWriter, r *http.Request) {
+func (h *Handler) ProvidersHandler(w http.ResponseWriter, r *http.Request) {
 	var data models.ProvidersWithBouquetsPageData
 	data.Title = "Fuzzy - Providers & Bouquets"
 
 	switch r.Method {
 	case http.MethodGet:
-		handleGetProviders(w, r, &data)
+		h.handleGetProviders(w, r, &data)
 	case http.MethodPost:
-		handlePostProviders(w, r, &data)
+		if !authorize(w, r, models.PermProvidersEdit) {
+			return
+		}
+		h.handlePostProviders(w, r, &data)
 	default:
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 }
 
-func handleGetProviders(w http.ResponseWriter, r *http.Request, data *models.ProvidersWithBouquetsPageData) {
-	// Check if this is a delete request for provider
-	if deleteID := r.URL.Query().Get("delete-provider"); deleteID != "" {
-		id, err := strconv.Atoi(deleteID)
-		if err != nil {
-			data.Error = "Invalid provider ID"
-		} else if models.GlobalStore.DeleteProvider(id) {
-			data.Message = "Provider deleted successfully"
-		} else {
-			data.Error = "Provider not found"
-		}
-	}
-
-	// Check if this is a delete request for bouquet
-	if deleteID := r.URL.Query().Get("delete-bouquet"); deleteID != "" {
-		id, err := strconv.Atoi(deleteID)
-		if err != nil {
-			data.Error = "Invalid bouquet ID"
-		} else if models.GlobalStore.DeleteBouquet(id) {
-			data.Message = "Bouquet deleted successfully"
-		} else {
-			data.Error = "Bouquet not found"
-		}
-	}
-
+func (h *Handler) handleGetProviders(w http.ResponseWriter, r *http.Request, data *models.ProvidersWithBouquetsPageData) {
 	// Get all providers with their bouquets
-	data.Providers = models.GlobalStore.GetProvidersWithBouquets()
+	data.Providers = h.Store.GetProvidersWithBouquets()
+	data.Channels = h.Store.GetAllChannels()
 
 	// Render template
-	renderProvidersTemplate(w, data)
+	renderProvidersTemplate(w, r, data)
 }
 
-func handlePostProviders(w http.ResponseWriter, r *http.Request, data *models.ProvidersWithBouquetsPageData) {
+func (h *Handler) handlePostProviders(w http.ResponseWriter, r *http.Request, data *models.ProvidersWithBouquetsPageData) {
 	if err := r.ParseForm(); err != nil {
 		data.Error = "Failed to parse form data"
-		data.Providers = models.GlobalStore.GetProvidersWithBouquets()
-		renderProvidersTemplate(w, data)
+		data.Providers = h.Store.GetProvidersWithBouquets()
+		data.Channels = h.Store.GetAllChannels()
+		renderProvidersTemplate(w, r, data)
 		return
 	}
 
 	action := r.FormValue("action")
 	switch action {
 	case "create-provider":
-		handleCreateProvider(r, data)
+		h.handleCreateProvider(r, data)
 	case "update-provider":
-		handleUpdateProvider(r, data)
+		h.handleUpdateProvider(r, data)
 	case "create-bouquet":
-		handleCreateBouquet(r, data)
+		h.handleCreateBouquet(r, data)
 	case "update-bouquet":
-		handleUpdateBouquet(r, data)
+		h.handleUpdateBouquet(r, data)
+	case "delete-provider":
+		h.handleDeleteProvider(r, data)
+	case "delete-bouquet":
+		h.handleDeleteBouquet(r, data)
+	case "add-channel":
+		h.handleAddBouquetChannel(r, data)
+	case "remove-channel":
+		h.handleRemoveBouquetChannel(r, data)
 	default:
 		data.Error = "Invalid action"
 	}
 
 	// Get updated providers list
-	data.Providers = models.GlobalStore.GetProvidersWithBouquets()
-	renderProvidersTemplate(w, data)
+	data.Providers = h.Store.GetProvidersWithBouquets()
+	data.Channels = h.Store.GetAllChannels()
+	renderProvidersTemplate(w, r, data)
 }
 
-func handleCreateProvider(r *http.Request, data *models.ProvidersWithBouquetsPageData) {
-	name := strings.TrimSpace(r.FormValue("name"))
-	description := strings.TrimSpace(r.FormValue("description"))
-	url := strings.TrimSpace(r.FormValue("url"))
-	apiKey := strings.TrimSpace(r.FormValue("api_key"))
-	active := r.FormValue("active") == "on"
-	
-	// Validate input
-	if name == "" {
-		data.Error = "Provider name is required"
+func (h *Handler) handleCreateProvider(r *http.Request, data *models.ProvidersWithBouquetsPageData) {
+	provider := providerFromForm(r)
+	if errs := validation.Provider(&provider); len(errs) > 0 {
+		data.Error = errs.Error()
+		data.FieldErrors = formErrors("create-provider", 0, errs)
 		return
 	}
 
-	// Create provider
-	provider := models.Provider{
-		Name:        name,
-		Description: description,
-		URL:         url,
-		APIKey:      apiKey,
-		Active:      active,
+	if _, err := h.storeFor(r).CreateProvider(provider); err != nil {
+		log.Printf("Error creating provider: %v", err)
+		data.Error = "Failed to create provider"
+		return
 	}
-
-	models.GlobalStore.CreateProvider(provider)
 	data.Message = "Provider created successfully"
 }
 
-func handleUpdateProvider(r *http.Request, data *models.ProvidersWithBouquetsPageData) {
+func (h *Handler) handleUpdateProvider(r *http.Request, data *models.ProvidersWithBouquetsPageData) {
 	idStr := strings.TrimSpace(r.FormValue("id"))
 	id, err := strconv.Atoi(idStr)
 	if err != nil {
@@ -120,158 +100,259 @@ func handleUpdateProvider(r *http.Request, data *models.ProvidersWithBouquetsPag
 		return
 	}
 
+	version, err := strconv.Atoi(r.FormValue("version"))
+	if err != nil {
+		data.Error = "Invalid provider version"
+		return
+	}
+
 	// Get existing provider
-	existing, exists := models.GlobalStore.GetProvider(id)
+	existing, exists := h.Store.GetProvider(id)
 	if !exists {
 		data.Error = "Provider not found"
 		return
 	}
 
-	name := strings.TrimSpace(r.FormValue("name"))
-	description := strings.TrimSpace(r.FormValue("description"))
-	url := strings.TrimSpace(r.FormValue("url"))
-	apiKey := strings.TrimSpace(r.FormValue("api_key"))
-	active := r.FormValue("active") == "on"
-
-	// Validate input
-	if name == "" {
-		data.Error = "Provider name is required"
+	form := providerFromForm(r)
+	if errs := validation.Provider(&form); len(errs) > 0 {
+		data.Error = errs.Error()
+		data.FieldErrors = formErrors("update-provider", id, errs)
 		return
 	}
 
 	// Update provider
 	updated := existing
-	updated.Name = name
-	updated.Description = description
-	updated.URL = url
-	updated.APIKey = apiKey
-	updated.Active = active
-
-	if models.GlobalStore.UpdateProvider(updated) {
+	updated.Name = form.Name
+	updated.Description = form.Description
+	updated.URL = form.URL
+	updated.APIKey = form.APIKey
+	updated.Active = form.Active
+	updated.Version = version
+
+	if err := h.storeFor(r).UpdateProvider(updated); err == nil {
 		data.Message = "Provider updated successfully"
+	} else if msg, ok := conflictMessage(err); ok {
+		data.Error = msg
+		data.ConflictProviderID = id
 	} else {
+		log.Printf("Error updating provider %d: %v", id, err)
 		data.Error = "Failed to update provider"
 	}
 }
 
-func handleCreateBouquet(r *http.Request, data *models.ProvidersWithBouquetsPageData) {
-	name := strings.TrimSpace(r.FormValue("name"))
-	description := strings.TrimSpace(r.FormValue("description"))
-	providerIDStr := strings.TrimSpace(r.FormValue("provider_id"))
-	
-	// Validate input
-	if name == "" {
-		data.Error = "Bouquet name is required"
-		return
-	}
-	
-	providerID, err := strconv.Atoi(providerIDStr)
+func (h *Handler) handleCreateBouquet(r *http.Request, data *models.ProvidersWithBouquetsPageData) {
+	providerID, err := strconv.Atoi(strings.TrimSpace(r.FormValue("provider_id")))
 	if err != nil {
 		data.Error = "Invalid provider ID"
 		return
 	}
 
-	// Parse channels from form data
-	var channels []models.Channel
-	
-	// Get channel data from form - we'll implement multiple channels later
-	channelName := strings.TrimSpace(r.FormValue("channel_name"))
-	channelManifest := strings.TrimSpace(r.FormValue("channel_manifest"))
-	channelKeyKid := strings.TrimSpace(r.FormValue("channel_keykid"))
-	videoCodec := strings.TrimSpace(r.FormValue("channel_video_codec"))
-	audioCodec := strings.TrimSpace(r.FormValue("channel_audio_codec"))
-	resolution := strings.TrimSpace(r.FormValue("channel_resolution"))
-	videoBitrate := strings.TrimSpace(r.FormValue("channel_video_bitrate"))
-	audioBitrate := strings.TrimSpace(r.FormValue("channel_audio_bitrate"))
-	quality := strings.TrimSpace(r.FormValue("channel_quality"))
-	
-	if channelName != "" && channelManifest != "" && channelKeyKid != "" {
-		// Set defaults for video encoding fields
-		if videoCodec == "" {
-			videoCodec = "x265"
-		}
-		if audioCodec == "" {
-			audioCodec = "AAC"
-		}
-		if resolution == "" {
-			resolution = "1080p"
+	bouquet := models.Bouquet{
+		Name:        r.FormValue("name"),
+		Description: r.FormValue("description"),
+	}
+	if errs := validation.Bouquet(&bouquet); len(errs) > 0 {
+		data.Error = errs.Error()
+		data.FieldErrors = formErrors("create-bouquet", providerID, errs)
+		return
+	}
+
+	if _, exists := h.Store.GetProvider(providerID); !exists {
+		data.Error = "Provider not found"
+		return
+	}
+
+	// Existing channels selected for the bouquet
+	var channelIDs []int
+	for _, idStr := range r.Form["channel_ids"] {
+		channelID, err := strconv.Atoi(idStr)
+		if err != nil {
+			data.Error = "Invalid channel ID"
+			return
 		}
-		if videoBitrate == "" {
-			videoBitrate = "5000k"
+		channelIDs = append(channelIDs, channelID)
+	}
+	
+	// Optional new channel created together with the bouquet, with the same
+	// rules and defaults as on the channels page
+	var newChannel *models.Channel
+	channel := models.Channel{
+		Name:     r.FormValue("channel_name"),
+		Manifest: r.FormValue("channel_manifest"),
+		KeyKid:   r.FormValue("channel_keykid"),
+		Quality:  r.FormValue("channel_quality"),
+	}
+	if strings.TrimSpace(channel.Name) != "" && strings.TrimSpace(channel.Manifest) != "" && strings.TrimSpace(channel.KeyKid) != "" {
+		settings, errs := mediaSettingsFromForm(r, "channel_")
+		channel.MediaSettings = settings
+		errs = append(errs, validation.Channel(&channel, models.Channel{})...)
+		if len(errs) > 0 {
+			data.Error = errs.Error()
+			return
 		}
-		if audioBitrate == "" {
-			audioBitrate = "128k"
+		newChannel = &channel
+	}
+
+	// Create the new channel and the bouquet together, so a failure leaves neither behind
+	err = h.storeFor(r).Update(func(tx models.Tx) error {
+		if newChannel != nil {
+			channel, err := tx.CreateChannel(*newChannel)
+			if err != nil {
+				return err
+			}
+			channelIDs = append(channelIDs, channel.ID)
 		}
-		if quality == "" {
-			quality = "High"
+		bouquet.ProviderID = providerID
+		bouquet.ChannelIDs = channelIDs
+		_, err := tx.CreateBouquet(bouquet)
+		return err
+	})
+	if err != nil {
+		if msg, ok := integrityMessage(err); ok {
+			data.Error = msg
+			return
 		}
-		
-		channels = append(channels, models.Channel{
-			Name:         channelName,
-			Manifest:     channelManifest,
-			KeyKid:       channelKeyKid,
-			VideoCodec:   videoCodec,
-			AudioCodec:   audioCodec,
-			Resolution:   resolution,
-			VideoBitrate: videoBitrate,
-			AudioBitrate: audioBitrate,
-			Quality:      quality,
-			Running:      false,
-			RemuxPort:    0,
-		})
-	}
-
-	// Create bouquet
-	bouquet := models.Bouquet{
-		Name:        name,
-		Description: description,
-		ProviderID:  providerID,
-		Channels:    channels,
+		log.Printf("Error creating bouquet: %v", err)
+		data.Error = "Failed to create bouquet"
+		return
 	}
-
-	models.GlobalStore.CreateBouquet(bouquet)
 	data.Message = "Bouquet created successfully"
 }
 
-func handleUpdateBouquet(r *http.Request, data *models.ProvidersWithBouquetsPageData) {
-	idStr := strings.TrimSpace(r.FormValue("id"))
-	id, err := strconv.Atoi(idStr)
+func (h *Handler) handleDeleteProvider(r *http.Request, data *models.ProvidersWithBouquetsPageData) {
+	id, err := strconv.Atoi(strings.TrimSpace(r.FormValue("id")))
+	if err != nil {
+		data.Error = "Invalid provider ID"
+	} else if err := h.storeFor(r).DeleteProvider(id); err == nil {
+		data.Message = "Provider deleted successfully"
+	} else if errors.Is(err, models.ErrNotFound) {
+		data.Error = "Provider not found"
+	} else if msg, ok := integrityMessage(err); ok {
+		data.Error = msg
+	} else {
+		log.Printf("Error deleting provider %d: %v", id, err)
+		data.Error = "Failed to delete provider"
+	}
+}
+
+func (h *Handler) handleDeleteBouquet(r *http.Request, data *models.ProvidersWithBouquetsPageData) {
+	id, err := strconv.Atoi(strings.TrimSpace(r.FormValue("id")))
 	if err != nil {
 		data.Error = "Invalid bouquet ID"
+	} else if err := h.storeFor(r).DeleteBouquet(id); err == nil {
+		data.Message = "Bouquet deleted successfully"
+	} else if errors.Is(err, models.ErrNotFound) {
+		data.Error = "Bouquet not found"
+	} else {
+		log.Printf("Error deleting bouquet %d: %v", id, err)
+		data.Error = "Failed to delete bouquet"
+	}
+}
+
+func (h *Handler) handleAddBouquetChannel(r *http.Request, data *models.ProvidersWithBouquetsPageData) {
+	bouquetID, err := strconv.Atoi(strings.TrimSpace(r.FormValue("bouquet_id")))
+	if err != nil {
+		data.Error = "Invalid bouquet ID"
+		return
+	}
+	channelID, err := strconv.Atoi(strings.TrimSpace(r.FormValue("channel_id")))
+	if err != nil {
+		data.Error = "Invalid channel ID"
 		return
 	}
 
-	// Get existing bouquet
-	existing, exists := models.GlobalStore.GetBouquet(id)
-	if !exists {
+	if err := h.storeFor(r).AddChannelToBouquet(bouquetID, channelID); err == nil {
+		data.Message = "Channel added to bouquet"
+	} else if errors.Is(err, models.ErrNotFound) {
 		data.Error = "Bouquet not found"
+	} else if msg, ok := integrityMessage(err); ok {
+		data.Error = msg
+	} else {
+		log.Printf("Error adding channel %d to bouquet %d: %v", channelID, bouquetID, err)
+		data.Error = "Failed to add channel to bouquet"
+	}
+}
+
+func (h *Handler) handleRemoveBouquetChannel(r *http.Request, data *models.ProvidersWithBouquetsPageData) {
+	bouquetID, err := strconv.Atoi(strings.TrimSpace(r.FormValue("bouquet_id")))
+	if err != nil {
+		data.Error = "Invalid bouquet ID"
+		return
+	}
+	channelID, err := strconv.Atoi(strings.TrimSpace(r.FormValue("channel_id")))
+	if err != nil {
+		data.Error = "Invalid channel ID"
+		return
+	}
+
+	if err := h.storeFor(r).RemoveChannelFromBouquet(bouquetID, channelID); err == nil {
+		data.Message = "Channel removed from bouquet"
+	} else if errors.Is(err, models.ErrNotFound) {
+		data.Error = "Channel is not in this bouquet"
+	} else {
+		log.Printf("Error removing channel %d from bouquet %d: %v", channelID, bouquetID, err)
+		data.Error = "Failed to remove channel from bouquet"
+	}
+}
+
+func (h *Handler) handleUpdateBouquet(r *http.Request, data *models.ProvidersWithBouquetsPageData) {
+	idStr := strings.TrimSpace(r.FormValue("id"))
+	id, err := strconv.Atoi(idStr)
+	if err != nil {
+		data.Error = "Invalid bouquet ID"
 		return
 	}
 
-	name := strings.TrimSpace(r.FormValue("name"))
-	description := strings.TrimSpace(r.FormValue("description"))
+	version, err := strconv.Atoi(r.FormValue("version"))
+	if err != nil {
+		data.Error = "Invalid bouquet version"
+		return
+	}
 
-	// Validate input
-	if name == "" {
-		data.Error = "Bouquet name is required"
+	// Get existing bouquet
+	existing, exists := h.Store.GetBouquet(id)
+	if !exists {
+		data.Error = "Bouquet not found"
 		return
 	}
 
 	// Update bouquet (keeping existing channels for now)
 	updated := existing
-	updated.Name = name
-	updated.Description = description
+	updated.Name = r.FormValue("name")
+	updated.Description = r.FormValue("description")
+	updated.Version = version
+	if errs := validation.Bouquet(&updated); len(errs) > 0 {
+		data.Error = errs.Error()
+		data.FieldErrors = formErrors("update-bouquet", id, errs)
+		return
+	}
 
-	if models.GlobalStore.UpdateBouquet(updated) {
+	if err := h.storeFor(r).UpdateBouquet(updated); err == nil {
 		data.Message = "Bouquet updated successfully"
+	} else if msg, ok := conflictMessage(err); ok {
+		data.Error = msg
+		data.ConflictBouquetID = id
+	} else if msg, ok := integrityMessage(err); ok {
+		data.Error = msg
 	} else {
+		log.Printf("Error updating bouquet %d: %v", id, err)
 		data.Error = "Failed to update bouquet"
 	}
 }
 
-func renderProvidersTemplate(w http.ResponseWriter, data *models.ProvidersWithBouquetsPageData) {
-	tmplPath := filepath.Join("templates", "providers.html")
-	t, err := template.ParseFiles(tmplPath)
+// providerFromForm reads the editable fields of a provider from the provider form
+func providerFromForm(r *http.Request) models.Provider {
+	return models.Provider{
+		Name:        r.FormValue("name"),
+		Description: r.FormValue("description"),
+		URL:         r.FormValue("url"),
+		APIKey:      r.FormValue("api_key"),
+		Active:      r.FormValue("active") == "on",
+	}
+}
+
+func renderProvidersTemplate(w http.ResponseWriter, r *http.Request, data *models.ProvidersWithBouquetsPageData) {
+	t, err := parseTemplate(w, r, "providers.html")
 	if err != nil {
 		log.Printf("Error parsing template: %v", err)
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
@@ -287,7 +368,7 @@ func renderProvidersTemplate(w http.ResponseWriter, data *models.ProvidersWithBo
 }
 
 // ChannelStartHandler handles channel start requests
-func ChannelStartHandler(w http.ResponseWriter, r *http.Request) {
+func (h *Handler) ChannelStartHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
@@ -300,21 +381,27 @@ func ChannelStartHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	port, success := models.GlobalStore.StartChannel(channelID)
-	if !success {
-		http.Error(w, "Failed to start channel", http.StatusInternalServerError)
+	port, err := h.Supervisor.Start(channelID, actorOf(r))
+	if errors.Is(err, models.ErrNotFound) {
+		http.Error(w, "Channel not found", http.StatusNotFound)
+		return
+	}
+	if msg, ok := transitionMessage(err); ok {
+		http.Error(w, msg, http.StatusConflict)
+		return
+	}
+	if err != nil {
+		log.Printf("Error starting channel %d: %v", channelID, err)
+		http.Error(w, startFailure(err), http.StatusServiceUnavailable)
 		return
 	}
-
-	// Update channel in bouquets
-	models.GlobalStore.UpdateChannelInBouquet(channelID)
 
 	log.Printf("Channel %d started on port %d", channelID, port)
-	http.Redirect(w, r, "/providers", http.StatusSeeOther)
+	redirectBack(w, r, "/providers")
 }
 
 // ChannelStopHandler handles channel stop requests
-func ChannelStopHandler(w http.ResponseWriter, r *http.Request) {
+func (h *Handler) ChannelStopHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
@@ -327,15 +414,21 @@ func ChannelStopHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	success := models.GlobalStore.StopChannel(channelID)
-	if !success {
+	err = h.Supervisor.Stop(channelID, actorOf(r))
+	if errors.Is(err, models.ErrNotFound) {
+		http.Error(w, "Channel not found", http.StatusNotFound)
+		return
+	}
+	if msg, ok := transitionMessage(err); ok {
+		http.Error(w, msg, http.StatusConflict)
+		return
+	}
+	if err != nil {
+		log.Printf("Error stopping channel %d: %v", channelID, err)
 		http.Error(w, "Failed to stop channel", http.StatusInternalServerError)
 		return
 	}
 
-	// Update channel in bouquets
-	models.GlobalStore.UpdateChannelInBouquet(channelID)
-
 	log.Printf("Channel %d stopped", channelID)
-	http.Redirect(w, r, "/providers", http.StatusSeeOther)
+	redirectBack(w, r, "/providers")
 }
\ No newline at end of file
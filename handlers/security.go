@@ -1,6 +1,7 @@
 package handlers
 
 import (
+	"context"
 	"crypto/rand"
 	"encoding/base64"
 	"fmt"
@@ -9,7 +10,11 @@ import (
 	"fuzzy/config"
 )
 
-// SecurityMiddleware adds security headers to responses
+// cspNonceKey is the request context key of the CSP nonce
+type cspNonceKey struct{}
+
+// SecurityMiddleware adds security headers to responses. The CSP only allows
+// scripts carrying the per-request nonce, which templates get from cspNonce.
 func SecurityMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// Generate nonce for CSP
@@ -40,13 +45,19 @@ func SecurityMiddleware(next http.Handler) http.Handler {
 			w.Header().Set("Strict-Transport-Security", "max-age=31536000; includeSubDomains")
 		}
 		
-		// Store nonce in request context for templates if needed
-		r = r.WithContext(r.Context())
+		// Store nonce in request context for templates
+		r = r.WithContext(context.WithValue(r.Context(), cspNonceKey{}, nonce))
 		
 		next.ServeHTTP(w, r)
 	})
 }
 
+// cspNonce returns the CSP nonce of the request, or "" outside SecurityMiddleware
+func cspNonce(r *http.Request) string {
+	nonce, _ := r.Context().Value(cspNonceKey{}).(string)
+	return nonce
+}
+
 // generateNonce creates a cryptographically secure nonce for CSP
 func generateNonce() string {
 	bytes := make([]byte, 16)
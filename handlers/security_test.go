@@ -0,0 +1,52 @@
+package handlers
+
+import (
+	"html"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+)
+
+var (
+	cspNonceRe    = regexp.MustCompile(`script-src [^;]*'nonce-([^']+)'`)
+	scriptTagRe   = regexp.MustCompile(`<script\b[^>]*>`)
+	scriptNonceRe = regexp.MustCompile(`\bnonce="([^"]*)"`)
+)
+
+// TestCSPNonce checks that the scripts of a page carry the nonce of its
+// Content-Security-Policy header, which changes on every request
+func TestCSPNonce(t *testing.T) {
+	f := newAuthFixture(t)
+	t.Chdir("..") // Templates are read from the working directory
+	routes := f.h.Routes()
+
+	var nonces []string
+	for range 2 {
+		rec := httptest.NewRecorder()
+		routes.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/login", nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("GET /login: status %d", rec.Code)
+		}
+		match := cspNonceRe.FindStringSubmatch(rec.Header().Get("Content-Security-Policy"))
+		if match == nil {
+			t.Fatalf("no script nonce in Content-Security-Policy %q", rec.Header().Get("Content-Security-Policy"))
+		}
+		nonce := match[1]
+
+		scripts := scriptTagRe.FindAllString(rec.Body.String(), -1)
+		if len(scripts) == 0 {
+			t.Fatalf("no <script> in the login page")
+		}
+		for _, script := range scripts {
+			attribute := scriptNonceRe.FindStringSubmatch(script)
+			if attribute == nil || html.UnescapeString(attribute[1]) != nonce {
+				t.Errorf("%s: want nonce %q from the Content-Security-Policy", script, nonce)
+			}
+		}
+		nonces = append(nonces, nonce)
+	}
+	if nonces[0] == nonces[1] {
+		t.Errorf("nonce %q reused by the next request", nonces[0])
+	}
+}
@@ -0,0 +1,372 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"fuzzy/models"
+)
+
+const (
+	// sessionTouchInterval is the resolution of Session.LastSeen. Recording it
+	// at most once a minute keeps busy sessions from writing to the store on
+	// every request.
+	sessionTouchInterval = time.Minute
+
+	// sessionSweepInterval is how often expired sessions are removed
+	sessionSweepInterval = 5 * time.Minute
+
+	// maxUserAgentLength bounds the user agent recorded with a session
+	maxUserAgentLength = 256
+)
+
+// SessionManager keeps the logged-in sessions and expires them once they
+// reach their absolute lifetime or stay unused for the idle timeout. When
+// given a store, it mirrors every session to it so logins survive restarts.
+// Sessions are identified by the hash of their cookie (see sessionIDFor), so
+// the store never holds a value that signs in.
+type SessionManager struct {
+	mutex    sync.Mutex
+	sessions map[string]models.Session
+	store    models.Repository // Nil when sessions are kept in memory only
+	lifetime time.Duration
+	idle     time.Duration
+	now      func() time.Time // time.Now, replaced by tests
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+// NewSessionManager loads the unexpired sessions of store, which may be nil,
+// and starts the background sweeper. Call Close to stop it.
+func NewSessionManager(store models.Repository, lifetime, idle time.Duration) *SessionManager {
+	m := &SessionManager{
+		sessions: make(map[string]models.Session),
+		store:    store,
+		lifetime: lifetime,
+		idle:     idle,
+		now:      time.Now,
+		stop:     make(chan struct{}),
+	}
+
+	if store != nil {
+		now := m.now()
+		for _, session := range store.GetAllSessions() {
+			if m.expired(session, now) {
+				m.forget(session.ID)
+				continue
+			}
+			m.sessions[session.ID] = session
+		}
+	}
+
+	go m.sweepEvery(sessionSweepInterval)
+	return m
+}
+
+// Create starts a new session for the user, recording the client it was
+// started from. It returns the session and the token for its cookie.
+func (m *SessionManager) Create(userID int, clientIP, userAgent string) (models.Session, string, error) {
+	if len(userAgent) > maxUserAgentLength {
+		userAgent = userAgent[:maxUserAgentLength]
+	}
+
+	token := generateSessionID()
+	now := m.now()
+	session := models.Session{
+		ID:        sessionIDFor(token),
+		UserID:    userID,
+		ClientIP:  clientIP,
+		UserAgent: userAgent,
+		CreatedAt: now,
+		LastSeen:  now,
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if m.store != nil {
+		if err := m.store.SaveSession(session); err != nil {
+			return models.Session{}, "", err
+		}
+	}
+	m.sessions[session.ID] = session
+	return session, token, nil
+}
+
+// Get returns the session with the given ID and records that it was used.
+// Unknown and expired sessions report false; expired ones are removed.
+func (m *SessionManager) Get(id string) (models.Session, bool) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	session, exists := m.sessions[id]
+	if !exists {
+		return models.Session{}, false
+	}
+
+	now := m.now()
+	if m.expired(session, now) {
+		delete(m.sessions, id)
+		m.forget(id)
+		return models.Session{}, false
+	}
+
+	if now.Sub(session.LastSeen) >= sessionTouchInterval {
+		session.LastSeen = now
+		m.sessions[id] = session
+		if m.store != nil {
+			if err := m.store.SaveSession(session); err != nil {
+				log.Printf("Error saving session of user %d: %v", session.UserID, err)
+			}
+		}
+	}
+	return session, true
+}
+
+// Active reports whether the session with the given ID exists and has not
+// expired, without recording that it was used
+func (m *SessionManager) Active(id string) bool {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	session, exists := m.sessions[id]
+	return exists && !m.expired(session, m.now())
+}
+
+// Delete ends a session. Unknown IDs are ignored.
+func (m *SessionManager) Delete(id string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	delete(m.sessions, id)
+	m.forget(id)
+}
+
+// List returns the unexpired sessions, most recently used first. A positive
+// userID restricts the list to the sessions of that user.
+func (m *SessionManager) List(userID int) []models.Session {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	now := m.now()
+	var sessions []models.Session
+	for _, session := range m.sessions {
+		if m.expired(session, now) || (userID > 0 && session.UserID != userID) {
+			continue
+		}
+		sessions = append(sessions, session)
+	}
+	slices.SortFunc(sessions, func(a, b models.Session) int {
+		return b.LastSeen.Compare(a.LastSeen)
+	})
+	return sessions
+}
+
+// DeleteUser ends every session of the user and returns how many were ended
+func (m *SessionManager) DeleteUser(userID int) int {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	removed := 0
+	for id, session := range m.sessions {
+		if session.UserID == userID {
+			delete(m.sessions, id)
+			m.forget(id)
+			removed++
+		}
+	}
+	return removed
+}
+
+// Sweep removes every expired session and returns how many were removed
+func (m *SessionManager) Sweep() int {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	now := m.now()
+	removed := 0
+	for id, session := range m.sessions {
+		if m.expired(session, now) {
+			delete(m.sessions, id)
+			m.forget(id)
+			removed++
+		}
+	}
+	return removed
+}
+
+// Close stops the background sweeper
+func (m *SessionManager) Close() {
+	m.stopOnce.Do(func() { close(m.stop) })
+}
+
+// sweepEvery runs Sweep at every interval until Close is called
+func (m *SessionManager) sweepEvery(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if removed := m.Sweep(); removed > 0 {
+				log.Printf("Removed %d expired session(s)", removed)
+			}
+		case <-m.stop:
+			return
+		}
+	}
+}
+
+// expired reports whether the session has outlived its lifetime or its idle timeout
+func (m *SessionManager) expired(session models.Session, now time.Time) bool {
+	return now.Sub(session.CreatedAt) >= m.lifetime || now.Sub(session.LastSeen) >= m.idle
+}
+
+// forget deletes a session from the store, if any
+func (m *SessionManager) forget(id string) {
+	if m.store == nil {
+		return
+	}
+	if err := m.store.DeleteSession(id); err != nil && !errors.Is(err, models.ErrNotFound) {
+		log.Printf("Error deleting session: %v", err)
+	}
+}
+
+// sessionIDFor returns the ID of the session whose cookie holds token. Tokens
+// are random, so a fast hash is enough to make a leaked store useless.
+func sessionIDFor(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// sessionKey identifies a session on the sessions page with a value shorter
+// than its ID
+func sessionKey(id string) string {
+	sum := sha256.Sum256([]byte(id))
+	return hex.EncodeToString(sum[:8])
+}
+
+// SessionsHandler shows the active sessions of the current user, or of every
+// user for administrators, and revokes them
+func (h *Handler) SessionsHandler(w http.ResponseWriter, r *http.Request) {
+	if rejectAPIToken(w, r) {
+		return
+	}
+
+	var data models.SessionsPageData
+	data.Title = "Fuzzy - Sessions"
+
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		if responded := h.handlePostSessions(w, r, &data); responded {
+			return
+		}
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	data.Sessions = h.sessionInfos(r)
+	renderSessionsTemplate(w, r, &data)
+}
+
+// handlePostSessions applies a revoke action. It reports whether it already
+// responded, which happens when the current session itself was revoked.
+func (h *Handler) handlePostSessions(w http.ResponseWriter, r *http.Request, data *models.SessionsPageData) bool {
+	if err := r.ParseForm(); err != nil {
+		data.Error = "Error parsing form data"
+		return false
+	}
+
+	user, _ := currentUser(r)
+	current := currentSessionID(r)
+
+	switch r.FormValue("action") {
+	case "revoke":
+		key := r.FormValue("key")
+		for _, session := range h.visibleSessions(user) {
+			if sessionKey(session.ID) != key {
+				continue
+			}
+			h.Sessions.Delete(session.ID)
+			if session.ID == current {
+				clearSessionCookie(w)
+				http.Redirect(w, r, "/login", http.StatusSeeOther)
+				return true
+			}
+			data.Message = "Session revoked"
+			return false
+		}
+		data.Error = "Session not found"
+	case "revoke_others":
+		revoked := 0
+		for _, session := range h.Sessions.List(user.ID) {
+			if session.ID != current {
+				h.Sessions.Delete(session.ID)
+				revoked++
+			}
+		}
+		data.Message = fmt.Sprintf("Signed out %d other session(s)", revoked)
+	default:
+		data.Error = "Invalid action"
+	}
+	return false
+}
+
+// visibleSessions returns the sessions the user may see and revoke: every
+// session for administrators, their own otherwise
+func (h *Handler) visibleSessions(user models.User) []models.Session {
+	if user.Can(models.PermUsersManage) {
+		return h.Sessions.List(0)
+	}
+	return h.Sessions.List(user.ID)
+}
+
+// sessionInfos describes the sessions visible to the current user
+func (h *Handler) sessionInfos(r *http.Request) []models.SessionInfo {
+	user, _ := currentUser(r)
+	current := currentSessionID(r)
+
+	usernames := make(map[int]string)
+	for _, u := range h.Store.GetAllUsers() {
+		usernames[u.ID] = u.Username
+	}
+
+	var infos []models.SessionInfo
+	for _, session := range h.visibleSessions(user) {
+		infos = append(infos, models.SessionInfo{
+			Key:       sessionKey(session.ID),
+			UserID:    session.UserID,
+			Username:  usernames[session.UserID],
+			ClientIP:  session.ClientIP,
+			UserAgent: session.UserAgent,
+			CreatedAt: session.CreatedAt,
+			LastSeen:  session.LastSeen,
+			Current:   session.ID == current,
+		})
+	}
+	return infos
+}
+
+func renderSessionsTemplate(w http.ResponseWriter, r *http.Request, data *models.SessionsPageData) {
+	t, err := parseTemplate(w, r, "sessions.html")
+	if err != nil {
+		log.Printf("Error parsing sessions template: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := t.Execute(w, data); err != nil {
+		log.Printf("Error executing sessions template: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,256 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"fuzzy/config"
+	"fuzzy/models"
+)
+
+// testClock is a clock that only moves when told to
+type testClock struct{ time time.Time }
+
+func (c *testClock) now() time.Time { return c.time }
+
+func (c *testClock) advance(d time.Duration) { c.time = c.time.Add(d) }
+
+// newTestSessionManager returns a manager with a 2-hour lifetime and a
+// 30-minute idle timeout, on a test clock starting now. Sessions of store are
+// loaded at the real time.
+func newTestSessionManager(t *testing.T, store models.Repository) (*SessionManager, *testClock) {
+	t.Helper()
+	clock := &testClock{time: time.Now()}
+	m := NewSessionManager(store, 2*time.Hour, 30*time.Minute)
+	m.now = clock.now
+	t.Cleanup(m.Close)
+	return m, clock
+}
+
+// TestSessionExpiry checks that a session ends at its absolute lifetime even
+// when used, and after the idle timeout when not
+func TestSessionExpiry(t *testing.T) {
+	tests := []struct {
+		name  string
+		uses  []time.Duration // Time between uses, the last one checking the session
+		valid bool
+	}{
+		{"fresh", []time.Duration{0}, true},
+		{"used within the idle timeout", []time.Duration{29 * time.Minute}, true},
+		{"idle", []time.Duration{30 * time.Minute}, false},
+		{"kept alive", []time.Duration{25 * time.Minute, 25 * time.Minute, 25 * time.Minute, 25 * time.Minute}, true},
+		{"kept alive past its lifetime", []time.Duration{25 * time.Minute, 25 * time.Minute, 25 * time.Minute, 25 * time.Minute, 25 * time.Minute}, false},
+	}
+	for _, test := range tests {
+		m, clock := newTestSessionManager(t, nil)
+		session, _, err := m.Create(1, "192.0.2.1", "test")
+		if err != nil {
+			t.Fatalf("Create: %v", err)
+		}
+		valid := true
+		for _, wait := range test.uses {
+			clock.advance(wait)
+			_, valid = m.Get(session.ID)
+		}
+		if valid != test.valid {
+			t.Errorf("%s: valid %v, want %v", test.name, valid, test.valid)
+		}
+		if active := m.Active(session.ID); active != test.valid {
+			t.Errorf("%s: active %v, want %v", test.name, active, test.valid)
+		}
+	}
+}
+
+// TestSessionActiveDoesNotTouch checks that checking a session, as open event
+// streams do, does not keep it from going idle
+func TestSessionActiveDoesNotTouch(t *testing.T) {
+	m, clock := newTestSessionManager(t, nil)
+	session, _, _ := m.Create(1, "192.0.2.1", "test")
+	for range 3 {
+		clock.advance(10 * time.Minute)
+		m.Active(session.ID)
+	}
+	if _, valid := m.Get(session.ID); valid {
+		t.Errorf("session idle for 30 minutes still valid")
+	}
+}
+
+// TestSessionSweep checks that expired sessions are removed from the manager
+// and its store, by Sweep and by the background sweeper
+func TestSessionSweep(t *testing.T) {
+	store := models.NewMemoryStore()
+	user, err := store.CreateUser(models.User{Username: "alice", Password: "hash", Role: models.RoleAdmin, Active: true})
+	if err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	m, clock := newTestSessionManager(t, store)
+	old, _, _ := m.Create(user.ID, "192.0.2.1", "test")
+	clock.advance(20 * time.Minute)
+	recent, _, _ := m.Create(user.ID, "192.0.2.2", "test")
+
+	clock.advance(15 * time.Minute)
+	if removed := m.Sweep(); removed != 1 {
+		t.Errorf("Sweep removed %d sessions, want 1", removed)
+	}
+	if sessions := store.GetAllSessions(); len(sessions) != 1 || sessions[0].ID != recent.ID {
+		t.Errorf("stored sessions after Sweep: %+v, want only %s", sessions, recent.ID)
+	}
+	if _, valid := m.Get(old.ID); valid {
+		t.Errorf("swept session still valid")
+	}
+
+	clock.advance(time.Hour)
+	go m.sweepEvery(time.Millisecond)
+	deadline := time.Now().Add(5 * time.Second)
+	for len(store.GetAllSessions()) > 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if sessions := store.GetAllSessions(); len(sessions) != 0 {
+		t.Errorf("background sweeper left %+v", sessions)
+	}
+	if listed := m.List(0); len(listed) != 0 {
+		t.Errorf("sessions listed after expiry: %+v", listed)
+	}
+}
+
+// TestSessionsPersist checks that sessions survive a restart of the file
+// store, stored by the hash of their cookie, and that expired ones are
+// dropped on loading
+func TestSessionsPersist(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "fuzzy.data")
+	store, err := models.NewFileStore(path)
+	if err != nil {
+		t.Fatalf("NewFileStore: %v", err)
+	}
+	user, err := store.CreateUser(models.User{Username: "alice", Password: "hash", Role: models.RoleAdmin, Active: true})
+	if err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	m, clock := newTestSessionManager(t, store)
+	session, token, err := m.Create(user.ID, "192.0.2.1", "test")
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	if session.ID != sessionIDFor(token) || session.ID == token {
+		t.Errorf("session ID %q is not the hash of its token", session.ID)
+	}
+	clock.advance(-time.Hour)
+	if _, _, err := m.Create(user.ID, "192.0.2.2", "test"); err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	m.Close()
+	store.Close()
+
+	reopened, err := models.NewFileStore(path)
+	if err != nil {
+		t.Fatalf("reopening: %v", err)
+	}
+	defer reopened.Close()
+	if sessions := reopened.GetAllSessions(); len(sessions) != 2 {
+		t.Fatalf("%d sessions stored, want 2", len(sessions))
+	}
+	for _, stored := range reopened.GetAllSessions() {
+		if stored.ID == token {
+			t.Errorf("session token stored in clear")
+		}
+	}
+
+	restarted, _ := newTestSessionManager(t, reopened)
+	if got, valid := restarted.Get(sessionIDFor(token)); !valid || got.UserID != user.ID || got.ClientIP != "192.0.2.1" {
+		t.Errorf("session after restart: %+v, valid %v", got, valid)
+	}
+	if _, valid := restarted.Get(token); valid {
+		t.Errorf("session found by its token rather than its ID")
+	}
+	if sessions := reopened.GetAllSessions(); len(sessions) != 1 {
+		t.Errorf("%d sessions stored after loading, want the expired one dropped", len(sessions))
+	}
+
+	// Without a store, sessions are kept in memory only
+	memoryOnly, _ := newTestSessionManager(t, nil)
+	if _, _, err := memoryOnly.Create(user.ID, "192.0.2.3", "test"); err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	if sessions := reopened.GetAllSessions(); len(sessions) != 1 {
+		t.Errorf("%d sessions stored, want 1", len(sessions))
+	}
+}
+
+// TestSessionsPage checks that users see and revoke their own sessions only,
+// while administrators manage the sessions of every user
+func TestSessionsPage(t *testing.T) {
+	f := newAuthFixture(t)
+	t.Chdir("..") // Templates are read from the working directory
+	page := f.h.RequireAuth(f.h.SessionsHandler)
+	call := func(method, role string, form url.Values) *httptest.ResponseRecorder {
+		t.Helper()
+		r := httptest.NewRequest(method, "/sessions", strings.NewReader(form.Encode()))
+		r.AddCookie(&http.Cookie{Name: config.AppConfig.Security.SessionCookieName, Value: f.sessions[role]})
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rec := httptest.NewRecorder()
+		page(rec, r)
+		return rec
+	}
+	operator, _ := f.h.Store.GetUserByUsername(models.RoleOperator)
+	_, other, err := f.h.Sessions.Create(operator.ID, "192.0.2.2", "other browser")
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyOf := func(token string) string { return sessionKey(sessionIDFor(token)) }
+
+	tests := []struct {
+		role    string
+		visible []string // Tokens of the sessions on the page
+		hidden  []string
+	}{
+		{models.RoleOperator, []string{f.sessions[models.RoleOperator], other}, []string{f.sessions[models.RoleAdmin], f.sessions[models.RoleViewer]}},
+		{models.RoleViewer, []string{f.sessions[models.RoleViewer]}, []string{f.sessions[models.RoleOperator], other}},
+		{models.RoleAdmin, []string{f.sessions[models.RoleAdmin], f.sessions[models.RoleViewer], other}, nil},
+	}
+	for _, tt := range tests {
+		rec := call(http.MethodGet, tt.role, nil)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: GET /sessions: status %d", tt.role, rec.Code)
+		}
+		for _, token := range tt.visible {
+			if !strings.Contains(rec.Body.String(), keyOf(token)) {
+				t.Errorf("%s: session %s not listed", tt.role, keyOf(token))
+			}
+		}
+		for _, token := range tt.hidden {
+			if strings.Contains(rec.Body.String(), keyOf(token)) {
+				t.Errorf("%s: session %s of another user listed", tt.role, keyOf(token))
+			}
+		}
+	}
+
+	// Users revoke their own sessions only
+	call(http.MethodPost, models.RoleOperator, url.Values{"action": {"revoke"}, "key": {keyOf(f.sessions[models.RoleAdmin])}})
+	if !f.h.Sessions.Active(sessionIDFor(f.sessions[models.RoleAdmin])) {
+		t.Errorf("session of another user revoked")
+	}
+	call(http.MethodPost, models.RoleOperator, url.Values{"action": {"revoke"}, "key": {keyOf(other)}})
+	if f.h.Sessions.Active(sessionIDFor(other)) {
+		t.Errorf("own session not revoked")
+	}
+
+	// Administrators revoke the sessions of other users
+	call(http.MethodPost, models.RoleAdmin, url.Values{"action": {"revoke"}, "key": {keyOf(f.sessions[models.RoleViewer])}})
+	if f.h.Sessions.Active(sessionIDFor(f.sessions[models.RoleViewer])) {
+		t.Errorf("administrator could not revoke the session of another user")
+	}
+
+	// Revoking the current session signs out
+	rec := call(http.MethodPost, models.RoleOperator, url.Values{"action": {"revoke"}, "key": {keyOf(f.sessions[models.RoleOperator])}})
+	if rec.Code != http.StatusSeeOther || rec.Header().Get("Location") != "/login" {
+		t.Errorf("revoking the current session: status %d, location %q", rec.Code, rec.Header().Get("Location"))
+	}
+	if f.h.Sessions.Active(sessionIDFor(f.sessions[models.RoleOperator])) {
+		t.Errorf("current session not revoked")
+	}
+}
@@ -0,0 +1,36 @@
+package handlers
+
+import (
+	"html/template"
+	"net/http"
+	"path/filepath"
+
+	"fuzzy/models"
+)
+
+// parseTemplate parses a page from the templates directory with the helper
+// functions every page can use:
+//
+//	csrfField  the hidden CSRF token input to place in every POST form
+//	csrfToken  the raw CSRF token, for scripts sending the X-CSRF-Token header
+//	cspNonce   the nonce every <script> tag needs to pass the Content Security Policy
+//	can        whether the current user has a permission, to hide controls they cannot use
+func parseTemplate(w http.ResponseWriter, r *http.Request, name string) (*template.Template, error) {
+	token := csrfToken(w, r)
+	nonce := cspNonce(r)
+	funcs := template.FuncMap{
+		"csrfField": func() template.HTML {
+			return template.HTML(`<input type="hidden" name="` + csrfFieldName + `" value="` + template.HTMLEscapeString(token) + `">`)
+		},
+		"csrfToken": func() string {
+			return token
+		},
+		"cspNonce": func() string {
+			return nonce
+		},
+		"can": func(permission string) bool {
+			return can(r, models.Permission(permission))
+		},
+	}
+	return template.New(name).Funcs(funcs).ParseFiles(filepath.Join("templates", name))
+}
@@ -0,0 +1,231 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"fuzzy/models"
+)
+
+// apiTokenPrefix starts every API token, so leaked tokens are easy to spot
+const apiTokenPrefix = "fz_"
+
+// apiTokenKey is the request context key of the API token a request was
+// authenticated with
+type apiTokenKey struct{}
+
+// withAPIToken returns a copy of r carrying the API token it was authenticated with
+func withAPIToken(r *http.Request, token models.APIToken) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), apiTokenKey{}, token))
+}
+
+// requestAPIToken returns the API token stored in the request by RequireAuth.
+// It reports false for requests authenticated with a session.
+func requestAPIToken(r *http.Request) (models.APIToken, bool) {
+	token, ok := r.Context().Value(apiTokenKey{}).(models.APIToken)
+	return token, ok
+}
+
+// rejectAPIToken answers 403 Forbidden to requests authenticated with an API
+// token, for pages that manage the account's credentials. It reports whether
+// it responded.
+func rejectAPIToken(w http.ResponseWriter, r *http.Request) bool {
+	if _, viaToken := requestAPIToken(r); viaToken {
+		http.Error(w, "This page requires signing in with a password", http.StatusForbidden)
+		return true
+	}
+	return false
+}
+
+// bearerToken returns the token of an "Authorization: Bearer" header, or ""
+func bearerToken(r *http.Request) string {
+	scheme, token, found := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !found || !strings.EqualFold(scheme, "Bearer") {
+		return ""
+	}
+	return strings.TrimSpace(token)
+}
+
+// hashAPIToken returns the hash under which a token is stored. Tokens are
+// random, so a fast hash is enough to make a leaked database useless.
+func hashAPIToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// newAPIToken generates a random API token
+func newAPIToken() (string, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	return apiTokenPrefix + hex.EncodeToString(raw), nil
+}
+
+// authenticateAPIToken returns the user of a valid, unexpired token and
+// records that the token was used
+func (h *Handler) authenticateAPIToken(value string) (models.User, models.APIToken, bool) {
+	token, exists := h.Store.GetAPITokenByHash(hashAPIToken(value))
+	now := time.Now()
+	if !exists || token.Expired(now) {
+		return models.User{}, models.APIToken{}, false
+	}
+	user, exists := h.Store.GetUser(token.UserID)
+	if !exists || !user.Active {
+		return models.User{}, models.APIToken{}, false
+	}
+
+	// Like sessions, record the last use at most once a minute
+	if now.Sub(token.LastUsed) >= sessionTouchInterval {
+		token.LastUsed = now
+		if err := h.Store.TouchAPIToken(token.ID, now); err != nil {
+			log.Printf("Error recording use of API token %d: %v", token.ID, err)
+		}
+	}
+	return user, token, true
+}
+
+// TokensHandler handles the page where users create, list and revoke their
+// API tokens
+func (h *Handler) TokensHandler(w http.ResponseWriter, r *http.Request) {
+	// A token must not be able to mint or revoke tokens
+	if rejectAPIToken(w, r) {
+		return
+	}
+
+	var data models.TokensPageData
+	data.Title = "Fuzzy - API Tokens"
+
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		h.handlePostTokens(r, &data)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	user, _ := currentUser(r)
+	data.Tokens = h.Store.GetAPITokensByUser(user.ID)
+	data.Scopes = user.Permissions()
+	data.Now = time.Now()
+	renderTokensTemplate(w, r, &data)
+}
+
+func (h *Handler) handlePostTokens(r *http.Request, data *models.TokensPageData) {
+	if err := r.ParseForm(); err != nil {
+		data.Error = "Error parsing form data"
+		return
+	}
+
+	switch r.FormValue("action") {
+	case "create":
+		h.handleCreateToken(r, data)
+	case "revoke":
+		h.handleRevokeToken(r, data)
+	default:
+		data.Error = "Invalid action"
+	}
+}
+
+func (h *Handler) handleCreateToken(r *http.Request, data *models.TokensPageData) {
+	user, _ := currentUser(r)
+
+	name := strings.TrimSpace(r.FormValue("name"))
+	if name == "" {
+		data.Error = "Token name is required"
+		return
+	}
+
+	days, err := strconv.Atoi(r.FormValue("expires_days"))
+	if err != nil || days < 0 {
+		data.Error = "Invalid expiry"
+		return
+	}
+
+	// Tokens can only be limited to permissions the user has
+	allowed := user.Permissions()
+	var scopes []models.Permission
+	for _, value := range r.Form["scopes"] {
+		scope := models.Permission(value)
+		if !slices.Contains(allowed, scope) {
+			data.Error = "Invalid scope: " + value
+			return
+		}
+		scopes = append(scopes, scope)
+	}
+
+	value, err := newAPIToken()
+	if err != nil {
+		log.Printf("Error generating API token: %v", err)
+		data.Error = "Failed to create token"
+		return
+	}
+
+	token := models.APIToken{
+		UserID: user.ID,
+		Name:   name,
+		Hash:   hashAPIToken(value),
+		Prefix: value[:len(apiTokenPrefix)+8],
+		Scopes: scopes,
+	}
+	if days > 0 {
+		token.ExpiresAt = time.Now().AddDate(0, 0, days)
+	}
+
+	if _, err := h.storeFor(r).CreateAPIToken(token); err != nil {
+		log.Printf("Error creating API token for user %d: %v", user.ID, err)
+		data.Error = "Failed to create token"
+		return
+	}
+	data.NewToken = value
+	data.Message = "Token created. Copy it now: it will not be shown again."
+}
+
+func (h *Handler) handleRevokeToken(r *http.Request, data *models.TokensPageData) {
+	user, _ := currentUser(r)
+
+	id, err := strconv.Atoi(strings.TrimSpace(r.FormValue("id")))
+	if err != nil {
+		data.Error = "Invalid token ID"
+		return
+	}
+
+	// Users can only revoke their own tokens
+	owned := slices.ContainsFunc(h.Store.GetAPITokensByUser(user.ID), func(token models.APIToken) bool {
+		return token.ID == id
+	})
+	if !owned {
+		data.Error = "Token not found"
+		return
+	}
+	if err := h.storeFor(r).DeleteAPIToken(id); err != nil {
+		log.Printf("Error deleting API token %d: %v", id, err)
+		data.Error = "Failed to revoke token"
+		return
+	}
+	data.Message = "Token revoked"
+}
+
+func renderTokensTemplate(w http.ResponseWriter, r *http.Request, data *models.TokensPageData) {
+	t, err := parseTemplate(w, r, "tokens.html")
+	if err != nil {
+		log.Printf("Error parsing tokens template: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := t.Execute(w, data); err != nil {
+		log.Printf("Error executing tokens template: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
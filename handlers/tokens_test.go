@@ -0,0 +1,214 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"fuzzy/config"
+	"fuzzy/models"
+)
+
+// TestAPITokenAuthentication checks that a bearer token signs in as its
+// user until it is revoked or expires, and records when it was used
+func TestAPITokenAuthentication(t *testing.T) {
+	f := newAuthFixture(t)
+	var reached string
+	page := f.h.RequireAuth(func(w http.ResponseWriter, r *http.Request) {
+		user, _ := currentUser(r)
+		reached = user.Username
+	})
+	serve := func(r *http.Request) *httptest.ResponseRecorder {
+		reached = ""
+		rec := httptest.NewRecorder()
+		page(rec, r)
+		return rec
+	}
+	stored, exists := f.h.Store.GetAPITokenByHash(hashAPIToken(f.tokens["full"]))
+	if !exists {
+		t.Fatalf("token not found by the hash of its value")
+	}
+	if stored.Hash == f.tokens["full"] {
+		t.Errorf("token value stored in clear")
+	}
+
+	tests := []struct {
+		name    string
+		request *http.Request
+		status  int
+		user    string
+	}{
+		{"token", f.request(http.MethodGet, "/api/channels", "", "full"), http.StatusOK, models.RoleAdmin},
+		{"lowercase scheme", func() *http.Request {
+			r := f.request(http.MethodGet, "/api/channels", "", "")
+			r.Header.Set("Authorization", "bearer "+f.tokens["full"])
+			return r
+		}(), http.StatusOK, models.RoleAdmin},
+		{"unknown token", func() *http.Request {
+			r := f.request(http.MethodGet, "/api/channels", "", "")
+			r.Header.Set("Authorization", "Bearer "+apiTokenPrefix+"invalid")
+			return r
+		}(), http.StatusUnauthorized, ""},
+		{"hash instead of token", func() *http.Request {
+			r := f.request(http.MethodGet, "/api/channels", "", "")
+			r.Header.Set("Authorization", "Bearer "+stored.Hash)
+			return r
+		}(), http.StatusUnauthorized, ""},
+		// The token is checked instead of the session, and answered without redirect
+		{"session and invalid token", func() *http.Request {
+			r := f.request(http.MethodGet, "/api/channels", models.RoleAdmin, "")
+			r.Header.Set("Authorization", "Bearer "+apiTokenPrefix+"invalid")
+			return r
+		}(), http.StatusUnauthorized, ""},
+	}
+	for _, test := range tests {
+		rec := serve(test.request)
+		if rec.Code != test.status || reached != test.user {
+			t.Errorf("%s: status %d, served to %q; want %d, %q", test.name, rec.Code, reached, test.status, test.user)
+		}
+		if rec.Code == http.StatusUnauthorized && rec.Header().Get("WWW-Authenticate") == "" {
+			t.Errorf("%s: no WWW-Authenticate header", test.name)
+		}
+	}
+
+	if used, _ := f.h.Store.GetAPIToken(stored.ID); used.LastUsed.IsZero() {
+		t.Errorf("token use not recorded")
+	}
+
+	// Expired and revoked tokens are refused
+	expiring, _ := f.h.Store.GetAPITokenByHash(hashAPIToken(f.tokens["control"]))
+	expiring.ExpiresAt = time.Now().Add(-time.Second)
+	if err := f.h.Store.DeleteAPIToken(expiring.ID); err != nil {
+		t.Fatalf("DeleteAPIToken: %v", err)
+	}
+	if _, err := f.h.Store.CreateAPIToken(expiring); err != nil {
+		t.Fatalf("CreateAPIToken: %v", err)
+	}
+	if rec := serve(f.request(http.MethodGet, "/api/channels", "", "control")); rec.Code != http.StatusUnauthorized {
+		t.Errorf("expired token: status %d, want %d", rec.Code, http.StatusUnauthorized)
+	}
+	if err := f.h.Store.DeleteAPIToken(stored.ID); err != nil {
+		t.Fatalf("DeleteAPIToken: %v", err)
+	}
+	if rec := serve(f.request(http.MethodGet, "/api/channels", "", "full")); rec.Code != http.StatusUnauthorized {
+		t.Errorf("revoked token: status %d, want %d", rec.Code, http.StatusUnauthorized)
+	}
+
+	// So are the tokens of a disabled user
+	admin, _ := f.h.Store.GetUserByUsername(models.RoleAdmin)
+	admin.Active = false
+	if err := f.h.Store.UpdateUser(admin); err != nil {
+		t.Fatalf("UpdateUser: %v", err)
+	}
+	if rec := serve(f.request(http.MethodGet, "/api/channels", "", "view only")); rec.Code != http.StatusUnauthorized {
+		t.Errorf("token of a disabled user: status %d, want %d", rec.Code, http.StatusUnauthorized)
+	}
+}
+
+// TestAPITokenScopes checks that a token is limited to its scopes, and to
+// the permissions of its user
+func TestAPITokenScopes(t *testing.T) {
+	f := newAuthFixture(t)
+	viewer, _ := f.h.Store.GetUserByUsername(models.RoleViewer)
+	value, err := newAPIToken()
+	if err != nil {
+		t.Fatalf("newAPIToken: %v", err)
+	}
+	// Scopes beyond the role are refused when creating tokens, but the role
+	// still bounds a token stored with them
+	if _, err := f.h.Store.CreateAPIToken(models.APIToken{UserID: viewer.ID, Name: "viewer", Hash: hashAPIToken(value), Scopes: []models.Permission{models.PermChannelsControl}}); err != nil {
+		t.Fatalf("CreateAPIToken: %v", err)
+	}
+	f.tokens["viewer"] = value
+
+	tests := []struct {
+		token      string
+		permission models.Permission
+		status     int
+	}{
+		{"full", models.PermChannelsControl, http.StatusOK},
+		{"full", models.PermUsersManage, http.StatusOK},
+		{"control", models.PermChannelsControl, http.StatusOK},
+		{"control", models.PermUsersManage, http.StatusForbidden},
+		{"view only", models.PermChannelsView, http.StatusOK},
+		{"view only", models.PermChannelsControl, http.StatusForbidden},
+		{"viewer", models.PermChannelsControl, http.StatusForbidden},
+	}
+	for _, test := range tests {
+		page := f.h.RequireAuth(f.h.RequirePermission(test.permission, func(w http.ResponseWriter, r *http.Request) {}))
+		rec := httptest.NewRecorder()
+		page(rec, f.request(http.MethodPost, "/api/channels", "", test.token))
+		if rec.Code != test.status {
+			t.Errorf("token %q, permission %s: status %d, want %d", test.token, test.permission, rec.Code, test.status)
+		}
+	}
+}
+
+var newTokenRe = regexp.MustCompile(apiTokenPrefix + `[0-9a-f]{64}`)
+
+// TestTokensPage checks creating and revoking tokens on the API Tokens page
+func TestTokensPage(t *testing.T) {
+	f := newAuthFixture(t)
+	t.Chdir("..") // Templates are read from the working directory
+	page := f.h.RequireAuth(f.h.TokensHandler)
+	post := func(role string, form url.Values) string {
+		t.Helper()
+		r := httptest.NewRequest(http.MethodPost, "/tokens", strings.NewReader(form.Encode()))
+		r.AddCookie(&http.Cookie{Name: config.AppConfig.Security.SessionCookieName, Value: f.sessions[role]})
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rec := httptest.NewRecorder()
+		page(rec, r)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("POST /tokens: status %d", rec.Code)
+		}
+		return rec.Body.String()
+	}
+	operator, _ := f.h.Store.GetUserByUsername(models.RoleOperator)
+
+	body := post(models.RoleOperator, url.Values{"action": {"create"}, "name": {"deploy"}, "expires_days": {"30"}, "scopes": {string(models.PermChannelsView)}})
+	value := newTokenRe.FindString(body)
+	if value == "" {
+		t.Fatalf("new token not shown:\n%s", body)
+	}
+	token, exists := f.h.Store.GetAPITokenByHash(hashAPIToken(value))
+	if !exists || token.UserID != operator.ID || token.Name != "deploy" || !strings.HasPrefix(value, token.Prefix) {
+		t.Fatalf("created token: %+v, found %v", token, exists)
+	}
+	if len(token.Scopes) != 1 || token.Scopes[0] != models.PermChannelsView {
+		t.Errorf("scopes %v, want [%s]", token.Scopes, models.PermChannelsView)
+	}
+	if days := time.Until(token.ExpiresAt).Hours() / 24; days < 29 || days > 30 {
+		t.Errorf("token expires in %.1f days, want 30", days)
+	}
+
+	// Scopes are limited to the permissions of the user
+	before := len(f.h.Store.GetAPITokensByUser(operator.ID))
+	post(models.RoleOperator, url.Values{"action": {"create"}, "name": {"admin"}, "expires_days": {"0"}, "scopes": {string(models.PermUsersManage)}})
+	if after := len(f.h.Store.GetAPITokensByUser(operator.ID)); after != before {
+		t.Errorf("token created with a scope beyond the role")
+	}
+
+	// Users revoke their own tokens only
+	admin, _ := f.h.Store.GetUserByUsername(models.RoleAdmin)
+	adminToken := f.h.Store.GetAPITokensByUser(admin.ID)[0]
+	post(models.RoleOperator, url.Values{"action": {"revoke"}, "id": {strconv.Itoa(adminToken.ID)}})
+	if _, exists := f.h.Store.GetAPIToken(adminToken.ID); !exists {
+		t.Errorf("token of another user revoked")
+	}
+	post(models.RoleOperator, url.Values{"action": {"revoke"}, "id": {strconv.Itoa(token.ID)}})
+	if _, exists := f.h.Store.GetAPIToken(token.ID); exists {
+		t.Errorf("own token not revoked")
+	}
+
+	// A token cannot manage tokens
+	rec := httptest.NewRecorder()
+	page(rec, f.request(http.MethodGet, "/tokens", "", "full"))
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("GET /tokens with an API token: status %d, want %d", rec.Code, http.StatusForbidden)
+	}
+}
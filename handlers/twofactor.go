@@ -0,0 +1,167 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"fuzzy/config"
+	"fuzzy/models"
+)
+
+const (
+	// twoFactorChallengeLifetime is how long a user has to enter their code
+	// once their password was accepted
+	twoFactorChallengeLifetime = 5 * time.Minute
+
+	// maxTwoFactorAttempts is how many codes may be tried per password check
+	maxTwoFactorAttempts = 5
+)
+
+// errInvalidTwoFactorCode reports a wrong TOTP or recovery code
+var errInvalidTwoFactorCode = errors.New("invalid authentication code")
+
+// twoFactorChallenge is a login whose password was accepted and that waits
+// for its second factor
+type twoFactorChallenge struct {
+	userID   int
+	expires  time.Time
+	attempts int
+}
+
+// twoFactorState tracks the logins waiting for their second factor
+type twoFactorState struct {
+	mutex      sync.Mutex
+	challenges map[string]twoFactorChallenge // Challenge token -> pending login
+}
+
+func newTwoFactorState() *twoFactorState {
+	return &twoFactorState{
+		challenges: make(map[string]twoFactorChallenge),
+	}
+}
+
+// begin starts a challenge for the user and returns its token
+func (s *twoFactorState) begin(userID int) string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	now := time.Now()
+	for token, challenge := range s.challenges {
+		if now.After(challenge.expires) {
+			delete(s.challenges, token)
+		}
+	}
+
+	token := generateSessionID()
+	s.challenges[token] = twoFactorChallenge{userID: userID, expires: now.Add(twoFactorChallengeLifetime)}
+	return token
+}
+
+// attempt records a code attempt on a challenge and returns its user. It
+// reports false once the challenge is unknown, expired or out of attempts.
+func (s *twoFactorState) attempt(token string) (int, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	challenge, exists := s.challenges[token]
+	if !exists {
+		return 0, false
+	}
+	if time.Now().After(challenge.expires) || challenge.attempts >= maxTwoFactorAttempts {
+		delete(s.challenges, token)
+		return 0, false
+	}
+	challenge.attempts++
+	s.challenges[token] = challenge
+	return challenge.userID, true
+}
+
+// finish ends a challenge after a successful login
+func (s *twoFactorState) finish(token string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	delete(s.challenges, token)
+}
+
+// checkTwoFactorCode verifies a TOTP code of the user or, when allowRecovery
+// is set, one of their recovery codes, which is then used up. The time step of
+// a TOTP code is recorded on the user, so that the code, or an earlier one,
+// cannot be used again even after a restart.
+func (h *Handler) checkTwoFactorCode(user models.User, code string, allowRecovery bool) error {
+	if step, ok := user.MatchTOTP(code, time.Now()); ok {
+		err := h.Store.UseTOTPStep(user.ID, step)
+		if errors.Is(err, models.ErrTOTPStepUsed) {
+			return errInvalidTwoFactorCode
+		}
+		return err
+	}
+	if !allowRecovery {
+		return errInvalidTwoFactorCode
+	}
+
+	// Recovery codes are compared outside the transaction: bcrypt is slow
+	hash, ok := user.MatchRecoveryCode(code)
+	if !ok {
+		return errInvalidTwoFactorCode
+	}
+	err := h.Store.As(models.UserActor(user)).Update(func(tx models.Tx) error {
+		current, exists := tx.GetUser(user.ID)
+		if !exists {
+			return models.ErrNotFound
+		}
+		if !current.RemoveRecoveryCode(hash) {
+			return errInvalidTwoFactorCode // Used concurrently
+		}
+		return tx.UpdateUser(current)
+	})
+	if err == nil {
+		log.Printf("User %s signed in with a recovery code", user.Username)
+	}
+	return err
+}
+
+// handleTwoFactorLogin completes a login with the code of its second factor
+func (h *Handler) handleTwoFactorLogin(w http.ResponseWriter, r *http.Request, clientIP, token string) {
+	userID, ok := h.twoFactor.attempt(token)
+	user, exists := h.Store.GetUser(userID)
+	if !ok || !exists || !user.Active || !user.TOTPEnabled {
+		data := models.LoginPageData{
+			Title: "Fuzzy - Login",
+			Error: "Your sign-in has expired. Please enter your username and password again.",
+		}
+		renderLoginTemplate(w, r, &data)
+		return
+	}
+
+	code := strings.TrimSpace(r.FormValue("code"))
+	if err := h.checkTwoFactorCode(user, code, true); err != nil {
+		if !errors.Is(err, errInvalidTwoFactorCode) {
+			log.Printf("Error using recovery code of user %d: %v", user.ID, err)
+		}
+		recordLoginAttempt(clientIP)
+		data := models.LoginPageData{
+			Title:          "Fuzzy - Login",
+			Error:          "Invalid authentication code",
+			TwoFactorToken: token,
+		}
+		renderLoginTemplate(w, r, &data)
+		return
+	}
+
+	h.twoFactor.finish(token)
+	clearLoginAttempts(clientIP)
+	h.startSession(w, r, user, clientIP)
+}
+
+// needsTwoFactorEnrollment reports whether the user must enable two-factor
+// authentication before using the panel
+func needsTwoFactorEnrollment(user models.User) bool {
+	return config.AppConfig.Security.RequireAdmin2FA &&
+		models.NormalizeRole(user.Role) == models.RoleAdmin &&
+		!user.TOTPEnabled
+}
@@ -0,0 +1,52 @@
+package handlers
+
+import (
+	"errors"
+	"testing"
+
+	"fuzzy/models"
+)
+
+// TestTwoFactorRecoveryCodeSingleUse checks that a recovery code signs in once
+// and is removed from the stored user
+func TestTwoFactorRecoveryCodeSingleUse(t *testing.T) {
+	store := models.NewMemoryStore()
+	h := &Handler{Store: store, twoFactor: newTwoFactorState()}
+
+	user := models.User{Username: "alice", Role: models.RoleAdmin, Active: true, TOTPEnabled: true}
+	secret, err := models.NewTOTPSecret()
+	if err != nil {
+		t.Fatalf("NewTOTPSecret: %v", err)
+	}
+	user.TOTPSecret = secret
+	codes, err := user.SetRecoveryCodes()
+	if err != nil {
+		t.Fatalf("SetRecoveryCodes: %v", err)
+	}
+	user, err = store.CreateUser(user)
+	if err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+
+	if err := h.checkTwoFactorCode(user, codes[0], false); !errors.Is(err, errInvalidTwoFactorCode) {
+		t.Errorf("recovery code where only TOTP is allowed: %v, want errInvalidTwoFactorCode", err)
+	}
+	if err := h.checkTwoFactorCode(user, codes[0], true); err != nil {
+		t.Fatalf("first use of recovery code: %v", err)
+	}
+	stored, _ := store.GetUser(user.ID)
+	if len(stored.RecoveryCodes) != len(codes)-1 {
+		t.Errorf("%d recovery codes stored, want %d", len(stored.RecoveryCodes), len(codes)-1)
+	}
+	// Checked against the stored user, as a new login would
+	if err := h.checkTwoFactorCode(stored, codes[0], true); !errors.Is(err, errInvalidTwoFactorCode) {
+		t.Errorf("second use of recovery code: %v, want errInvalidTwoFactorCode", err)
+	}
+	// Against the user read before the first use, the store still refuses it
+	if err := h.checkTwoFactorCode(user, codes[0], true); !errors.Is(err, errInvalidTwoFactorCode) {
+		t.Errorf("concurrent second use of recovery code: %v, want errInvalidTwoFactorCode", err)
+	}
+	if err := h.checkTwoFactorCode(stored, codes[1], true); err != nil {
+		t.Errorf("another recovery code: %v", err)
+	}
+}
@@ -1,58 +1,47 @@
 package handlers
 
 import (
-	"html/template"
+	"errors"
+	"fmt"
 	"log"
 	"net/http"
-	"path/filepath"
 	"strconv"
 	"strings"
 
 	"fuzzy/models"
+	"fuzzy/validation"
 )
 
 // UsersHandler handles requests to the users page for managing users
-func UsersHandler(w http.ResponseWriter, r *http.Request) {
+func (h *Handler) UsersHandler(w http.ResponseWriter, r *http.Request) {
 	var data models.UsersPageData
 	data.Title = "Fuzzy - Users"
 
 	switch r.Method {
 	case http.MethodGet:
-		handleGetUsers(w, r, &data)
+		h.handleGetUsers(w, r, &data)
 	case http.MethodPost:
-		handlePostUsers(w, r, &data)
+		h.handlePostUsers(w, r, &data)
 	default:
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 }
 
-func handleGetUsers(w http.ResponseWriter, r *http.Request, data *models.UsersPageData) {
-	// Check if this is a delete request
-	if deleteID := r.URL.Query().Get("delete"); deleteID != "" {
-		id, err := strconv.Atoi(deleteID)
-		if err != nil {
-			data.Error = "Invalid user ID"
-		} else if models.GlobalStore.DeleteUser(id) {
-			data.Message = "User deleted successfully"
-		} else {
-			data.Error = "User not found"
-		}
-	}
-
+func (h *Handler) handleGetUsers(w http.ResponseWriter, r *http.Request, data *models.UsersPageData) {
 	// Get all users
-	data.Users = models.GlobalStore.GetAllUsers()
+	data.Users = h.Store.GetAllUsers()
 
 	// Render template
-	renderUsersTemplate(w, data)
+	renderUsersTemplate(w, r, data)
 }
 
-func handlePostUsers(w http.ResponseWriter, r *http.Request, data *models.UsersPageData) {
+func (h *Handler) handlePostUsers(w http.ResponseWriter, r *http.Request, data *models.UsersPageData) {
 	// Parse form data
 	if err := r.ParseForm(); err != nil {
 		data.Error = "Error parsing form data"
-		data.Users = models.GlobalStore.GetAllUsers()
-		renderUsersTemplate(w, data)
+		data.Users = h.Store.GetAllUsers()
+		renderUsersTemplate(w, r, data)
 		return
 	}
 
@@ -60,54 +49,47 @@ func handlePostUsers(w http.ResponseWriter, r *http.Request, data *models.UsersP
 	
 	switch action {
 	case "create":
-		handleCreateUser(r, data)
+		h.handleCreateUser(r, data)
 	case "update":
-		handleUpdateUser(r, data)
+		h.handleUpdateUser(r, data)
+	case "delete":
+		h.handleDeleteUser(r, data)
+	case "signout":
+		h.handleSignOutUser(r, data)
+	case "reset_2fa":
+		h.handleResetTwoFactor(r, data)
 	default:
 		data.Error = "Invalid action"
 	}
 
 	// Get updated users list
-	data.Users = models.GlobalStore.GetAllUsers()
-	renderUsersTemplate(w, data)
+	data.Users = h.Store.GetAllUsers()
+	renderUsersTemplate(w, r, data)
 }
 
-func handleCreateUser(r *http.Request, data *models.UsersPageData) {
-	username := strings.TrimSpace(r.FormValue("username"))
-	email := strings.TrimSpace(r.FormValue("email"))
+func (h *Handler) handleCreateUser(r *http.Request, data *models.UsersPageData) {
 	password := r.FormValue("password")
-	role := strings.TrimSpace(r.FormValue("role"))
 	activeStr := r.FormValue("active")
 
-	// Validate input
-	if username == "" {
-		data.Error = "Username is required"
-		return
-	}
-	if password == "" {
-		data.Error = "Password is required"
-		return
-	}
-	if role == "" {
-		role = "User" // Default role
-	}
-
-	// Check if username already exists
-	if _, exists := models.GlobalStore.GetUserByUsername(username); exists {
-		data.Error = "Username already exists"
-		return
-	}
-
-	active := activeStr == "on" || activeStr == "true"
-
 	// Create user
 	user := models.User{
-		Username:  username,
-		Email:     email,
+		Username:  r.FormValue("username"),
+		Email:     r.FormValue("email"),
 		FirstName: "",
 		LastName:  "",
-		Role:      role,
-		Active:    active,
+		Role:      r.FormValue("role"),
+		Active:    activeStr == "on" || activeStr == "true",
+	}
+
+	// Validate input
+	errs := h.checkUser(&user)
+	if password == "" {
+		errs.Add("password", "Password is required")
+	}
+	if len(errs) > 0 {
+		data.Error = errs.Error()
+		data.FieldErrors = formErrors("create", 0, errs)
+		return
 	}
 
 	// Set password
@@ -116,11 +98,15 @@ func handleCreateUser(r *http.Request, data *models.UsersPageData) {
 		return
 	}
 
-	models.GlobalStore.CreateUser(user)
+	if _, err := h.storeFor(r).CreateUser(user); err != nil {
+		log.Printf("Error creating user: %v", err)
+		data.Error = "Failed to create user"
+		return
+	}
 	data.Message = "User created successfully"
 }
 
-func handleUpdateUser(r *http.Request, data *models.UsersPageData) {
+func (h *Handler) handleUpdateUser(r *http.Request, data *models.UsersPageData) {
 	idStr := strings.TrimSpace(r.FormValue("id"))
 	id, err := strconv.Atoi(idStr)
 	if err != nil {
@@ -128,49 +114,142 @@ func handleUpdateUser(r *http.Request, data *models.UsersPageData) {
 		return
 	}
 
+	version, err := strconv.Atoi(r.FormValue("version"))
+	if err != nil {
+		data.Error = "Invalid user version"
+		return
+	}
+
 	// Get existing user
-	existing, exists := models.GlobalStore.GetUser(id)
+	existing, exists := h.Store.GetUser(id)
 	if !exists {
 		data.Error = "User not found"
 		return
 	}
 
-	username := strings.TrimSpace(r.FormValue("username"))
-	email := strings.TrimSpace(r.FormValue("email"))
-	role := strings.TrimSpace(r.FormValue("role"))
 	activeStr := r.FormValue("active")
 
-	// Validate input
-	if username == "" {
-		data.Error = "Username is required"
-		return
-	}
-	if role == "" {
-		role = "User" // Default role
-	}
-
-	active := activeStr == "on" || activeStr == "true"
-
 	// Update user
 	updated := existing
-	updated.Username = username
-	updated.Email = email
+	updated.Username = r.FormValue("username")
+	updated.Email = r.FormValue("email")
 	updated.FirstName = ""
 	updated.LastName = ""
-	updated.Role = role
-	updated.Active = active
+	updated.Role = r.FormValue("role")
+	updated.Active = activeStr == "on" || activeStr == "true"
+	updated.Version = version
+
+	// Validate input
+	if errs := h.checkUser(&updated); len(errs) > 0 {
+		data.Error = errs.Error()
+		data.FieldErrors = formErrors("update", id, errs)
+		return
+	}
+	if msg := checkSelfUpdate(r, updated); msg != "" {
+		data.Error = msg
+		return
+	}
 
-	if models.GlobalStore.UpdateUser(updated) {
+	if err := h.storeFor(r).UpdateUser(updated); err == nil {
 		data.Message = "User updated successfully"
+		if !updated.Active && existing.Active {
+			// A deactivated user is logged out everywhere
+			h.Sessions.DeleteUser(id)
+		}
+	} else if msg, ok := conflictMessage(err); ok {
+		data.Error = msg
+		data.ConflictID = id
 	} else {
+		log.Printf("Error updating user %d: %v", id, err)
 		data.Error = "Failed to update user"
 	}
 }
 
-func renderUsersTemplate(w http.ResponseWriter, data *models.UsersPageData) {
+func (h *Handler) handleDeleteUser(r *http.Request, data *models.UsersPageData) {
+	id, err := strconv.Atoi(strings.TrimSpace(r.FormValue("id")))
+	if err != nil {
+		data.Error = "Invalid user ID"
+	} else if self, ok := currentUser(r); ok && self.ID == id {
+		data.Error = "You cannot delete your own account"
+	} else if err := h.storeFor(r).DeleteUser(id); err == nil {
+		data.Message = "User deleted successfully"
+		h.Sessions.DeleteUser(id)
+	} else if errors.Is(err, models.ErrNotFound) {
+		data.Error = "User not found"
+	} else {
+		log.Printf("Error deleting user %d: %v", id, err)
+		data.Error = "Failed to delete user"
+	}
+}
+
+// handleSignOutUser ends every session of a user
+func (h *Handler) handleSignOutUser(r *http.Request, data *models.UsersPageData) {
+	id, err := strconv.Atoi(strings.TrimSpace(r.FormValue("id")))
+	if err != nil {
+		data.Error = "Invalid user ID"
+		return
+	}
+	user, exists := h.Store.GetUser(id)
+	if !exists {
+		data.Error = "User not found"
+		return
+	}
+	if self, ok := currentUser(r); ok && self.ID == id {
+		data.Error = "Use the Sessions page to sign out your own sessions"
+		return
+	}
+
+	revoked := h.Sessions.DeleteUser(id)
+	data.Message = fmt.Sprintf("Signed out %s everywhere (%d session(s))", user.Username, revoked)
+}
+
+// handleResetTwoFactor turns off the two-factor authentication of a user who
+// lost their authenticator app and recovery codes
+func (h *Handler) handleResetTwoFactor(r *http.Request, data *models.UsersPageData) {
+	id, err := strconv.Atoi(strings.TrimSpace(r.FormValue("id")))
+	if err != nil {
+		data.Error = "Invalid user ID"
+		return
+	}
+	user, exists := h.Store.GetUser(id)
+	if !exists {
+		data.Error = "User not found"
+		return
+	}
+
+	user.ClearTOTP()
+	if err := h.storeFor(r).UpdateUser(user); err != nil {
+		log.Printf("Error resetting two-factor authentication of user %d: %v", id, err)
+		data.Error = "Failed to reset two-factor authentication"
+		return
+	}
+	data.Message = fmt.Sprintf("Two-factor authentication reset for %s", user.Username)
+}
+
+// checkUser validates a user about to be saved, including that no other
+// user has the same username. The forms and the JSON API share it.
+func (h *Handler) checkUser(user *models.User) validation.Errors {
+	errs := validation.User(user)
+	if user.Username != "" {
+		if other, exists := h.Store.GetUserByUsername(user.Username); exists && other.ID != user.ID {
+			errs.Add("username", "Username already exists")
+		}
+	}
+	return errs
+}
+
+// checkSelfUpdate returns a user-facing message when the current user would
+// lose their administrator access by saving updated, or ""
+func checkSelfUpdate(r *http.Request, updated models.User) string {
+	if self, ok := currentUser(r); ok && self.ID == updated.ID && (updated.Role != models.RoleAdmin || !updated.Active) {
+		return "You cannot remove your own administrator access"
+	}
+	return ""
+}
+
+func renderUsersTemplate(w http.ResponseWriter, r *http.Request, data *models.UsersPageData) {
 	// Parse the template file
-	tmplPath := filepath.Join("templates", "users.html")
-	t, err := template.ParseFiles(tmplPath)
+	t, err := parseTemplate(w, r, "users.html")
 	if err != nil {
 		log.Printf("Error parsing template: %v", err)
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
@@ -0,0 +1,236 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"fuzzy/models"
+	"fuzzy/validation"
+	"fuzzy/webhooks"
+)
+
+// WebhooksHandler handles requests to the webhooks page for managing the
+// webhooks and reading their delivery logs
+func (h *Handler) WebhooksHandler(w http.ResponseWriter, r *http.Request) {
+	var data models.WebhooksPageData
+	data.Title = "Fuzzy - Webhooks"
+	data.Events = models.WebhookEvents
+
+	switch r.Method {
+	case http.MethodGet:
+		h.handleGetWebhooks(w, r, &data)
+	case http.MethodPost:
+		h.handlePostWebhooks(w, r, &data)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+}
+
+func (h *Handler) handleGetWebhooks(w http.ResponseWriter, r *http.Request, data *models.WebhooksPageData) {
+	// Show the delivery log of the webhook named by ?log=
+	if logID, err := strconv.Atoi(r.URL.Query().Get("log")); err == nil {
+		h.selectWebhook(data, logID)
+	}
+
+	data.Webhooks = h.Store.GetAllWebhooks()
+	renderWebhooksTemplate(w, r, data)
+}
+
+func (h *Handler) handlePostWebhooks(w http.ResponseWriter, r *http.Request, data *models.WebhooksPageData) {
+	// Parse form data
+	if err := r.ParseForm(); err != nil {
+		data.Error = "Error parsing form data"
+		data.Webhooks = h.Store.GetAllWebhooks()
+		renderWebhooksTemplate(w, r, data)
+		return
+	}
+
+	action := r.FormValue("action")
+
+	switch action {
+	case "create":
+		h.handleCreateWebhook(r, data)
+	case "update":
+		h.handleUpdateWebhook(r, data)
+	case "delete":
+		h.handleDeleteWebhook(r, data)
+	case "test":
+		h.handleTestWebhook(r, data)
+	default:
+		data.Error = "Invalid action"
+	}
+
+	// Get updated webhooks list
+	data.Webhooks = h.Store.GetAllWebhooks()
+	renderWebhooksTemplate(w, r, data)
+}
+
+func (h *Handler) handleCreateWebhook(r *http.Request, data *models.WebhooksPageData) {
+	webhook := webhookFromForm(r)
+
+	// Validate input
+	if errs := validation.Webhook(&webhook); len(errs) > 0 {
+		data.Error = errs.Error()
+		data.FieldErrors = formErrors("create", 0, errs)
+		data.Form = webhook
+		return
+	}
+
+	// Generate a secret when none is given; it is shown once below
+	generated := webhook.Secret == ""
+	if generated {
+		secret, err := webhooks.NewSecret()
+		if err != nil {
+			log.Printf("Error generating webhook secret: %v", err)
+			data.Error = "Failed to generate webhook secret"
+			return
+		}
+		webhook.Secret = secret
+	}
+
+	if _, err := h.storeFor(r).CreateWebhook(webhook); err != nil {
+		log.Printf("Error creating webhook: %v", err)
+		data.Error = "Failed to create webhook"
+		return
+	}
+	data.Message = "Webhook created successfully"
+	if generated {
+		data.NewSecret = webhook.Secret
+	}
+}
+
+func (h *Handler) handleUpdateWebhook(r *http.Request, data *models.WebhooksPageData) {
+	id, err := strconv.Atoi(strings.TrimSpace(r.FormValue("id")))
+	if err != nil {
+		data.Error = "Invalid webhook ID"
+		return
+	}
+
+	version, err := strconv.Atoi(r.FormValue("version"))
+	if err != nil {
+		data.Error = "Invalid webhook version"
+		return
+	}
+
+	// Get existing webhook
+	existing, exists := h.Store.GetWebhook(id)
+	if !exists {
+		data.Error = "Webhook not found"
+		return
+	}
+
+	updated := webhookFromForm(r)
+	updated.ID = id
+	updated.Version = version
+	updated.CreatedAt = existing.CreatedAt
+
+	// Validate input
+	if errs := validation.Webhook(&updated); len(errs) > 0 {
+		data.Error = errs.Error()
+		data.FieldErrors = formErrors("update", id, errs)
+		return
+	}
+	// A blank secret keeps the current one
+	if updated.Secret == "" {
+		updated.Secret = existing.Secret
+	}
+
+	if err := h.storeFor(r).UpdateWebhook(updated); err == nil {
+		data.Message = "Webhook updated successfully"
+	} else if msg, ok := conflictMessage(err); ok {
+		data.Error = msg
+		data.ConflictID = id
+	} else if errors.Is(err, models.ErrNotFound) {
+		data.Error = "Webhook not found"
+	} else {
+		log.Printf("Error updating webhook %d: %v", id, err)
+		data.Error = "Failed to update webhook"
+	}
+}
+
+func (h *Handler) handleDeleteWebhook(r *http.Request, data *models.WebhooksPageData) {
+	id, err := strconv.Atoi(strings.TrimSpace(r.FormValue("id")))
+	if err != nil {
+		data.Error = "Invalid webhook ID"
+	} else if err := h.storeFor(r).DeleteWebhook(id); err == nil {
+		data.Message = "Webhook deleted successfully"
+	} else if errors.Is(err, models.ErrNotFound) {
+		data.Error = "Webhook not found"
+	} else {
+		log.Printf("Error deleting webhook %d: %v", id, err)
+		data.Error = "Failed to delete webhook"
+	}
+}
+
+// handleTestWebhook sends a test event to a webhook and shows its delivery
+// log with the outcome
+func (h *Handler) handleTestWebhook(r *http.Request, data *models.WebhooksPageData) {
+	id, err := strconv.Atoi(strings.TrimSpace(r.FormValue("id")))
+	if err != nil {
+		data.Error = "Invalid webhook ID"
+		return
+	}
+
+	delivery, err := h.Webhooks.SendTest(id, actorOf(r))
+	if errors.Is(err, models.ErrNotFound) {
+		data.Error = "Webhook not found"
+		return
+	} else if err != nil {
+		log.Printf("Error sending test event to webhook %d: %v", id, err)
+		data.Error = "Failed to send test event"
+		return
+	}
+
+	duration := delivery.Duration.Round(time.Millisecond)
+	if delivery.Delivered() {
+		data.Message = fmt.Sprintf("Test event delivered (HTTP %d in %s)", delivery.StatusCode, duration)
+	} else {
+		data.Error = fmt.Sprintf("Test event failed after %s: %s", duration, delivery.Error)
+	}
+	h.selectWebhook(data, id)
+}
+
+// selectWebhook shows the delivery log of the webhook id, if it exists
+func (h *Handler) selectWebhook(data *models.WebhooksPageData, id int) {
+	if webhook, exists := h.Store.GetWebhook(id); exists {
+		data.Selected = webhook
+		data.Deliveries = h.Store.GetWebhookDeliveries(id)
+	}
+}
+
+// webhookFromForm reads the fields of a webhook from the create and edit
+// forms. Unchecked events leave the filter empty, delivering every event.
+func webhookFromForm(r *http.Request) models.Webhook {
+	activeStr := r.FormValue("active")
+	return models.Webhook{
+		Name:   r.FormValue("name"),
+		URL:    strings.TrimSpace(r.FormValue("url")),
+		Secret: r.FormValue("secret"),
+		Events: r.Form["events"],
+		Active: activeStr == "on" || activeStr == "true",
+	}
+}
+
+func renderWebhooksTemplate(w http.ResponseWriter, r *http.Request, data *models.WebhooksPageData) {
+	// Parse the template file
+	t, err := parseTemplate(w, r, "webhooks.html")
+	if err != nil {
+		log.Printf("Error parsing template: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	// Set content type and execute template
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := t.Execute(w, data); err != nil {
+		log.Printf("Error executing template: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+}
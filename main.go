@@ -1,12 +1,19 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"io"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"fuzzy/config"
 	"fuzzy/handlers"
+	"fuzzy/models"
 )
 
 func main() {
@@ -23,22 +30,19 @@ func main() {
 		log.Printf("Warning: Failed to create data directory: %v", err)
 	}
 
-	// Set up HTTP routes
-	http.HandleFunc("/", handlers.HomeHandler)
-	http.HandleFunc("/health", handlers.HealthHandler)
-	http.HandleFunc("/login", handlers.LoginHandler)
-	http.HandleFunc("/logout", handlers.LogoutHandler)
-	http.HandleFunc("/setup", handlers.SetupHandler)
-	
-	// Static files
-	http.Handle("/static/", http.StripPrefix("/static/", http.FileServer(http.Dir("static/"))))
-	
-	// Protected routes
-	http.HandleFunc("/providers", handlers.RequireSetupOrAuth(handlers.ProvidersHandler))
-	http.HandleFunc("/channels", handlers.RequireSetupOrAuth(handlers.ChannelsHandler))
-	http.HandleFunc("/users", handlers.RequireSetupOrAuth(handlers.UsersHandler))
-	http.HandleFunc("/channel/start", handlers.RequireSetupOrAuth(handlers.ChannelStartHandler))
-	http.HandleFunc("/channel/stop", handlers.RequireSetupOrAuth(handlers.ChannelStopHandler))
+	// Initialize the data store
+	dbConfig := config.AppConfig.Database
+	store, err := models.OpenStore(dbConfig.Type, dbConfig.DataFile, models.Options{
+		ProviderDeletePolicy: models.DeletePolicy(dbConfig.ProviderDeletePolicy),
+	})
+	if err != nil {
+		log.Fatalf("Failed to initialize data store: %v", err)
+	}
+	log.Printf("Using %s database", dbConfig.Type)
+
+	// Set up HTTP routes, wrapped with the security headers and CSRF checks
+	h := handlers.New(store)
+	router := h.Routes()
 
 	// Get server configuration
 	serverAddr := config.AppConfig.GetServerAddress()
@@ -51,8 +55,29 @@ func main() {
 	log.Printf("Health check: http://localhost%s/health", serverAddr)
 	log.Printf("Configuration loaded from: config/config.cfg")
 
-	// Start the HTTP server
-	if err := http.ListenAndServe(serverAddr, nil); err != nil {
-		log.Fatalf("Server failed to start: %v", err)
+	// Start the HTTP server, until interrupted
+	server := &http.Server{Addr: serverAddr, Handler: router}
+	server.RegisterOnShutdown(h.CloseStreams)
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatalf("Server failed to start: %v", err)
+		}
+	}()
+	<-ctx.Done()
+
+	// Finish the requests in flight, then stop the channel processes
+	log.Printf("Shutting down")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		log.Printf("Error shutting down the server: %v", err)
+	}
+	h.Close()
+	if closer, ok := store.(io.Closer); ok {
+		if err := closer.Close(); err != nil {
+			log.Printf("Error closing the data store: %v", err)
+		}
 	}
 }
\ No newline at end of file
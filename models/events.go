@@ -0,0 +1,335 @@
+package models
+
+import (
+	"slices"
+	"sync"
+	"time"
+)
+
+// EntityKind is the kind of entity an Event is about
+type EntityKind string
+
+// Entity kinds
+const (
+	EntityBouquet  EntityKind = "bouquet"
+	EntityUser     EntityKind = "user"
+	EntityChannel  EntityKind = "channel"
+	EntityProvider EntityKind = "provider"
+	EntityProfile  EntityKind = "profile"
+	EntityAPIToken EntityKind = "api_token"
+	EntityWebhook  EntityKind = "webhook"
+)
+
+// EventAction is what happened to the entity of an Event
+type EventAction string
+
+// Event actions
+const (
+	EventCreated      EventAction = "created"
+	EventUpdated      EventAction = "updated"
+	EventDeleted      EventAction = "deleted"
+	EventStateChanged EventAction = "state_changed" // A channel moved to another lifecycle state
+)
+
+// Actor is who made a change: a user, or the server itself for the zero Actor,
+// such as when the supervisor restarts a channel
+type Actor struct {
+	UserID   int    `json:"user_id,omitempty"`
+	Username string `json:"username,omitempty"`
+}
+
+// UserActor returns the actor for changes made by user
+func UserActor(user User) Actor {
+	return Actor{UserID: user.ID, Username: user.Username}
+}
+
+// String returns the username of the actor, or "system" for the server
+func (a Actor) String() string {
+	if a.UserID == 0 {
+		return "system"
+	}
+	return a.Username
+}
+
+// Event is a change to an entity, published once the transaction making it is
+// committed. Before and After hold the entity as the store returns it, typed
+// after Entity: Bouquet, User, Channel, Provider, EncodingProfile, APIToken or
+// Webhook.
+//
+// Every mutation of the Repository publishes events, except those of sessions,
+// UseTOTPStep, TouchAPIToken and AddWebhookDelivery, which only record
+// sign-ins, the use of tokens and the delivery of webhooks.
+// Mutations that change other entities publish an event for each: deleting a
+// provider also reports the bouquets deleted with it, deleting a channel the
+// bouquets it is removed from, and deleting a user its API tokens.
+type Event struct {
+	Entity EntityKind  `json:"entity"`
+	Action EventAction `json:"action"`
+	ID     int         `json:"id"`
+	Before any         `json:"before,omitempty"` // Nil when the entity was created
+	After  any         `json:"after,omitempty"`  // Nil when the entity was deleted
+	Actor  Actor       `json:"actor"`
+	Time   time.Time   `json:"time"` // When the change was committed
+}
+
+// eventBus delivers the events of a store to its subscribers
+type eventBus struct {
+	mutex       sync.Mutex
+	subscribers map[chan Event]struct{}
+}
+
+// Subscribe returns a channel receiving the events committed from now on, and
+// a function to call once done with it. The channel holds up to buffer
+// events: a subscriber that falls further behind is dropped and its channel
+// closed, so it knows to read the current state again.
+func (b *eventBus) Subscribe(buffer int) (<-chan Event, func()) {
+	events := make(chan Event, buffer)
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if b.subscribers == nil {
+		b.subscribers = make(map[chan Event]struct{})
+	}
+	b.subscribers[events] = struct{}{}
+
+	return events, func() {
+		b.mutex.Lock()
+		defer b.mutex.Unlock()
+		if _, subscribed := b.subscribers[events]; subscribed {
+			delete(b.subscribers, events)
+			close(events)
+		}
+	}
+}
+
+// publish stamps events with the current time and delivers them to every
+// subscriber without waiting for any
+func (b *eventBus) publish(events []Event) {
+	if len(events) == 0 {
+		return
+	}
+	now := time.Now()
+	for i := range events {
+		events[i].Time = now
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	for subscriber := range b.subscribers {
+		for _, event := range events {
+			select {
+			case subscriber <- event:
+				continue
+			default:
+			}
+			delete(b.subscribers, subscriber)
+			close(subscriber)
+			break
+		}
+	}
+}
+
+// recordingTx records the events of the changes made through a transaction,
+// for the store to publish once it is committed
+type recordingTx struct {
+	Tx
+	actor  Actor
+	events []Event
+}
+
+func (t *recordingTx) record(entity EntityKind, action EventAction, id int, before, after any) {
+	t.events = append(t.events, Event{Entity: entity, Action: action, ID: id, Before: before, After: after, Actor: t.actor})
+}
+
+// found returns entity when it exists and nil otherwise, for Event.Before and
+// Event.After
+func found[T any](entity T, exists bool) any {
+	if !exists {
+		return nil
+	}
+	return entity
+}
+
+// recordCreated records the creation of the entity id when err is nil
+func recordCreated[T any](t *recordingTx, entity EntityKind, id int, get func(int) (T, bool), err error) error {
+	if err == nil {
+		t.record(entity, EventCreated, id, nil, found(get(id)))
+	}
+	return err
+}
+
+// recordChange runs mutate and records its event when it succeeds, reading the
+// entity id before and after it
+func recordChange[T any](t *recordingTx, entity EntityKind, action EventAction, id int, get func(int) (T, bool), mutate func() error) error {
+	before := found(get(id))
+	if err := mutate(); err != nil {
+		return err
+	}
+	t.record(entity, action, id, before, found(get(id)))
+	return nil
+}
+
+func (t *recordingTx) CreateBouquet(bouquet Bouquet) (Bouquet, error) {
+	created, err := t.Tx.CreateBouquet(bouquet)
+	return created, recordCreated(t, EntityBouquet, created.ID, t.Tx.GetBouquet, err)
+}
+
+func (t *recordingTx) UpdateBouquet(bouquet Bouquet) error {
+	return recordChange(t, EntityBouquet, EventUpdated, bouquet.ID, t.Tx.GetBouquet, func() error {
+		return t.Tx.UpdateBouquet(bouquet)
+	})
+}
+
+func (t *recordingTx) DeleteBouquet(id int) error {
+	return recordChange(t, EntityBouquet, EventDeleted, id, t.Tx.GetBouquet, func() error {
+		return t.Tx.DeleteBouquet(id)
+	})
+}
+
+func (t *recordingTx) AddChannelToBouquet(bouquetID, channelID int) error {
+	return recordChange(t, EntityBouquet, EventUpdated, bouquetID, t.Tx.GetBouquet, func() error {
+		return t.Tx.AddChannelToBouquet(bouquetID, channelID)
+	})
+}
+
+func (t *recordingTx) RemoveChannelFromBouquet(bouquetID, channelID int) error {
+	return recordChange(t, EntityBouquet, EventUpdated, bouquetID, t.Tx.GetBouquet, func() error {
+		return t.Tx.RemoveChannelFromBouquet(bouquetID, channelID)
+	})
+}
+
+func (t *recordingTx) CreateUser(user User) (User, error) {
+	created, err := t.Tx.CreateUser(user)
+	return created, recordCreated(t, EntityUser, created.ID, t.Tx.GetUser, err)
+}
+
+func (t *recordingTx) UpdateUser(user User) error {
+	return recordChange(t, EntityUser, EventUpdated, user.ID, t.Tx.GetUser, func() error {
+		return t.Tx.UpdateUser(user)
+	})
+}
+
+func (t *recordingTx) DeleteUser(id int) error {
+	tokens := t.Tx.GetAPITokensByUser(id)
+	err := recordChange(t, EntityUser, EventDeleted, id, t.Tx.GetUser, func() error {
+		return t.Tx.DeleteUser(id)
+	})
+	if err == nil {
+		for _, token := range tokens {
+			t.record(EntityAPIToken, EventDeleted, token.ID, token, nil)
+		}
+	}
+	return err
+}
+
+func (t *recordingTx) CreateChannel(channel Channel) (Channel, error) {
+	created, err := t.Tx.CreateChannel(channel)
+	return created, recordCreated(t, EntityChannel, created.ID, t.Tx.GetChannel, err)
+}
+
+func (t *recordingTx) UpdateChannel(channel Channel) error {
+	return recordChange(t, EntityChannel, EventUpdated, channel.ID, t.Tx.GetChannel, func() error {
+		return t.Tx.UpdateChannel(channel)
+	})
+}
+
+func (t *recordingTx) DeleteChannel(id int) error {
+	var bouquets []Bouquet
+	for _, bouquet := range t.Tx.GetAllBouquets() {
+		if slices.Contains(bouquet.ChannelIDs, id) {
+			bouquets = append(bouquets, bouquet)
+		}
+	}
+	err := recordChange(t, EntityChannel, EventDeleted, id, t.Tx.GetChannel, func() error {
+		return t.Tx.DeleteChannel(id)
+	})
+	if err == nil {
+		for _, bouquet := range bouquets {
+			t.record(EntityBouquet, EventUpdated, bouquet.ID, bouquet, found(t.Tx.GetBouquet(bouquet.ID)))
+		}
+	}
+	return err
+}
+
+func (t *recordingTx) StartChannel(channelID, port int) error {
+	return recordChange(t, EntityChannel, EventStateChanged, channelID, t.Tx.GetChannel, func() error {
+		return t.Tx.StartChannel(channelID, port)
+	})
+}
+
+func (t *recordingTx) SetChannelState(channelID int, state ChannelState, pid int, lastError string) error {
+	return recordChange(t, EntityChannel, EventStateChanged, channelID, t.Tx.GetChannel, func() error {
+		return t.Tx.SetChannelState(channelID, state, pid, lastError)
+	})
+}
+
+func (t *recordingTx) CreateProvider(provider Provider) (Provider, error) {
+	created, err := t.Tx.CreateProvider(provider)
+	return created, recordCreated(t, EntityProvider, created.ID, t.Tx.GetProvider, err)
+}
+
+func (t *recordingTx) UpdateProvider(provider Provider) error {
+	return recordChange(t, EntityProvider, EventUpdated, provider.ID, t.Tx.GetProvider, func() error {
+		return t.Tx.UpdateProvider(provider)
+	})
+}
+
+func (t *recordingTx) DeleteProvider(id int) error {
+	bouquets := t.Tx.GetBouquetsByProvider(id)
+	before := found(t.Tx.GetProvider(id))
+	if err := t.Tx.DeleteProvider(id); err != nil {
+		return err
+	}
+	// Bouquets deleted with the provider under the cascade policy
+	for _, bouquet := range bouquets {
+		if _, exists := t.Tx.GetBouquet(bouquet.ID); !exists {
+			t.record(EntityBouquet, EventDeleted, bouquet.ID, bouquet, nil)
+		}
+	}
+	t.record(EntityProvider, EventDeleted, id, before, nil)
+	return nil
+}
+
+func (t *recordingTx) CreateProfile(profile EncodingProfile) (EncodingProfile, error) {
+	created, err := t.Tx.CreateProfile(profile)
+	return created, recordCreated(t, EntityProfile, created.ID, t.Tx.GetProfile, err)
+}
+
+func (t *recordingTx) UpdateProfile(profile EncodingProfile) error {
+	return recordChange(t, EntityProfile, EventUpdated, profile.ID, t.Tx.GetProfile, func() error {
+		return t.Tx.UpdateProfile(profile)
+	})
+}
+
+func (t *recordingTx) DeleteProfile(id int) error {
+	return recordChange(t, EntityProfile, EventDeleted, id, t.Tx.GetProfile, func() error {
+		return t.Tx.DeleteProfile(id)
+	})
+}
+
+func (t *recordingTx) CreateAPIToken(token APIToken) (APIToken, error) {
+	created, err := t.Tx.CreateAPIToken(token)
+	return created, recordCreated(t, EntityAPIToken, created.ID, t.Tx.GetAPIToken, err)
+}
+
+func (t *recordingTx) DeleteAPIToken(id int) error {
+	return recordChange(t, EntityAPIToken, EventDeleted, id, t.Tx.GetAPIToken, func() error {
+		return t.Tx.DeleteAPIToken(id)
+	})
+}
+
+func (t *recordingTx) CreateWebhook(webhook Webhook) (Webhook, error) {
+	created, err := t.Tx.CreateWebhook(webhook)
+	return created, recordCreated(t, EntityWebhook, created.ID, t.Tx.GetWebhook, err)
+}
+
+func (t *recordingTx) UpdateWebhook(webhook Webhook) error {
+	return recordChange(t, EntityWebhook, EventUpdated, webhook.ID, t.Tx.GetWebhook, func() error {
+		return t.Tx.UpdateWebhook(webhook)
+	})
+}
+
+func (t *recordingTx) DeleteWebhook(id int) error {
+	return recordChange(t, EntityWebhook, EventDeleted, id, t.Tx.GetWebhook, func() error {
+		return t.Tx.DeleteWebhook(id)
+	})
+}
@@ -0,0 +1,169 @@
+package models
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// receive returns the events waiting on events, and whether it is still open
+func receive(events <-chan Event) ([]Event, bool) {
+	var received []Event
+	for {
+		select {
+		case event, open := <-events:
+			if !open {
+				return received, false
+			}
+			received = append(received, event)
+		default:
+			return received, true
+		}
+	}
+}
+
+// TestEventsPublished checks that the changes of an Update are published once
+// it commits, with the entity before and after and the actor, and that
+// rolled back and bookkeeping writes publish nothing
+func TestEventsPublished(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, store Store) {
+		user, err := store.CreateUser(User{Username: "alice", Password: "hash", Role: RoleAdmin, Active: true})
+		if err != nil {
+			t.Fatalf("CreateUser: %v", err)
+		}
+		actor := UserActor(user)
+		events, unsubscribe := store.Subscribe(100)
+		defer unsubscribe()
+
+		var provider Provider
+		var channel Channel
+		err = store.As(actor).Update(func(tx Tx) error {
+			var err error
+			if provider, err = tx.CreateProvider(Provider{Name: "BBC", Active: true}); err != nil {
+				return err
+			}
+			if channel, err = tx.CreateChannel(Channel{Name: "BBC One", Manifest: "https://example.com/one.mpd", KeyKid: "key:kid", State: ChannelStopped}); err != nil {
+				return err
+			}
+			if pending, _ := receive(events); len(pending) != 0 {
+				t.Errorf("events published before the commit: %+v", pending)
+			}
+			renamed := provider
+			renamed.Name = "BBC Studios"
+			return tx.UpdateProvider(renamed)
+		})
+		if err != nil {
+			t.Fatalf("Update: %v", err)
+		}
+
+		received, _ := receive(events)
+		want := []struct {
+			entity EntityKind
+			action EventAction
+			before string // Name of the entity before the change, "" when created
+			after  string
+		}{
+			{EntityProvider, EventCreated, "", "BBC"},
+			{EntityChannel, EventCreated, "", "BBC One"},
+			{EntityProvider, EventUpdated, "BBC", "BBC Studios"},
+		}
+		if len(received) != len(want) {
+			t.Fatalf("%d events, want %d: %+v", len(received), len(want), received)
+		}
+		// name returns the name of an entity of an event, or "" for nil
+		name := func(entity any) string {
+			switch entity := entity.(type) {
+			case Provider:
+				return entity.Name
+			case Channel:
+				return entity.Name
+			}
+			return ""
+		}
+		for i, event := range received {
+			if event.Entity != want[i].entity || event.Action != want[i].action ||
+				name(event.Before) != want[i].before || name(event.After) != want[i].after {
+				t.Errorf("event %d: %s %s %q → %q, want %s %s %q → %q", i, event.Entity, event.Action,
+					name(event.Before), name(event.After), want[i].entity, want[i].action, want[i].before, want[i].after)
+			}
+			if want[i].before == "" && event.Before != nil {
+				t.Errorf("event %d: Before %+v, want nil", i, event.Before)
+			}
+			if event.Actor != actor || event.Time.IsZero() {
+				t.Errorf("event %d: actor %+v at %v, want %+v", i, event.Actor, event.Time, actor)
+			}
+		}
+		if received[1].ID != channel.ID || received[2].ID != provider.ID {
+			t.Errorf("event IDs %d, %d; want %d, %d", received[1].ID, received[2].ID, channel.ID, provider.ID)
+		}
+
+		// Deleting an entity reports Before only, and the changes it causes
+		if _, err := store.CreateBouquet(Bouquet{Name: "News", ProviderID: provider.ID, ChannelIDs: []int{channel.ID}}); err != nil {
+			t.Fatalf("CreateBouquet: %v", err)
+		}
+		receive(events)
+		if err := store.DeleteChannel(channel.ID); err != nil {
+			t.Fatalf("DeleteChannel: %v", err)
+		}
+		received, _ = receive(events)
+		if len(received) != 2 || received[0].Entity != EntityChannel || received[0].Action != EventDeleted || received[0].After != nil || name(received[0].Before) != "BBC One" ||
+			received[1].Entity != EntityBouquet || received[1].Action != EventUpdated {
+			t.Fatalf("deleting a channel in a bouquet published %+v", received)
+		}
+		if received[0].Actor != (Actor{}) {
+			t.Errorf("change without actor attributed to %+v", received[0].Actor)
+		}
+
+		// Rolled back changes and bookkeeping publish nothing
+		errAbort := errors.New("abort")
+		err = store.Update(func(tx Tx) error {
+			if _, err := tx.CreateProvider(Provider{Name: "ITV"}); err != nil {
+				return err
+			}
+			return errAbort
+		})
+		if !errors.Is(err, errAbort) {
+			t.Fatalf("Update: %v", err)
+		}
+		token, err := store.CreateAPIToken(APIToken{UserID: user.ID, Name: "deploy", Hash: "token-hash"})
+		if err != nil {
+			t.Fatalf("CreateAPIToken: %v", err)
+		}
+		receive(events)
+		if err := store.SaveSession(Session{ID: "session-hash", UserID: user.ID, CreatedAt: time.Now(), LastSeen: time.Now()}); err != nil {
+			t.Fatalf("SaveSession: %v", err)
+		}
+		if err := store.TouchAPIToken(token.ID, time.Now()); err != nil {
+			t.Fatalf("TouchAPIToken: %v", err)
+		}
+		if err := store.DeleteSession("session-hash"); err != nil {
+			t.Fatalf("DeleteSession: %v", err)
+		}
+		if received, _ := receive(events); len(received) != 0 {
+			t.Errorf("events published for rolled back or bookkeeping writes: %+v", received)
+		}
+	})
+}
+
+// TestSlowSubscriberDropped checks that a subscriber falling further behind
+// than its buffer is dropped and its channel closed, without holding up the
+// others
+func TestSlowSubscriberDropped(t *testing.T) {
+	store := NewMemoryStore()
+	slow, unsubscribeSlow := store.Subscribe(2)
+	fast, unsubscribeFast := store.Subscribe(10)
+	defer unsubscribeFast()
+
+	for _, name := range []string{"BBC", "ITV", "Sky"} {
+		if _, err := store.CreateProvider(Provider{Name: name}); err != nil {
+			t.Fatalf("CreateProvider: %v", err)
+		}
+	}
+	if received, open := receive(slow); len(received) != 2 || open {
+		t.Errorf("slow subscriber: %d events, open %v; want 2, closed", len(received), open)
+	}
+	if received, open := receive(fast); len(received) != 3 || !open {
+		t.Errorf("fast subscriber: %d events, open %v; want 3, open", len(received), open)
+	}
+	unsubscribeSlow() // Safe once dropped
+}
@@ -0,0 +1,88 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// ChannelState is the lifecycle state of a channel
+type ChannelState string
+
+// Channel states
+const (
+	ChannelStopped    ChannelState = "stopped"    // Not started
+	ChannelStarting   ChannelState = "starting"   // Started, its process is being launched
+	ChannelRunning    ChannelState = "running"    // Its process is running
+	ChannelRestarting ChannelState = "restarting" // Its process exited and is about to be launched again
+	ChannelStopping   ChannelState = "stopping"   // Its process is being stopped
+	ChannelFailed     ChannelState = "failed"     // It could not start, or the server stopped while it ran
+)
+
+// ChannelStates lists the channel states
+var ChannelStates = []ChannelState{ChannelStopped, ChannelStarting, ChannelRunning, ChannelRestarting, ChannelStopping, ChannelFailed}
+
+// channelTransitions lists the states a channel can move to from each state
+var channelTransitions = map[ChannelState][]ChannelState{
+	ChannelStopped:    {ChannelStarting},
+	ChannelStarting:   {ChannelRunning, ChannelStopping, ChannelFailed},
+	ChannelRunning:    {ChannelRestarting, ChannelStopping, ChannelFailed},
+	ChannelRestarting: {ChannelRunning, ChannelRestarting, ChannelStopping, ChannelFailed},
+	ChannelStopping:   {ChannelStopped, ChannelFailed},
+	ChannelFailed:     {ChannelStarting, ChannelStopped},
+}
+
+// CanBecome reports whether a channel in state s can move to state to
+func (s ChannelState) CanBecome(to ChannelState) bool {
+	return slices.Contains(channelTransitions[s], to)
+}
+
+// Active reports whether a channel in state s is started: it holds its remux
+// port and has a process, or is about to
+func (s ChannelState) Active() bool {
+	return s == ChannelStarting || s == ChannelRunning || s == ChannelRestarting || s == ChannelStopping
+}
+
+// Label returns the state as shown in pages, e.g. "Running"
+func (s ChannelState) Label() string {
+	if s == "" {
+		return ""
+	}
+	return strings.ToUpper(string(s[:1])) + string(s[1:])
+}
+
+// CanStart reports whether the channel can be started
+func (c Channel) CanStart() bool {
+	return c.State.CanBecome(ChannelStarting)
+}
+
+// CanStop reports whether the channel can be stopped, which for a failed
+// channel only clears the failure
+func (c Channel) CanStop() bool {
+	return c.State.CanBecome(ChannelStopping) || c.State == ChannelFailed
+}
+
+// TransitionError is returned when a channel cannot move to a state from the
+// one it is in, such as when starting a channel that is already running
+type TransitionError struct {
+	ID   int
+	From ChannelState
+	To   ChannelState
+}
+
+func (e *TransitionError) Error() string {
+	return fmt.Sprintf("channel %d cannot go from %s to %s", e.ID, e.From, e.To)
+}
+
+// errStartThroughStartChannel is returned by SetChannelState for the starting
+// state, which needs a remux port
+var errStartThroughStartChannel = errors.New("channels are started through StartChannel")
+
+// CheckTransition returns a *TransitionError when channel cannot move to state to
+func CheckTransition(channel Channel, to ChannelState) error {
+	if !channel.State.CanBecome(to) {
+		return &TransitionError{ID: channel.ID, From: channel.State, To: to}
+	}
+	return nil
+}
@@ -0,0 +1,149 @@
+package models
+
+import (
+	"errors"
+	"testing"
+)
+
+// TestCheckTransition checks every pair of states against the transitions
+// the supervisor relies on
+func TestCheckTransition(t *testing.T) {
+	legal := map[[2]ChannelState]bool{
+		{ChannelStopped, ChannelStarting}:      true,
+		{ChannelStarting, ChannelRunning}:      true,
+		{ChannelStarting, ChannelStopping}:     true,
+		{ChannelStarting, ChannelFailed}:       true,
+		{ChannelRunning, ChannelRestarting}:    true,
+		{ChannelRunning, ChannelStopping}:      true,
+		{ChannelRunning, ChannelFailed}:        true,
+		{ChannelRestarting, ChannelRunning}:    true,
+		{ChannelRestarting, ChannelRestarting}: true, // Another failed launch
+		{ChannelRestarting, ChannelStopping}:   true,
+		{ChannelRestarting, ChannelFailed}:     true,
+		{ChannelStopping, ChannelStopped}:      true,
+		{ChannelStopping, ChannelFailed}:       true,
+		{ChannelFailed, ChannelStarting}:       true,
+		{ChannelFailed, ChannelStopped}:        true,
+	}
+	for _, from := range ChannelStates {
+		for _, to := range ChannelStates {
+			err := CheckTransition(Channel{ID: 7, State: from}, to)
+			if legal[[2]ChannelState{from, to}] {
+				if err != nil {
+					t.Errorf("%s -> %s: %v, want allowed", from, to, err)
+				}
+				continue
+			}
+			var transitionErr *TransitionError
+			if !errors.As(err, &transitionErr) {
+				t.Errorf("%s -> %s: %v, want a *TransitionError", from, to, err)
+				continue
+			}
+			if *transitionErr != (TransitionError{ID: 7, From: from, To: to}) {
+				t.Errorf("%s -> %s: %+v", from, to, *transitionErr)
+			}
+		}
+	}
+}
+
+// TestChannelStateActions checks which states are started and which offer
+// the start and stop buttons
+func TestChannelStateActions(t *testing.T) {
+	tests := []struct {
+		state                     ChannelState
+		active, canStart, canStop bool
+	}{
+		{ChannelStopped, false, true, false},
+		{ChannelStarting, true, false, true},
+		{ChannelRunning, true, false, true},
+		{ChannelRestarting, true, false, true},
+		{ChannelStopping, true, false, false},
+		{ChannelFailed, false, true, true},
+	}
+	for _, test := range tests {
+		channel := Channel{State: test.state}
+		if test.state.Active() != test.active || channel.CanStart() != test.canStart || channel.CanStop() != test.canStop {
+			t.Errorf("%s: active %v, can start %v, can stop %v", test.state, test.state.Active(), channel.CanStart(), channel.CanStop())
+		}
+	}
+}
+
+// TestChannelRuntimeStateKeepsVersion checks that starting and stopping a
+// channel, in every backend, follows the transitions without changing its
+// Version, while an edit still does
+func TestChannelRuntimeStateKeepsVersion(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, store Store) {
+		channel, err := store.CreateChannel(Channel{Name: "BBC One", Manifest: "https://example.com/m.mpd", KeyKid: "key:kid"})
+		if err != nil {
+			t.Fatalf("CreateChannel: %v", err)
+		}
+		if channel.State != ChannelStopped || channel.Version != 1 {
+			t.Fatalf("new channel: state %s, version %d", channel.State, channel.Version)
+		}
+
+		check := func(step string, state ChannelState, running bool) Channel {
+			t.Helper()
+			got, _ := store.GetChannel(channel.ID)
+			if got.State != state || got.Running != running || got.Version != 1 {
+				t.Errorf("after %s: state %s, running %v, version %d, want %s, %v, 1", step, got.State, got.Running, got.Version, state, running)
+			}
+			return got
+		}
+
+		if err := store.StartChannel(channel.ID, 8000); err != nil {
+			t.Fatalf("StartChannel: %v", err)
+		}
+		started := check("start", ChannelStarting, true)
+		if started.RemuxPort != 8000 || started.StartedAt.IsZero() {
+			t.Errorf("started channel: port %d, started at %v", started.RemuxPort, started.StartedAt)
+		}
+
+		steps := []struct {
+			state     ChannelState
+			lastError string
+		}{
+			{ChannelRunning, ""},
+			{ChannelRestarting, "exit status 1"},
+			{ChannelRunning, ""},
+			{ChannelStopping, ""},
+			{ChannelStopped, ""},
+		}
+		for _, step := range steps {
+			if err := store.SetChannelState(channel.ID, step.state, 42, step.lastError); err != nil {
+				t.Fatalf("SetChannelState(%s): %v", step.state, err)
+			}
+			check(string(step.state), step.state, step.state.Active())
+		}
+		stopped := check("stop", ChannelStopped, false)
+		if stopped.RemuxPort != 8000 || stopped.LastError != "exit status 1" || stopped.StoppedAt.IsZero() {
+			t.Errorf("stopped channel: port %d, last error %q, stopped at %v", stopped.RemuxPort, stopped.LastError, stopped.StoppedAt)
+		}
+
+		var transitionErr *TransitionError
+		if err := store.SetChannelState(channel.ID, ChannelRunning, 1, ""); !errors.As(err, &transitionErr) {
+			t.Errorf("stopped -> running: %v, want a *TransitionError", err)
+		}
+		if err := store.SetChannelState(channel.ID, ChannelStarting, 1, ""); err == nil {
+			t.Errorf("SetChannelState(starting) allowed")
+		}
+		if err := store.StartChannel(channel.ID, 8000); err != nil {
+			t.Fatalf("starting again: %v", err)
+		}
+		if err := store.StartChannel(channel.ID, 8000); !errors.As(err, &transitionErr) {
+			t.Errorf("double start: %v, want a *TransitionError", err)
+		}
+		check("failed transitions", ChannelStarting, true)
+
+		// An edit changes the version and leaves the runtime state alone
+		edited, _ := store.GetChannel(channel.ID)
+		edited.Name = "BBC One HD"
+		edited.State = ChannelStopped
+		edited.Running = false
+		if err := store.UpdateChannel(edited); err != nil {
+			t.Fatalf("UpdateChannel: %v", err)
+		}
+		if got, _ := store.GetChannel(channel.ID); got.Version != 2 || got.State != ChannelStarting || !got.Running || got.Name != "BBC One HD" {
+			t.Errorf("after edit: %+v", got)
+		}
+	})
+}
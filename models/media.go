@@ -0,0 +1,299 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// VideoCodec is the video codec a channel is encoded with
+type VideoCodec string
+
+// Supported video codecs
+const (
+	VideoCodecH264 VideoCodec = "x264"
+	VideoCodecH265 VideoCodec = "x265"
+	VideoCodecAV1  VideoCodec = "AV1"
+	VideoCodecVP9  VideoCodec = "VP9"
+)
+
+// VideoCodecs lists the supported video codecs
+var VideoCodecs = []VideoCodec{VideoCodecH264, VideoCodecH265, VideoCodecAV1, VideoCodecVP9}
+
+// AudioCodec is the audio codec a channel is encoded with
+type AudioCodec string
+
+// Supported audio codecs
+const (
+	AudioCodecAAC AudioCodec = "AAC"
+	AudioCodecMP3 AudioCodec = "MP3"
+	AudioCodecAC3 AudioCodec = "AC3"
+	AudioCodecDTS AudioCodec = "DTS"
+)
+
+// AudioCodecs lists the supported audio codecs
+var AudioCodecs = []AudioCodec{AudioCodecAAC, AudioCodecMP3, AudioCodecAC3, AudioCodecDTS}
+
+// Valid reports whether c is a supported video codec
+func (c VideoCodec) Valid() bool {
+	_, ok := canonicalName(string(c), VideoCodecs)
+	return ok
+}
+
+// UnmarshalText reads a codec in any case. Unknown codecs are kept as they
+// are, for validation to report.
+func (c *VideoCodec) UnmarshalText(text []byte) error {
+	*c, _ = canonicalName(string(text), VideoCodecs)
+	return nil
+}
+
+// Valid reports whether c is a supported audio codec
+func (c AudioCodec) Valid() bool {
+	_, ok := canonicalName(string(c), AudioCodecs)
+	return ok
+}
+
+// UnmarshalText reads a codec in any case. Unknown codecs are kept as they
+// are, for validation to report.
+func (c *AudioCodec) UnmarshalText(text []byte) error {
+	*c, _ = canonicalName(string(text), AudioCodecs)
+	return nil
+}
+
+// canonicalName returns the allowed name matching value regardless of case
+// and surrounding spaces, or value itself when none does
+func canonicalName[T ~string](value string, allowed []T) (T, bool) {
+	trimmed := strings.TrimSpace(value)
+	for _, name := range allowed {
+		if strings.EqualFold(trimmed, string(name)) {
+			return name, true
+		}
+	}
+	return T(value), false
+}
+
+// Bitrate is a bit rate in bits per second. Its text form is the one
+// channels have always stored, kbit/s such as "5000k", and parsing also
+// accepts Mbit/s such as "2.5M" and plain bits per second.
+type Bitrate int64
+
+// Bitrate units
+const (
+	BitPerSecond Bitrate = 1
+	Kbps                 = 1000 * BitPerSecond
+	Mbps                 = 1000 * Kbps
+)
+
+// ParseBitrate parses the text form of a bitrate. An empty value is a zero
+// bitrate, meaning unset.
+func ParseBitrate(value string) (Bitrate, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, nil
+	}
+
+	unit, digits := BitPerSecond, value
+	switch value[len(value)-1] {
+	case 'k', 'K':
+		unit, digits = Kbps, value[:len(value)-1]
+	case 'm', 'M':
+		unit, digits = Mbps, value[:len(value)-1]
+	}
+	if digits == "" || strings.Trim(digits, "0123456789.") != "" {
+		return 0, fmt.Errorf(`invalid bitrate %q, expected kbit/s or Mbit/s such as "5000k" or "2.5M"`, value)
+	}
+	number, err := strconv.ParseFloat(digits, 64)
+	if err != nil || number <= 0 || number > 1e12/float64(unit) {
+		return 0, fmt.Errorf("invalid bitrate %q", value)
+	}
+
+	bps := number * float64(unit)
+	if bps != float64(int64(bps)) {
+		return 0, fmt.Errorf("invalid bitrate %q, expected a whole number of bits per second", value)
+	}
+	return Bitrate(bps), nil
+}
+
+// Kbps returns the bitrate in kbit/s, rounded down
+func (b Bitrate) Kbps() int64 {
+	return int64(b / Kbps)
+}
+
+// String formats the bitrate in kbit/s, or in bit/s when it is not a whole
+// number of kbit/s. A zero bitrate formats as an empty string.
+func (b Bitrate) String() string {
+	switch {
+	case b == 0:
+		return ""
+	case b%Kbps == 0:
+		return strconv.FormatInt(b.Kbps(), 10) + "k"
+	default:
+		return strconv.FormatInt(int64(b), 10)
+	}
+}
+
+// MarshalText encodes the bitrate in its string form
+func (b Bitrate) MarshalText() ([]byte, error) {
+	return []byte(b.String()), nil
+}
+
+// UnmarshalText parses the string form of a bitrate
+func (b *Bitrate) UnmarshalText(text []byte) error {
+	parsed, err := ParseBitrate(string(text))
+	if err != nil {
+		return err
+	}
+	*b = parsed
+	return nil
+}
+
+// UnmarshalJSON accepts a string such as "5000k" or a number of bits per second
+func (b *Bitrate) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		return nil
+	}
+	if len(data) > 0 && data[0] == '"' {
+		var text string
+		if err := json.Unmarshal(data, &text); err != nil {
+			return err
+		}
+		return b.UnmarshalText([]byte(text))
+	}
+	bps, err := strconv.ParseInt(string(data), 10, 64)
+	if err != nil || bps < 0 {
+		return fmt.Errorf("invalid bitrate %s, expected a number of bits per second", data)
+	}
+	*b = Bitrate(bps)
+	return nil
+}
+
+// Resolution is the frame size of a channel in pixels. Its text form is the
+// name of a preset such as "1080p", or "1920x1080" for other sizes.
+type Resolution struct {
+	Width  int
+	Height int
+}
+
+// Named resolutions
+var (
+	Resolution720p  = Resolution{Width: 1280, Height: 720}
+	Resolution1080p = Resolution{Width: 1920, Height: 1080}
+	Resolution1440p = Resolution{Width: 2560, Height: 1440}
+	Resolution2160p = Resolution{Width: 3840, Height: 2160}
+)
+
+// ResolutionPresets lists the named resolutions, smallest first. A preset
+// is named after its height, as in "1080p".
+var ResolutionPresets = []Resolution{Resolution720p, Resolution1080p, Resolution1440p, Resolution2160p}
+
+// ParseResolution parses a preset name such as "1080p" or a size such as
+// "1920x1080". An empty value is the zero resolution, meaning unset.
+func ParseResolution(value string) (Resolution, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return Resolution{}, nil
+	}
+
+	if height, isPreset := strings.CutSuffix(strings.ToLower(value), "p"); isPreset {
+		for _, preset := range ResolutionPresets {
+			if strconv.Itoa(preset.Height) == height {
+				return preset, nil
+			}
+		}
+		return Resolution{}, fmt.Errorf("unknown resolution %q", value)
+	}
+
+	width, height, found := strings.Cut(strings.ReplaceAll(strings.ToLower(value), "×", "x"), "x")
+	if !found {
+		return Resolution{}, fmt.Errorf(`invalid resolution %q, expected a preset such as "1080p" or a size such as "1920x1080"`, value)
+	}
+	w, errW := strconv.Atoi(strings.TrimSpace(width))
+	h, errH := strconv.Atoi(strings.TrimSpace(height))
+	if errW != nil || errH != nil || w <= 0 || h <= 0 {
+		return Resolution{}, fmt.Errorf("invalid resolution %q", value)
+	}
+	return Resolution{Width: w, Height: h}, nil
+}
+
+// IsZero reports whether the resolution is unset
+func (r Resolution) IsZero() bool {
+	return r == Resolution{}
+}
+
+// Pixels returns the number of pixels in a frame, for comparing resolutions
+func (r Resolution) Pixels() int {
+	return r.Width * r.Height
+}
+
+// Preset returns the name of the resolution if it is a preset
+func (r Resolution) Preset() (string, bool) {
+	for _, preset := range ResolutionPresets {
+		if r == preset {
+			return strconv.Itoa(r.Height) + "p", true
+		}
+	}
+	return "", false
+}
+
+// String returns the preset name of the resolution, or its size as
+// "1920x1080". The zero resolution formats as an empty string.
+func (r Resolution) String() string {
+	if r.IsZero() {
+		return ""
+	}
+	if name, ok := r.Preset(); ok {
+		return name
+	}
+	return strconv.Itoa(r.Width) + "x" + strconv.Itoa(r.Height)
+}
+
+// MarshalText encodes the resolution in its string form
+func (r Resolution) MarshalText() ([]byte, error) {
+	return []byte(r.String()), nil
+}
+
+// UnmarshalText parses the string form of a resolution
+func (r *Resolution) UnmarshalText(text []byte) error {
+	parsed, err := ParseResolution(string(text))
+	if err != nil {
+		return err
+	}
+	*r = parsed
+	return nil
+}
+
+// MediaSettings are the encoding settings of a channel or an encoding
+// profile. A zero field is unset: channels take it from their profile.
+type MediaSettings struct {
+	VideoCodec   VideoCodec `json:"video_codec"`   // x264, x265, AV1, VP9
+	AudioCodec   AudioCodec `json:"audio_codec"`   // AAC, MP3, AC3, DTS
+	Resolution   Resolution `json:"resolution"`    // Encoded as a preset ("1080p") or a size ("1920x1080")
+	VideoBitrate Bitrate    `json:"video_bitrate"` // Encoded in kbit/s (e.g., "5000k", "8000k")
+	AudioBitrate Bitrate    `json:"audio_bitrate"` // Encoded in kbit/s (e.g., "128k", "256k")
+}
+
+// IsZero reports whether every setting is unset
+func (s MediaSettings) IsZero() bool {
+	return s == MediaSettings{}
+}
+
+// Over returns the settings with those left unset taken from base
+func (s MediaSettings) Over(base MediaSettings) MediaSettings {
+	if s.VideoCodec == "" {
+		s.VideoCodec = base.VideoCodec
+	}
+	if s.AudioCodec == "" {
+		s.AudioCodec = base.AudioCodec
+	}
+	if s.Resolution.IsZero() {
+		s.Resolution = base.Resolution
+	}
+	if s.VideoBitrate == 0 {
+		s.VideoBitrate = base.VideoBitrate
+	}
+	if s.AudioBitrate == 0 {
+		s.AudioBitrate = base.AudioBitrate
+	}
+	return s
+}
@@ -0,0 +1,193 @@
+package models
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+// TestParseBitrate checks the bitrate forms accepted, in bits per second or
+// with a k or M suffix
+func TestParseBitrate(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    Bitrate
+		wantErr bool
+	}{
+		{"5000k", 5000 * Kbps, false},
+		{"5000K", 5000 * Kbps, false},
+		{" 128k ", 128 * Kbps, false},
+		{"5M", 5 * Mbps, false},
+		{"2.5m", 2500 * Kbps, false},
+		{"96000", 96 * Kbps, false},
+		{"", 0, false},
+		{"k", 0, true},
+		{"fast", 0, true},
+		{"-5k", 0, true},
+		{"0k", 0, true},
+		{"1.5", 0, true}, // Not a whole number of bits
+		{"5 Mbps", 0, true},
+		{"1.2.3k", 0, true},
+	}
+	for _, test := range tests {
+		got, err := ParseBitrate(test.value)
+		if (err != nil) != test.wantErr || got != test.want {
+			t.Errorf("ParseBitrate(%q) = %d, %v; want %d, error %v", test.value, got, err, test.want, test.wantErr)
+		}
+	}
+}
+
+// TestBitrateString checks that a bitrate prints in the form it parses from
+func TestBitrateString(t *testing.T) {
+	tests := []struct {
+		bitrate Bitrate
+		want    string
+	}{
+		{0, ""},
+		{5000 * Kbps, "5000k"},
+		{5 * Mbps, "5000k"},
+		{1500, "1500"},
+	}
+	for _, test := range tests {
+		if got := test.bitrate.String(); got != test.want {
+			t.Errorf("Bitrate(%d).String() = %q, want %q", int64(test.bitrate), got, test.want)
+		}
+		if parsed, err := ParseBitrate(test.want); err != nil || parsed != test.bitrate {
+			t.Errorf("ParseBitrate(%q) = %d, %v; want %d", test.want, parsed, err, test.bitrate)
+		}
+	}
+}
+
+// TestParseResolution checks the named and WIDTHxHEIGHT resolutions accepted
+func TestParseResolution(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    Resolution
+		wantErr bool
+	}{
+		{"1080p", Resolution1080p, false},
+		{"720P", Resolution720p, false},
+		{"2160p", Resolution2160p, false},
+		{"1920x1080", Resolution1080p, false},
+		{"1920×800", Resolution{Width: 1920, Height: 800}, false},
+		{" 1280 X 534 ", Resolution{Width: 1280, Height: 534}, false},
+		{"", Resolution{}, false},
+		{"480p", Resolution{}, true},
+		{"huge", Resolution{}, true},
+		{"1920x", Resolution{}, true},
+		{"0x1080", Resolution{}, true},
+		{"-1920x1080", Resolution{}, true},
+	}
+	for _, test := range tests {
+		got, err := ParseResolution(test.value)
+		if (err != nil) != test.wantErr || got != test.want {
+			t.Errorf("ParseResolution(%q) = %v, %v; want %v, error %v", test.value, got, err, test.want, test.wantErr)
+		}
+	}
+}
+
+// TestResolutionString checks that the named resolutions print by name
+func TestResolutionString(t *testing.T) {
+	tests := []struct {
+		resolution Resolution
+		want       string
+	}{
+		{Resolution{}, ""},
+		{Resolution1080p, "1080p"},
+		{Resolution{Width: 1920, Height: 800}, "1920x800"},
+	}
+	for _, test := range tests {
+		if got := test.resolution.String(); got != test.want {
+			t.Errorf("%+v.String() = %q, want %q", test.resolution, got, test.want)
+		}
+	}
+}
+
+// TestCodecNames checks that codec names are matched in any case and spacing,
+// and that unknown names are kept for validation to report
+func TestCodecNames(t *testing.T) {
+	videoTests := []struct {
+		value string
+		want  VideoCodec
+		valid bool
+	}{
+		{"x264", VideoCodecH264, true},
+		{"X265", VideoCodecH265, true},
+		{" av1 ", VideoCodecAV1, true},
+		{"vp9", VideoCodecVP9, true},
+		{"mpeg2", "mpeg2", false},
+		{"", "", false},
+	}
+	for _, test := range videoTests {
+		var codec VideoCodec
+		if err := codec.UnmarshalText([]byte(test.value)); err != nil {
+			t.Fatalf("UnmarshalText(%q): %v", test.value, err)
+		}
+		if codec != test.want || codec.Valid() != test.valid {
+			t.Errorf("video codec %q: %q, valid %v; want %q, %v", test.value, codec, codec.Valid(), test.want, test.valid)
+		}
+	}
+
+	audioTests := []struct {
+		value string
+		want  AudioCodec
+		valid bool
+	}{
+		{"aac", AudioCodecAAC, true},
+		{"Mp3", AudioCodecMP3, true},
+		{"AC3", AudioCodecAC3, true},
+		{"dts", AudioCodecDTS, true},
+		{"opus", "opus", false},
+	}
+	for _, test := range audioTests {
+		var codec AudioCodec
+		if err := codec.UnmarshalText([]byte(test.value)); err != nil {
+			t.Fatalf("UnmarshalText(%q): %v", test.value, err)
+		}
+		if codec != test.want || codec.Valid() != test.valid {
+			t.Errorf("audio codec %q: %q, valid %v; want %q, %v", test.value, codec, codec.Valid(), test.want, test.valid)
+		}
+	}
+}
+
+// TestMediaSettingsJSON checks that settings encode in the text form channels
+// have always used and decode back, from text or from numbers
+func TestMediaSettingsJSON(t *testing.T) {
+	settings := MediaSettings{
+		VideoCodec:   VideoCodecAV1,
+		AudioCodec:   AudioCodecAAC,
+		Resolution:   Resolution{Width: 1920, Height: 800},
+		VideoBitrate: 5 * Mbps,
+		AudioBitrate: 128 * Kbps,
+	}
+	encoded, err := json.Marshal(settings)
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+	want := `{"video_codec":"AV1","audio_codec":"AAC","resolution":"1920x800","video_bitrate":"5000k","audio_bitrate":"128k"}`
+	if string(encoded) != want {
+		t.Errorf("encoded %s, want %s", encoded, want)
+	}
+	var decoded MediaSettings
+	if err := json.Unmarshal(encoded, &decoded); err != nil || decoded != settings {
+		t.Errorf("round trip: %+v, %v; want %+v", decoded, err, settings)
+	}
+
+	inputs := []struct {
+		json    string
+		want    MediaSettings
+		wantErr bool
+	}{
+		{`{"video_codec":"av1","resolution":"1080p","video_bitrate":"2.5M","audio_bitrate":128000}`, MediaSettings{VideoCodec: VideoCodecAV1, Resolution: Resolution1080p, VideoBitrate: 2500 * Kbps, AudioBitrate: 128 * Kbps}, false},
+		{`{"video_bitrate":null}`, MediaSettings{}, false},
+		{`{"video_bitrate":"fast"}`, MediaSettings{}, true},
+		{`{"audio_bitrate":-1}`, MediaSettings{}, true},
+		{`{"resolution":"huge"}`, MediaSettings{}, true},
+	}
+	for _, input := range inputs {
+		var got MediaSettings
+		err := json.Unmarshal([]byte(input.json), &got)
+		if (err != nil) != input.wantErr || (err == nil && got != input.want) {
+			t.Errorf("Unmarshal(%s) = %+v, %v; want %+v, error %v", input.json, got, err, input.want, input.wantErr)
+		}
+	}
+}
@@ -0,0 +1,1013 @@
+package models
+
+import (
+	"maps"
+	"slices"
+	"sync"
+	"time"
+)
+
+// MemoryStore provides in-memory storage for bouquets, users, channels, and providers.
+// It optionally mirrors its content to a data file (see NewFileStore).
+type MemoryStore struct {
+	autoTx
+	eventBus
+	data     memoryData
+	dataFile string // Empty for memory-only stores
+	dirty    bool   // Whether data holds writes not yet in the data file
+	options  Options
+	mutex    sync.RWMutex
+
+	stop      chan struct{} // Closed to stop flushing the data file
+	closeOnce sync.Once
+}
+
+// memoryData holds the entities of a MemoryStore
+type memoryData struct {
+	bouquets       map[int]Bouquet
+	users          map[int]User
+	channels       map[int]Channel
+	providers      map[int]Provider
+	profiles       map[int]EncodingProfile
+	sessions       map[string]Session
+	apiTokens      map[int]APIToken
+	webhooks       map[int]Webhook
+	deliveries     map[int]WebhookDelivery
+	nextBouquetID  int
+	nextUserID     int
+	nextChannelID  int
+	nextProviderID int
+	nextProfileID  int
+	nextAPITokenID int
+	nextWebhookID  int
+	nextDeliveryID int
+}
+
+// memoryTx runs operations directly on a memoryData. It does no locking:
+// MemoryStore hands it out only while holding the mutex.
+type memoryTx struct {
+	*memoryData
+	options Options
+	deferred bool         // Whether a write that may wait for the next flush was made; see NewFileStore
+	saveNow  bool         // Whether a write publishing no event that may not wait was made
+	owned    map[any]bool // Maps of memoryData already copied for writing; see own
+}
+
+var _ Store = (*MemoryStore)(nil)
+
+// NewMemoryStore creates a new in-memory store
+func NewMemoryStore() *MemoryStore {
+	store := &MemoryStore{
+		data: memoryData{
+			bouquets:       make(map[int]Bouquet),
+			users:          make(map[int]User),
+			channels:       make(map[int]Channel),
+			providers:      make(map[int]Provider),
+			profiles:       make(map[int]EncodingProfile),
+			sessions:       make(map[string]Session),
+			apiTokens:      make(map[int]APIToken),
+			webhooks:       make(map[int]Webhook),
+			deliveries:     make(map[int]WebhookDelivery),
+			nextBouquetID:  1,
+			nextUserID:     1,
+			nextChannelID:  1,
+			nextProviderID: 1,
+			nextProfileID:  1,
+			nextAPITokenID: 1,
+			nextWebhookID:  1,
+			nextDeliveryID: 1,
+		},
+	}
+	store.autoTx = autoTx{view: store.view, update: store.Update}
+	
+	// Don't add sample data anymore - let users set up from scratch
+	return store
+}
+
+// Update runs fn on a copy of the store data and swaps the copy in once fn
+// succeeds and the data file, if any, has been written
+func (s *MemoryStore) Update(fn func(tx Tx) error) error {
+	return s.update(Actor{}, fn)
+}
+
+// As returns the store with the changes made through it attributed to actor
+func (s *MemoryStore) As(actor Actor) Store {
+	return newActorStore(s, actor)
+}
+
+// update is Update with the events of the changes attributed to actor
+func (s *MemoryStore) update(actor Actor, fn func(tx Tx) error) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	working := s.data // Maps are copied on their first write, see own
+	memory := &memoryTx{memoryData: &working, options: s.options}
+	tx := &recordingTx{Tx: memory, actor: actor}
+	if err := fn(tx); err != nil {
+		return err
+	}
+
+	previous := s.data
+	s.data = working
+	// Writes that only record activity are not worth rewriting the whole
+	// file for; they are saved with the next other change or flush
+	if memory.deferred && !memory.saveNow && len(tx.events) == 0 {
+		s.dirty = true
+		return nil
+	}
+	if err := s.save(); err != nil {
+		s.data = previous
+		return err
+	}
+	s.dirty = false
+	s.publish(tx.events)
+	return nil
+}
+
+// view runs read-only operations on the store data under the read lock
+func (s *MemoryStore) view(fn func(tx Tx)) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	fn(&memoryTx{memoryData: &s.data, options: s.options})
+}
+
+// own returns the entity map *m of the transaction for writing. The first
+// write to a map replaces it with a copy, so that the store data is left as it
+// was until the transaction succeeds, and a transaction only copies the maps
+// it changes. Entities are stored by value and their slices are never
+// modified in place, so a shallow copy is enough.
+func own[K comparable, V any](t *memoryTx, m *map[K]V) map[K]V {
+	if !t.owned[m] {
+		if t.owned == nil {
+			t.owned = make(map[any]bool)
+		}
+		*m = maps.Clone(*m)
+		t.owned[m] = true
+	}
+	return *m
+}
+
+// initSampleData adds initial sample data
+func (d *memoryData) initSampleData() {
+	now := time.Now()
+	
+	// Sample bouquets linked to providers
+	d.bouquets[1] = Bouquet{
+		ID:          1,
+		Name:        "Basic Package",
+		Description: "Essential channels for everyday viewing",
+		ProviderID:  1, // BBC
+		ChannelIDs:  []int{1, 2, 3, 4},
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+	d.bouquets[2] = Bouquet{
+		ID:          2,
+		Name:        "Premium Package", 
+		Description: "Complete entertainment experience with sports and movies",
+		ProviderID:  2, // Sky
+		ChannelIDs:  []int{1, 2, 5, 6, 7},
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+	d.nextBouquetID = 3
+	
+	// Sample users
+	d.users[1] = User{
+		ID:        1,
+		Username:  "admin",
+		Email:     "admin@example.com",
+		FirstName: "Admin",
+		LastName:  "User",
+		Role:      RoleAdmin,
+		Active:    true,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	d.users[2] = User{
+		ID:        2,
+		Username:  "user1",
+		Email:     "user1@example.com",
+		FirstName: "John",
+		LastName:  "Doe",
+		Role:      RoleOperator,
+		Active:    true,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	d.nextUserID = 3
+	
+	// Sample channels with video encoding configurations
+	d.channels[1] = Channel{
+		ID:       1,
+		Name:     "BBC One",
+		Manifest: "https://manifest.bbc.co.uk/bbc1/manifest.mpd",
+		KeyKid:   "bbc1-key-001",
+		MediaSettings: MediaSettings{
+			VideoCodec:   VideoCodecH265,
+			AudioCodec:   AudioCodecAAC,
+			Resolution:   Resolution1080p,
+			VideoBitrate: 5000 * Kbps,
+			AudioBitrate: 128 * Kbps,
+		},
+		Quality:   "High",
+		State:     ChannelStopped,
+		Running:   false,
+		RemuxPort: 0,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	d.channels[2] = Channel{
+		ID:       2,
+		Name:     "BBC Two",
+		Manifest: "https://manifest.bbc.co.uk/bbc2/manifest.mpd",
+		KeyKid:   "bbc2-key-001",
+		MediaSettings: MediaSettings{
+			VideoCodec:   VideoCodecH264,
+			AudioCodec:   AudioCodecAAC,
+			Resolution:   Resolution720p,
+			VideoBitrate: 3000 * Kbps,
+			AudioBitrate: 128 * Kbps,
+		},
+		Quality:   "Medium",
+		State:     ChannelStopped,
+		Running:   false,
+		RemuxPort: 0,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	d.channels[3] = Channel{
+		ID:       3,
+		Name:     "ITV",
+		Manifest: "https://manifest.itv.com/itv1/manifest.mpd",
+		KeyKid:   "itv1-key-001",
+		MediaSettings: MediaSettings{
+			VideoCodec:   VideoCodecH265,
+			AudioCodec:   AudioCodecAC3,
+			Resolution:   Resolution1080p,
+			VideoBitrate: 6000 * Kbps,
+			AudioBitrate: 256 * Kbps,
+		},
+		Quality:   "High",
+		State:     ChannelStopped,
+		Running:   false,
+		RemuxPort: 0,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	d.channels[4] = Channel{
+		ID:       4,
+		Name:     "Channel 4",
+		Manifest: "https://manifest.channel4.com/c4/manifest.mpd",
+		KeyKid:   "c4-key-001",
+		MediaSettings: MediaSettings{
+			VideoCodec:   VideoCodecH264,
+			AudioCodec:   AudioCodecAAC,
+			Resolution:   Resolution720p,
+			VideoBitrate: 3500 * Kbps,
+			AudioBitrate: 128 * Kbps,
+		},
+		Quality:   "Medium",
+		State:     ChannelStopped,
+		Running:   false,
+		RemuxPort: 0,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	d.channels[5] = Channel{
+		ID:       5,
+		Name:     "Sky Sports",
+		Manifest: "https://manifest.sky.com/sports/manifest.mpd",
+		KeyKid:   "sky-sports-key-001",
+		MediaSettings: MediaSettings{
+			VideoCodec:   VideoCodecH265,
+			AudioCodec:   AudioCodecAC3,
+			Resolution:   Resolution2160p,
+			VideoBitrate: 15000 * Kbps,
+			AudioBitrate: 256 * Kbps,
+		},
+		Quality:   "Ultra",
+		State:     ChannelStopped,
+		Running:   false,
+		RemuxPort: 0,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	d.channels[6] = Channel{
+		ID:       6,
+		Name:     "Sky Movies",
+		Manifest: "https://manifest.sky.com/movies/manifest.mpd",
+		KeyKid:   "sky-movies-key-001",
+		MediaSettings: MediaSettings{
+			VideoCodec:   VideoCodecH265,
+			AudioCodec:   AudioCodecDTS,
+			Resolution:   Resolution2160p,
+			VideoBitrate: 20000 * Kbps,
+			AudioBitrate: 512 * Kbps,
+		},
+		Quality:   "Ultra",
+		State:     ChannelStopped,
+		Running:   false,
+		RemuxPort: 0,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	d.channels[7] = Channel{
+		ID:       7,
+		Name:     "Discovery",
+		Manifest: "https://manifest.discovery.com/main/manifest.mpd",
+		KeyKid:   "discovery-key-001",
+		MediaSettings: MediaSettings{
+			VideoCodec:   VideoCodecH264,
+			AudioCodec:   AudioCodecAAC,
+			Resolution:   Resolution1080p,
+			VideoBitrate: 4000 * Kbps,
+			AudioBitrate: 128 * Kbps,
+		},
+		Quality:   "Medium",
+		State:     ChannelStopped,
+		Running:   false,
+		RemuxPort: 0,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	d.nextChannelID = 8
+	
+	// Sample providers
+	d.providers[1] = Provider{
+		ID:          1,
+		Name:        "BBC",
+		Description: "British Broadcasting Corporation",
+		URL:         "https://www.bbc.co.uk",
+		APIKey:      "bbc-api-key-123",
+		Active:      true,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+	d.providers[2] = Provider{
+		ID:          2,
+		Name:        "Sky",
+		Description: "Sky Television Services",
+		URL:         "https://www.sky.com",
+		APIKey:      "sky-api-key-456",
+		Active:      true,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+	d.nextProviderID = 3
+}
+
+// Bouquet operations
+func (t *memoryTx) GetAllBouquets() []Bouquet {
+	bouquets := make([]Bouquet, 0, len(t.bouquets))
+	for _, bouquet := range t.bouquets {
+		bouquets = append(bouquets, t.resolveChannels(bouquet))
+	}
+	return bouquets
+}
+
+func (t *memoryTx) GetBouquet(id int) (Bouquet, bool) {
+	bouquet, exists := t.bouquets[id]
+	if !exists {
+		return Bouquet{}, false
+	}
+	return t.resolveChannels(bouquet), true
+}
+
+func (t *memoryTx) CreateBouquet(bouquet Bouquet) (Bouquet, error) {
+	if _, exists := t.providers[bouquet.ProviderID]; !exists {
+		return Bouquet{}, &ReferenceError{Entity: "provider", ID: bouquet.ProviderID}
+	}
+	channelIDs, err := t.checkChannelIDs(bouquet.ChannelIDs)
+	if err != nil {
+		return Bouquet{}, err
+	}
+	bouquet.ID = t.nextBouquetID
+	bouquet.ChannelIDs = channelIDs
+	bouquet.Channels = nil
+	bouquet.Version = 1
+	bouquet.CreatedAt = time.Now()
+	bouquet.UpdatedAt = time.Now()
+	own(t, &t.bouquets)[bouquet.ID] = bouquet
+	t.nextBouquetID++
+	return t.resolveChannels(bouquet), nil
+}
+
+func (t *memoryTx) UpdateBouquet(bouquet Bouquet) error {
+	stored, exists := t.bouquets[bouquet.ID]
+	if !exists {
+		return ErrNotFound
+	}
+	if stored.Version != bouquet.Version {
+		return &ConflictError{Entity: "bouquet", ID: bouquet.ID, Version: stored.Version}
+	}
+	if _, exists := t.providers[bouquet.ProviderID]; !exists {
+		return &ReferenceError{Entity: "provider", ID: bouquet.ProviderID}
+	}
+	channelIDs, err := t.checkChannelIDs(bouquet.ChannelIDs)
+	if err != nil {
+		return err
+	}
+	bouquet.ChannelIDs = channelIDs
+	bouquet.Channels = nil
+	bouquet.Version++
+	bouquet.UpdatedAt = time.Now()
+	own(t, &t.bouquets)[bouquet.ID] = bouquet
+	return nil
+}
+
+// AddChannelToBouquet appends a channel to the end of a bouquet
+func (t *memoryTx) AddChannelToBouquet(bouquetID, channelID int) error {
+	bouquet, exists := t.bouquets[bouquetID]
+	if !exists {
+		return ErrNotFound
+	}
+	if _, exists := t.channels[channelID]; !exists {
+		return &ReferenceError{Entity: "channel", ID: channelID}
+	}
+	if slices.Contains(bouquet.ChannelIDs, channelID) {
+		return nil
+	}
+	bouquet.ChannelIDs = append(slices.Clone(bouquet.ChannelIDs), channelID)
+	bouquet.Version++
+	bouquet.UpdatedAt = time.Now()
+	own(t, &t.bouquets)[bouquetID] = bouquet
+	return nil
+}
+
+// RemoveChannelFromBouquet removes a channel from a bouquet, keeping the order of the others
+func (t *memoryTx) RemoveChannelFromBouquet(bouquetID, channelID int) error {
+	bouquet, exists := t.bouquets[bouquetID]
+	if !exists || !slices.Contains(bouquet.ChannelIDs, channelID) {
+		return ErrNotFound
+	}
+	bouquet.ChannelIDs = slices.DeleteFunc(slices.Clone(bouquet.ChannelIDs), func(id int) bool { return id == channelID })
+	bouquet.Version++
+	bouquet.UpdatedAt = time.Now()
+	own(t, &t.bouquets)[bouquetID] = bouquet
+	return nil
+}
+
+// resolveChannels fills Channels with the current state of the member channels.
+func (t *memoryTx) resolveChannels(bouquet Bouquet) Bouquet {
+	bouquet.ChannelIDs = slices.Clone(bouquet.ChannelIDs)
+	bouquet.Channels = make([]Channel, 0, len(bouquet.ChannelIDs))
+	for _, id := range bouquet.ChannelIDs {
+		if channel, exists := t.channels[id]; exists {
+			bouquet.Channels = append(bouquet.Channels, channel)
+		}
+	}
+	return bouquet
+}
+
+// checkChannelIDs verifies that every channel exists and drops duplicates.
+func (t *memoryTx) checkChannelIDs(ids []int) ([]int, error) {
+	checked := make([]int, 0, len(ids))
+	for _, id := range ids {
+		if _, exists := t.channels[id]; !exists {
+			return nil, &ReferenceError{Entity: "channel", ID: id}
+		}
+		if !slices.Contains(checked, id) {
+			checked = append(checked, id)
+		}
+	}
+	return checked, nil
+}
+
+func (t *memoryTx) DeleteBouquet(id int) error {
+	if _, exists := t.bouquets[id]; !exists {
+		return ErrNotFound
+	}
+	delete(own(t, &t.bouquets), id)
+	return nil
+}
+
+// GetBouquetsByProvider returns all bouquets for a specific provider
+func (t *memoryTx) GetBouquetsByProvider(providerID int) []Bouquet {
+	var bouquets []Bouquet
+	for _, bouquet := range t.bouquets {
+		if bouquet.ProviderID == providerID {
+			bouquets = append(bouquets, t.resolveChannels(bouquet))
+		}
+	}
+	return bouquets
+}
+
+// GetProvidersWithBouquets returns all providers with their associated bouquets
+func (t *memoryTx) GetProvidersWithBouquets() []ProviderWithBouquets {
+	var providersWithBouquets []ProviderWithBouquets
+	for _, provider := range t.providers {
+		pwb := ProviderWithBouquets{
+			Provider: provider,
+			Bouquets: t.GetBouquetsByProvider(provider.ID),
+		}
+		providersWithBouquets = append(providersWithBouquets, pwb)
+	}
+	return providersWithBouquets
+}
+
+// User operations
+func (t *memoryTx) GetAllUsers() []User {
+	users := make([]User, 0, len(t.users))
+	for _, user := range t.users {
+		users = append(users, user)
+	}
+	return users
+}
+
+func (t *memoryTx) GetUser(id int) (User, bool) {
+	user, exists := t.users[id]
+	return user, exists
+}
+
+// HasUsers returns true if any users exist in the store
+func (t *memoryTx) HasUsers() bool {
+	return len(t.users) > 0
+}
+
+// GetUserByUsername returns a user by username
+func (t *memoryTx) GetUserByUsername(username string) (User, bool) {
+	for _, user := range t.users {
+		if user.Username == username {
+			return user, true
+		}
+	}
+	return User{}, false
+}
+
+func (t *memoryTx) CreateUser(user User) (User, error) {
+	user.ID = t.nextUserID
+	user.Version = 1
+	user.CreatedAt = time.Now()
+	user.UpdatedAt = time.Now()
+	own(t, &t.users)[user.ID] = user
+	t.nextUserID++
+	return user, nil
+}
+
+func (t *memoryTx) UpdateUser(user User) error {
+	stored, exists := t.users[user.ID]
+	if !exists {
+		return ErrNotFound
+	}
+	if stored.Version != user.Version {
+		return &ConflictError{Entity: "user", ID: user.ID, Version: stored.Version}
+	}
+	user.TOTPLastStep = stored.TOTPLastStep // Only moved forward by UseTOTPStep
+	user.Version++
+	user.UpdatedAt = time.Now()
+	own(t, &t.users)[user.ID] = user
+	return nil
+}
+
+// UseTOTPStep records that the user signed in with the code of a TOTP time step
+func (t *memoryTx) UseTOTPStep(userID int, step uint64) error {
+	user, exists := t.users[userID]
+	if !exists {
+		return ErrNotFound
+	}
+	if step <= user.TOTPLastStep {
+		return ErrTOTPStepUsed
+	}
+	user.TOTPLastStep = step
+	own(t, &t.users)[userID] = user
+	return nil
+}
+
+func (t *memoryTx) DeleteUser(id int) error {
+	if _, exists := t.users[id]; !exists {
+		return ErrNotFound
+	}
+	delete(own(t, &t.users), id)
+	for sessionID, session := range t.sessions {
+		if session.UserID == id {
+			delete(own(t, &t.sessions), sessionID)
+		}
+	}
+	for tokenID, token := range t.apiTokens {
+		if token.UserID == id {
+			delete(own(t, &t.apiTokens), tokenID)
+		}
+	}
+	return nil
+}
+
+// Channel operations
+func (t *memoryTx) GetAllChannels() []Channel {
+	channels := make([]Channel, 0, len(t.channels))
+	for _, channel := range t.channels {
+		channels = append(channels, channel)
+	}
+	return channels
+}
+
+func (t *memoryTx) GetChannel(id int) (Channel, bool) {
+	channel, exists := t.channels[id]
+	return channel, exists
+}
+
+func (t *memoryTx) CreateChannel(channel Channel) (Channel, error) {
+	if err := t.checkProfile(channel.ProfileID); err != nil {
+		return Channel{}, err
+	}
+	channel.ID = t.nextChannelID
+	channel.State = ChannelStopped
+	channel.Running = false
+	channel.Version = 1
+	channel.CreatedAt = time.Now()
+	channel.UpdatedAt = time.Now()
+	own(t, &t.channels)[channel.ID] = channel
+	t.nextChannelID++
+	return channel, nil
+}
+
+func (t *memoryTx) UpdateChannel(channel Channel) error {
+	stored, exists := t.channels[channel.ID]
+	if !exists {
+		return ErrNotFound
+	}
+	if stored.Version != channel.Version {
+		return &ConflictError{Entity: "channel", ID: channel.ID, Version: stored.Version}
+	}
+	if err := t.checkProfile(channel.ProfileID); err != nil {
+		return err
+	}
+	channel.State = stored.State
+	channel.Running = stored.Running
+	channel.RemuxPort = stored.RemuxPort
+	channel.PID = stored.PID
+	channel.LastError = stored.LastError
+	channel.StartedAt = stored.StartedAt
+	channel.StoppedAt = stored.StoppedAt
+	channel.Version++
+	channel.UpdatedAt = time.Now()
+	own(t, &t.channels)[channel.ID] = channel
+	return nil
+}
+
+func (t *memoryTx) DeleteChannel(id int) error {
+	if _, exists := t.channels[id]; !exists {
+		return ErrNotFound
+	}
+	delete(own(t, &t.channels), id)
+	
+	// Remove the channel from every bouquet that contains it
+	for bouquetID, bouquet := range t.bouquets {
+		if slices.Contains(bouquet.ChannelIDs, id) {
+			bouquet.ChannelIDs = slices.DeleteFunc(slices.Clone(bouquet.ChannelIDs), func(channelID int) bool { return channelID == id })
+			bouquet.Version++
+			bouquet.UpdatedAt = time.Now()
+			own(t, &t.bouquets)[bouquetID] = bouquet
+		}
+	}
+	return nil
+}
+
+// StartChannel moves a channel to the starting state on the given remux port
+func (t *memoryTx) StartChannel(channelID, port int) error {
+	channel, exists := t.channels[channelID]
+	if !exists {
+		return ErrNotFound
+	}
+	if err := CheckTransition(channel, ChannelStarting); err != nil {
+		return err
+	}
+	for _, other := range t.channels {
+		if other.ID != channelID && other.Running && other.RemuxPort == port {
+			return &PortInUseError{Port: port, ChannelID: other.ID}
+		}
+	}
+
+	channel.State = ChannelStarting
+	channel.Running = true
+	channel.RemuxPort = port
+	channel.PID = 0
+	channel.LastError = ""
+	channel.StartedAt = time.Now()
+	own(t, &t.channels)[channelID] = channel
+	return nil
+}
+
+// SetChannelState moves a started channel to state, recording its process
+// and why the previous one failed
+func (t *memoryTx) SetChannelState(channelID int, state ChannelState, pid int, lastError string) error {
+	channel, exists := t.channels[channelID]
+	if !exists {
+		return ErrNotFound
+	}
+	if state == ChannelStarting {
+		return errStartThroughStartChannel
+	}
+	if err := CheckTransition(channel, state); err != nil {
+		return err
+	}
+
+	if channel.State.Active() && !state.Active() {
+		channel.StoppedAt = time.Now()
+	}
+	channel.State = state
+	channel.Running = state.Active()
+	channel.PID = pid
+	if lastError != "" {
+		channel.LastError = lastError
+	}
+	own(t, &t.channels)[channelID] = channel
+	return nil
+}
+
+// Provider operations
+func (t *memoryTx) GetAllProviders() []Provider {
+	providers := make([]Provider, 0, len(t.providers))
+	for _, provider := range t.providers {
+		providers = append(providers, provider)
+	}
+	return providers
+}
+
+func (t *memoryTx) GetProvider(id int) (Provider, bool) {
+	provider, exists := t.providers[id]
+	return provider, exists
+}
+
+func (t *memoryTx) CreateProvider(provider Provider) (Provider, error) {
+	provider.ID = t.nextProviderID
+	provider.Version = 1
+	provider.CreatedAt = time.Now()
+	provider.UpdatedAt = time.Now()
+	own(t, &t.providers)[provider.ID] = provider
+	t.nextProviderID++
+	return provider, nil
+}
+
+func (t *memoryTx) UpdateProvider(provider Provider) error {
+	stored, exists := t.providers[provider.ID]
+	if !exists {
+		return ErrNotFound
+	}
+	if stored.Version != provider.Version {
+		return &ConflictError{Entity: "provider", ID: provider.ID, Version: stored.Version}
+	}
+	provider.Version++
+	provider.UpdatedAt = time.Now()
+	own(t, &t.providers)[provider.ID] = provider
+	return nil
+}
+
+func (t *memoryTx) DeleteProvider(id int) error {
+	if _, exists := t.providers[id]; !exists {
+		return ErrNotFound
+	}
+	
+	bouquets := t.GetBouquetsByProvider(id)
+	if len(bouquets) > 0 && t.options.ProviderDeletePolicy != DeleteCascade {
+		return &InUseError{Entity: "provider", ID: id, By: "bouquet", Count: len(bouquets)}
+	}
+	for _, bouquet := range bouquets {
+		delete(own(t, &t.bouquets), bouquet.ID)
+	}
+	delete(own(t, &t.providers), id)
+	return nil
+}
+
+// checkProfile verifies that the profile of a channel exists, if it has one
+func (t *memoryTx) checkProfile(profileID int) error {
+	if _, exists := t.profiles[profileID]; profileID != 0 && !exists {
+		return &ReferenceError{Entity: "profile", ID: profileID}
+	}
+	return nil
+}
+
+// Encoding profile operations
+func (t *memoryTx) GetAllProfiles() []EncodingProfile {
+	profiles := make([]EncodingProfile, 0, len(t.profiles))
+	for _, profile := range t.profiles {
+		profiles = append(profiles, t.resolveUsedBy(profile))
+	}
+	return profiles
+}
+
+func (t *memoryTx) GetProfile(id int) (EncodingProfile, bool) {
+	profile, exists := t.profiles[id]
+	if !exists {
+		return EncodingProfile{}, false
+	}
+	return t.resolveUsedBy(profile), true
+}
+
+func (t *memoryTx) CreateProfile(profile EncodingProfile) (EncodingProfile, error) {
+	profile.ID = t.nextProfileID
+	profile.UsedBy = nil
+	profile.Version = 1
+	profile.CreatedAt = time.Now()
+	profile.UpdatedAt = time.Now()
+	own(t, &t.profiles)[profile.ID] = profile
+	t.nextProfileID++
+	return t.resolveUsedBy(profile), nil
+}
+
+func (t *memoryTx) UpdateProfile(profile EncodingProfile) error {
+	stored, exists := t.profiles[profile.ID]
+	if !exists {
+		return ErrNotFound
+	}
+	if stored.Version != profile.Version {
+		return &ConflictError{Entity: "profile", ID: profile.ID, Version: stored.Version}
+	}
+	profile.UsedBy = nil
+	profile.Version++
+	profile.UpdatedAt = time.Now()
+	own(t, &t.profiles)[profile.ID] = profile
+	return nil
+}
+
+func (t *memoryTx) DeleteProfile(id int) error {
+	profile, exists := t.GetProfile(id)
+	if !exists {
+		return ErrNotFound
+	}
+	if len(profile.UsedBy) > 0 {
+		return &InUseError{Entity: "profile", ID: id, By: "channel", Count: len(profile.UsedBy)}
+	}
+	delete(own(t, &t.profiles), id)
+	return nil
+}
+
+// resolveUsedBy fills UsedBy with the channels using the profile, by ID
+func (t *memoryTx) resolveUsedBy(profile EncodingProfile) EncodingProfile {
+	profile.UsedBy = []int{}
+	for _, channel := range t.channels {
+		if channel.ProfileID == profile.ID {
+			profile.UsedBy = append(profile.UsedBy, channel.ID)
+		}
+	}
+	slices.Sort(profile.UsedBy)
+	return profile
+}
+
+// Session operations
+func (t *memoryTx) GetAllSessions() []Session {
+	sessions := make([]Session, 0, len(t.sessions))
+	for _, session := range t.sessions {
+		sessions = append(sessions, session)
+	}
+	return sessions
+}
+
+// SaveSession creates or replaces a session
+func (t *memoryTx) SaveSession(session Session) error {
+	if _, exists := t.users[session.UserID]; !exists {
+		return &ReferenceError{Entity: "user", ID: session.UserID}
+	}
+	if _, exists := t.sessions[session.ID]; exists {
+		t.deferred = true // Touches of existing sessions can wait
+	} else {
+		t.saveNow = true
+	}
+	own(t, &t.sessions)[session.ID] = session
+	return nil
+}
+
+func (t *memoryTx) DeleteSession(id string) error {
+	if _, exists := t.sessions[id]; !exists {
+		return ErrNotFound
+	}
+	delete(own(t, &t.sessions), id)
+	t.saveNow = true // A revoked session must not come back after a crash
+	return nil
+}
+
+// API token operations
+func (t *memoryTx) GetAPITokensByUser(userID int) []APIToken {
+	var tokens []APIToken
+	for _, token := range t.apiTokens {
+		if token.UserID == userID {
+			tokens = append(tokens, token)
+		}
+	}
+	slices.SortFunc(tokens, func(a, b APIToken) int { return a.ID - b.ID })
+	return tokens
+}
+
+func (t *memoryTx) GetAPIToken(id int) (APIToken, bool) {
+	token, exists := t.apiTokens[id]
+	return token, exists
+}
+
+func (t *memoryTx) GetAPITokenByHash(hash string) (APIToken, bool) {
+	for _, token := range t.apiTokens {
+		if token.Hash == hash {
+			return token, true
+		}
+	}
+	return APIToken{}, false
+}
+
+func (t *memoryTx) CreateAPIToken(token APIToken) (APIToken, error) {
+	if _, exists := t.users[token.UserID]; !exists {
+		return APIToken{}, &ReferenceError{Entity: "user", ID: token.UserID}
+	}
+	token.ID = t.nextAPITokenID
+	token.CreatedAt = time.Now()
+	own(t, &t.apiTokens)[token.ID] = token
+	t.nextAPITokenID++
+	return token, nil
+}
+
+// TouchAPIToken records when a token was last used
+func (t *memoryTx) TouchAPIToken(id int, lastUsed time.Time) error {
+	token, exists := t.apiTokens[id]
+	if !exists {
+		return ErrNotFound
+	}
+	token.LastUsed = lastUsed
+	own(t, &t.apiTokens)[id] = token
+	t.deferred = true
+	return nil
+}
+
+func (t *memoryTx) DeleteAPIToken(id int) error {
+	if _, exists := t.apiTokens[id]; !exists {
+		return ErrNotFound
+	}
+	delete(own(t, &t.apiTokens), id)
+	return nil
+}
+
+// Webhook operations
+func (t *memoryTx) GetAllWebhooks() []Webhook {
+	webhooks := slices.Collect(maps.Values(t.webhooks))
+	slices.SortFunc(webhooks, func(a, b Webhook) int { return a.ID - b.ID })
+	return webhooks
+}
+
+func (t *memoryTx) GetWebhook(id int) (Webhook, bool) {
+	webhook, exists := t.webhooks[id]
+	return webhook, exists
+}
+
+func (t *memoryTx) CreateWebhook(webhook Webhook) (Webhook, error) {
+	webhook.ID = t.nextWebhookID
+	webhook.Events = slices.Clone(webhook.Events)
+	webhook.Version = 1
+	webhook.CreatedAt = time.Now()
+	webhook.UpdatedAt = webhook.CreatedAt
+	own(t, &t.webhooks)[webhook.ID] = webhook
+	t.nextWebhookID++
+	return webhook, nil
+}
+
+func (t *memoryTx) UpdateWebhook(webhook Webhook) error {
+	stored, exists := t.webhooks[webhook.ID]
+	if !exists {
+		return ErrNotFound
+	}
+	if stored.Version != webhook.Version {
+		return &ConflictError{Entity: "webhook", ID: webhook.ID, Version: stored.Version}
+	}
+	webhook.Events = slices.Clone(webhook.Events)
+	webhook.CreatedAt = stored.CreatedAt
+	webhook.Version++
+	webhook.UpdatedAt = time.Now()
+	own(t, &t.webhooks)[webhook.ID] = webhook
+	return nil
+}
+
+// DeleteWebhook deletes a webhook and its deliveries
+func (t *memoryTx) DeleteWebhook(id int) error {
+	if _, exists := t.webhooks[id]; !exists {
+		return ErrNotFound
+	}
+	delete(own(t, &t.webhooks), id)
+	maps.DeleteFunc(t.deliveries, func(_ int, delivery WebhookDelivery) bool { return delivery.WebhookID == id })
+	return nil
+}
+
+func (t *memoryTx) GetWebhookDeliveries(webhookID int) []WebhookDelivery {
+	var deliveries []WebhookDelivery
+	for _, delivery := range t.deliveries {
+		if delivery.WebhookID == webhookID {
+			deliveries = append(deliveries, delivery)
+		}
+	}
+	slices.SortFunc(deliveries, func(a, b WebhookDelivery) int { return b.ID - a.ID })
+	return deliveries
+}
+
+// AddWebhookDelivery records a delivery, dropping the oldest ones of the
+// webhook beyond WebhookDeliveryLimit
+func (t *memoryTx) AddWebhookDelivery(delivery WebhookDelivery) error {
+	if _, exists := t.webhooks[delivery.WebhookID]; !exists {
+		return &ReferenceError{Entity: "webhook", ID: delivery.WebhookID}
+	}
+	delivery.ID = t.nextDeliveryID
+	own(t, &t.deliveries)[delivery.ID] = delivery
+	t.nextDeliveryID++
+
+	deliveries := t.GetWebhookDeliveries(delivery.WebhookID)
+	for _, old := range deliveries[min(len(deliveries), WebhookDeliveryLimit):] {
+		delete(own(t, &t.deliveries), old.ID)
+	}
+	t.deferred = true
+	return nil
+}
@@ -0,0 +1,343 @@
+package models
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"time"
+)
+
+// migration is a numbered schema change applied once by the SQL store
+type migration struct {
+	Version     int
+	Description string
+	SQL         string
+}
+
+// migrations lists every schema change in order. Never edit an applied
+// migration: append a new one with the next version number instead.
+var migrations = []migration{
+	{
+		Version:     1,
+		Description: "create initial schema",
+		SQL: `
+CREATE TABLE users (
+	id         INTEGER PRIMARY KEY AUTOINCREMENT,
+	username   TEXT NOT NULL UNIQUE,
+	email      TEXT NOT NULL DEFAULT '',
+	password   TEXT NOT NULL DEFAULT '',
+	first_name TEXT NOT NULL DEFAULT '',
+	last_name  TEXT NOT NULL DEFAULT '',
+	role       TEXT NOT NULL DEFAULT '',
+	active     BOOLEAN NOT NULL DEFAULT 1,
+	created_at DATETIME NOT NULL,
+	updated_at DATETIME NOT NULL
+);
+
+CREATE TABLE providers (
+	id          INTEGER PRIMARY KEY AUTOINCREMENT,
+	name        TEXT NOT NULL,
+	description TEXT NOT NULL DEFAULT '',
+	url         TEXT NOT NULL DEFAULT '',
+	api_key     TEXT NOT NULL DEFAULT '',
+	active      BOOLEAN NOT NULL DEFAULT 1,
+	created_at  DATETIME NOT NULL,
+	updated_at  DATETIME NOT NULL
+);
+
+CREATE TABLE channels (
+	id            INTEGER PRIMARY KEY AUTOINCREMENT,
+	name          TEXT NOT NULL,
+	manifest      TEXT NOT NULL DEFAULT '',
+	key_kid       TEXT NOT NULL DEFAULT '',
+	video_codec   TEXT NOT NULL DEFAULT '',
+	audio_codec   TEXT NOT NULL DEFAULT '',
+	resolution    TEXT NOT NULL DEFAULT '',
+	video_bitrate TEXT NOT NULL DEFAULT '',
+	audio_bitrate TEXT NOT NULL DEFAULT '',
+	quality       TEXT NOT NULL DEFAULT '',
+	running       BOOLEAN NOT NULL DEFAULT 0,
+	remux_port    INTEGER NOT NULL DEFAULT 0,
+	created_at    DATETIME NOT NULL,
+	updated_at    DATETIME NOT NULL
+);
+
+CREATE TABLE bouquets (
+	id          INTEGER PRIMARY KEY AUTOINCREMENT,
+	name        TEXT NOT NULL,
+	description TEXT NOT NULL DEFAULT '',
+	provider_id INTEGER NOT NULL,
+	created_at  DATETIME NOT NULL,
+	updated_at  DATETIME NOT NULL
+);
+
+CREATE INDEX idx_bouquets_provider ON bouquets(provider_id);
+
+CREATE TABLE bouquet_channels (
+	bouquet_id INTEGER NOT NULL REFERENCES bouquets(id) ON DELETE CASCADE,
+	channel_id INTEGER NOT NULL REFERENCES channels(id) ON DELETE CASCADE,
+	position   INTEGER NOT NULL DEFAULT 0,
+	PRIMARY KEY (bouquet_id, channel_id)
+);
+
+CREATE INDEX idx_bouquet_channels_channel ON bouquet_channels(channel_id);
+`,
+	},
+	{
+		Version:     2,
+		Description: "add entity versions for optimistic concurrency",
+		SQL: `
+ALTER TABLE users ADD COLUMN version INTEGER NOT NULL DEFAULT 1;
+ALTER TABLE providers ADD COLUMN version INTEGER NOT NULL DEFAULT 1;
+ALTER TABLE channels ADD COLUMN version INTEGER NOT NULL DEFAULT 1;
+ALTER TABLE bouquets ADD COLUMN version INTEGER NOT NULL DEFAULT 1;
+`,
+	},
+	{
+		Version:     3,
+		Description: "rename legacy user roles",
+		SQL: `
+UPDATE users SET role = 'admin' WHERE role = 'Administrator';
+UPDATE users SET role = 'operator' WHERE role = 'User';
+`,
+	},
+	{
+		Version:     4,
+		Description: "create sessions table",
+		SQL: `
+CREATE TABLE sessions (
+	id         TEXT PRIMARY KEY,
+	user_id    INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	created_at DATETIME NOT NULL,
+	last_seen  DATETIME NOT NULL
+);
+
+CREATE INDEX idx_sessions_user ON sessions(user_id);
+`,
+	},
+	{
+		Version:     5,
+		Description: "record session client details",
+		SQL: `
+ALTER TABLE sessions ADD COLUMN client_ip TEXT NOT NULL DEFAULT '';
+ALTER TABLE sessions ADD COLUMN user_agent TEXT NOT NULL DEFAULT '';
+`,
+	},
+	{
+		Version:     6,
+		Description: "add two-factor authentication to users",
+		SQL: `
+ALTER TABLE users ADD COLUMN totp_secret TEXT NOT NULL DEFAULT '';
+ALTER TABLE users ADD COLUMN totp_enabled BOOLEAN NOT NULL DEFAULT 0;
+ALTER TABLE users ADD COLUMN recovery_codes TEXT NOT NULL DEFAULT '';
+`,
+	},
+	{
+		Version:     7,
+		Description: "create api tokens table",
+		SQL: `
+CREATE TABLE api_tokens (
+	id         INTEGER PRIMARY KEY AUTOINCREMENT,
+	user_id    INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	name       TEXT NOT NULL,
+	token_hash TEXT NOT NULL UNIQUE,
+	prefix     TEXT NOT NULL,
+	scopes     TEXT NOT NULL DEFAULT '',
+	expires_at DATETIME,
+	last_used  DATETIME,
+	created_at DATETIME NOT NULL
+);
+
+CREATE INDEX idx_api_tokens_user ON api_tokens(user_id);
+`,
+	},
+	{
+		Version:     8,
+		Description: "store channel resolutions and bitrates as numbers",
+		// Sizes and bitrates that do not parse become 0, which the next
+		// edit of the channel replaces with the default
+		SQL: `
+ALTER TABLE channels ADD COLUMN width INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE channels ADD COLUMN height INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE channels ADD COLUMN video_bitrate_bps INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE channels ADD COLUMN audio_bitrate_bps INTEGER NOT NULL DEFAULT 0;
+
+UPDATE channels SET resolution = lower(trim(replace(resolution, '×', 'x')));
+UPDATE channels SET
+	width = CASE
+		WHEN resolution = '720p' THEN 1280
+		WHEN resolution = '1080p' THEN 1920
+		WHEN resolution = '1440p' THEN 2560
+		WHEN resolution = '2160p' THEN 3840
+		WHEN instr(resolution, 'x') > 0 THEN max(CAST(substr(resolution, 1, instr(resolution, 'x') - 1) AS INTEGER), 0)
+		ELSE 0 END,
+	height = CASE
+		WHEN resolution IN ('720p', '1080p', '1440p', '2160p') THEN CAST(substr(resolution, 1, length(resolution) - 1) AS INTEGER)
+		WHEN instr(resolution, 'x') > 0 THEN max(CAST(substr(resolution, instr(resolution, 'x') + 1) AS INTEGER), 0)
+		ELSE 0 END;
+UPDATE channels SET width = 0, height = 0 WHERE width = 0 OR height = 0;
+
+UPDATE channels SET video_bitrate = trim(video_bitrate), audio_bitrate = trim(audio_bitrate);
+UPDATE channels SET
+	video_bitrate_bps = CASE
+		WHEN video_bitrate LIKE '%k' THEN CAST(round(CAST(substr(video_bitrate, 1, length(video_bitrate) - 1) AS REAL) * 1000) AS INTEGER)
+		WHEN video_bitrate LIKE '%m' THEN CAST(round(CAST(substr(video_bitrate, 1, length(video_bitrate) - 1) AS REAL) * 1000000) AS INTEGER)
+		ELSE CAST(video_bitrate AS INTEGER) END,
+	audio_bitrate_bps = CASE
+		WHEN audio_bitrate LIKE '%k' THEN CAST(round(CAST(substr(audio_bitrate, 1, length(audio_bitrate) - 1) AS REAL) * 1000) AS INTEGER)
+		WHEN audio_bitrate LIKE '%m' THEN CAST(round(CAST(substr(audio_bitrate, 1, length(audio_bitrate) - 1) AS REAL) * 1000000) AS INTEGER)
+		ELSE CAST(audio_bitrate AS INTEGER) END;
+UPDATE channels SET video_bitrate_bps = 0 WHERE video_bitrate_bps < 0;
+UPDATE channels SET audio_bitrate_bps = 0 WHERE audio_bitrate_bps < 0;
+
+UPDATE channels SET video_codec = CASE lower(trim(video_codec))
+	WHEN 'x264' THEN 'x264' WHEN 'x265' THEN 'x265' WHEN 'av1' THEN 'AV1' WHEN 'vp9' THEN 'VP9'
+	ELSE video_codec END;
+UPDATE channels SET audio_codec = CASE lower(trim(audio_codec))
+	WHEN 'aac' THEN 'AAC' WHEN 'mp3' THEN 'MP3' WHEN 'ac3' THEN 'AC3' WHEN 'dts' THEN 'DTS'
+	ELSE audio_codec END;
+
+ALTER TABLE channels DROP COLUMN resolution;
+ALTER TABLE channels DROP COLUMN video_bitrate;
+ALTER TABLE channels DROP COLUMN audio_bitrate;
+`,
+	},
+	{
+		Version:     9,
+		Description: "create encoding profiles",
+		SQL: `
+CREATE TABLE encoding_profiles (
+	id                INTEGER PRIMARY KEY AUTOINCREMENT,
+	name              TEXT NOT NULL,
+	description       TEXT NOT NULL DEFAULT '',
+	video_codec       TEXT NOT NULL DEFAULT '',
+	audio_codec       TEXT NOT NULL DEFAULT '',
+	width             INTEGER NOT NULL DEFAULT 0,
+	height            INTEGER NOT NULL DEFAULT 0,
+	video_bitrate_bps INTEGER NOT NULL DEFAULT 0,
+	audio_bitrate_bps INTEGER NOT NULL DEFAULT 0,
+	version           INTEGER NOT NULL DEFAULT 1,
+	created_at        DATETIME NOT NULL,
+	updated_at        DATETIME NOT NULL
+);
+
+ALTER TABLE channels ADD COLUMN profile_id INTEGER NOT NULL DEFAULT 0;
+
+CREATE INDEX idx_channels_profile ON channels(profile_id);
+`,
+	},
+	{
+		Version:     10,
+		Description: "track channel processes",
+		SQL: `
+ALTER TABLE channels ADD COLUMN pid INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE channels ADD COLUMN last_error TEXT NOT NULL DEFAULT '';
+`,
+	},
+	{
+		Version:     11,
+		Description: "one running channel per remux port",
+		SQL: `
+CREATE UNIQUE INDEX idx_channels_running_port ON channels(remux_port) WHERE running = 1;
+`,
+	},
+	{
+		Version:     12,
+		Description: "channel lifecycle states",
+		SQL: `
+ALTER TABLE channels ADD COLUMN state TEXT NOT NULL DEFAULT 'stopped';
+ALTER TABLE channels ADD COLUMN started_at DATETIME;
+ALTER TABLE channels ADD COLUMN stopped_at DATETIME;
+UPDATE channels SET state = 'running' WHERE running = 1;
+`,
+	},
+	{
+		Version:     13,
+		Description: "create webhooks",
+		SQL: `
+CREATE TABLE webhooks (
+	id         INTEGER PRIMARY KEY AUTOINCREMENT,
+	name       TEXT NOT NULL,
+	url        TEXT NOT NULL,
+	secret     TEXT NOT NULL DEFAULT '',
+	events     TEXT NOT NULL DEFAULT '',
+	active     BOOLEAN NOT NULL DEFAULT 1,
+	version    INTEGER NOT NULL DEFAULT 1,
+	created_at DATETIME NOT NULL,
+	updated_at DATETIME NOT NULL
+);
+
+CREATE TABLE webhook_deliveries (
+	id          INTEGER PRIMARY KEY AUTOINCREMENT,
+	webhook_id  INTEGER NOT NULL REFERENCES webhooks(id) ON DELETE CASCADE,
+	delivery_id TEXT NOT NULL,
+	event       TEXT NOT NULL,
+	attempt     INTEGER NOT NULL,
+	status_code INTEGER NOT NULL DEFAULT 0,
+	error       TEXT NOT NULL DEFAULT '',
+	duration_ms INTEGER NOT NULL DEFAULT 0,
+	created_at  DATETIME NOT NULL
+);
+
+CREATE INDEX idx_webhook_deliveries_webhook ON webhook_deliveries(webhook_id, id);
+`,
+	},
+	{
+		Version:     14,
+		Description: "key sessions by the hash of their cookie",
+		// Sessions were keyed by the cookie itself; they cannot be rekeyed
+		// without it, so everyone signs in again once
+		SQL: `
+DELETE FROM sessions;
+`,
+	},
+	{
+		Version:     15,
+		Description: "record the last TOTP time step used by each user",
+		SQL: `
+ALTER TABLE users ADD COLUMN totp_last_step INTEGER NOT NULL DEFAULT 0;
+`,
+	},
+}
+
+// migrate applies every migration newer than the recorded schema version.
+// Each migration runs in its own transaction together with its version record.
+func migrate(db *sql.DB) error {
+	if _, err := db.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (
+	version    INTEGER PRIMARY KEY,
+	applied_at DATETIME NOT NULL
+)`); err != nil {
+		return fmt.Errorf("failed to create schema_migrations table: %v", err)
+	}
+
+	var current int
+	if err := db.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&current); err != nil {
+		return fmt.Errorf("failed to read schema version: %v", err)
+	}
+
+	for _, m := range migrations {
+		if m.Version <= current {
+			continue
+		}
+
+		tx, err := db.Begin()
+		if err != nil {
+			return fmt.Errorf("failed to begin migration %d: %v", m.Version, err)
+		}
+		if _, err := tx.Exec(m.SQL); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migration %d (%s) failed: %v", m.Version, m.Description, err)
+		}
+		if _, err := tx.Exec(`INSERT INTO schema_migrations (version, applied_at) VALUES (?, ?)`, m.Version, time.Now()); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("failed to record migration %d: %v", m.Version, err)
+		}
+		if err := tx.Commit(); err != nil {
+			return fmt.Errorf("failed to commit migration %d: %v", m.Version, err)
+		}
+
+		log.Printf("Applied database migration %d: %s", m.Version, m.Description)
+		current = m.Version
+	}
+	return nil
+}
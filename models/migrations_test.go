@@ -0,0 +1,192 @@
+package models
+
+import (
+	"database/sql"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+// appliedMigrations returns the versions recorded in schema_migrations with
+// the time each was applied
+func appliedMigrations(t *testing.T, db *sql.DB) map[int]time.Time {
+	t.Helper()
+	rows, err := db.Query(`SELECT version, applied_at FROM schema_migrations`)
+	if err != nil {
+		t.Fatalf("reading schema_migrations: %v", err)
+	}
+	defer rows.Close()
+
+	applied := make(map[int]time.Time)
+	for rows.Next() {
+		var version int
+		var appliedAt time.Time
+		if err := rows.Scan(&version, &appliedAt); err != nil {
+			t.Fatalf("reading schema_migrations: %v", err)
+		}
+		applied[version] = appliedAt
+	}
+	return applied
+}
+
+// openAtVersion creates the database at path with the migrations up to
+// version applied, as an older release left it
+func openAtVersion(t *testing.T, path string, version int) *sql.DB {
+	t.Helper()
+	db, err := sql.Open("sqlite", fmt.Sprintf("file:%s?_pragma=foreign_keys(1)", path))
+	if err != nil {
+		t.Fatalf("opening database: %v", err)
+	}
+	if _, err := db.Exec(`CREATE TABLE schema_migrations (version INTEGER PRIMARY KEY, applied_at DATETIME NOT NULL)`); err != nil {
+		t.Fatalf("creating schema_migrations: %v", err)
+	}
+	for _, m := range migrations {
+		if m.Version > version {
+			break
+		}
+		if _, err := db.Exec(m.SQL); err != nil {
+			t.Fatalf("migration %d: %v", m.Version, err)
+		}
+		if _, err := db.Exec(`INSERT INTO schema_migrations (version, applied_at) VALUES (?, ?)`, m.Version, time.Now()); err != nil {
+			t.Fatalf("recording migration %d: %v", m.Version, err)
+		}
+	}
+	return db
+}
+
+// TestMigrationsNumbered checks that the migrations are numbered from 1
+// without gaps
+func TestMigrationsNumbered(t *testing.T) {
+	for i, m := range migrations {
+		if m.Version != i+1 {
+			t.Errorf("migration %d has version %d", i+1, m.Version)
+		}
+		if m.Description == "" || m.SQL == "" {
+			t.Errorf("migration %d has no description or SQL", m.Version)
+		}
+	}
+}
+
+// TestMigrationsApply checks that a new database gets every migration, and
+// that opening it again applies none
+func TestMigrationsApply(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "fuzzy.db")
+	store, err := NewSQLStore(path)
+	if err != nil {
+		t.Fatalf("NewSQLStore: %v", err)
+	}
+	applied := appliedMigrations(t, store.db)
+	if len(applied) != len(migrations) {
+		t.Errorf("%d migrations applied, want %d", len(applied), len(migrations))
+	}
+	for _, m := range migrations {
+		if _, ok := applied[m.Version]; !ok {
+			t.Errorf("migration %d (%s) not applied", m.Version, m.Description)
+		}
+	}
+	if _, err := store.CreateProvider(Provider{Name: "BBC"}); err != nil {
+		t.Fatalf("CreateProvider: %v", err)
+	}
+	store.Close()
+
+	reopened, err := NewSQLStore(path)
+	if err != nil {
+		t.Fatalf("reopening: %v", err)
+	}
+	defer reopened.Close()
+	again := appliedMigrations(t, reopened.db)
+	if len(again) != len(applied) {
+		t.Errorf("%d migrations recorded after reopening, want %d", len(again), len(applied))
+	}
+	for version, appliedAt := range applied {
+		if !again[version].Equal(appliedAt) {
+			t.Errorf("migration %d applied again", version)
+		}
+	}
+	if providers := reopened.GetAllProviders(); len(providers) != 1 || providers[0].Name != "BBC" {
+		t.Errorf("providers after reopening: %+v", providers)
+	}
+}
+
+// TestMigrationsUpgradeData checks that a database created before the media
+// settings were typed (migration 8) upgrades with its data intact
+func TestMigrationsUpgradeData(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "fuzzy.db")
+	db := openAtVersion(t, path, 2)
+	created := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	statements := []struct {
+		query string
+		args  []any
+	}{
+		{`INSERT INTO users (id, username, password, role, active, created_at, updated_at) VALUES (1, 'root', 'hash', 'Administrator', 1, ?, ?)`, []any{created, created}},
+		{`INSERT INTO users (id, username, password, role, active, created_at, updated_at) VALUES (2, 'ops', 'hash', 'User', 1, ?, ?)`, []any{created, created}},
+		{`INSERT INTO providers (id, name, api_key, active, created_at, updated_at) VALUES (1, 'BBC', 'key', 1, ?, ?)`, []any{created, created}},
+		{`INSERT INTO channels (id, name, manifest, key_kid, video_codec, audio_codec, resolution, video_bitrate, audio_bitrate, quality, running, remux_port, created_at, updated_at)
+			VALUES (1, 'BBC One', 'https://example.com/one.mpd', 'key:kid', 'av1', ' aac', '1920×1080', '5000k', '128k', 'High', 1, 8001, ?, ?)`, []any{created, created}},
+		{`INSERT INTO channels (id, name, manifest, key_kid, video_codec, audio_codec, resolution, video_bitrate, audio_bitrate, quality, running, remux_port, created_at, updated_at)
+			VALUES (2, 'BBC Two', 'https://example.com/two.mpd', 'key:kid', 'x264', 'MP3', '720p', '2.5M', '96000', 'Low', 0, 0, ?, ?)`, []any{created, created}},
+		{`INSERT INTO channels (id, name, manifest, key_kid, video_codec, audio_codec, resolution, video_bitrate, audio_bitrate, quality, running, remux_port, created_at, updated_at)
+			VALUES (3, 'Broken', 'https://example.com/three.mpd', 'key:kid', 'mpeg2', 'AAC', 'huge', 'fast', '', 'Low', 0, 0, ?, ?)`, []any{created, created}},
+		{`INSERT INTO bouquets (id, name, provider_id, created_at, updated_at) VALUES (1, 'Basics', 1, ?, ?)`, []any{created, created}},
+		{`INSERT INTO bouquet_channels (bouquet_id, channel_id, position) VALUES (1, 2, 0), (1, 1, 1)`, nil},
+	}
+	for _, statement := range statements {
+		if _, err := db.Exec(statement.query, statement.args...); err != nil {
+			t.Fatalf("inserting %q: %v", statement.query, err)
+		}
+	}
+	db.Close()
+
+	store, err := NewSQLStore(path)
+	if err != nil {
+		t.Fatalf("upgrading: %v", err)
+	}
+	defer store.Close()
+	if applied := appliedMigrations(t, store.db); len(applied) != len(migrations) {
+		t.Errorf("%d migrations applied, want %d", len(applied), len(migrations))
+	}
+
+	if user, _ := store.GetUser(1); user.Role != RoleAdmin || user.Password != "hash" {
+		t.Errorf("user 1: role %q, password %q", user.Role, user.Password)
+	}
+	if user, _ := store.GetUser(2); user.Role != RoleOperator {
+		t.Errorf("user 2: role %q, want operator", user.Role)
+	}
+	if provider, _ := store.GetProvider(1); provider.Name != "BBC" || provider.APIKey != "key" || provider.Version != 1 {
+		t.Errorf("provider: %+v", provider)
+	}
+
+	channels := []struct {
+		id       int
+		settings MediaSettings
+		state    ChannelState
+	}{
+		{1, MediaSettings{VideoCodec: VideoCodecAV1, AudioCodec: AudioCodecAAC, Resolution: Resolution1080p, VideoBitrate: 5000 * Kbps, AudioBitrate: 128 * Kbps}, ChannelRunning},
+		{2, MediaSettings{VideoCodec: VideoCodecH264, AudioCodec: AudioCodecMP3, Resolution: Resolution720p, VideoBitrate: 2500 * Kbps, AudioBitrate: 96 * Kbps}, ChannelStopped},
+		// Values that do not parse are kept for validation to report, or unset
+		{3, MediaSettings{VideoCodec: "mpeg2", AudioCodec: AudioCodecAAC}, ChannelStopped},
+	}
+	for _, want := range channels {
+		channel, exists := store.GetChannel(want.id)
+		if !exists {
+			t.Errorf("channel %d lost", want.id)
+			continue
+		}
+		if channel.MediaSettings != want.settings {
+			t.Errorf("channel %d settings %+v, want %+v", want.id, channel.MediaSettings, want.settings)
+		}
+		if channel.State != want.state || channel.Version != 1 || !channel.CreatedAt.Equal(created) {
+			t.Errorf("channel %d: state %s, version %d, created %v", want.id, channel.State, channel.Version, channel.CreatedAt)
+		}
+	}
+	if channel, _ := store.GetChannel(1); channel.RemuxPort != 8001 || !channel.Running || channel.KeyKid != "key:kid" {
+		t.Errorf("channel 1: %+v", channel)
+	}
+
+	bouquet, exists := store.GetBouquet(1)
+	if !exists || bouquet.ProviderID != 1 || !slices.Equal(bouquet.ChannelIDs, []int{2, 1}) {
+		t.Errorf("bouquet: %+v", bouquet)
+	}
+}
@@ -1,6 +1,8 @@
 package models
 
 import (
+	"html/template"
+	"slices"
 	"time"
 	"golang.org/x/crypto/bcrypt"
 )
@@ -19,18 +21,22 @@ type Channel struct {
 	Manifest    string `json:"manifest"`
 	KeyKid      string `json:"key_kid"`
 	
-	// Video encoding properties
-	VideoCodec    string `json:"video_codec"`    // x264, x265, AV1, VP9
-	AudioCodec    string `json:"audio_codec"`    // AAC, MP3, AC3, DTS
-	Resolution    string `json:"resolution"`     // 720p, 1080p, 1440p, 2160p
-	VideoBitrate  string `json:"video_bitrate"`  // Video bitrate (e.g., "5000k", "8000k")
-	AudioBitrate  string `json:"audio_bitrate"`  // Audio bitrate (e.g., "128k", "256k")
-	Quality       string `json:"quality"`        // Low, Medium, High, Ultra
+	// Video encoding properties. With a profile, the settings left unset
+	// are taken from it; see ResolveSettings.
+	ProfileID int `json:"profile_id"` // Encoding profile of the channel, 0 for none
+	MediaSettings
+	Quality   string `json:"quality"` // Low, Medium, High, Ultra
 	
 	// Channel state for remuxer control
-	Running   bool `json:"running"`   // Whether the channel is currently running
-	RemuxPort int  `json:"remux_port"` // Port for internal remuxer (0 if not running)
+	State     ChannelState `json:"state"`                // Lifecycle state; see ChannelState
+	Running   bool         `json:"running"`              // Whether the state is active, kept for older clients
+	RemuxPort int          `json:"remux_port"`           // Port for internal remuxer, kept once stopped for the next start
+	PID       int          `json:"pid"`                  // Process of the running channel, 0 in any other state
+	LastError string       `json:"last_error,omitempty"` // Why the channel last failed, cleared on start
+	StartedAt time.Time    `json:"started_at"`           // Zero until the channel is first started
+	StoppedAt time.Time    `json:"stopped_at"`           // Zero until the channel first stops or fails
 	
+	Version   int       `json:"version"` // Incremented on every write, used to detect concurrent edits
 	CreatedAt time.Time `json:"created_at"`
 	UpdatedAt time.Time `json:"updated_at"`
 }
@@ -43,6 +49,7 @@ type Provider struct {
 	URL         string    `json:"url"`
 	APIKey      string    `json:"api_key"`
 	Active      bool      `json:"active"`
+	Version     int       `json:"version"` // Incremented on every write, used to detect concurrent edits
 	CreatedAt   time.Time `json:"created_at"`
 	UpdatedAt   time.Time `json:"updated_at"`
 }
@@ -53,7 +60,9 @@ type Bouquet struct {
 	Name        string    `json:"name"`
 	Description string    `json:"description"`
 	ProviderID  int       `json:"provider_id"`  // Links bouquet to a provider
-	Channels    []Channel `json:"channels"`
+	ChannelIDs  []int     `json:"channel_ids"`  // Member channels, in display order
+	Channels    []Channel `json:"channels"`     // Current state of the member channels, resolved on read
+	Version     int       `json:"version"`      // Incremented on every write, used to detect concurrent edits
 	CreatedAt   time.Time `json:"created_at"`
 	UpdatedAt   time.Time `json:"updated_at"`
 }
@@ -68,8 +77,16 @@ type User struct {
 	LastName  string    `json:"last_name"`
 	Role      string    `json:"role"`
 	Active    bool      `json:"active"`
+	Version   int       `json:"version"` // Incremented on every write, used to detect concurrent edits
 	CreatedAt time.Time `json:"created_at"`
 	UpdatedAt time.Time `json:"updated_at"`
+
+	// Two-factor authentication. A TOTPSecret without TOTPEnabled is an
+	// enrollment waiting for its first code.
+	TOTPSecret    string   `json:"-"`
+	TOTPEnabled   bool     `json:"totp_enabled"`
+	RecoveryCodes []string `json:"-"` // bcrypt hashes of the unused recovery codes
+	TOTPLastStep  uint64   `json:"-"` // Last TOTP time step signed in with, so that a code is used once
 }
 
 // SetPassword hashes and sets the user's password
@@ -88,6 +105,39 @@ func (u *User) CheckPassword(password string) bool {
 	return err == nil
 }
 
+// Session represents a logged-in browser session
+type Session struct {
+	ID        string    `json:"id"` // SHA-256 of the random token stored in the session cookie; the token itself is never stored
+	UserID    int       `json:"user_id"`
+	ClientIP  string    `json:"client_ip"`  // Address the user logged in from
+	UserAgent string    `json:"user_agent"` // Browser the user logged in with
+	CreatedAt time.Time `json:"created_at"`
+	LastSeen  time.Time `json:"last_seen"`
+}
+
+// APIToken is a personal token that authenticates scripts as its user
+type APIToken struct {
+	ID        int          `json:"id"`
+	UserID    int          `json:"user_id"`
+	Name      string       `json:"name"`
+	Hash      string       `json:"-"`          // SHA-256 of the token; the token itself is never stored
+	Prefix    string       `json:"prefix"`     // Start of the token, to tell tokens apart
+	Scopes    []Permission `json:"scopes"`     // Permissions the token is limited to; empty for all of the user's
+	ExpiresAt time.Time    `json:"expires_at"` // Zero when the token never expires
+	LastUsed  time.Time    `json:"last_used"`  // Zero until the token is first used
+	CreatedAt time.Time    `json:"created_at"`
+}
+
+// Expired reports whether the token can no longer be used at time now
+func (t APIToken) Expired(now time.Time) bool {
+	return !t.ExpiresAt.IsZero() && !now.Before(t.ExpiresAt)
+}
+
+// Allows reports whether the scopes of the token include the permission
+func (t APIToken) Allows(permission Permission) bool {
+	return len(t.Scopes) == 0 || slices.Contains(t.Scopes, permission)
+}
+
 // ProvidersPageData represents the data structure for the providers page template
 type ProvidersPageData struct {
 	Title    string
@@ -100,8 +150,15 @@ type ProvidersPageData struct {
 type ProvidersWithBouquetsPageData struct {
 	Title     string
 	Providers []ProviderWithBouquets
+	Channels  []Channel // All channels, offered when adding channels to a bouquet
 	Message   string
 	Error     string
+
+	// Set after a conflicting edit, to reopen the form with the current values
+	ConflictProviderID int
+	ConflictBouquetID  int
+
+	FieldErrors FormErrors // Invalid fields of the submitted form
 }
 
 // ProviderWithBouquets combines provider info with its bouquets
@@ -116,6 +173,56 @@ type UsersPageData struct {
 	Users   []User
 	Message string
 	Error   string
+
+	ConflictID  int        // Set after a conflicting edit, to reopen the form with the current values
+	FieldErrors FormErrors // Invalid fields of the submitted form
+}
+
+// SessionsPageData represents the data structure for the sessions page template
+type SessionsPageData struct {
+	Title    string
+	Sessions []SessionInfo // The user's own sessions, or every session for administrators
+	Message  string
+	Error    string
+}
+
+// SessionInfo describes a session on the sessions page without exposing its ID
+type SessionInfo struct {
+	Key       string // Identifies the session in revoke forms
+	UserID    int
+	Username  string
+	ClientIP  string
+	UserAgent string
+	CreatedAt time.Time
+	LastSeen  time.Time
+	Current   bool // The session of the request being served
+}
+
+// TokensPageData represents the data structure for the API tokens page template
+type TokensPageData struct {
+	Title    string
+	Tokens   []APIToken
+	Scopes   []Permission // Permissions the user can grant to a token
+	NewToken string       // Token just created, shown only once
+	Now      time.Time    // To tell expired tokens apart
+	Message  string
+	Error    string
+}
+
+// WebhooksPageData represents the data structure for the webhooks page template
+type WebhooksPageData struct {
+	Title      string
+	Webhooks   []Webhook
+	Events     []string          // Events a webhook can receive
+	Selected   Webhook           // Webhook whose delivery log is shown; zero for none
+	Deliveries []WebhookDelivery // Delivery log of Selected, newest first
+	NewSecret  string            // Secret generated for a webhook just created, shown only once
+	Message    string
+	Error      string
+
+	ConflictID  int        // Set after a conflicting edit, to reopen the form with the current values
+	FieldErrors FormErrors // Invalid fields of the submitted form
+	Form        Webhook    // Values of the create form, kept when they are invalid
 }
 
 // ChannelsPageData represents the data structure for the channels page template
@@ -124,6 +231,73 @@ type ChannelsPageData struct {
 	Channels []Channel
 	Message  string
 	Error    string
+
+	ConflictID  int        // Set after a conflicting edit, to reopen the form with the current values
+	FieldErrors FormErrors // Invalid fields of the submitted form
+	Form        Channel    // Values of the create form, kept when they are invalid
+
+	Profiles []EncodingProfile // Encoding profiles channels can use
+}
+
+// Profile returns the listed encoding profile with the given ID, or a zero
+// profile
+func (d ChannelsPageData) Profile(id int) EncodingProfile {
+	for _, profile := range d.Profiles {
+		if profile.ID == id {
+			return profile
+		}
+	}
+	return EncodingProfile{}
+}
+
+// Settings returns the settings channel is encoded with, completed from its
+// profile
+func (d ChannelsPageData) Settings(channel Channel) MediaSettings {
+	return channel.MediaSettings.Over(d.Profile(channel.ProfileID).MediaSettings)
+}
+
+// ProfilesPageData represents the data structure for the encoding profiles page template
+type ProfilesPageData struct {
+	Title    string
+	Profiles []EncodingProfile
+	Channels []Channel // Channels listed under the profiles they use
+	Message  string
+	Error    string
+
+	ConflictID  int             // Set after a conflicting edit, to reopen the form with the current values
+	FieldErrors FormErrors      // Invalid fields of the submitted form
+	Form        EncodingProfile // Values of the create form, kept when they are invalid
+}
+
+// Channel returns the listed channel with the given ID, or a zero channel
+func (d ProfilesPageData) Channel(id int) Channel {
+	for _, channel := range d.Channels {
+		if channel.ID == id {
+			return channel
+		}
+	}
+	return Channel{}
+}
+
+// FormErrors holds the messages of the invalid fields of a submitted form, so
+// the page can show them next to the fields
+type FormErrors struct {
+	Action string            // Action of the form, e.g. "create" or "update"
+	ID     int               // Entity edited by the form; 0 for creation forms
+	Fields map[string]string // Messages by field name
+}
+
+// For returns the message of a field of the form for action and id, or ""
+func (e FormErrors) For(action string, id int, field string) string {
+	if e.Action != action || e.ID != id {
+		return ""
+	}
+	return e.Fields[field]
+}
+
+// Has reports whether the form for action and id has invalid fields
+func (e FormErrors) Has(action string, id int) bool {
+	return e.Action == action && e.ID == id && len(e.Fields) > 0
 }
 
 // ProvidersManagementPageData represents the data structure for the providers management page template
@@ -139,6 +313,20 @@ type LoginPageData struct {
 	Title   string
 	Message string
 	Error   string
+
+	TwoFactorToken string // Set when the password was accepted and a second factor is expected
+}
+
+// AccountPageData represents the data structure for the account page template
+type AccountPageData struct {
+	Title   string
+	User    User
+	Message string
+	Error   string
+
+	TOTPURI           template.URL // otpauth:// URI of a pending enrollment, which html/template would otherwise reject
+	RecoveryCodes     []string     // Freshly generated recovery codes, shown only once
+	TwoFactorRequired bool         // The user must enable two-factor authentication to use the panel
 }
 
 // SetupPageData represents the data structure for the first-time setup page template
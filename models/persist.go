@@ -0,0 +1,372 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// flushInterval is how long the deferred writes of a file store may wait in
+// memory before the data file is rewritten for them
+const flushInterval = 30 * time.Second
+
+// storeSnapshot is the on-disk representation of a file-backed store
+type storeSnapshot struct {
+	Bouquets       []Bouquet         `json:"bouquets"`
+	Users          []storedUser      `json:"users"`
+	Channels       []storedChannel   `json:"channels"`
+	Providers      []Provider        `json:"providers"`
+	Profiles       []EncodingProfile `json:"profiles,omitempty"`
+	Sessions       []Session         `json:"sessions,omitempty"`
+	SessionsHashed bool              `json:"sessions_hashed,omitempty"` // Unset by versions that keyed sessions by their cookie
+	APITokens      []storedAPIToken  `json:"api_tokens,omitempty"`
+	Webhooks       []storedWebhook   `json:"webhooks,omitempty"`
+	Deliveries     []WebhookDelivery `json:"webhook_deliveries,omitempty"`
+	NextBouquetID  int               `json:"next_bouquet_id"`
+	NextUserID     int               `json:"next_user_id"`
+	NextChannelID  int               `json:"next_channel_id"`
+	NextProviderID int               `json:"next_provider_id"`
+	NextProfileID  int               `json:"next_profile_id"`
+	NextAPITokenID int               `json:"next_api_token_id"`
+	NextWebhookID  int               `json:"next_webhook_id"`
+	NextDeliveryID int               `json:"next_webhook_delivery_id"`
+}
+
+// storedUser keeps the secrets that User hides from JSON output
+type storedUser struct {
+	User
+	PasswordHash  string   `json:"password_hash"`
+	TOTPSecret    string   `json:"totp_secret,omitempty"`
+	RecoveryCodes []string `json:"recovery_codes,omitempty"`
+	TOTPLastStep  uint64   `json:"totp_last_step,omitempty"`
+}
+
+// storedChannel reads the channels of a data file, including those written
+// when resolutions and bitrates were free-form strings or before channels had
+// a state. Settings that no
+// longer parse are dropped with a warning, so the next edit of the channel
+// sets them to their default instead of the whole file failing to load.
+type storedChannel struct {
+	Channel
+	migrated bool // Whether a setting was rewritten in its current form
+}
+
+func (c *storedChannel) UnmarshalJSON(data []byte) error {
+	type plainChannel Channel // Without this method, to avoid recursing
+	var raw struct {
+		plainChannel
+		VideoCodec   string `json:"video_codec"`
+		AudioCodec   string `json:"audio_codec"`
+		Resolution   string `json:"resolution"`
+		VideoBitrate string `json:"video_bitrate"`
+		AudioBitrate string `json:"audio_bitrate"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	c.Channel = Channel(raw.plainChannel)
+	c.VideoCodec, _ = canonicalName(raw.VideoCodec, VideoCodecs)
+	c.AudioCodec, _ = canonicalName(raw.AudioCodec, AudioCodecs)
+
+	var err error
+	if c.Resolution, err = ParseResolution(raw.Resolution); err != nil {
+		log.Printf("Warning: channel %d: dropping %v", c.ID, err)
+	}
+	if c.VideoBitrate, err = ParseBitrate(raw.VideoBitrate); err != nil {
+		log.Printf("Warning: channel %d: dropping video %v", c.ID, err)
+	}
+	if c.AudioBitrate, err = ParseBitrate(raw.AudioBitrate); err != nil {
+		log.Printf("Warning: channel %d: dropping audio %v", c.ID, err)
+	}
+	c.migrated = string(c.VideoCodec) != raw.VideoCodec || string(c.AudioCodec) != raw.AudioCodec ||
+		c.Resolution.String() != raw.Resolution ||
+		c.VideoBitrate.String() != raw.VideoBitrate ||
+		c.AudioBitrate.String() != raw.AudioBitrate
+
+	// Files written before channel states only have the running flag
+	if c.State == "" {
+		c.State = ChannelStopped
+		if c.Running {
+			c.State = ChannelRunning
+		}
+		c.migrated = true
+	}
+	return nil
+}
+
+// storedAPIToken keeps the token hash, which APIToken hides from JSON output
+type storedAPIToken struct {
+	APIToken
+	TokenHash string `json:"hash"`
+}
+
+// storedWebhook keeps the webhook secret, which Webhook hides from JSON output
+type storedWebhook struct {
+	Webhook
+	WebhookSecret string `json:"secret"`
+}
+
+// NewFileStore creates a store that loads its data from path and writes
+// every change back to it, atomically, before Update returns. A missing file
+// starts an empty store.
+//
+// The only exception are writes that merely record activity, which set
+// memoryTx.deferred: a transaction making only such writes, and publishing no
+// event, is written with the next other change, within flushInterval, or by
+// Close, so a crash may lose the last flushInterval of them.
+func NewFileStore(path string) (*MemoryStore, error) {
+	store := NewMemoryStore()
+	store.dataFile = path
+
+	if err := store.load(); err != nil {
+		return nil, err
+	}
+	store.stop = make(chan struct{})
+	go store.flushEvery(flushInterval)
+	return store, nil
+}
+
+// Close stops the background flush and writes the changes still pending
+func (s *MemoryStore) Close() error {
+	s.closeOnce.Do(func() {
+		if s.stop != nil {
+			close(s.stop)
+		}
+	})
+	return s.flush()
+}
+
+// flush writes the data file when it lacks deferred writes
+func (s *MemoryStore) flush() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if !s.dirty {
+		return nil
+	}
+	if err := s.save(); err != nil {
+		return err
+	}
+	s.dirty = false
+	return nil
+}
+
+// flushEvery runs flush at every interval until Close is called
+func (s *MemoryStore) flushEvery(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := s.flush(); err != nil {
+				log.Printf("Error writing data file: %v", err)
+			}
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+// load reads the data file into the store
+func (s *MemoryStore) load() error {
+	content, err := os.ReadFile(s.dataFile)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read data file: %v", err)
+	}
+
+	var snapshot storeSnapshot
+	if err := json.Unmarshal(content, &snapshot); err != nil {
+		return fmt.Errorf("failed to decode data file %s: %v", s.dataFile, err)
+	}
+
+	for _, stored := range snapshot.Users {
+		user := stored.User
+		user.Password = stored.PasswordHash
+		user.TOTPSecret = stored.TOTPSecret
+		user.RecoveryCodes = stored.RecoveryCodes
+		user.TOTPLastStep = stored.TOTPLastStep
+		user.Role = NormalizeRole(user.Role)
+		s.data.users[user.ID] = user
+	}
+	migrated := false
+	for _, stored := range snapshot.Channels {
+		s.data.channels[stored.ID] = stored.Channel
+		migrated = migrated || stored.migrated
+	}
+	for _, provider := range snapshot.Providers {
+		s.data.providers[provider.ID] = provider
+	}
+	for _, profile := range snapshot.Profiles {
+		profile.UsedBy = nil
+		s.data.profiles[profile.ID] = profile
+	}
+	if snapshot.SessionsHashed {
+		for _, session := range snapshot.Sessions {
+			s.data.sessions[session.ID] = session
+		}
+	} else if len(snapshot.Sessions) > 0 {
+		// Sessions keyed by their cookie cannot be rekeyed without it
+		log.Printf("Signing out the %d session(s) stored by an older version", len(snapshot.Sessions))
+		migrated = true
+	}
+	for _, stored := range snapshot.APITokens {
+		token := stored.APIToken
+		token.Hash = stored.TokenHash
+		s.data.apiTokens[token.ID] = token
+	}
+	for _, stored := range snapshot.Webhooks {
+		webhook := stored.Webhook
+		webhook.Secret = stored.WebhookSecret
+		s.data.webhooks[webhook.ID] = webhook
+	}
+	for _, delivery := range snapshot.Deliveries {
+		s.data.deliveries[delivery.ID] = delivery
+	}
+
+	s.data.nextBouquetID = max(snapshot.NextBouquetID, 1)
+	s.data.nextUserID = max(snapshot.NextUserID, 1)
+	s.data.nextChannelID = max(snapshot.NextChannelID, 1)
+	s.data.nextProviderID = max(snapshot.NextProviderID, 1)
+	s.data.nextProfileID = max(snapshot.NextProfileID, 1)
+	s.data.nextAPITokenID = max(snapshot.NextAPITokenID, 1)
+	s.data.nextWebhookID = max(snapshot.NextWebhookID, 1)
+	s.data.nextDeliveryID = max(snapshot.NextDeliveryID, 1)
+
+	for _, bouquet := range snapshot.Bouquets {
+		if len(bouquet.ChannelIDs) == 0 && len(bouquet.Channels) > 0 {
+			bouquet.ChannelIDs = s.data.adoptEmbeddedChannels(bouquet.Channels)
+			migrated = true
+		}
+		bouquet.Channels = nil
+		s.data.bouquets[bouquet.ID] = bouquet
+	}
+	if migrated {
+		return s.save()
+	}
+	return nil
+}
+
+// adoptEmbeddedChannels converts the channel copies stored in bouquets by
+// older versions into channel IDs. A copy is matched to an existing channel by
+// ID and name; unmatched copies become new channels.
+func (d *memoryData) adoptEmbeddedChannels(embedded []Channel) []int {
+	var ids []int
+	for _, channel := range embedded {
+		if existing, exists := d.channels[channel.ID]; exists && existing.Name == channel.Name {
+			ids = append(ids, existing.ID)
+			continue
+		}
+		channel.ID = d.nextChannelID
+		d.channels[channel.ID] = channel
+		d.nextChannelID++
+		ids = append(ids, channel.ID)
+	}
+	return ids
+}
+
+// save writes the whole store to the data file. The caller must hold the mutex.
+// Memory-only stores have no data file and save is a no-op.
+func (s *MemoryStore) save() error {
+	if s.dataFile == "" {
+		return nil
+	}
+
+	snapshot := storeSnapshot{
+		NextBouquetID:  s.data.nextBouquetID,
+		NextUserID:     s.data.nextUserID,
+		NextChannelID:  s.data.nextChannelID,
+		NextProviderID: s.data.nextProviderID,
+		NextProfileID:  s.data.nextProfileID,
+		NextAPITokenID: s.data.nextAPITokenID,
+		NextWebhookID:  s.data.nextWebhookID,
+		NextDeliveryID: s.data.nextDeliveryID,
+		SessionsHashed: true,
+	}
+	for _, bouquet := range s.data.bouquets {
+		snapshot.Bouquets = append(snapshot.Bouquets, bouquet)
+	}
+	for _, user := range s.data.users {
+		snapshot.Users = append(snapshot.Users, storedUser{
+			User:          user,
+			PasswordHash:  user.Password,
+			TOTPSecret:    user.TOTPSecret,
+			RecoveryCodes: user.RecoveryCodes,
+			TOTPLastStep:  user.TOTPLastStep,
+		})
+	}
+	for _, channel := range s.data.channels {
+		snapshot.Channels = append(snapshot.Channels, storedChannel{Channel: channel})
+	}
+	for _, provider := range s.data.providers {
+		snapshot.Providers = append(snapshot.Providers, provider)
+	}
+	for _, profile := range s.data.profiles {
+		snapshot.Profiles = append(snapshot.Profiles, profile)
+	}
+	for _, session := range s.data.sessions {
+		snapshot.Sessions = append(snapshot.Sessions, session)
+	}
+	for _, token := range s.data.apiTokens {
+		snapshot.APITokens = append(snapshot.APITokens, storedAPIToken{APIToken: token, TokenHash: token.Hash})
+	}
+	for _, webhook := range s.data.webhooks {
+		snapshot.Webhooks = append(snapshot.Webhooks, storedWebhook{Webhook: webhook, WebhookSecret: webhook.Secret})
+	}
+	for _, delivery := range s.data.deliveries {
+		snapshot.Deliveries = append(snapshot.Deliveries, delivery)
+	}
+
+	content, err := json.MarshalIndent(snapshot, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode store: %v", err)
+	}
+	return writeFileAtomic(s.dataFile, content)
+}
+
+// writeFileAtomic writes content to a temporary file in the same directory,
+// syncs it and renames it over path, so a crash never leaves a partial file
+func writeFileAtomic(path string, content []byte) error {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create data directory: %v", err)
+	}
+
+	tmp, err := os.CreateTemp(dir, filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary data file: %v", err)
+	}
+	tmpName := tmp.Name()
+	defer os.Remove(tmpName)
+
+	if _, err := tmp.Write(content); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write temporary data file: %v", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to sync temporary data file: %v", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to close temporary data file: %v", err)
+	}
+	if err := os.Chmod(tmpName, 0600); err != nil {
+		return fmt.Errorf("failed to set data file permissions: %v", err)
+	}
+	if err := os.Rename(tmpName, path); err != nil {
+		return fmt.Errorf("failed to replace data file: %v", err)
+	}
+
+	// Sync the directory so the rename itself survives a crash
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+	return nil
+}
@@ -0,0 +1,399 @@
+package models
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+// legacyData is a data file written before channel states and typed media
+// settings, with a bouquet still holding copies of its channels
+const legacyData = `{
+	"channels": [
+		{"id": 1, "name": "BBC One", "manifest": "https://example.com/one.mpd", "key_kid": "key:kid",
+		 "video_codec": "av1", "audio_codec": " aac", "resolution": "1920×1080",
+		 "video_bitrate": "5000k", "audio_bitrate": "128k", "quality": "High",
+		 "running": true, "remux_port": 8001, "version": 3},
+		{"id": 2, "name": "BBC Two", "manifest": "https://example.com/two.mpd", "key_kid": "key:kid",
+		 "video_codec": "x264", "audio_codec": "MP3", "resolution": "720p",
+		 "video_bitrate": "2.5M", "audio_bitrate": "96000", "quality": "Low"},
+		{"id": 3, "name": "Broken", "manifest": "https://example.com/three.mpd", "key_kid": "key:kid",
+		 "video_codec": "mpeg2", "audio_codec": "AAC", "resolution": "huge",
+		 "video_bitrate": "fast", "audio_bitrate": "", "quality": "Low"}
+	],
+	"providers": [{"id": 1, "name": "BBC", "api_key": "key", "active": true}],
+	"bouquets": [
+		{"id": 1, "name": "Basics", "provider_id": 1, "channels": [
+			{"id": 2, "name": "BBC Two"},
+			{"id": 1, "name": "BBC One"}
+		]}
+	],
+	"next_bouquet_id": 2,
+	"next_channel_id": 4,
+	"next_provider_id": 2
+}`
+
+// TestFileStoreLoadsLegacyChannels checks that a data file written by an
+// older version loads with typed settings and states, and is rewritten in the
+// current form
+func TestFileStoreLoadsLegacyChannels(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "fuzzy.data")
+	if err := os.WriteFile(path, []byte(legacyData), 0o600); err != nil {
+		t.Fatalf("writing data file: %v", err)
+	}
+	store, err := NewFileStore(path)
+	if err != nil {
+		t.Fatalf("NewFileStore: %v", err)
+	}
+
+	channels := []struct {
+		id       int
+		settings MediaSettings
+		state    ChannelState
+	}{
+		{1, MediaSettings{VideoCodec: VideoCodecAV1, AudioCodec: AudioCodecAAC, Resolution: Resolution1080p, VideoBitrate: 5000 * Kbps, AudioBitrate: 128 * Kbps}, ChannelRunning},
+		{2, MediaSettings{VideoCodec: VideoCodecH264, AudioCodec: AudioCodecMP3, Resolution: Resolution720p, VideoBitrate: 2500 * Kbps, AudioBitrate: 96 * Kbps}, ChannelStopped},
+		// Values that do not parse are kept for validation to report, or unset
+		{3, MediaSettings{VideoCodec: "mpeg2", AudioCodec: AudioCodecAAC}, ChannelStopped},
+	}
+	for _, want := range channels {
+		channel, exists := store.GetChannel(want.id)
+		if !exists {
+			t.Errorf("channel %d lost", want.id)
+			continue
+		}
+		if channel.MediaSettings != want.settings {
+			t.Errorf("channel %d settings %+v, want %+v", want.id, channel.MediaSettings, want.settings)
+		}
+		if channel.State != want.state {
+			t.Errorf("channel %d state %s, want %s", want.id, channel.State, want.state)
+		}
+	}
+	if channel, _ := store.GetChannel(1); channel.RemuxPort != 8001 || channel.Version != 3 || channel.KeyKid != "key:kid" {
+		t.Errorf("channel 1: %+v", channel)
+	}
+	if bouquet, _ := store.GetBouquet(1); !slices.Equal(bouquet.ChannelIDs, []int{2, 1}) {
+		t.Errorf("bouquet channels %v, want [2 1]", bouquet.ChannelIDs)
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("reading data file: %v", err)
+	}
+	var rewritten struct {
+		Channels []map[string]any `json:"channels"`
+		Bouquets []map[string]any `json:"bouquets"`
+	}
+	if err := json.Unmarshal(content, &rewritten); err != nil {
+		t.Fatalf("decoding rewritten data file: %v", err)
+	}
+	for _, channel := range rewritten.Channels {
+		if channel["id"] == 1.0 && (channel["state"] != "running" || channel["resolution"] != "1080p" || channel["video_codec"] != "AV1") {
+			t.Errorf("channel 1 rewritten as %v", channel)
+		}
+	}
+	if len(rewritten.Bouquets) != 1 || rewritten.Bouquets[0]["channels"] != nil {
+		t.Errorf("bouquets rewritten as %v", rewritten.Bouquets)
+	}
+
+	reloaded, err := NewFileStore(path)
+	if err != nil {
+		t.Fatalf("reloading: %v", err)
+	}
+	for _, want := range channels {
+		if channel, _ := reloaded.GetChannel(want.id); channel.MediaSettings != want.settings || channel.State != want.state {
+			t.Errorf("channel %d after reloading: %+v", want.id, channel)
+		}
+	}
+}
+
+// TestFileStoreKeepsSecrets checks that the fields hidden from JSON output
+// are written to the data file and read back
+func TestFileStoreKeepsSecrets(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "fuzzy.data")
+	store, err := NewFileStore(path)
+	if err != nil {
+		t.Fatalf("NewFileStore: %v", err)
+	}
+	secret, err := NewTOTPSecret()
+	if err != nil {
+		t.Fatalf("NewTOTPSecret: %v", err)
+	}
+	user := User{Username: "alice", Password: "$2a$10$hash", Role: RoleAdmin, Active: true, TOTPSecret: secret, TOTPEnabled: true}
+	if _, err := user.SetRecoveryCodes(); err != nil {
+		t.Fatalf("SetRecoveryCodes: %v", err)
+	}
+	user, err = store.CreateUser(user)
+	if err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	token, err := store.CreateAPIToken(APIToken{UserID: user.ID, Name: "deploy", Hash: "token-hash", Scopes: []Permission{PermChannelsView}})
+	if err != nil {
+		t.Fatalf("CreateAPIToken: %v", err)
+	}
+	webhook, err := store.CreateWebhook(Webhook{Name: "ops", URL: "https://example.com/hook", Secret: "webhook-secret", Active: true})
+	if err != nil {
+		t.Fatalf("CreateWebhook: %v", err)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	reloaded, err := NewFileStore(path)
+	if err != nil {
+		t.Fatalf("reloading: %v", err)
+	}
+	defer reloaded.Close()
+	if got, _ := reloaded.GetUser(user.ID); got.Password != user.Password || got.TOTPSecret != secret || !slices.Equal(got.RecoveryCodes, user.RecoveryCodes) {
+		t.Errorf("user secrets: password %q, TOTP secret %q, recovery codes %v; want %q, %q, %v",
+			got.Password, got.TOTPSecret, got.RecoveryCodes, user.Password, secret, user.RecoveryCodes)
+	}
+	if got, _ := reloaded.GetAPIToken(token.ID); got.Hash != "token-hash" || !slices.Equal(got.Scopes, token.Scopes) {
+		t.Errorf("API token: hash %q, scopes %v", got.Hash, got.Scopes)
+	}
+	if got, exists := reloaded.GetAPITokenByHash("token-hash"); !exists || got.ID != token.ID {
+		t.Errorf("API token not found by its hash")
+	}
+	if got, _ := reloaded.GetWebhook(webhook.ID); got.Secret != "webhook-secret" {
+		t.Errorf("webhook secret %q, want %q", got.Secret, "webhook-secret")
+	}
+}
+
+// reloadFileStore opens the data file at path as a restart after a crash
+// would find it
+func reloadFileStore(t *testing.T, path string) *MemoryStore {
+	t.Helper()
+	reloaded, err := NewFileStore(path)
+	if err != nil {
+		t.Fatalf("reloading: %v", err)
+	}
+	t.Cleanup(func() { reloaded.Close() })
+	return reloaded
+}
+
+// TestFileStoreDefersSessionTouches checks that the last activity of a
+// session waits for the next save of the data file, while new and deleted
+// sessions are saved at once
+func TestFileStoreDefersSessionTouches(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "fuzzy.data")
+	store, err := NewFileStore(path)
+	if err != nil {
+		t.Fatalf("NewFileStore: %v", err)
+	}
+	defer store.Close()
+	user, err := store.CreateUser(User{Username: "alice", Password: "hash", Role: RoleAdmin, Active: true})
+	if err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	created := time.Now().Truncate(time.Second)
+	session := Session{ID: "session-hash", UserID: user.ID, CreatedAt: created, LastSeen: created}
+	if err := store.SaveSession(session); err != nil {
+		t.Fatalf("SaveSession: %v", err)
+	}
+	if sessions := reloadFileStore(t, path).GetAllSessions(); len(sessions) != 1 {
+		t.Fatalf("new session not saved: %+v", sessions)
+	}
+
+	session.LastSeen = created.Add(time.Minute)
+	if err := store.SaveSession(session); err != nil {
+		t.Fatalf("SaveSession: %v", err)
+	}
+	if sessions := reloadFileStore(t, path).GetAllSessions(); len(sessions) != 1 || !sessions[0].LastSeen.Equal(created) {
+		t.Errorf("session touch written at once: %+v", sessions)
+	}
+	if err := store.flush(); err != nil {
+		t.Fatalf("flush: %v", err)
+	}
+	if sessions := reloadFileStore(t, path).GetAllSessions(); len(sessions) != 1 || !sessions[0].LastSeen.Equal(session.LastSeen) {
+		t.Errorf("sessions after flush: %+v", sessions)
+	}
+
+	if err := store.DeleteSession(session.ID); err != nil {
+		t.Fatalf("DeleteSession: %v", err)
+	}
+	if sessions := reloadFileStore(t, path).GetAllSessions(); len(sessions) != 0 {
+		t.Errorf("deleted session still in the data file: %+v", sessions)
+	}
+}
+
+// TestFileStoreDropsUnhashedSessions checks that sessions written before
+// they were keyed by the hash of their cookie are not loaded
+func TestFileStoreDropsUnhashedSessions(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "fuzzy.data")
+	data := `{"sessions": [{"id": "raw-cookie", "user_id": 1}], "next_user_id": 1}`
+	if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+		t.Fatalf("writing the data file: %v", err)
+	}
+	if sessions := reloadFileStore(t, path).GetAllSessions(); len(sessions) != 0 {
+		t.Errorf("sessions loaded: %+v", sessions)
+	}
+	if sessions := reloadFileStore(t, path).GetAllSessions(); len(sessions) != 0 {
+		t.Errorf("sessions still in the data file: %+v", sessions)
+	}
+}
+
+// TestFileStoreDefersTokenUses checks that the last use of an API token
+// waits for the next change, flush or Close
+func TestFileStoreDefersTokenUses(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "fuzzy.data")
+	store, err := NewFileStore(path)
+	if err != nil {
+		t.Fatalf("NewFileStore: %v", err)
+	}
+	defer store.Close()
+	user, err := store.CreateUser(User{Username: "alice", Password: "hash", Role: RoleAdmin, Active: true})
+	if err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	token, err := store.CreateAPIToken(APIToken{UserID: user.ID, Name: "deploy", Hash: "token-hash"})
+	if err != nil {
+		t.Fatalf("CreateAPIToken: %v", err)
+	}
+	// lastUsed returns when the data file says the token was last used
+	lastUsed := func() time.Time {
+		t.Helper()
+		got, _ := reloadFileStore(t, path).GetAPIToken(token.ID)
+		return got.LastUsed
+	}
+
+	used := time.Now().Truncate(time.Second)
+	if err := store.TouchAPIToken(token.ID, used); err != nil {
+		t.Fatalf("TouchAPIToken: %v", err)
+	}
+	if got, _ := store.GetAPIToken(token.ID); !got.LastUsed.Equal(used) {
+		t.Errorf("token last used %v in the store, want %v", got.LastUsed, used)
+	}
+	if got := lastUsed(); !got.IsZero() {
+		t.Errorf("token use written at once")
+	}
+	if err := store.flush(); err != nil {
+		t.Fatalf("flush: %v", err)
+	}
+	if got := lastUsed(); !got.Equal(used) {
+		t.Errorf("token last used %v after flush, want %v", got, used)
+	}
+
+	// Any other change saves the pending ones with it, and Close the rest
+	if err := store.TouchAPIToken(token.ID, used.Add(time.Minute)); err != nil {
+		t.Fatalf("TouchAPIToken: %v", err)
+	}
+	if _, err := store.CreateProvider(Provider{Name: "BBC"}); err != nil {
+		t.Fatalf("CreateProvider: %v", err)
+	}
+	if got := lastUsed(); !got.Equal(used.Add(time.Minute)) {
+		t.Errorf("token use not saved with the next change")
+	}
+	if err := store.TouchAPIToken(token.ID, used.Add(2*time.Minute)); err != nil {
+		t.Fatalf("TouchAPIToken: %v", err)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if got := lastUsed(); !got.Equal(used.Add(2 * time.Minute)) {
+		t.Errorf("token use not saved by Close")
+	}
+}
+
+// TestFileStoreDefersWebhookDeliveries checks that the delivery log waits for
+// the next change, flush or Close
+func TestFileStoreDefersWebhookDeliveries(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "fuzzy.data")
+	store, err := NewFileStore(path)
+	if err != nil {
+		t.Fatalf("NewFileStore: %v", err)
+	}
+	defer store.Close()
+	webhook, err := store.CreateWebhook(Webhook{Name: "ops", URL: "https://example.com/hook", Secret: "secret", Active: true})
+	if err != nil {
+		t.Fatalf("CreateWebhook: %v", err)
+	}
+	// logged returns how many deliveries the data file holds
+	logged := func() int {
+		t.Helper()
+		return len(reloadFileStore(t, path).GetWebhookDeliveries(webhook.ID))
+	}
+	deliver := func() {
+		t.Helper()
+		if err := store.AddWebhookDelivery(WebhookDelivery{WebhookID: webhook.ID, Event: "channel.created", Attempt: 1, StatusCode: 200}); err != nil {
+			t.Fatalf("AddWebhookDelivery: %v", err)
+		}
+	}
+
+	deliver()
+	if got := len(store.GetWebhookDeliveries(webhook.ID)); got != 1 {
+		t.Errorf("%d deliveries in the store, want 1", got)
+	}
+	if got := logged(); got != 0 {
+		t.Errorf("webhook delivery written at once")
+	}
+	if err := store.flush(); err != nil {
+		t.Fatalf("flush: %v", err)
+	}
+	if got := logged(); got != 1 {
+		t.Errorf("%d deliveries after flush, want 1", got)
+	}
+
+	deliver()
+	if _, err := store.CreateProvider(Provider{Name: "BBC"}); err != nil {
+		t.Fatalf("CreateProvider: %v", err)
+	}
+	if got := logged(); got != 2 {
+		t.Errorf("%d deliveries after the next change, want 2", got)
+	}
+	deliver()
+	if err := store.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if got := logged(); got != 3 {
+		t.Errorf("%d deliveries after Close, want 3", got)
+	}
+}
+
+// TestFileStoreAdoptsEmbeddedChannels checks that the channel copies held by
+// the bouquets of older versions become references to the channel with the
+// same ID and name, or new channels, in their order
+func TestFileStoreAdoptsEmbeddedChannels(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "fuzzy.data")
+	data := `{
+		"channels": [{"id": 1, "name": "BBC One", "manifest": "https://example.com/one.mpd", "key_kid": "key:kid"}],
+		"providers": [{"id": 1, "name": "BBC"}],
+		"bouquets": [
+			{"id": 1, "name": "Basics", "provider_id": 1, "channels": [
+				{"id": 5, "name": "Bouquet only"},
+				{"id": 1, "name": "BBC One"},
+				{"id": 1, "name": "Another copy"}
+			]},
+			{"id": 2, "name": "Sport", "provider_id": 1, "channels": [{"id": 1, "name": "BBC One"}]}
+		],
+		"next_bouquet_id": 3,
+		"next_channel_id": 2,
+		"next_provider_id": 2
+	}`
+	if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+		t.Fatalf("writing data file: %v", err)
+	}
+
+	for _, step := range []string{"loaded", "reloaded"} {
+		store := reloadFileStore(t, path)
+		basics, _ := store.GetBouquet(1)
+		if !slices.Equal(basics.ChannelIDs, []int{2, 1, 3}) {
+			t.Errorf("%s: bouquet channels %v, want [2 1 3]", step, basics.ChannelIDs)
+		}
+		if sport, _ := store.GetBouquet(2); !slices.Equal(sport.ChannelIDs, []int{1}) {
+			t.Errorf("%s: shared channel adopted as %v, want [1]", step, sport.ChannelIDs)
+		}
+		for id, name := range map[int]string{1: "BBC One", 2: "Bouquet only", 3: "Another copy"} {
+			if channel, _ := store.GetChannel(id); channel.Name != name {
+				t.Errorf("%s: channel %d named %q, want %q", step, id, channel.Name, name)
+			}
+		}
+		store.Close()
+	}
+	created, err := reloadFileStore(t, path).CreateChannel(Channel{Name: "BBC Two", Manifest: "https://example.com/two.mpd", KeyKid: "key:kid"})
+	if err != nil || created.ID != 4 {
+		t.Errorf("next channel %d, %v; want 4", created.ID, err)
+	}
+}
@@ -0,0 +1,29 @@
+package models
+
+import "time"
+
+// EncodingProfile is a named set of encoding settings shared by channels, so
+// a change to the profile applies to every channel using it
+type EncodingProfile struct {
+	ID          int    `json:"id"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	MediaSettings
+	UsedBy    []int     `json:"used_by"` // IDs of the channels using the profile, resolved on read
+	Version   int       `json:"version"` // Incremented on every write, used to detect concurrent edits
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// ResolveSettings returns the settings a channel is encoded with: its own,
+// with those it leaves unset taken from its profile
+func ResolveSettings(repo Repository, channel Channel) MediaSettings {
+	if channel.ProfileID == 0 {
+		return channel.MediaSettings
+	}
+	profile, exists := repo.GetProfile(channel.ProfileID)
+	if !exists {
+		return channel.MediaSettings
+	}
+	return channel.MediaSettings.Over(profile.MediaSettings)
+}
@@ -0,0 +1,77 @@
+package models
+
+import (
+	"errors"
+	"slices"
+	"testing"
+)
+
+// TestResolveSettings checks that a channel with a profile is encoded with its
+// own settings where set, and those of its profile elsewhere
+func TestResolveSettings(t *testing.T) {
+	store := NewMemoryStore()
+	profile, err := store.CreateProfile(EncodingProfile{Name: "HD", MediaSettings: MediaSettings{
+		VideoCodec: VideoCodecH265, AudioCodec: AudioCodecAAC, Resolution: Resolution1080p, VideoBitrate: 8000 * Kbps, AudioBitrate: 192 * Kbps,
+	}})
+	if err != nil {
+		t.Fatalf("CreateProfile: %v", err)
+	}
+
+	own := MediaSettings{VideoCodec: VideoCodecH264, AudioCodec: AudioCodecAC3, Resolution: Resolution720p, VideoBitrate: 3000 * Kbps, AudioBitrate: 128 * Kbps}
+	tests := []struct {
+		name    string
+		channel Channel
+		want    MediaSettings
+	}{
+		{"without profile", Channel{MediaSettings: own}, own},
+		{"profile only", Channel{ProfileID: profile.ID}, profile.MediaSettings},
+		{"overrides", Channel{ProfileID: profile.ID, MediaSettings: MediaSettings{Resolution: Resolution720p, AudioBitrate: 96 * Kbps}},
+			MediaSettings{VideoCodec: VideoCodecH265, AudioCodec: AudioCodecAAC, Resolution: Resolution720p, VideoBitrate: 8000 * Kbps, AudioBitrate: 96 * Kbps}},
+		{"every setting overridden", Channel{ProfileID: profile.ID, MediaSettings: own}, own},
+		{"missing profile", Channel{ProfileID: profile.ID + 1, MediaSettings: MediaSettings{VideoCodec: VideoCodecVP9}}, MediaSettings{VideoCodec: VideoCodecVP9}},
+	}
+	for _, test := range tests {
+		if got := ResolveSettings(store, test.channel); got != test.want {
+			t.Errorf("%s: %+v, want %+v", test.name, got, test.want)
+		}
+	}
+}
+
+// TestProfileReferences checks that channels cannot use a missing profile,
+// and that a profile cannot be deleted while channels use it
+func TestProfileReferences(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, store Store) {
+		profile, err := store.CreateProfile(EncodingProfile{Name: "HD", MediaSettings: MediaSettings{VideoCodec: VideoCodecH265}})
+		if err != nil {
+			t.Fatalf("CreateProfile: %v", err)
+		}
+		channel, err := store.CreateChannel(Channel{Name: "BBC One", Manifest: "https://example.com/one.mpd", KeyKid: "key:kid", ProfileID: profile.ID, State: ChannelStopped})
+		if err != nil {
+			t.Fatalf("CreateChannel: %v", err)
+		}
+		var refErr *ReferenceError
+		if _, err := store.CreateChannel(Channel{Name: "BBC Two", Manifest: "https://example.com/two.mpd", KeyKid: "key:kid", ProfileID: 99, State: ChannelStopped}); !errors.As(err, &refErr) || refErr.Entity != "profile" {
+			t.Errorf("channel with a missing profile: %v, want a *ReferenceError", err)
+		}
+		if stored, _ := store.GetProfile(profile.ID); !slices.Equal(stored.UsedBy, []int{channel.ID}) {
+			t.Errorf("profile used by %v, want [%d]", stored.UsedBy, channel.ID)
+		}
+
+		var inUse *InUseError
+		if err := store.DeleteProfile(profile.ID); !errors.As(err, &inUse) || inUse.By != "channel" || inUse.Count != 1 {
+			t.Fatalf("deleting a profile in use: %v, want a *InUseError", err)
+		}
+		if _, exists := store.GetProfile(profile.ID); !exists {
+			t.Fatalf("profile in use deleted")
+		}
+
+		channel, _ = store.GetChannel(channel.ID)
+		channel.ProfileID = 0
+		if err := store.UpdateChannel(channel); err != nil {
+			t.Fatalf("UpdateChannel: %v", err)
+		}
+		if err := store.DeleteProfile(profile.ID); err != nil {
+			t.Errorf("deleting an unused profile: %v", err)
+		}
+	})
+}
@@ -0,0 +1,74 @@
+package models
+
+import "slices"
+
+// Roles a user can have, from most to least privileged
+const (
+	RoleAdmin    = "admin"    // Everything, including user management
+	RoleOperator = "operator" // Manages providers, bouquets and channels
+	RoleViewer   = "viewer"   // Read-only access
+)
+
+// Roles lists every valid role, from most to least privileged
+var Roles = []string{RoleAdmin, RoleOperator, RoleViewer}
+
+// Permission names an action that a role may be allowed to perform
+type Permission string
+
+const (
+	PermChannelsView    Permission = "channels:view"
+	PermChannelsEdit    Permission = "channels:edit"    // Create, update and delete channels
+	PermChannelsControl Permission = "channels:control" // Start and stop channels
+	PermProvidersView   Permission = "providers:view"
+	PermProvidersEdit   Permission = "providers:edit" // Providers and their bouquets
+	PermUsersManage     Permission = "users:manage"
+	PermWebhooksManage  Permission = "webhooks:manage" // Webhooks and their delivery logs
+)
+
+// rolePermissions grants permissions to each role
+var rolePermissions = map[string][]Permission{
+	RoleAdmin: {
+		PermChannelsView, PermChannelsEdit, PermChannelsControl,
+		PermProvidersView, PermProvidersEdit,
+		PermUsersManage, PermWebhooksManage,
+	},
+	RoleOperator: {
+		PermChannelsView, PermChannelsEdit, PermChannelsControl,
+		PermProvidersView, PermProvidersEdit,
+	},
+	RoleViewer: {
+		PermChannelsView,
+		PermProvidersView,
+	},
+}
+
+// NormalizeRole maps the role names used by older versions ("Administrator"
+// and "User") to the current roles. Other values are returned unchanged.
+func NormalizeRole(role string) string {
+	switch role {
+	case "Administrator":
+		return RoleAdmin
+	case "User":
+		return RoleOperator
+	}
+	return role
+}
+
+// ValidRole reports whether role is one of Roles
+func ValidRole(role string) bool {
+	return slices.Contains(Roles, role)
+}
+
+// Permissions returns the permissions granted to the user's role
+func (u User) Permissions() []Permission {
+	return slices.Clone(rolePermissions[NormalizeRole(u.Role)])
+}
+
+// Can reports whether the user's role grants the permission. Inactive users
+// and unknown roles have no permissions.
+func (u User) Can(permission Permission) bool {
+	if !u.Active {
+		return false
+	}
+	return slices.Contains(rolePermissions[NormalizeRole(u.Role)], permission)
+}
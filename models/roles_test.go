@@ -0,0 +1,57 @@
+package models
+
+import (
+	"slices"
+	"testing"
+)
+
+// TestUserCan checks the permissions granted to each role, including the
+// role names of older versions
+func TestUserCan(t *testing.T) {
+	all := []Permission{
+		PermChannelsView, PermChannelsEdit, PermChannelsControl,
+		PermProvidersView, PermProvidersEdit,
+		PermUsersManage, PermWebhooksManage,
+	}
+	tests := []struct {
+		role    string
+		active  bool
+		granted []Permission
+	}{
+		{RoleAdmin, true, all},
+		{"Administrator", true, all},
+		{RoleOperator, true, []Permission{PermChannelsView, PermChannelsEdit, PermChannelsControl, PermProvidersView, PermProvidersEdit}},
+		{"User", true, []Permission{PermChannelsView, PermChannelsEdit, PermChannelsControl, PermProvidersView, PermProvidersEdit}},
+		{RoleViewer, true, []Permission{PermChannelsView, PermProvidersView}},
+		{RoleAdmin, false, nil},
+		{"root", true, nil},
+	}
+	for _, test := range tests {
+		user := User{Username: "alice", Role: test.role, Active: test.active}
+		for _, permission := range all {
+			if got, want := user.Can(permission), slices.Contains(test.granted, permission); got != want {
+				t.Errorf("role %q, active %v: Can(%s) = %v, want %v", test.role, test.active, permission, got, want)
+			}
+		}
+	}
+}
+
+// TestNormalizeRole checks that the roles of older versions map to the
+// current ones and that only current roles are valid
+func TestNormalizeRole(t *testing.T) {
+	tests := []struct {
+		role, normalized string
+		valid            bool
+	}{
+		{"Administrator", RoleAdmin, true},
+		{"User", RoleOperator, true},
+		{RoleViewer, RoleViewer, true},
+		{"root", "root", false},
+	}
+	for _, test := range tests {
+		normalized := NormalizeRole(test.role)
+		if normalized != test.normalized || ValidRole(normalized) != test.valid {
+			t.Errorf("%q: normalized to %q, valid %v; want %q, %v", test.role, normalized, ValidRole(normalized), test.normalized, test.valid)
+		}
+	}
+}
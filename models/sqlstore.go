@@ -0,0 +1,870 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	_ "modernc.org/sqlite" // Pure-Go SQLite driver
+)
+
+// SQLStore provides SQLite-backed storage for bouquets, users, channels, and providers
+type SQLStore struct {
+	autoTx
+	eventBus
+	db      *sql.DB
+	options Options
+}
+
+// sqlTx runs operations on a database transaction, or directly on the
+// database when a read transaction cannot be started
+type sqlTx struct {
+	q       querier
+	options Options
+}
+
+// querier is implemented by *sql.DB and *sql.Tx
+type querier interface {
+	Exec(query string, args ...any) (sql.Result, error)
+	Query(query string, args ...any) (*sql.Rows, error)
+	QueryRow(query string, args ...any) *sql.Row
+}
+
+var _ Store = (*SQLStore)(nil)
+
+// rowScanner is implemented by *sql.Row and *sql.Rows
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+const (
+	userColumns     = `id, username, email, password, first_name, last_name, role, active, totp_secret, totp_enabled, recovery_codes, totp_last_step, version, created_at, updated_at`
+	providerColumns = `id, name, description, url, api_key, active, version, created_at, updated_at`
+	channelColumns  = `id, name, manifest, key_kid, profile_id, video_codec, audio_codec, width, height, video_bitrate_bps, audio_bitrate_bps, quality, state, running, remux_port, pid, last_error, started_at, stopped_at, version, created_at, updated_at`
+	bouquetColumns  = `id, name, description, provider_id, version, created_at, updated_at`
+	profileColumns  = `id, name, description, video_codec, audio_codec, width, height, video_bitrate_bps, audio_bitrate_bps, version, created_at, updated_at`
+	sessionColumns  = `id, user_id, client_ip, user_agent, created_at, last_seen`
+	apiTokenColumns = `id, user_id, name, token_hash, prefix, scopes, expires_at, last_used, created_at`
+	webhookColumns  = `id, name, url, secret, events, active, version, created_at, updated_at`
+	deliveryColumns = `id, webhook_id, delivery_id, event, attempt, status_code, error, duration_ms, created_at`
+)
+
+// NewSQLStore opens the SQLite database at path and applies pending migrations
+func NewSQLStore(path string) (*SQLStore, error) {
+	dsn := fmt.Sprintf("file:%s?_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)", path)
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %v", err)
+	}
+
+	// SQLite allows a single writer; one connection avoids SQLITE_BUSY between our own queries
+	db.SetMaxOpenConns(1)
+
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to connect to database: %v", err)
+	}
+	if err := migrate(db); err != nil {
+		db.Close()
+		return nil, err
+	}
+	store := &SQLStore{db: db}
+	store.autoTx = autoTx{view: store.view, update: store.Update}
+	return store, nil
+}
+
+// Update runs fn in a database transaction, committed when fn returns nil
+func (s *SQLStore) Update(fn func(tx Tx) error) error {
+	return s.update(Actor{}, fn)
+}
+
+// As returns the store with the changes made through it attributed to actor
+func (s *SQLStore) As(actor Actor) Store {
+	return newActorStore(s, actor)
+}
+
+// update is Update with the events of the changes attributed to actor
+func (s *SQLStore) update(actor Actor, fn func(tx Tx) error) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback() // No-op once committed
+
+	recording := &recordingTx{Tx: &sqlTx{q: tx, options: s.options}, actor: actor}
+	if err := fn(recording); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	s.publish(recording.events)
+	return nil
+}
+
+// view runs read-only operations in a read-only transaction, so that reads
+// spanning several queries see a single snapshot of the database
+func (s *SQLStore) view(fn func(tx Tx)) {
+	tx, err := s.db.BeginTx(context.Background(), &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		log.Printf("Error beginning read transaction: %v", err)
+		fn(&sqlTx{q: s.db, options: s.options})
+		return
+	}
+	defer tx.Rollback() // Nothing to commit
+	fn(&sqlTx{q: tx, options: s.options})
+}
+
+// Close closes the underlying database
+func (s *SQLStore) Close() error {
+	return s.db.Close()
+}
+
+func scanUser(row rowScanner) (User, error) {
+	var u User
+	var recoveryCodes string
+	err := row.Scan(&u.ID, &u.Username, &u.Email, &u.Password, &u.FirstName, &u.LastName, &u.Role, &u.Active,
+		&u.TOTPSecret, &u.TOTPEnabled, &recoveryCodes, &u.TOTPLastStep, &u.Version, &u.CreatedAt, &u.UpdatedAt)
+	if recoveryCodes != "" {
+		u.RecoveryCodes = strings.Split(recoveryCodes, "\n")
+	}
+	return u, err
+}
+
+func scanProvider(row rowScanner) (Provider, error) {
+	var p Provider
+	err := row.Scan(&p.ID, &p.Name, &p.Description, &p.URL, &p.APIKey, &p.Active, &p.Version, &p.CreatedAt, &p.UpdatedAt)
+	return p, err
+}
+
+func scanChannel(row rowScanner) (Channel, error) {
+	var c Channel
+	var startedAt, stoppedAt sql.NullTime
+	err := row.Scan(&c.ID, &c.Name, &c.Manifest, &c.KeyKid, &c.ProfileID, &c.VideoCodec, &c.AudioCodec, &c.Resolution.Width, &c.Resolution.Height,
+		&c.VideoBitrate, &c.AudioBitrate, &c.Quality, &c.State, &c.Running, &c.RemuxPort, &c.PID, &c.LastError, &startedAt, &stoppedAt,
+		&c.Version, &c.CreatedAt, &c.UpdatedAt)
+	c.StartedAt = startedAt.Time
+	c.StoppedAt = stoppedAt.Time
+	return c, err
+}
+
+func scanProfile(row rowScanner) (EncodingProfile, error) {
+	var p EncodingProfile
+	err := row.Scan(&p.ID, &p.Name, &p.Description, &p.VideoCodec, &p.AudioCodec, &p.Resolution.Width, &p.Resolution.Height,
+		&p.VideoBitrate, &p.AudioBitrate, &p.Version, &p.CreatedAt, &p.UpdatedAt)
+	return p, err
+}
+
+func scanBouquet(row rowScanner) (Bouquet, error) {
+	var b Bouquet
+	err := row.Scan(&b.ID, &b.Name, &b.Description, &b.ProviderID, &b.Version, &b.CreatedAt, &b.UpdatedAt)
+	return b, err
+}
+
+func scanSession(row rowScanner) (Session, error) {
+	var s Session
+	err := row.Scan(&s.ID, &s.UserID, &s.ClientIP, &s.UserAgent, &s.CreatedAt, &s.LastSeen)
+	return s, err
+}
+
+func scanAPIToken(row rowScanner) (APIToken, error) {
+	var t APIToken
+	var scopes string
+	var expiresAt, lastUsed sql.NullTime
+	err := row.Scan(&t.ID, &t.UserID, &t.Name, &t.Hash, &t.Prefix, &scopes, &expiresAt, &lastUsed, &t.CreatedAt)
+	for _, scope := range strings.Fields(scopes) {
+		t.Scopes = append(t.Scopes, Permission(scope))
+	}
+	t.ExpiresAt = expiresAt.Time
+	t.LastUsed = lastUsed.Time
+	return t, err
+}
+
+func scanWebhook(row rowScanner) (Webhook, error) {
+	var w Webhook
+	var events string
+	err := row.Scan(&w.ID, &w.Name, &w.URL, &w.Secret, &events, &w.Active, &w.Version, &w.CreatedAt, &w.UpdatedAt)
+	w.Events = strings.Fields(events)
+	return w, err
+}
+
+func scanDelivery(row rowScanner) (WebhookDelivery, error) {
+	var d WebhookDelivery
+	var durationMS int64
+	err := row.Scan(&d.ID, &d.WebhookID, &d.DeliveryID, &d.Event, &d.Attempt, &d.StatusCode, &d.Error, &durationMS, &d.CreatedAt)
+	d.Duration = time.Duration(durationMS) * time.Millisecond
+	return d, err
+}
+
+// nullTime stores the zero time as NULL
+func nullTime(t time.Time) sql.NullTime {
+	return sql.NullTime{Time: t, Valid: !t.IsZero()}
+}
+
+func scanInt(row rowScanner) (int, error) {
+	var n int
+	err := row.Scan(&n)
+	return n, err
+}
+
+// queryList runs query and collects every row with scan. Errors are logged and yield an empty list.
+func queryList[T any](q querier, scan func(rowScanner) (T, error), query string, args ...any) []T {
+	rows, err := q.Query(query, args...)
+	if err != nil {
+		log.Printf("Error querying database: %v", err)
+		return nil
+	}
+	defer rows.Close()
+
+	var items []T
+	for rows.Next() {
+		item, err := scan(rows)
+		if err != nil {
+			log.Printf("Error reading database row: %v", err)
+			return nil
+		}
+		items = append(items, item)
+	}
+	if err := rows.Err(); err != nil {
+		log.Printf("Error reading database rows: %v", err)
+		return nil
+	}
+	return items
+}
+
+// queryOne runs query and scans a single row. A missing row reports false; other errors are logged.
+func queryOne[T any](q querier, scan func(rowScanner) (T, error), query string, args ...any) (T, bool) {
+	item, err := scan(q.QueryRow(query, args...))
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			log.Printf("Error querying database: %v", err)
+		}
+		var zero T
+		return zero, false
+	}
+	return item, true
+}
+
+// execAffecting runs a statement and returns ErrNotFound when no row was affected
+func execAffecting(q querier, query string, args ...any) error {
+	result, err := q.Exec(query, args...)
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// execVersioned runs an update guarded by "WHERE id = ? AND version = ?" and,
+// when no row matched, tells a missing entity apart from a stale version
+func (t *sqlTx) execVersioned(table, entity string, id int, query string, args ...any) error {
+	err := execAffecting(t.q, query, args...)
+	if !errors.Is(err, ErrNotFound) {
+		return err
+	}
+	version, exists := queryOne(t.q, scanInt, `SELECT version FROM `+table+` WHERE id = ?`, id)
+	if !exists {
+		return ErrNotFound
+	}
+	return &ConflictError{Entity: entity, ID: id, Version: version}
+}
+
+// Bouquet operations
+
+// withChannels resolves the channel IDs and channels of each bouquet from the membership table
+func (t *sqlTx) withChannels(bouquets []Bouquet) []Bouquet {
+	for i := range bouquets {
+		bouquets[i].ChannelIDs = queryList(t.q, scanInt,
+			`SELECT channel_id FROM bouquet_channels WHERE bouquet_id = ? ORDER BY position`, bouquets[i].ID)
+		bouquets[i].Channels = queryList(t.q, scanChannel,
+			`SELECT `+channelColumns+` FROM channels JOIN bouquet_channels ON channel_id = id
+WHERE bouquet_id = ? ORDER BY position`, bouquets[i].ID)
+	}
+	return bouquets
+}
+
+func (t *sqlTx) GetAllBouquets() []Bouquet {
+	return t.withChannels(queryList(t.q, scanBouquet, `SELECT `+bouquetColumns+` FROM bouquets ORDER BY id`))
+}
+
+func (t *sqlTx) GetBouquet(id int) (Bouquet, bool) {
+	bouquet, exists := queryOne(t.q, scanBouquet, `SELECT `+bouquetColumns+` FROM bouquets WHERE id = ?`, id)
+	if !exists {
+		return Bouquet{}, false
+	}
+	return t.withChannels([]Bouquet{bouquet})[0], true
+}
+
+func (t *sqlTx) CreateBouquet(bouquet Bouquet) (Bouquet, error) {
+	if err := t.checkBouquetReferences(bouquet); err != nil {
+		return Bouquet{}, err
+	}
+
+	now := time.Now()
+	bouquet.CreatedAt = now
+	bouquet.UpdatedAt = now
+
+	result, err := t.q.Exec(`INSERT INTO bouquets (name, description, provider_id, created_at, updated_at) VALUES (?, ?, ?, ?, ?)`,
+		bouquet.Name, bouquet.Description, bouquet.ProviderID, bouquet.CreatedAt, bouquet.UpdatedAt)
+	if err != nil {
+		return Bouquet{}, err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return Bouquet{}, err
+	}
+	bouquet.ID = int(id)
+	bouquet.Version = 1 // Column default
+
+	if err := t.setBouquetChannels(bouquet.ID, bouquet.ChannelIDs); err != nil {
+		return Bouquet{}, err
+	}
+	return t.withChannels([]Bouquet{bouquet})[0], nil
+}
+
+func (t *sqlTx) UpdateBouquet(bouquet Bouquet) error {
+	if _, exists := t.GetBouquet(bouquet.ID); !exists {
+		return ErrNotFound
+	}
+	if err := t.checkBouquetReferences(bouquet); err != nil {
+		return err
+	}
+
+	bouquet.UpdatedAt = time.Now()
+	err := t.execVersioned("bouquets", "bouquet", bouquet.ID, `UPDATE bouquets SET name = ?, description = ?, provider_id = ?, version = version + 1, updated_at = ?
+WHERE id = ? AND version = ?`,
+		bouquet.Name, bouquet.Description, bouquet.ProviderID, bouquet.UpdatedAt, bouquet.ID, bouquet.Version)
+	if err != nil {
+		return err
+	}
+	return t.setBouquetChannels(bouquet.ID, bouquet.ChannelIDs)
+}
+
+// checkBouquetReferences verifies that the provider and channels of a bouquet exist
+func (t *sqlTx) checkBouquetReferences(bouquet Bouquet) error {
+	if _, exists := t.GetProvider(bouquet.ProviderID); !exists {
+		return &ReferenceError{Entity: "provider", ID: bouquet.ProviderID}
+	}
+	for _, channelID := range bouquet.ChannelIDs {
+		if _, exists := t.GetChannel(channelID); !exists {
+			return &ReferenceError{Entity: "channel", ID: channelID}
+		}
+	}
+	return nil
+}
+
+// setBouquetChannels replaces the membership of a bouquet, keeping the slice order
+func (t *sqlTx) setBouquetChannels(bouquetID int, channelIDs []int) error {
+	if _, err := t.q.Exec(`DELETE FROM bouquet_channels WHERE bouquet_id = ?`, bouquetID); err != nil {
+		return err
+	}
+	for position, channelID := range channelIDs {
+		if _, err := t.q.Exec(`INSERT OR IGNORE INTO bouquet_channels (bouquet_id, channel_id, position) VALUES (?, ?, ?)`,
+			bouquetID, channelID, position); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// AddChannelToBouquet appends a channel to the end of a bouquet
+func (t *sqlTx) AddChannelToBouquet(bouquetID, channelID int) error {
+	if _, exists := t.GetBouquet(bouquetID); !exists {
+		return ErrNotFound
+	}
+	if _, exists := t.GetChannel(channelID); !exists {
+		return &ReferenceError{Entity: "channel", ID: channelID}
+	}
+	err := execAffecting(t.q, `INSERT OR IGNORE INTO bouquet_channels (bouquet_id, channel_id, position)
+SELECT ?, ?, COALESCE(MAX(position), -1) + 1 FROM bouquet_channels WHERE bouquet_id = ?`,
+		bouquetID, channelID, bouquetID)
+	if errors.Is(err, ErrNotFound) {
+		return nil // Already a member
+	}
+	if err != nil {
+		return err
+	}
+	return t.touchBouquet(bouquetID)
+}
+
+// RemoveChannelFromBouquet removes a channel from a bouquet, keeping the order of the others
+func (t *sqlTx) RemoveChannelFromBouquet(bouquetID, channelID int) error {
+	if err := execAffecting(t.q, `DELETE FROM bouquet_channels WHERE bouquet_id = ? AND channel_id = ?`, bouquetID, channelID); err != nil {
+		return err
+	}
+	return t.touchBouquet(bouquetID)
+}
+
+// touchBouquet records a change to the membership of a bouquet
+func (t *sqlTx) touchBouquet(bouquetID int) error {
+	_, err := t.q.Exec(`UPDATE bouquets SET version = version + 1, updated_at = ? WHERE id = ?`, time.Now(), bouquetID)
+	return err
+}
+
+func (t *sqlTx) DeleteBouquet(id int) error {
+	return execAffecting(t.q, `DELETE FROM bouquets WHERE id = ?`, id)
+}
+
+// GetBouquetsByProvider returns all bouquets for a specific provider
+func (t *sqlTx) GetBouquetsByProvider(providerID int) []Bouquet {
+	return t.withChannels(queryList(t.q, scanBouquet,
+		`SELECT `+bouquetColumns+` FROM bouquets WHERE provider_id = ? ORDER BY id`, providerID))
+}
+
+// GetProvidersWithBouquets returns all providers with their associated bouquets
+func (t *sqlTx) GetProvidersWithBouquets() []ProviderWithBouquets {
+	var providersWithBouquets []ProviderWithBouquets
+	for _, provider := range t.GetAllProviders() {
+		providersWithBouquets = append(providersWithBouquets, ProviderWithBouquets{
+			Provider: provider,
+			Bouquets: t.GetBouquetsByProvider(provider.ID),
+		})
+	}
+	return providersWithBouquets
+}
+
+// User operations
+func (t *sqlTx) GetAllUsers() []User {
+	return queryList(t.q, scanUser, `SELECT `+userColumns+` FROM users ORDER BY id`)
+}
+
+func (t *sqlTx) GetUser(id int) (User, bool) {
+	return queryOne(t.q, scanUser, `SELECT `+userColumns+` FROM users WHERE id = ?`, id)
+}
+
+// HasUsers returns true if any users exist in the store
+func (t *sqlTx) HasUsers() bool {
+	var exists bool
+	if err := t.q.QueryRow(`SELECT EXISTS (SELECT 1 FROM users)`).Scan(&exists); err != nil {
+		log.Printf("Error querying database: %v", err)
+		return false
+	}
+	return exists
+}
+
+// GetUserByUsername returns a user by username
+func (t *sqlTx) GetUserByUsername(username string) (User, bool) {
+	return queryOne(t.q, scanUser, `SELECT `+userColumns+` FROM users WHERE username = ?`, username)
+}
+
+func (t *sqlTx) CreateUser(user User) (User, error) {
+	now := time.Now()
+	user.CreatedAt = now
+	user.UpdatedAt = now
+
+	result, err := t.q.Exec(`INSERT INTO users (username, email, password, first_name, last_name, role, active, totp_secret, totp_enabled, recovery_codes, created_at, updated_at)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		user.Username, user.Email, user.Password, user.FirstName, user.LastName, user.Role, user.Active,
+		user.TOTPSecret, user.TOTPEnabled, strings.Join(user.RecoveryCodes, "\n"), user.CreatedAt, user.UpdatedAt)
+	if err != nil {
+		return User{}, err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return User{}, err
+	}
+	user.ID = int(id)
+	user.Version = 1 // Column default
+	return user, nil
+}
+
+func (t *sqlTx) UpdateUser(user User) error {
+	user.UpdatedAt = time.Now()
+	return t.execVersioned("users", "user", user.ID, `UPDATE users SET username = ?, email = ?, password = ?, first_name = ?, last_name = ?, role = ?, active = ?,
+totp_secret = ?, totp_enabled = ?, recovery_codes = ?, version = version + 1, updated_at = ? WHERE id = ? AND version = ?`,
+		user.Username, user.Email, user.Password, user.FirstName, user.LastName, user.Role, user.Active,
+		user.TOTPSecret, user.TOTPEnabled, strings.Join(user.RecoveryCodes, "\n"), user.UpdatedAt, user.ID, user.Version)
+}
+
+// UseTOTPStep records that the user signed in with the code of a TOTP time step
+func (t *sqlTx) UseTOTPStep(userID int, step uint64) error {
+	var last uint64
+	if err := t.q.QueryRow(`SELECT totp_last_step FROM users WHERE id = ?`, userID).Scan(&last); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrNotFound
+		}
+		return err
+	}
+	if step <= last {
+		return ErrTOTPStepUsed
+	}
+	return execAffecting(t.q, `UPDATE users SET totp_last_step = ? WHERE id = ?`, step, userID)
+}
+
+func (t *sqlTx) DeleteUser(id int) error {
+	return execAffecting(t.q, `DELETE FROM users WHERE id = ?`, id)
+}
+
+// Channel operations
+func (t *sqlTx) GetAllChannels() []Channel {
+	return queryList(t.q, scanChannel, `SELECT `+channelColumns+` FROM channels ORDER BY id`)
+}
+
+func (t *sqlTx) GetChannel(id int) (Channel, bool) {
+	return queryOne(t.q, scanChannel, `SELECT `+channelColumns+` FROM channels WHERE id = ?`, id)
+}
+
+func (t *sqlTx) CreateChannel(channel Channel) (Channel, error) {
+	if err := t.checkProfile(channel.ProfileID); err != nil {
+		return Channel{}, err
+	}
+
+	now := time.Now()
+	channel.State = ChannelStopped
+	channel.Running = false
+	channel.CreatedAt = now
+	channel.UpdatedAt = now
+
+	result, err := t.q.Exec(`INSERT INTO channels (name, manifest, key_kid, profile_id, video_codec, audio_codec, width, height, video_bitrate_bps, audio_bitrate_bps, quality, state, running, remux_port, created_at, updated_at)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		channel.Name, channel.Manifest, channel.KeyKid, channel.ProfileID, channel.VideoCodec, channel.AudioCodec, channel.Resolution.Width, channel.Resolution.Height,
+		channel.VideoBitrate, channel.AudioBitrate, channel.Quality, channel.State, channel.Running, channel.RemuxPort, channel.CreatedAt, channel.UpdatedAt)
+	if err != nil {
+		return Channel{}, err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return Channel{}, err
+	}
+	channel.ID = int(id)
+	channel.Version = 1 // Column default
+	return channel, nil
+}
+
+func (t *sqlTx) UpdateChannel(channel Channel) error {
+	if _, exists := t.GetChannel(channel.ID); !exists {
+		return ErrNotFound
+	}
+	if err := t.checkProfile(channel.ProfileID); err != nil {
+		return err
+	}
+
+	channel.UpdatedAt = time.Now()
+	return t.execVersioned("channels", "channel", channel.ID, `UPDATE channels SET name = ?, manifest = ?, key_kid = ?, profile_id = ?, video_codec = ?, audio_codec = ?, width = ?, height = ?,
+video_bitrate_bps = ?, audio_bitrate_bps = ?, quality = ?, version = version + 1, updated_at = ? WHERE id = ? AND version = ?`,
+		channel.Name, channel.Manifest, channel.KeyKid, channel.ProfileID, channel.VideoCodec, channel.AudioCodec, channel.Resolution.Width, channel.Resolution.Height,
+		channel.VideoBitrate, channel.AudioBitrate, channel.Quality, channel.UpdatedAt, channel.ID, channel.Version)
+}
+
+func (t *sqlTx) DeleteChannel(id int) error {
+	// The membership rows go with the channel; record the change on the bouquets first
+	if _, err := t.q.Exec(`UPDATE bouquets SET version = version + 1, updated_at = ?
+WHERE id IN (SELECT bouquet_id FROM bouquet_channels WHERE channel_id = ?)`, time.Now(), id); err != nil {
+		return err
+	}
+	return execAffecting(t.q, `DELETE FROM channels WHERE id = ?`, id)
+}
+
+// StartChannel moves a channel to the starting state on the given remux port
+func (t *sqlTx) StartChannel(channelID, port int) error {
+	channel, exists := t.GetChannel(channelID)
+	if !exists {
+		return ErrNotFound
+	}
+	if err := CheckTransition(channel, ChannelStarting); err != nil {
+		return err
+	}
+	var otherID int
+	err := t.q.QueryRow(`SELECT id FROM channels WHERE running = 1 AND remux_port = ? AND id <> ?`, port, channelID).Scan(&otherID)
+	if err == nil {
+		return &PortInUseError{Port: port, ChannelID: otherID}
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+	return execAffecting(t.q, `UPDATE channels SET state = ?, running = 1, remux_port = ?, pid = 0, last_error = '', started_at = ? WHERE id = ?`,
+		ChannelStarting, port, time.Now(), channelID)
+}
+
+// SetChannelState moves a started channel to state, recording its process
+// and why the previous one failed
+func (t *sqlTx) SetChannelState(channelID int, state ChannelState, pid int, lastError string) error {
+	channel, exists := t.GetChannel(channelID)
+	if !exists {
+		return ErrNotFound
+	}
+	if state == ChannelStarting {
+		return errStartThroughStartChannel
+	}
+	if err := CheckTransition(channel, state); err != nil {
+		return err
+	}
+
+	stoppedAt := channel.StoppedAt
+	if channel.State.Active() && !state.Active() {
+		stoppedAt = time.Now()
+	}
+	if lastError == "" {
+		lastError = channel.LastError
+	}
+	return execAffecting(t.q, `UPDATE channels SET state = ?, running = ?, pid = ?, last_error = ?, stopped_at = ? WHERE id = ?`,
+		state, state.Active(), pid, lastError, nullTime(stoppedAt), channelID)
+}
+
+// Provider operations
+func (t *sqlTx) GetAllProviders() []Provider {
+	return queryList(t.q, scanProvider, `SELECT `+providerColumns+` FROM providers ORDER BY id`)
+}
+
+func (t *sqlTx) GetProvider(id int) (Provider, bool) {
+	return queryOne(t.q, scanProvider, `SELECT `+providerColumns+` FROM providers WHERE id = ?`, id)
+}
+
+func (t *sqlTx) CreateProvider(provider Provider) (Provider, error) {
+	now := time.Now()
+	provider.CreatedAt = now
+	provider.UpdatedAt = now
+
+	result, err := t.q.Exec(`INSERT INTO providers (name, description, url, api_key, active, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		provider.Name, provider.Description, provider.URL, provider.APIKey, provider.Active, provider.CreatedAt, provider.UpdatedAt)
+	if err != nil {
+		return Provider{}, err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return Provider{}, err
+	}
+	provider.ID = int(id)
+	provider.Version = 1 // Column default
+	return provider, nil
+}
+
+func (t *sqlTx) UpdateProvider(provider Provider) error {
+	provider.UpdatedAt = time.Now()
+	return t.execVersioned("providers", "provider", provider.ID, `UPDATE providers SET name = ?, description = ?, url = ?, api_key = ?, active = ?,
+version = version + 1, updated_at = ? WHERE id = ? AND version = ?`,
+		provider.Name, provider.Description, provider.URL, provider.APIKey, provider.Active, provider.UpdatedAt, provider.ID, provider.Version)
+}
+
+func (t *sqlTx) DeleteProvider(id int) error {
+	if _, exists := t.GetProvider(id); !exists {
+		return ErrNotFound
+	}
+
+	var count int
+	if err := t.q.QueryRow(`SELECT COUNT(*) FROM bouquets WHERE provider_id = ?`, id).Scan(&count); err != nil {
+		return err
+	}
+	if count > 0 && t.options.ProviderDeletePolicy != DeleteCascade {
+		return &InUseError{Entity: "provider", ID: id, By: "bouquet", Count: count}
+	}
+	if _, err := t.q.Exec(`DELETE FROM bouquets WHERE provider_id = ?`, id); err != nil {
+		return err
+	}
+	return execAffecting(t.q, `DELETE FROM providers WHERE id = ?`, id)
+}
+
+// checkProfile verifies that the profile of a channel exists, if it has one
+func (t *sqlTx) checkProfile(profileID int) error {
+	if _, exists := t.GetProfile(profileID); profileID != 0 && !exists {
+		return &ReferenceError{Entity: "profile", ID: profileID}
+	}
+	return nil
+}
+
+// Encoding profile operations
+
+// withUsedBy resolves the channels using each profile
+func (t *sqlTx) withUsedBy(profiles []EncodingProfile) []EncodingProfile {
+	for i := range profiles {
+		profiles[i].UsedBy = queryList(t.q, scanInt, `SELECT id FROM channels WHERE profile_id = ? ORDER BY id`, profiles[i].ID)
+		if profiles[i].UsedBy == nil {
+			profiles[i].UsedBy = []int{}
+		}
+	}
+	return profiles
+}
+
+func (t *sqlTx) GetAllProfiles() []EncodingProfile {
+	return t.withUsedBy(queryList(t.q, scanProfile, `SELECT `+profileColumns+` FROM encoding_profiles ORDER BY id`))
+}
+
+func (t *sqlTx) GetProfile(id int) (EncodingProfile, bool) {
+	profile, exists := queryOne(t.q, scanProfile, `SELECT `+profileColumns+` FROM encoding_profiles WHERE id = ?`, id)
+	if !exists {
+		return EncodingProfile{}, false
+	}
+	return t.withUsedBy([]EncodingProfile{profile})[0], true
+}
+
+func (t *sqlTx) CreateProfile(profile EncodingProfile) (EncodingProfile, error) {
+	now := time.Now()
+	profile.CreatedAt = now
+	profile.UpdatedAt = now
+
+	result, err := t.q.Exec(`INSERT INTO encoding_profiles (name, description, video_codec, audio_codec, width, height, video_bitrate_bps, audio_bitrate_bps, created_at, updated_at)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		profile.Name, profile.Description, profile.VideoCodec, profile.AudioCodec, profile.Resolution.Width, profile.Resolution.Height,
+		profile.VideoBitrate, profile.AudioBitrate, profile.CreatedAt, profile.UpdatedAt)
+	if err != nil {
+		return EncodingProfile{}, err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return EncodingProfile{}, err
+	}
+	profile.ID = int(id)
+	profile.UsedBy = []int{}
+	profile.Version = 1 // Column default
+	return profile, nil
+}
+
+func (t *sqlTx) UpdateProfile(profile EncodingProfile) error {
+	profile.UpdatedAt = time.Now()
+	return t.execVersioned("encoding_profiles", "profile", profile.ID, `UPDATE encoding_profiles SET name = ?, description = ?, video_codec = ?, audio_codec = ?,
+width = ?, height = ?, video_bitrate_bps = ?, audio_bitrate_bps = ?, version = version + 1, updated_at = ? WHERE id = ? AND version = ?`,
+		profile.Name, profile.Description, profile.VideoCodec, profile.AudioCodec, profile.Resolution.Width, profile.Resolution.Height,
+		profile.VideoBitrate, profile.AudioBitrate, profile.UpdatedAt, profile.ID, profile.Version)
+}
+
+func (t *sqlTx) DeleteProfile(id int) error {
+	profile, exists := t.GetProfile(id)
+	if !exists {
+		return ErrNotFound
+	}
+	if len(profile.UsedBy) > 0 {
+		return &InUseError{Entity: "profile", ID: id, By: "channel", Count: len(profile.UsedBy)}
+	}
+	return execAffecting(t.q, `DELETE FROM encoding_profiles WHERE id = ?`, id)
+}
+
+// Session operations
+func (t *sqlTx) GetAllSessions() []Session {
+	return queryList(t.q, scanSession, `SELECT `+sessionColumns+` FROM sessions ORDER BY created_at`)
+}
+
+// SaveSession creates or replaces a session
+func (t *sqlTx) SaveSession(session Session) error {
+	if _, exists := t.GetUser(session.UserID); !exists {
+		return &ReferenceError{Entity: "user", ID: session.UserID}
+	}
+	_, err := t.q.Exec(`INSERT INTO sessions (`+sessionColumns+`) VALUES (?, ?, ?, ?, ?, ?)
+ON CONFLICT (id) DO UPDATE SET user_id = excluded.user_id, client_ip = excluded.client_ip,
+	user_agent = excluded.user_agent, last_seen = excluded.last_seen`,
+		session.ID, session.UserID, session.ClientIP, session.UserAgent, session.CreatedAt, session.LastSeen)
+	return err
+}
+
+func (t *sqlTx) DeleteSession(id string) error {
+	return execAffecting(t.q, `DELETE FROM sessions WHERE id = ?`, id)
+}
+
+// API token operations
+func (t *sqlTx) GetAPITokensByUser(userID int) []APIToken {
+	return queryList(t.q, scanAPIToken, `SELECT `+apiTokenColumns+` FROM api_tokens WHERE user_id = ? ORDER BY id`, userID)
+}
+
+func (t *sqlTx) GetAPIToken(id int) (APIToken, bool) {
+	return queryOne(t.q, scanAPIToken, `SELECT `+apiTokenColumns+` FROM api_tokens WHERE id = ?`, id)
+}
+
+func (t *sqlTx) GetAPITokenByHash(hash string) (APIToken, bool) {
+	return queryOne(t.q, scanAPIToken, `SELECT `+apiTokenColumns+` FROM api_tokens WHERE token_hash = ?`, hash)
+}
+
+func (t *sqlTx) CreateAPIToken(token APIToken) (APIToken, error) {
+	if _, exists := t.GetUser(token.UserID); !exists {
+		return APIToken{}, &ReferenceError{Entity: "user", ID: token.UserID}
+	}
+	token.CreatedAt = time.Now()
+
+	scopes := make([]string, len(token.Scopes))
+	for i, scope := range token.Scopes {
+		scopes[i] = string(scope)
+	}
+	result, err := t.q.Exec(`INSERT INTO api_tokens (user_id, name, token_hash, prefix, scopes, expires_at, last_used, created_at)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		token.UserID, token.Name, token.Hash, token.Prefix, strings.Join(scopes, " "),
+		nullTime(token.ExpiresAt), nullTime(token.LastUsed), token.CreatedAt)
+	if err != nil {
+		return APIToken{}, err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return APIToken{}, err
+	}
+	token.ID = int(id)
+	return token, nil
+}
+
+// TouchAPIToken records when a token was last used
+func (t *sqlTx) TouchAPIToken(id int, lastUsed time.Time) error {
+	return execAffecting(t.q, `UPDATE api_tokens SET last_used = ? WHERE id = ?`, lastUsed, id)
+}
+
+func (t *sqlTx) DeleteAPIToken(id int) error {
+	return execAffecting(t.q, `DELETE FROM api_tokens WHERE id = ?`, id)
+}
+
+// Webhook operations
+func (t *sqlTx) GetAllWebhooks() []Webhook {
+	return queryList(t.q, scanWebhook, `SELECT `+webhookColumns+` FROM webhooks ORDER BY id`)
+}
+
+func (t *sqlTx) GetWebhook(id int) (Webhook, bool) {
+	return queryOne(t.q, scanWebhook, `SELECT `+webhookColumns+` FROM webhooks WHERE id = ?`, id)
+}
+
+func (t *sqlTx) CreateWebhook(webhook Webhook) (Webhook, error) {
+	now := time.Now()
+	webhook.CreatedAt = now
+	webhook.UpdatedAt = now
+
+	result, err := t.q.Exec(`INSERT INTO webhooks (name, url, secret, events, active, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		webhook.Name, webhook.URL, webhook.Secret, strings.Join(webhook.Events, " "), webhook.Active, webhook.CreatedAt, webhook.UpdatedAt)
+	if err != nil {
+		return Webhook{}, err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return Webhook{}, err
+	}
+	webhook.ID = int(id)
+	webhook.Version = 1 // Column default
+	return webhook, nil
+}
+
+func (t *sqlTx) UpdateWebhook(webhook Webhook) error {
+	webhook.UpdatedAt = time.Now()
+	return t.execVersioned("webhooks", "webhook", webhook.ID, `UPDATE webhooks SET name = ?, url = ?, secret = ?, events = ?, active = ?,
+version = version + 1, updated_at = ? WHERE id = ? AND version = ?`,
+		webhook.Name, webhook.URL, webhook.Secret, strings.Join(webhook.Events, " "), webhook.Active, webhook.UpdatedAt, webhook.ID, webhook.Version)
+}
+
+// DeleteWebhook deletes a webhook; its deliveries go with it through the
+// foreign key
+func (t *sqlTx) DeleteWebhook(id int) error {
+	return execAffecting(t.q, `DELETE FROM webhooks WHERE id = ?`, id)
+}
+
+func (t *sqlTx) GetWebhookDeliveries(webhookID int) []WebhookDelivery {
+	return queryList(t.q, scanDelivery, `SELECT `+deliveryColumns+` FROM webhook_deliveries WHERE webhook_id = ? ORDER BY id DESC`, webhookID)
+}
+
+// AddWebhookDelivery records a delivery, dropping the oldest ones of the
+// webhook beyond WebhookDeliveryLimit
+func (t *sqlTx) AddWebhookDelivery(delivery WebhookDelivery) error {
+	if _, exists := t.GetWebhook(delivery.WebhookID); !exists {
+		return &ReferenceError{Entity: "webhook", ID: delivery.WebhookID}
+	}
+	if _, err := t.q.Exec(`INSERT INTO webhook_deliveries (webhook_id, delivery_id, event, attempt, status_code, error, duration_ms, created_at)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		delivery.WebhookID, delivery.DeliveryID, delivery.Event, delivery.Attempt, delivery.StatusCode, delivery.Error,
+		delivery.Duration.Milliseconds(), delivery.CreatedAt); err != nil {
+		return err
+	}
+	_, err := t.q.Exec(`DELETE FROM webhook_deliveries WHERE webhook_id = ? AND id NOT IN
+(SELECT id FROM webhook_deliveries WHERE webhook_id = ? ORDER BY id DESC LIMIT ?)`,
+		delivery.WebhookID, delivery.WebhookID, WebhookDeliveryLimit)
+	return err
+}
@@ -1,585 +1,249 @@
 package models
 
 import (
-	"sync"
+	"errors"
+	"fmt"
 	"time"
 )
 
-// Store provides in-memory storage for bouquets, users, channels, and providers
-type Store struct {
-	bouquets      map[int]Bouquet
-	users         map[int]User
-	channels      map[int]Channel
-	providers     map[int]Provider
-	nextBouquetID int
-	nextUserID    int
-	nextChannelID int
-	nextProviderID int
-	mutex         sync.RWMutex
-}
-
-// NewStore creates a new in-memory store
-func NewStore() *Store {
-	store := &Store{
-		bouquets:       make(map[int]Bouquet),
-		users:          make(map[int]User),
-		channels:       make(map[int]Channel),
-		providers:      make(map[int]Provider),
-		nextBouquetID:  1,
-		nextUserID:     1,
-		nextChannelID:  1,
-		nextProviderID: 1,
-	}
-	
-	// Don't add sample data anymore - let users set up from scratch
-	return store
-}
-
-// initSampleData adds initial sample data
-func (s *Store) initSampleData() {
-	now := time.Now()
-	
-	// Sample bouquets linked to providers
-	s.bouquets[1] = Bouquet{
-		ID:          1,
-		Name:        "Basic Package",
-		Description: "Essential channels for everyday viewing",
-		ProviderID:  1, // BBC
-		Channels: []Channel{
-			{ID: 1, Name: "BBC One", Manifest: "https://manifest.bbc.co.uk/bbc1/manifest.mpd", KeyKid: "bbc1-key-001", VideoCodec: "x265", AudioCodec: "AAC", Resolution: "1080p", VideoBitrate: "5000k", AudioBitrate: "128k", Quality: "High", Running: false, RemuxPort: 0},
-			{ID: 2, Name: "BBC Two", Manifest: "https://manifest.bbc.co.uk/bbc2/manifest.mpd", KeyKid: "bbc2-key-001", VideoCodec: "x264", AudioCodec: "AAC", Resolution: "720p", VideoBitrate: "3000k", AudioBitrate: "128k", Quality: "Medium", Running: false, RemuxPort: 0},
-			{ID: 3, Name: "ITV", Manifest: "https://manifest.itv.com/itv1/manifest.mpd", KeyKid: "itv1-key-001", VideoCodec: "x265", AudioCodec: "AC3", Resolution: "1080p", VideoBitrate: "6000k", AudioBitrate: "256k", Quality: "High", Running: false, RemuxPort: 0},
-			{ID: 4, Name: "Channel 4", Manifest: "https://manifest.channel4.com/c4/manifest.mpd", KeyKid: "c4-key-001", VideoCodec: "x264", AudioCodec: "AAC", Resolution: "720p", VideoBitrate: "3500k", AudioBitrate: "128k", Quality: "Medium", Running: false, RemuxPort: 0},
-		},
-		CreatedAt:   now,
-		UpdatedAt:   now,
-	}
-	s.bouquets[2] = Bouquet{
-		ID:          2,
-		Name:        "Premium Package", 
-		Description: "Complete entertainment experience with sports and movies",
-		ProviderID:  2, // Sky
-		Channels: []Channel{
-			{ID: 1, Name: "BBC One", Manifest: "https://manifest.bbc.co.uk/bbc1/manifest.mpd", KeyKid: "bbc1-key-001", VideoCodec: "x265", AudioCodec: "AAC", Resolution: "1080p", VideoBitrate: "5000k", AudioBitrate: "128k", Quality: "High", Running: false, RemuxPort: 0},
-			{ID: 2, Name: "BBC Two", Manifest: "https://manifest.bbc.co.uk/bbc2/manifest.mpd", KeyKid: "bbc2-key-001", VideoCodec: "x264", AudioCodec: "AAC", Resolution: "720p", VideoBitrate: "3000k", AudioBitrate: "128k", Quality: "Medium", Running: false, RemuxPort: 0},
-			{ID: 5, Name: "Sky Sports", Manifest: "https://manifest.sky.com/sports/manifest.mpd", KeyKid: "sky-sports-key-001", VideoCodec: "x265", AudioCodec: "AC3", Resolution: "2160p", VideoBitrate: "15000k", AudioBitrate: "256k", Quality: "Ultra", Running: false, RemuxPort: 0},
-			{ID: 6, Name: "Sky Movies", Manifest: "https://manifest.sky.com/movies/manifest.mpd", KeyKid: "sky-movies-key-001", VideoCodec: "x265", AudioCodec: "DTS", Resolution: "2160p", VideoBitrate: "20000k", AudioBitrate: "512k", Quality: "Ultra", Running: false, RemuxPort: 0},
-			{ID: 7, Name: "Discovery", Manifest: "https://manifest.discovery.com/main/manifest.mpd", KeyKid: "discovery-key-001", VideoCodec: "x264", AudioCodec: "AAC", Resolution: "1080p", VideoBitrate: "4000k", AudioBitrate: "128k", Quality: "Medium", Running: false, RemuxPort: 0},
-		},
-		CreatedAt:   now,
-		UpdatedAt:   now,
-	}
-	s.nextBouquetID = 3
-	
-	// Sample users
-	s.users[1] = User{
-		ID:        1,
-		Username:  "admin",
-		Email:     "admin@example.com",
-		FirstName: "Admin",
-		LastName:  "User",
-		Role:      "Administrator",
-		Active:    true,
-		CreatedAt: now,
-		UpdatedAt: now,
-	}
-	s.users[2] = User{
-		ID:        2,
-		Username:  "user1",
-		Email:     "user1@example.com",
-		FirstName: "John",
-		LastName:  "Doe",
-		Role:      "User",
-		Active:    true,
-		CreatedAt: now,
-		UpdatedAt: now,
-	}
-	s.nextUserID = 3
-	
-	// Sample channels with video encoding configurations
-	s.channels[1] = Channel{
-		ID:           1,
-		Name:         "BBC One",
-		Manifest:     "https://manifest.bbc.co.uk/bbc1/manifest.mpd",
-		KeyKid:       "bbc1-key-001",
-		VideoCodec:   "x265",
-		AudioCodec:   "AAC",
-		Resolution:   "1080p",
-		VideoBitrate: "5000k",
-		AudioBitrate: "128k",
-		Quality:      "High",
-		Running:      false,
-		RemuxPort:    0,
-		CreatedAt:    now,
-		UpdatedAt:    now,
-	}
-	s.channels[2] = Channel{
-		ID:           2,
-		Name:         "BBC Two",
-		Manifest:     "https://manifest.bbc.co.uk/bbc2/manifest.mpd",
-		KeyKid:       "bbc2-key-001",
-		VideoCodec:   "x264",
-		AudioCodec:   "AAC",
-		Resolution:   "720p",
-		VideoBitrate: "3000k",
-		AudioBitrate: "128k",
-		Quality:      "Medium",
-		Running:      false,
-		RemuxPort:    0,
-		CreatedAt:    now,
-		UpdatedAt:    now,
-	}
-	s.channels[3] = Channel{
-		ID:           3,
-		Name:         "ITV",
-		Manifest:     "https://manifest.itv.com/itv1/manifest.mpd",
-		KeyKid:       "itv1-key-001",
-		VideoCodec:   "x265",
-		AudioCodec:   "AC3",
-		Resolution:   "1080p",
-		VideoBitrate: "6000k",
-		AudioBitrate: "256k",
-		Quality:      "High",
-		Running:      false,
-		RemuxPort:    0,
-		CreatedAt:    now,
-		UpdatedAt:    now,
-	}
-	s.channels[4] = Channel{
-		ID:           4,
-		Name:         "Channel 4",
-		Manifest:     "https://manifest.channel4.com/c4/manifest.mpd",
-		KeyKid:       "c4-key-001",
-		VideoCodec:   "x264",
-		AudioCodec:   "AAC",
-		Resolution:   "720p",
-		VideoBitrate: "3500k",
-		AudioBitrate: "128k",
-		Quality:      "Medium",
-		Running:      false,
-		RemuxPort:    0,
-		CreatedAt:    now,
-		UpdatedAt:    now,
-	}
-	s.channels[5] = Channel{
-		ID:           5,
-		Name:         "Sky Sports",
-		Manifest:     "https://manifest.sky.com/sports/manifest.mpd",
-		KeyKid:       "sky-sports-key-001",
-		VideoCodec:   "x265",
-		AudioCodec:   "AC3",
-		Resolution:   "2160p",
-		VideoBitrate: "15000k",
-		AudioBitrate: "256k",
-		Quality:      "Ultra",
-		Running:      false,
-		RemuxPort:    0,
-		CreatedAt:    now,
-		UpdatedAt:    now,
-	}
-	s.channels[6] = Channel{
-		ID:           6,
-		Name:         "Sky Movies",
-		Manifest:     "https://manifest.sky.com/movies/manifest.mpd",
-		KeyKid:       "sky-movies-key-001",
-		VideoCodec:   "x265",
-		AudioCodec:   "DTS",
-		Resolution:   "2160p",
-		VideoBitrate: "20000k",
-		AudioBitrate: "512k",
-		Quality:      "Ultra",
-		Running:      false,
-		RemuxPort:    0,
-		CreatedAt:    now,
-		UpdatedAt:    now,
-	}
-	s.channels[7] = Channel{
-		ID:           7,
-		Name:         "Discovery",
-		Manifest:     "https://manifest.discovery.com/main/manifest.mpd",
-		KeyKid:       "discovery-key-001",
-		VideoCodec:   "x264",
-		AudioCodec:   "AAC",
-		Resolution:   "1080p",
-		VideoBitrate: "4000k",
-		AudioBitrate: "128k",
-		Quality:      "Medium",
-		Running:      false,
-		RemuxPort:    0,
-		CreatedAt:    now,
-		UpdatedAt:    now,
-	}
-	s.nextChannelID = 8
-	
-	// Sample providers
-	s.providers[1] = Provider{
-		ID:          1,
-		Name:        "BBC",
-		Description: "British Broadcasting Corporation",
-		URL:         "https://www.bbc.co.uk",
-		APIKey:      "bbc-api-key-123",
-		Active:      true,
-		CreatedAt:   now,
-		UpdatedAt:   now,
-	}
-	s.providers[2] = Provider{
-		ID:          2,
-		Name:        "Sky",
-		Description: "Sky Television Services",
-		URL:         "https://www.sky.com",
-		APIKey:      "sky-api-key-456",
-		Active:      true,
-		CreatedAt:   now,
-		UpdatedAt:   now,
-	}
-	s.nextProviderID = 3
-}
+// ErrNotFound is returned when an operation targets an entity that does not exist
+var ErrNotFound = errors.New("not found")
 
-// Bouquet operations
-func (s *Store) GetAllBouquets() []Bouquet {
-	s.mutex.RLock()
-	defer s.mutex.RUnlock()
-	
-	bouquets := make([]Bouquet, 0, len(s.bouquets))
-	for _, bouquet := range s.bouquets {
-		bouquets = append(bouquets, bouquet)
-	}
-	return bouquets
-}
+// ErrTOTPStepUsed is returned by UseTOTPStep when the code of that time step,
+// or of a later one, was already used to sign in
+var ErrTOTPStepUsed = errors.New("authentication code already used")
 
-func (s *Store) GetBouquet(id int) (Bouquet, bool) {
-	s.mutex.RLock()
-	defer s.mutex.RUnlock()
-	
-	bouquet, exists := s.bouquets[id]
-	return bouquet, exists
+// ReferenceError is returned when an entity would reference another entity
+// that does not exist, such as a bouquet pointing to an unknown provider
+type ReferenceError struct {
+	Entity string // Kind of the missing entity, e.g. "provider"
+	ID     int
 }
 
-func (s *Store) CreateBouquet(bouquet Bouquet) Bouquet {
-	s.mutex.Lock()
-	defer s.mutex.Unlock()
-	
-	bouquet.ID = s.nextBouquetID
-	bouquet.CreatedAt = time.Now()
-	bouquet.UpdatedAt = time.Now()
-	s.bouquets[bouquet.ID] = bouquet
-	s.nextBouquetID++
-	return bouquet
+func (e *ReferenceError) Error() string {
+	return fmt.Sprintf("%s %d does not exist", e.Entity, e.ID)
 }
 
-func (s *Store) UpdateBouquet(bouquet Bouquet) bool {
-	s.mutex.Lock()
-	defer s.mutex.Unlock()
-	
-	if _, exists := s.bouquets[bouquet.ID]; !exists {
-		return false
-	}
-	bouquet.UpdatedAt = time.Now()
-	s.bouquets[bouquet.ID] = bouquet
-	return true
+// InUseError is returned when deleting an entity that other entities still
+// reference and the configured policy forbids cascading the deletion
+type InUseError struct {
+	Entity string // Kind of the entity being deleted, e.g. "provider"
+	ID     int
+	By     string // Kind of the referencing entities, e.g. "bouquet"
+	Count  int
 }
 
-func (s *Store) DeleteBouquet(id int) bool {
-	s.mutex.Lock()
-	defer s.mutex.Unlock()
-	
-	if _, exists := s.bouquets[id]; !exists {
-		return false
-	}
-	delete(s.bouquets, id)
-	return true
+func (e *InUseError) Error() string {
+	return fmt.Sprintf("%s %d is still used by %d %s(s)", e.Entity, e.ID, e.Count, e.By)
 }
 
-// GetBouquetsByProvider returns all bouquets for a specific provider
-func (s *Store) GetBouquetsByProvider(providerID int) []Bouquet {
-	s.mutex.RLock()
-	defer s.mutex.RUnlock()
-	
-	var bouquets []Bouquet
-	for _, bouquet := range s.bouquets {
-		if bouquet.ProviderID == providerID {
-			bouquets = append(bouquets, bouquet)
-		}
-	}
-	return bouquets
+// ConflictError is returned when an update is based on an outdated version of
+// an entity, meaning someone else changed it since it was read
+type ConflictError struct {
+	Entity  string // Kind of the entity, e.g. "channel"
+	ID      int
+	Version int // Current version of the entity
 }
 
-// GetProvidersWithBouquets returns all providers with their associated bouquets
-func (s *Store) GetProvidersWithBouquets() []ProviderWithBouquets {
-	s.mutex.RLock()
-	defer s.mutex.RUnlock()
-	
-	var providersWithBouquets []ProviderWithBouquets
-	for _, provider := range s.providers {
-		pwb := ProviderWithBouquets{
-			Provider: provider,
-			Bouquets: s.getBouquetsByProviderUnsafe(provider.ID),
-		}
-		providersWithBouquets = append(providersWithBouquets, pwb)
-	}
-	return providersWithBouquets
+func (e *ConflictError) Error() string {
+	return fmt.Sprintf("%s %d was modified concurrently (current version %d)", e.Entity, e.ID, e.Version)
 }
 
-// getBouquetsByProviderUnsafe is the unsafe version for internal use (no mutex)
-func (s *Store) getBouquetsByProviderUnsafe(providerID int) []Bouquet {
-	var bouquets []Bouquet
-	for _, bouquet := range s.bouquets {
-		if bouquet.ProviderID == providerID {
-			bouquets = append(bouquets, bouquet)
-		}
-	}
-	return bouquets
+// PortInUseError is returned when starting a channel on a remux port that
+// another running channel already uses
+type PortInUseError struct {
+	Port      int
+	ChannelID int // Running channel using the port
 }
 
-// User operations
-func (s *Store) GetAllUsers() []User {
-	s.mutex.RLock()
-	defer s.mutex.RUnlock()
-	
-	users := make([]User, 0, len(s.users))
-	for _, user := range s.users {
-		users = append(users, user)
-	}
-	return users
+func (e *PortInUseError) Error() string {
+	return fmt.Sprintf("remux port %d is already used by channel %d", e.Port, e.ChannelID)
 }
 
-func (s *Store) GetUser(id int) (User, bool) {
-	s.mutex.RLock()
-	defer s.mutex.RUnlock()
-	
-	user, exists := s.users[id]
-	return user, exists
-}
+// DeletePolicy decides what happens to dependent entities when their parent is deleted
+type DeletePolicy string
 
-// HasUsers returns true if any users exist in the store
-func (s *Store) HasUsers() bool {
-	s.mutex.RLock()
-	defer s.mutex.RUnlock()
-	
-	return len(s.users) > 0
-}
+const (
+	// DeleteRestrict refuses to delete an entity that is still referenced
+	DeleteRestrict DeletePolicy = "restrict"
+	// DeleteCascade deletes the referencing entities together with the entity
+	DeleteCascade DeletePolicy = "cascade"
+)
 
-// GetUserByUsername returns a user by username
-func (s *Store) GetUserByUsername(username string) (User, bool) {
-	s.mutex.RLock()
-	defer s.mutex.RUnlock()
-	
-	for _, user := range s.users {
-		if user.Username == username {
-			return user, true
+// Options configures behaviour shared by all store backends
+type Options struct {
+	// ProviderDeletePolicy applies to the bouquets of a deleted provider
+	ProviderDeletePolicy DeletePolicy
+}
+
+// Repository lists the operations on bouquets, users, channels, and providers.
+// Lookups report existence with a boolean; mutations return an error, which is
+// ErrNotFound when the target entity does not exist.
+//
+// Stores enforce referential integrity: writing a bouquet whose provider or
+// channels do not exist fails with a *ReferenceError, and deleting a provider
+// that still has bouquets either fails with an *InUseError or deletes the
+// bouquets too, depending on Options.ProviderDeletePolicy.
+//
+// Bouquets reference their channels through Bouquet.ChannelIDs. Bouquets
+// returned by the store have Channels resolved to the current channel state;
+// Channels is ignored when a bouquet is written. Deleting a channel removes it
+// from every bouquet.
+//
+// Channels may reference an encoding profile through Channel.ProfileID, 0
+// meaning none. Writing a channel whose profile does not exist fails with a
+// *ReferenceError, and deleting a profile that channels still use fails with
+// an *InUseError. Profiles returned by the store have UsedBy resolved to the
+// channels using them; UsedBy is ignored when a profile is written.
+//
+// State, Running, RemuxPort, PID, LastError, StartedAt and StoppedAt are the
+// runtime state of a channel. They only change through StartChannel and
+// SetChannelState, which UpdateChannel leaves alone. Neither changes the
+// Version, so a process restarting never conflicts with an edit. Both fail
+// with a *TransitionError when the channel cannot move to the new state; see
+// ChannelState. StartChannel fails with a *PortInUseError when another running
+// channel uses the given port. A stopped channel keeps its RemuxPort, so it
+// can get the same port when it starts again. SetChannelState keeps the
+// LastError when given an empty one.
+//
+// Every entity carries a Version, set to 1 on creation and incremented by
+// every write to it. UpdateBouquet, UpdateUser, UpdateChannel, UpdateProvider,
+// UpdateProfile and UpdateWebhook only apply when the given Version is the stored one and fail
+// with a *ConflictError otherwise, so an edit based on stale data never
+// overwrites someone else's changes.
+//
+// Sessions are keyed by their ID. SaveSession creates or replaces a session,
+// and deleting a user deletes its sessions.
+//
+// API tokens are looked up by the SHA-256 hash of the token. Creating a token
+// for a missing user fails with a *ReferenceError, and deleting a user deletes
+// its tokens.
+//
+// Webhook deliveries are listed newest first. Adding a delivery for a missing
+// webhook fails with a *ReferenceError; only the last WebhookDeliveryLimit of
+// each webhook are kept, and deleting a webhook deletes its deliveries.
+type Repository interface {
+	// Bouquet operations
+	GetAllBouquets() []Bouquet
+	GetBouquet(id int) (Bouquet, bool)
+	CreateBouquet(bouquet Bouquet) (Bouquet, error)
+	UpdateBouquet(bouquet Bouquet) error
+	DeleteBouquet(id int) error
+	AddChannelToBouquet(bouquetID, channelID int) error
+	RemoveChannelFromBouquet(bouquetID, channelID int) error
+	GetBouquetsByProvider(providerID int) []Bouquet
+	GetProvidersWithBouquets() []ProviderWithBouquets
+
+	// User operations
+	GetAllUsers() []User
+	GetUser(id int) (User, bool)
+	HasUsers() bool
+	GetUserByUsername(username string) (User, bool)
+	CreateUser(user User) (User, error)
+	UpdateUser(user User) error
+	DeleteUser(id int) error
+	UseTOTPStep(userID int, step uint64) error
+
+	// Channel operations
+	GetAllChannels() []Channel
+	GetChannel(id int) (Channel, bool)
+	CreateChannel(channel Channel) (Channel, error)
+	UpdateChannel(channel Channel) error
+	DeleteChannel(id int) error
+	StartChannel(channelID, port int) error
+	SetChannelState(channelID int, state ChannelState, pid int, lastError string) error
+
+	// Provider operations
+	GetAllProviders() []Provider
+	GetProvider(id int) (Provider, bool)
+	CreateProvider(provider Provider) (Provider, error)
+	UpdateProvider(provider Provider) error
+	DeleteProvider(id int) error
+
+	// Encoding profile operations
+	GetAllProfiles() []EncodingProfile
+	GetProfile(id int) (EncodingProfile, bool)
+	CreateProfile(profile EncodingProfile) (EncodingProfile, error)
+	UpdateProfile(profile EncodingProfile) error
+	DeleteProfile(id int) error
+
+	// Session operations
+	GetAllSessions() []Session
+	SaveSession(session Session) error
+	DeleteSession(id string) error
+
+	// API token operations
+	GetAPITokensByUser(userID int) []APIToken
+	GetAPIToken(id int) (APIToken, bool)
+	GetAPITokenByHash(hash string) (APIToken, bool)
+	CreateAPIToken(token APIToken) (APIToken, error)
+	TouchAPIToken(id int, lastUsed time.Time) error
+	DeleteAPIToken(id int) error
+
+	// Webhook operations
+	GetAllWebhooks() []Webhook
+	GetWebhook(id int) (Webhook, bool)
+	CreateWebhook(webhook Webhook) (Webhook, error)
+	UpdateWebhook(webhook Webhook) error
+	DeleteWebhook(id int) error
+	GetWebhookDeliveries(webhookID int) []WebhookDelivery
+	AddWebhookDelivery(delivery WebhookDelivery) error
+}
+
+// Tx is a Repository bound to a transaction. Its reads see the writes made
+// earlier in the same transaction.
+type Tx interface {
+	Repository
+}
+
+// Store is the storage interface used by the application. Each Repository
+// method called on the store runs in its own transaction; use Update to apply
+// several mutations together.
+type Store interface {
+	Repository
+
+	// Update runs fn in a transaction and commits its writes when fn returns
+	// nil. When fn returns an error, nothing it wrote is kept and the error is
+	// returned. Other readers never observe a partially applied transaction.
+	// fn must only use tx: calling the store itself from fn may deadlock.
+	Update(fn func(tx Tx) error) error
+
+	// Subscribe returns a channel receiving an Event for each change committed
+	// from now on, and a function to call once done with it. The channel holds
+	// up to buffer events; a subscriber that falls further behind is dropped
+	// and its channel closed. Events are delivered from the goroutine making
+	// the change, so subscribers must not block.
+	Subscribe(buffer int) (<-chan Event, func())
+
+	// As returns a view of the store whose changes are attributed to actor in
+	// the events they publish. Changes made through the store itself are
+	// attributed to the server.
+	As(actor Actor) Store
+}
+
+// OpenStore creates the store backend selected by dbType ("memory", "file" or "sqlite").
+// dataFile is only used by persistent backends.
+func OpenStore(dbType, dataFile string, options Options) (Store, error) {
+	switch dbType {
+	case "memory":
+		store := NewMemoryStore()
+		store.options = options
+		return store, nil
+	case "file":
+		store, err := NewFileStore(dataFile)
+		if err != nil {
+			return nil, err
 		}
-	}
-	return User{}, false
-}
-
-func (s *Store) CreateUser(user User) User {
-	s.mutex.Lock()
-	defer s.mutex.Unlock()
-	
-	user.ID = s.nextUserID
-	user.CreatedAt = time.Now()
-	user.UpdatedAt = time.Now()
-	s.users[user.ID] = user
-	s.nextUserID++
-	return user
-}
-
-func (s *Store) UpdateUser(user User) bool {
-	s.mutex.Lock()
-	defer s.mutex.Unlock()
-	
-	if _, exists := s.users[user.ID]; !exists {
-		return false
-	}
-	user.UpdatedAt = time.Now()
-	s.users[user.ID] = user
-	return true
-}
-
-func (s *Store) DeleteUser(id int) bool {
-	s.mutex.Lock()
-	defer s.mutex.Unlock()
-	
-	if _, exists := s.users[id]; !exists {
-		return false
-	}
-	delete(s.users, id)
-	return true
-}
-
-// Channel operations
-func (s *Store) GetAllChannels() []Channel {
-	s.mutex.RLock()
-	defer s.mutex.RUnlock()
-	
-	channels := make([]Channel, 0, len(s.channels))
-	for _, channel := range s.channels {
-		channels = append(channels, channel)
-	}
-	return channels
-}
-
-func (s *Store) GetChannel(id int) (Channel, bool) {
-	s.mutex.RLock()
-	defer s.mutex.RUnlock()
-	
-	channel, exists := s.channels[id]
-	return channel, exists
-}
-
-func (s *Store) CreateChannel(channel Channel) Channel {
-	s.mutex.Lock()
-	defer s.mutex.Unlock()
-	
-	channel.ID = s.nextChannelID
-	channel.CreatedAt = time.Now()
-	channel.UpdatedAt = time.Now()
-	s.channels[channel.ID] = channel
-	s.nextChannelID++
-	return channel
-}
-
-func (s *Store) UpdateChannel(channel Channel) bool {
-	s.mutex.Lock()
-	defer s.mutex.Unlock()
-	
-	if _, exists := s.channels[channel.ID]; !exists {
-		return false
-	}
-	channel.UpdatedAt = time.Now()
-	s.channels[channel.ID] = channel
-	return true
-}
-
-func (s *Store) DeleteChannel(id int) bool {
-	s.mutex.Lock()
-	defer s.mutex.Unlock()
-	
-	if _, exists := s.channels[id]; !exists {
-		return false
-	}
-	delete(s.channels, id)
-	return true
-}
-
-// StartChannel starts a channel and assigns a remux port
-func (s *Store) StartChannel(channelID int) (int, bool) {
-	s.mutex.Lock()
-	defer s.mutex.Unlock()
-	
-	channel, exists := s.channels[channelID]
-	if !exists {
-		return 0, false
-	}
-	
-	if channel.Running {
-		return channel.RemuxPort, true // Already running
-	}
-	
-	// Assign a random port for remuxer (8000-9000 range)
-	port := 8000 + (channelID * 10) // Simple port assignment
-	channel.Running = true
-	channel.RemuxPort = port
-	channel.UpdatedAt = time.Now()
-	s.channels[channelID] = channel
-	
-	return port, true
-}
-
-// StopChannel stops a channel and releases the remux port
-func (s *Store) StopChannel(channelID int) bool {
-	s.mutex.Lock()
-	defer s.mutex.Unlock()
-	
-	channel, exists := s.channels[channelID]
-	if !exists {
-		return false
-	}
-	
-	channel.Running = false
-	channel.RemuxPort = 0
-	channel.UpdatedAt = time.Now()
-	s.channels[channelID] = channel
-	
-	return true
-}
-
-// UpdateChannelInBouquet updates a channel within all bouquets that contain it
-func (s *Store) UpdateChannelInBouquet(channelID int) {
-	s.mutex.Lock()
-	defer s.mutex.Unlock()
-	
-	channel, exists := s.channels[channelID]
-	if !exists {
-		return
-	}
-	
-	// Update the channel in all bouquets that contain it
-	for bouquetID, bouquet := range s.bouquets {
-		for i, ch := range bouquet.Channels {
-			if ch.Name == channel.Name && ch.Manifest == channel.Manifest {
-				bouquet.Channels[i] = channel
-				bouquet.UpdatedAt = time.Now()
-				s.bouquets[bouquetID] = bouquet
-			}
+		store.options = options
+		return store, nil
+	case "sqlite":
+		store, err := NewSQLStore(dataFile)
+		if err != nil {
+			return nil, err
 		}
+		store.options = options
+		return store, nil
+	default:
+		return nil, fmt.Errorf("unknown database type: %s", dbType)
 	}
 }
-
-// Provider operations
-func (s *Store) GetAllProviders() []Provider {
-	s.mutex.RLock()
-	defer s.mutex.RUnlock()
-	
-	providers := make([]Provider, 0, len(s.providers))
-	for _, provider := range s.providers {
-		providers = append(providers, provider)
-	}
-	return providers
-}
-
-func (s *Store) GetProvider(id int) (Provider, bool) {
-	s.mutex.RLock()
-	defer s.mutex.RUnlock()
-	
-	provider, exists := s.providers[id]
-	return provider, exists
-}
-
-func (s *Store) CreateProvider(provider Provider) Provider {
-	s.mutex.Lock()
-	defer s.mutex.Unlock()
-	
-	provider.ID = s.nextProviderID
-	provider.CreatedAt = time.Now()
-	provider.UpdatedAt = time.Now()
-	s.providers[provider.ID] = provider
-	s.nextProviderID++
-	return provider
-}
-
-func (s *Store) UpdateProvider(provider Provider) bool {
-	s.mutex.Lock()
-	defer s.mutex.Unlock()
-	
-	if _, exists := s.providers[provider.ID]; !exists {
-		return false
-	}
-	provider.UpdatedAt = time.Now()
-	s.providers[provider.ID] = provider
-	return true
-}
-
-func (s *Store) DeleteProvider(id int) bool {
-	s.mutex.Lock()
-	defer s.mutex.Unlock()
-	
-	if _, exists := s.providers[id]; !exists {
-		return false
-	}
-	delete(s.providers, id)
-	return true
-}
-
-// Global store instance
-var GlobalStore = NewStore()
\ No newline at end of file
@@ -0,0 +1,348 @@
+package models
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// forEachBackend runs test on a new empty store of each backend: memory,
+// file and SQLite
+func forEachBackend(t *testing.T, test func(t *testing.T, store Store)) {
+	forEachBackendWith(t, Options{}, test)
+}
+
+// forEachBackendWith is forEachBackend for stores opened with options
+func forEachBackendWith(t *testing.T, options Options, test func(t *testing.T, store Store)) {
+	for _, backend := range []string{"memory", "file", "sqlite"} {
+		t.Run(backend, func(t *testing.T) {
+			store, err := OpenStore(backend, filepath.Join(t.TempDir(), "fuzzy.data"), options)
+			if err != nil {
+				t.Fatalf("opening %s store: %v", backend, err)
+			}
+			if closer, ok := store.(io.Closer); ok {
+				t.Cleanup(func() { closer.Close() })
+			}
+			test(t, store)
+		})
+	}
+}
+
+// TestProviderDeletePolicy checks that deleting a provider with bouquets is
+// refused under the restrict policy, the default, and takes its bouquets
+// along under the cascade policy
+func TestProviderDeletePolicy(t *testing.T) {
+	tests := []struct {
+		policy  DeletePolicy
+		deleted bool
+	}{
+		{"", false},
+		{DeleteRestrict, false},
+		{DeleteCascade, true},
+	}
+	for _, test := range tests {
+		t.Run(cmp.Or(string(test.policy), "default"), func(t *testing.T) {
+			forEachBackendWith(t, Options{ProviderDeletePolicy: test.policy}, func(t *testing.T, store Store) {
+				provider, err := store.CreateProvider(Provider{Name: "BBC", Active: true})
+				if err != nil {
+					t.Fatalf("CreateProvider: %v", err)
+				}
+				other, err := store.CreateProvider(Provider{Name: "ITV", Active: true})
+				if err != nil {
+					t.Fatalf("CreateProvider: %v", err)
+				}
+				for _, bouquet := range []Bouquet{{Name: "News", ProviderID: provider.ID}, {Name: "Sport", ProviderID: provider.ID}, {Name: "Drama", ProviderID: other.ID}} {
+					if _, err := store.CreateBouquet(bouquet); err != nil {
+						t.Fatalf("CreateBouquet: %v", err)
+					}
+				}
+
+				err = store.DeleteProvider(provider.ID)
+				var inUse *InUseError
+				if test.deleted && err != nil {
+					t.Fatalf("DeleteProvider: %v", err)
+				}
+				if !test.deleted && (!errors.As(err, &inUse) || inUse.Entity != "provider" || inUse.By != "bouquet" || inUse.Count != 2) {
+					t.Fatalf("DeleteProvider: %v, want provider in use by 2 bouquets", err)
+				}
+				if _, exists := store.GetProvider(provider.ID); exists == test.deleted {
+					t.Errorf("provider exists %v after delete", exists)
+				}
+				if bouquets := store.GetBouquetsByProvider(provider.ID); (len(bouquets) == 0) != test.deleted {
+					t.Errorf("%d bouquets left to the provider", len(bouquets))
+				}
+				if bouquets := store.GetBouquetsByProvider(other.ID); len(bouquets) != 1 {
+					t.Errorf("%d bouquets left to the other provider, want 1", len(bouquets))
+				}
+			})
+		})
+	}
+}
+
+// TestBouquetReferences checks that bouquets cannot reference a missing
+// provider or channel
+func TestBouquetReferences(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, store Store) {
+		provider, err := store.CreateProvider(Provider{Name: "BBC", Active: true})
+		if err != nil {
+			t.Fatalf("CreateProvider: %v", err)
+		}
+		channel, err := store.CreateChannel(Channel{Name: "BBC One", Manifest: "https://example.com/one.mpd", KeyKid: "key:kid", State: ChannelStopped})
+		if err != nil {
+			t.Fatalf("CreateChannel: %v", err)
+		}
+		bouquet, err := store.CreateBouquet(Bouquet{Name: "News", ProviderID: provider.ID, ChannelIDs: []int{channel.ID}})
+		if err != nil {
+			t.Fatalf("CreateBouquet: %v", err)
+		}
+
+		tests := []struct {
+			name   string
+			write  func() error
+			entity string
+			id     int
+		}{
+			{"create with a missing provider", func() error {
+				_, err := store.CreateBouquet(Bouquet{Name: "Sport", ProviderID: 99})
+				return err
+			}, "provider", 99},
+			{"create with a missing channel", func() error {
+				_, err := store.CreateBouquet(Bouquet{Name: "Sport", ProviderID: provider.ID, ChannelIDs: []int{channel.ID, 98}})
+				return err
+			}, "channel", 98},
+			{"move to a missing provider", func() error {
+				moved := bouquet
+				moved.ProviderID = 97
+				return store.UpdateBouquet(moved)
+			}, "provider", 97},
+			{"add a missing channel", func() error {
+				return store.AddChannelToBouquet(bouquet.ID, 96)
+			}, "channel", 96},
+		}
+		for _, test := range tests {
+			var refErr *ReferenceError
+			if err := test.write(); !errors.As(err, &refErr) || refErr.Entity != test.entity || refErr.ID != test.id {
+				t.Errorf("%s: %v, want %s %d does not exist", test.name, err, test.entity, test.id)
+			}
+		}
+		if bouquets := store.GetAllBouquets(); len(bouquets) != 1 || bouquets[0].ProviderID != provider.ID || len(bouquets[0].ChannelIDs) != 1 {
+			t.Errorf("bouquets after the refused writes: %+v", bouquets)
+		}
+		if err := store.AddChannelToBouquet(99, channel.ID); !errors.Is(err, ErrNotFound) {
+			t.Errorf("adding to a missing bouquet: %v, want %v", err, ErrNotFound)
+		}
+	})
+}
+
+// versioned is a kind of entity whose updates are checked against its
+// version: create adds one, rename updates its name based on version, and get
+// reads its name and version back
+type versioned struct {
+	entity string
+	create func(t *testing.T, store Store) int
+	rename func(store Store, id int, name string, version int) error
+	get    func(store Store, id int) (string, int)
+}
+
+// versionedEntities lists every versioned entity kind
+var versionedEntities = []versioned{
+	{"channel", func(t *testing.T, store Store) int {
+		channel, err := store.CreateChannel(Channel{Name: "BBC One", Manifest: "https://example.com/one.mpd", KeyKid: "key:kid", State: ChannelStopped})
+		if err != nil {
+			t.Fatalf("CreateChannel: %v", err)
+		}
+		return channel.ID
+	}, func(store Store, id int, name string, version int) error {
+		channel, _ := store.GetChannel(id)
+		channel.Name, channel.Version = name, version
+		return store.UpdateChannel(channel)
+	}, func(store Store, id int) (string, int) {
+		channel, _ := store.GetChannel(id)
+		return channel.Name, channel.Version
+	}},
+	{"provider", func(t *testing.T, store Store) int {
+		provider, err := store.CreateProvider(Provider{Name: "BBC"})
+		if err != nil {
+			t.Fatalf("CreateProvider: %v", err)
+		}
+		return provider.ID
+	}, func(store Store, id int, name string, version int) error {
+		provider, _ := store.GetProvider(id)
+		provider.Name, provider.Version = name, version
+		return store.UpdateProvider(provider)
+	}, func(store Store, id int) (string, int) {
+		provider, _ := store.GetProvider(id)
+		return provider.Name, provider.Version
+	}},
+	{"bouquet", func(t *testing.T, store Store) int {
+		provider, err := store.CreateProvider(Provider{Name: "BBC"})
+		if err != nil {
+			t.Fatalf("CreateProvider: %v", err)
+		}
+		bouquet, err := store.CreateBouquet(Bouquet{Name: "News", ProviderID: provider.ID})
+		if err != nil {
+			t.Fatalf("CreateBouquet: %v", err)
+		}
+		return bouquet.ID
+	}, func(store Store, id int, name string, version int) error {
+		bouquet, _ := store.GetBouquet(id)
+		bouquet.Name, bouquet.Version = name, version
+		return store.UpdateBouquet(bouquet)
+	}, func(store Store, id int) (string, int) {
+		bouquet, _ := store.GetBouquet(id)
+		return bouquet.Name, bouquet.Version
+	}},
+	{"user", func(t *testing.T, store Store) int {
+		user, err := store.CreateUser(User{Username: "alice", Password: "hash", Role: RoleViewer, Active: true})
+		if err != nil {
+			t.Fatalf("CreateUser: %v", err)
+		}
+		return user.ID
+	}, func(store Store, id int, name string, version int) error {
+		user, _ := store.GetUser(id)
+		user.Username, user.Version = name, version
+		return store.UpdateUser(user)
+	}, func(store Store, id int) (string, int) {
+		user, _ := store.GetUser(id)
+		return user.Username, user.Version
+	}},
+	{"profile", func(t *testing.T, store Store) int {
+		profile, err := store.CreateProfile(EncodingProfile{Name: "HD"})
+		if err != nil {
+			t.Fatalf("CreateProfile: %v", err)
+		}
+		return profile.ID
+	}, func(store Store, id int, name string, version int) error {
+		profile, _ := store.GetProfile(id)
+		profile.Name, profile.Version = name, version
+		return store.UpdateProfile(profile)
+	}, func(store Store, id int) (string, int) {
+		profile, _ := store.GetProfile(id)
+		return profile.Name, profile.Version
+	}},
+	{"webhook", func(t *testing.T, store Store) int {
+		webhook, err := store.CreateWebhook(Webhook{Name: "ops", URL: "https://example.com/hook", Secret: "secret", Active: true})
+		if err != nil {
+			t.Fatalf("CreateWebhook: %v", err)
+		}
+		return webhook.ID
+	}, func(store Store, id int, name string, version int) error {
+		webhook, _ := store.GetWebhook(id)
+		webhook.Name, webhook.Version = name, version
+		return store.UpdateWebhook(webhook)
+	}, func(store Store, id int) (string, int) {
+		webhook, _ := store.GetWebhook(id)
+		return webhook.Name, webhook.Version
+	}},
+}
+
+// TestVersionConflicts checks that entities start at version 1, that each
+// update increments the version, and that an update based on another version
+// fails with a *ConflictError and changes nothing
+func TestVersionConflicts(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, store Store) {
+		for _, kind := range versionedEntities {
+			id := kind.create(t, store)
+			if _, version := kind.get(store, id); version != 1 {
+				t.Errorf("new %s at version %d, want 1", kind.entity, version)
+			}
+			for version := 1; version <= 2; version++ {
+				if err := kind.rename(store, id, fmt.Sprintf("renamed %d", version), version); err != nil {
+					t.Fatalf("%s update %d: %v", kind.entity, version, err)
+				}
+			}
+			if name, version := kind.get(store, id); name != "renamed 2" || version != 3 {
+				t.Errorf("%s after two updates: %q at version %d, want %q at 3", kind.entity, name, version, "renamed 2")
+			}
+
+			for _, stale := range []int{2, 4} {
+				var conflict *ConflictError
+				err := kind.rename(store, id, "lost", stale)
+				if !errors.As(err, &conflict) || conflict.Entity != kind.entity || conflict.ID != id || conflict.Version != 3 {
+					t.Errorf("%s update at version %d: %v, want a conflict at version 3", kind.entity, stale, err)
+				}
+			}
+			if name, version := kind.get(store, id); name != "renamed 2" || version != 3 {
+				t.Errorf("%s after conflicts: %q at version %d", kind.entity, name, version)
+			}
+		}
+
+		// Changing the channels of a bouquet is a write to it
+		bouquet := store.GetAllBouquets()[0]
+		channel := store.GetAllChannels()[0]
+		if err := store.AddChannelToBouquet(bouquet.ID, channel.ID); err != nil {
+			t.Fatalf("AddChannelToBouquet: %v", err)
+		}
+		if stored, _ := store.GetBouquet(bouquet.ID); stored.Version != bouquet.Version+1 {
+			t.Errorf("bouquet at version %d after adding a channel, want %d", stored.Version, bouquet.Version+1)
+		}
+	})
+}
+
+// TestBouquetChannelOrder checks that bouquets keep their channels in the
+// order given, and drop deleted channels without reordering the others
+func TestBouquetChannelOrder(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, store Store) {
+		provider, err := store.CreateProvider(Provider{Name: "BBC", Active: true})
+		if err != nil {
+			t.Fatalf("CreateProvider: %v", err)
+		}
+		var ids []int
+		for _, name := range []string{"BBC One", "BBC Two", "BBC Three", "BBC Four"} {
+			channel, err := store.CreateChannel(Channel{Name: name, Manifest: "https://example.com/" + name + ".mpd", KeyKid: "key:kid", State: ChannelStopped})
+			if err != nil {
+				t.Fatalf("CreateChannel: %v", err)
+			}
+			ids = append(ids, channel.ID)
+		}
+		bouquet, err := store.CreateBouquet(Bouquet{Name: "News", ProviderID: provider.ID, ChannelIDs: []int{ids[2], ids[0], ids[1]}})
+		if err != nil {
+			t.Fatalf("CreateBouquet: %v", err)
+		}
+		other, err := store.CreateBouquet(Bouquet{Name: "Sport", ProviderID: provider.ID, ChannelIDs: []int{ids[0]}})
+		if err != nil {
+			t.Fatalf("CreateBouquet: %v", err)
+		}
+
+		// check compares the channels of the bouquet id, by ID and resolved
+		check := func(step string, id int, want []int) {
+			t.Helper()
+			stored, _ := store.GetBouquet(id)
+			var resolved []int
+			for _, channel := range stored.Channels {
+				resolved = append(resolved, channel.ID)
+			}
+			if !slices.Equal(stored.ChannelIDs, want) || !slices.Equal(resolved, want) {
+				t.Errorf("%s: bouquet %d has channels %v, resolved %v; want %v", step, id, stored.ChannelIDs, resolved, want)
+			}
+		}
+		check("created", bouquet.ID, []int{ids[2], ids[0], ids[1]})
+
+		if err := store.AddChannelToBouquet(bouquet.ID, ids[3]); err != nil {
+			t.Fatalf("AddChannelToBouquet: %v", err)
+		}
+		check("channel added", bouquet.ID, []int{ids[2], ids[0], ids[1], ids[3]})
+
+		if err := store.RemoveChannelFromBouquet(bouquet.ID, ids[1]); err != nil {
+			t.Fatalf("RemoveChannelFromBouquet: %v", err)
+		}
+		check("channel removed", bouquet.ID, []int{ids[2], ids[0], ids[3]})
+
+		if err := store.DeleteChannel(ids[0]); err != nil {
+			t.Fatalf("DeleteChannel: %v", err)
+		}
+		check("channel deleted", bouquet.ID, []int{ids[2], ids[3]})
+		check("channel deleted", other.ID, nil)
+
+		stored, _ := store.GetBouquet(bouquet.ID)
+		stored.ChannelIDs = []int{ids[3], ids[2]}
+		if err := store.UpdateBouquet(stored); err != nil {
+			t.Fatalf("UpdateBouquet: %v", err)
+		}
+		check("reordered", bouquet.ID, []int{ids[3], ids[2]})
+	})
+}
@@ -0,0 +1,145 @@
+package models
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// TOTP parameters (RFC 6238), the defaults every authenticator app supports
+const (
+	totpDigits = 6
+	totpPeriod = 30 * time.Second
+	totpSkew   = 1 // Time steps accepted on each side of the current one, for clock drift
+
+	recoveryCodeCount = 10
+)
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// NewTOTPSecret returns a random base32-encoded TOTP secret
+func NewTOTPSecret() (string, error) {
+	secret := make([]byte, 20)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	return totpEncoding.EncodeToString(secret), nil
+}
+
+// totpCode computes the code of a base32 secret for a time step (RFC 4226)
+func totpCode(secret string, step uint64) (string, error) {
+	key, err := totpEncoding.DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return "", err
+	}
+
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], step)
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", totpDigits, value%1000000), nil
+}
+
+// TOTPURI returns the otpauth:// URI that authenticator apps import to
+// generate the user's codes
+func (u User) TOTPURI(issuer string) string {
+	label := url.PathEscape(issuer + ":" + u.Username)
+	params := url.Values{
+		"secret":    {u.TOTPSecret},
+		"issuer":    {issuer},
+		"algorithm": {"SHA1"},
+		"digits":    {fmt.Sprint(totpDigits)},
+		"period":    {fmt.Sprint(int(totpPeriod.Seconds()))},
+	}
+	return "otpauth://totp/" + label + "?" + params.Encode()
+}
+
+// MatchTOTP checks a code against the user's TOTP secret at time now. It
+// returns the time step the code belongs to, so callers can refuse a code
+// that was already used.
+func (u User) MatchTOTP(code string, now time.Time) (uint64, bool) {
+	code = strings.ReplaceAll(strings.TrimSpace(code), " ", "")
+	if u.TOTPSecret == "" || len(code) != totpDigits {
+		return 0, false
+	}
+
+	current := uint64(now.Unix()) / uint64(totpPeriod.Seconds())
+	for step := current - totpSkew; step <= current+totpSkew; step++ {
+		expected, err := totpCode(u.TOTPSecret, step)
+		if err != nil {
+			return 0, false
+		}
+		if subtle.ConstantTimeCompare([]byte(code), []byte(expected)) == 1 {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+// SetRecoveryCodes replaces the user's recovery codes with new random ones,
+// stored as bcrypt hashes like the password, and returns them in clear so
+// they can be shown once
+func (u *User) SetRecoveryCodes() ([]string, error) {
+	codes := make([]string, recoveryCodeCount)
+	hashes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		raw := make([]byte, 5)
+		if _, err := rand.Read(raw); err != nil {
+			return nil, err
+		}
+		encoded := strings.ToLower(totpEncoding.EncodeToString(raw))
+		codes[i] = encoded[:4] + "-" + encoded[4:]
+
+		hash, err := bcrypt.GenerateFromPassword([]byte(codes[i]), bcrypt.DefaultCost)
+		if err != nil {
+			return nil, err
+		}
+		hashes[i] = string(hash)
+	}
+	u.RecoveryCodes = hashes
+	return codes, nil
+}
+
+// MatchRecoveryCode checks a code against the user's unused recovery codes
+// and returns the hash it matches
+func (u User) MatchRecoveryCode(code string) (string, bool) {
+	code = strings.ToLower(strings.TrimSpace(code))
+	for _, hash := range u.RecoveryCodes {
+		if bcrypt.CompareHashAndPassword([]byte(hash), []byte(code)) == nil {
+			return hash, true
+		}
+	}
+	return "", false
+}
+
+// RemoveRecoveryCode marks the recovery code with the given hash as used. It
+// reports false when the code was already used.
+func (u *User) RemoveRecoveryCode(hash string) bool {
+	i := slices.Index(u.RecoveryCodes, hash)
+	if i < 0 {
+		return false
+	}
+	u.RecoveryCodes = slices.Delete(slices.Clone(u.RecoveryCodes), i, i+1)
+	return true
+}
+
+// ClearTOTP turns two-factor authentication off for the user
+func (u *User) ClearTOTP() {
+	u.TOTPSecret = ""
+	u.TOTPEnabled = false
+	u.RecoveryCodes = nil
+}
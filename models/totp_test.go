@@ -0,0 +1,187 @@
+package models
+
+import (
+	"encoding/base32"
+	"errors"
+	"testing"
+	"time"
+)
+
+// rfc6238Secret is the SHA-1 key of the RFC 6238 test vectors, base32-encoded
+var rfc6238Secret = base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString([]byte("12345678901234567890"))
+
+// TestTOTPCodeRFC6238 checks the codes against the SHA-1 test vectors of RFC
+// 6238 Appendix B, truncated to the 6 digits used here
+func TestTOTPCodeRFC6238(t *testing.T) {
+	tests := []struct {
+		unix int64
+		want string // Last 6 digits of the 8-digit code of the RFC
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+	user := User{ID: 1, TOTPSecret: rfc6238Secret}
+	for _, test := range tests {
+		code, err := totpCode(rfc6238Secret, uint64(test.unix)/30)
+		if err != nil {
+			t.Fatalf("totpCode at %d: %v", test.unix, err)
+		}
+		if code != test.want {
+			t.Errorf("code at %d: %s, want %s", test.unix, code, test.want)
+		}
+
+		now := time.Unix(test.unix, 0)
+		if step, ok := user.MatchTOTP(test.want, now); !ok || step != uint64(test.unix)/30 {
+			t.Errorf("MatchTOTP(%s) at %d: step %d, %v", test.want, test.unix, step, ok)
+		}
+	}
+}
+
+// TestMatchTOTPSkew checks that a code is accepted one time step early or late
+// and refused beyond
+func TestMatchTOTPSkew(t *testing.T) {
+	issued := time.Unix(1111111111, 0)
+	code := "050471" // RFC 6238 vector at issued
+	user := User{ID: 1, TOTPSecret: rfc6238Secret}
+
+	tests := []struct {
+		offset time.Duration
+		want   bool
+	}{
+		{0, true},
+		{-30 * time.Second, true},
+		{30 * time.Second, true},
+		{-60 * time.Second, false},
+		{60 * time.Second, false},
+		{10 * time.Minute, false},
+	}
+	for _, test := range tests {
+		step, ok := user.MatchTOTP(code, issued.Add(test.offset))
+		if ok != test.want {
+			t.Errorf("code checked %v after it was issued: %v, want %v", test.offset, ok, test.want)
+		}
+		if ok && step != uint64(issued.Unix())/30 {
+			t.Errorf("code checked %v after it was issued: step %d, want the step it was issued in", test.offset, step)
+		}
+	}
+}
+
+// TestMatchTOTPInput checks the codes refused whatever the time
+func TestMatchTOTPInput(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	tests := []struct {
+		name   string
+		secret string
+		code   string
+		want   bool
+	}{
+		{"spaces", rfc6238Secret, " 050 471 ", true},
+		{"lower-case secret", "gezdgnbvgy3tqojqgezdgnbvgy3tqojq", "050471", true},
+		{"wrong code", rfc6238Secret, "050472", false},
+		{"too short", rfc6238Secret, "50471", false},
+		{"too long", rfc6238Secret, "0504711", false},
+		{"no secret", "", "050471", false},
+		{"invalid secret", "not base32!", "050471", false},
+	}
+	for _, test := range tests {
+		user := User{ID: 1, TOTPSecret: test.secret}
+		if _, ok := user.MatchTOTP(test.code, now); ok != test.want {
+			t.Errorf("%s: %v, want %v", test.name, ok, test.want)
+		}
+	}
+}
+
+// TestRecoveryCodesSingleUse checks that each recovery code matches once it is
+// generated and no longer once it is removed
+func TestRecoveryCodesSingleUse(t *testing.T) {
+	var user User
+	codes, err := user.SetRecoveryCodes()
+	if err != nil {
+		t.Fatalf("SetRecoveryCodes: %v", err)
+	}
+	if len(codes) != recoveryCodeCount || len(user.RecoveryCodes) != recoveryCodeCount {
+		t.Fatalf("%d codes, %d hashes, want %d", len(codes), len(user.RecoveryCodes), recoveryCodeCount)
+	}
+	for i, hash := range user.RecoveryCodes {
+		if hash == codes[i] {
+			t.Fatalf("recovery code %d stored in clear", i)
+		}
+	}
+
+	hash, ok := user.MatchRecoveryCode("  " + codes[3] + " ")
+	if !ok {
+		t.Fatalf("recovery code %s not matched", codes[3])
+	}
+	if !user.RemoveRecoveryCode(hash) {
+		t.Fatalf("first use of recovery code %s refused", codes[3])
+	}
+	if user.RemoveRecoveryCode(hash) {
+		t.Errorf("recovery code %s removed twice", codes[3])
+	}
+	if _, ok := user.MatchRecoveryCode(codes[3]); ok {
+		t.Errorf("used recovery code %s still matches", codes[3])
+	}
+	if _, ok := user.MatchRecoveryCode(codes[4]); !ok {
+		t.Errorf("unused recovery code %s no longer matches", codes[4])
+	}
+	if len(user.RecoveryCodes) != recoveryCodeCount-1 {
+		t.Errorf("%d recovery codes left, want %d", len(user.RecoveryCodes), recoveryCodeCount-1)
+	}
+}
+
+// TestUseTOTPStep checks that the code of a TOTP time step is accepted once per
+// user, that a step older than the last one used is refused, and that the last
+// step survives edits of the user and reloading the store
+func TestUseTOTPStep(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, store Store) {
+		var ids []int
+		for _, username := range []string{"alice", "bob"} {
+			user, err := store.CreateUser(User{Username: username, Role: RoleViewer, Active: true})
+			if err != nil {
+				t.Fatalf("CreateUser: %v", err)
+			}
+			ids = append(ids, user.ID)
+		}
+		tests := []struct {
+			user int // Index in ids
+			step uint64
+			want error
+		}{
+			{0, 100, nil},
+			{0, 100, ErrTOTPStepUsed}, // Replayed
+			{0, 99, ErrTOTPStepUsed},  // Earlier code, still within the skew
+			{1, 100, nil},             // Another user
+			{0, 101, nil},
+			{0, 101, ErrTOTPStepUsed},
+		}
+		for i, test := range tests {
+			if err := store.UseTOTPStep(ids[test.user], test.step); !errors.Is(err, test.want) {
+				t.Errorf("%d: user %d step %d: %v, want %v", i, ids[test.user], test.step, err, test.want)
+			}
+		}
+		if err := store.UseTOTPStep(ids[1]+1, 100); !errors.Is(err, ErrNotFound) {
+			t.Errorf("unknown user: %v, want ErrNotFound", err)
+		}
+
+		// Editing the user does not rewind the step
+		user, _ := store.GetUser(ids[0])
+		user.TOTPLastStep = 0
+		user.FirstName = "Alice"
+		if err := store.UpdateUser(user); err != nil {
+			t.Fatalf("UpdateUser: %v", err)
+		}
+		if err := store.UseTOTPStep(ids[0], 101); !errors.Is(err, ErrTOTPStepUsed) {
+			t.Errorf("step after editing the user: %v, want ErrTOTPStepUsed", err)
+		}
+
+		if fileStore, ok := store.(*MemoryStore); ok && fileStore.dataFile != "" {
+			if err := reloadFileStore(t, fileStore.dataFile).UseTOTPStep(ids[0], 101); !errors.Is(err, ErrTOTPStepUsed) {
+				t.Errorf("step after reloading: %v, want ErrTOTPStepUsed", err)
+			}
+		}
+	})
+}
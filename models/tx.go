@@ -0,0 +1,301 @@
+package models
+
+import "time"
+
+// autoTx implements Repository for a store by running each operation in its
+// own transaction. Backends implement the operations once, on their
+// transaction type, and embed an autoTx wired to their view and update functions.
+type autoTx struct {
+	view   func(fn func(tx Tx))
+	update func(fn func(tx Tx) error) error
+}
+
+// backend is a store able to attribute the changes of a transaction
+type backend interface {
+	Store
+	update(actor Actor, fn func(tx Tx) error) error
+	view(fn func(tx Tx))
+}
+
+// actorStore is a Store whose changes are attributed to an actor. It shares
+// the data and subscribers of its backend.
+type actorStore struct {
+	autoTx
+	backend backend
+	actor   Actor
+}
+
+func newActorStore(b backend, actor Actor) *actorStore {
+	s := &actorStore{backend: b, actor: actor}
+	s.autoTx = autoTx{view: b.view, update: s.Update}
+	return s
+}
+
+func (s *actorStore) Update(fn func(tx Tx) error) error {
+	return s.backend.update(s.actor, fn)
+}
+
+func (s *actorStore) Subscribe(buffer int) (<-chan Event, func()) {
+	return s.backend.Subscribe(buffer)
+}
+
+func (s *actorStore) As(actor Actor) Store {
+	return s.backend.As(actor)
+}
+
+// Bouquet operations
+func (a autoTx) GetAllBouquets() (bouquets []Bouquet) {
+	a.view(func(tx Tx) { bouquets = tx.GetAllBouquets() })
+	return bouquets
+}
+
+func (a autoTx) GetBouquet(id int) (bouquet Bouquet, exists bool) {
+	a.view(func(tx Tx) { bouquet, exists = tx.GetBouquet(id) })
+	return bouquet, exists
+}
+
+func (a autoTx) CreateBouquet(bouquet Bouquet) (created Bouquet, err error) {
+	err = a.update(func(tx Tx) (err error) {
+		created, err = tx.CreateBouquet(bouquet)
+		return err
+	})
+	return created, err
+}
+
+func (a autoTx) UpdateBouquet(bouquet Bouquet) error {
+	return a.update(func(tx Tx) error { return tx.UpdateBouquet(bouquet) })
+}
+
+func (a autoTx) DeleteBouquet(id int) error {
+	return a.update(func(tx Tx) error { return tx.DeleteBouquet(id) })
+}
+
+func (a autoTx) AddChannelToBouquet(bouquetID, channelID int) error {
+	return a.update(func(tx Tx) error { return tx.AddChannelToBouquet(bouquetID, channelID) })
+}
+
+func (a autoTx) RemoveChannelFromBouquet(bouquetID, channelID int) error {
+	return a.update(func(tx Tx) error { return tx.RemoveChannelFromBouquet(bouquetID, channelID) })
+}
+
+func (a autoTx) GetBouquetsByProvider(providerID int) (bouquets []Bouquet) {
+	a.view(func(tx Tx) { bouquets = tx.GetBouquetsByProvider(providerID) })
+	return bouquets
+}
+
+func (a autoTx) GetProvidersWithBouquets() (providers []ProviderWithBouquets) {
+	a.view(func(tx Tx) { providers = tx.GetProvidersWithBouquets() })
+	return providers
+}
+
+// User operations
+func (a autoTx) GetAllUsers() (users []User) {
+	a.view(func(tx Tx) { users = tx.GetAllUsers() })
+	return users
+}
+
+func (a autoTx) GetUser(id int) (user User, exists bool) {
+	a.view(func(tx Tx) { user, exists = tx.GetUser(id) })
+	return user, exists
+}
+
+func (a autoTx) HasUsers() (hasUsers bool) {
+	a.view(func(tx Tx) { hasUsers = tx.HasUsers() })
+	return hasUsers
+}
+
+func (a autoTx) GetUserByUsername(username string) (user User, exists bool) {
+	a.view(func(tx Tx) { user, exists = tx.GetUserByUsername(username) })
+	return user, exists
+}
+
+func (a autoTx) CreateUser(user User) (created User, err error) {
+	err = a.update(func(tx Tx) (err error) {
+		created, err = tx.CreateUser(user)
+		return err
+	})
+	return created, err
+}
+
+func (a autoTx) UpdateUser(user User) error {
+	return a.update(func(tx Tx) error { return tx.UpdateUser(user) })
+}
+
+func (a autoTx) DeleteUser(id int) error {
+	return a.update(func(tx Tx) error { return tx.DeleteUser(id) })
+}
+
+func (a autoTx) UseTOTPStep(userID int, step uint64) error {
+	return a.update(func(tx Tx) error { return tx.UseTOTPStep(userID, step) })
+}
+
+// Channel operations
+func (a autoTx) GetAllChannels() (channels []Channel) {
+	a.view(func(tx Tx) { channels = tx.GetAllChannels() })
+	return channels
+}
+
+func (a autoTx) GetChannel(id int) (channel Channel, exists bool) {
+	a.view(func(tx Tx) { channel, exists = tx.GetChannel(id) })
+	return channel, exists
+}
+
+func (a autoTx) CreateChannel(channel Channel) (created Channel, err error) {
+	err = a.update(func(tx Tx) (err error) {
+		created, err = tx.CreateChannel(channel)
+		return err
+	})
+	return created, err
+}
+
+func (a autoTx) UpdateChannel(channel Channel) error {
+	return a.update(func(tx Tx) error { return tx.UpdateChannel(channel) })
+}
+
+func (a autoTx) DeleteChannel(id int) error {
+	return a.update(func(tx Tx) error { return tx.DeleteChannel(id) })
+}
+
+func (a autoTx) StartChannel(channelID, port int) error {
+	return a.update(func(tx Tx) error { return tx.StartChannel(channelID, port) })
+}
+
+func (a autoTx) SetChannelState(channelID int, state ChannelState, pid int, lastError string) error {
+	return a.update(func(tx Tx) error { return tx.SetChannelState(channelID, state, pid, lastError) })
+}
+
+// Provider operations
+func (a autoTx) GetAllProviders() (providers []Provider) {
+	a.view(func(tx Tx) { providers = tx.GetAllProviders() })
+	return providers
+}
+
+func (a autoTx) GetProvider(id int) (provider Provider, exists bool) {
+	a.view(func(tx Tx) { provider, exists = tx.GetProvider(id) })
+	return provider, exists
+}
+
+func (a autoTx) CreateProvider(provider Provider) (created Provider, err error) {
+	err = a.update(func(tx Tx) (err error) {
+		created, err = tx.CreateProvider(provider)
+		return err
+	})
+	return created, err
+}
+
+func (a autoTx) UpdateProvider(provider Provider) error {
+	return a.update(func(tx Tx) error { return tx.UpdateProvider(provider) })
+}
+
+func (a autoTx) DeleteProvider(id int) error {
+	return a.update(func(tx Tx) error { return tx.DeleteProvider(id) })
+}
+
+// Encoding profile operations
+func (a autoTx) GetAllProfiles() (profiles []EncodingProfile) {
+	a.view(func(tx Tx) { profiles = tx.GetAllProfiles() })
+	return profiles
+}
+
+func (a autoTx) GetProfile(id int) (profile EncodingProfile, exists bool) {
+	a.view(func(tx Tx) { profile, exists = tx.GetProfile(id) })
+	return profile, exists
+}
+
+func (a autoTx) CreateProfile(profile EncodingProfile) (created EncodingProfile, err error) {
+	err = a.update(func(tx Tx) (err error) {
+		created, err = tx.CreateProfile(profile)
+		return err
+	})
+	return created, err
+}
+
+func (a autoTx) UpdateProfile(profile EncodingProfile) error {
+	return a.update(func(tx Tx) error { return tx.UpdateProfile(profile) })
+}
+
+func (a autoTx) DeleteProfile(id int) error {
+	return a.update(func(tx Tx) error { return tx.DeleteProfile(id) })
+}
+
+// Session operations
+func (a autoTx) GetAllSessions() (sessions []Session) {
+	a.view(func(tx Tx) { sessions = tx.GetAllSessions() })
+	return sessions
+}
+
+func (a autoTx) SaveSession(session Session) error {
+	return a.update(func(tx Tx) error { return tx.SaveSession(session) })
+}
+
+func (a autoTx) DeleteSession(id string) error {
+	return a.update(func(tx Tx) error { return tx.DeleteSession(id) })
+}
+
+// API token operations
+func (a autoTx) GetAPITokensByUser(userID int) (tokens []APIToken) {
+	a.view(func(tx Tx) { tokens = tx.GetAPITokensByUser(userID) })
+	return tokens
+}
+
+func (a autoTx) GetAPIToken(id int) (token APIToken, exists bool) {
+	a.view(func(tx Tx) { token, exists = tx.GetAPIToken(id) })
+	return token, exists
+}
+
+func (a autoTx) GetAPITokenByHash(hash string) (token APIToken, exists bool) {
+	a.view(func(tx Tx) { token, exists = tx.GetAPITokenByHash(hash) })
+	return token, exists
+}
+
+func (a autoTx) CreateAPIToken(token APIToken) (created APIToken, err error) {
+	err = a.update(func(tx Tx) (err error) {
+		created, err = tx.CreateAPIToken(token)
+		return err
+	})
+	return created, err
+}
+
+func (a autoTx) TouchAPIToken(id int, lastUsed time.Time) error {
+	return a.update(func(tx Tx) error { return tx.TouchAPIToken(id, lastUsed) })
+}
+
+func (a autoTx) DeleteAPIToken(id int) error {
+	return a.update(func(tx Tx) error { return tx.DeleteAPIToken(id) })
+}
+
+// Webhook operations
+func (a autoTx) GetAllWebhooks() (webhooks []Webhook) {
+	a.view(func(tx Tx) { webhooks = tx.GetAllWebhooks() })
+	return webhooks
+}
+
+func (a autoTx) GetWebhook(id int) (webhook Webhook, exists bool) {
+	a.view(func(tx Tx) { webhook, exists = tx.GetWebhook(id) })
+	return webhook, exists
+}
+
+func (a autoTx) CreateWebhook(webhook Webhook) (created Webhook, err error) {
+	err = a.update(func(tx Tx) (err error) {
+		created, err = tx.CreateWebhook(webhook)
+		return err
+	})
+	return created, err
+}
+
+func (a autoTx) UpdateWebhook(webhook Webhook) error {
+	return a.update(func(tx Tx) error { return tx.UpdateWebhook(webhook) })
+}
+
+func (a autoTx) DeleteWebhook(id int) error {
+	return a.update(func(tx Tx) error { return tx.DeleteWebhook(id) })
+}
+
+func (a autoTx) GetWebhookDeliveries(webhookID int) (deliveries []WebhookDelivery) {
+	a.view(func(tx Tx) { deliveries = tx.GetWebhookDeliveries(webhookID) })
+	return deliveries
+}
+
+func (a autoTx) AddWebhookDelivery(delivery WebhookDelivery) error {
+	return a.update(func(tx Tx) error { return tx.AddWebhookDelivery(delivery) })
+}
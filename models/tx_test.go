@@ -0,0 +1,145 @@
+package models
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// TestUpdateRollsBack checks that nothing written in an Update that fails is
+// kept, in the store, its events or its data file
+func TestUpdateRollsBack(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, store Store) {
+		provider, err := store.CreateProvider(Provider{Name: "BBC", Active: true})
+		if err != nil {
+			t.Fatalf("CreateProvider: %v", err)
+		}
+		events, unsubscribe := store.Subscribe(10)
+		defer unsubscribe()
+
+		errAbort := errors.New("abort")
+		tests := []struct {
+			name  string
+			fail  func(tx Tx) error
+			check func(err error) bool
+		}{
+			{"error returned", func(tx Tx) error { return errAbort }, func(err error) bool { return errors.Is(err, errAbort) }},
+			{"failed write", func(tx Tx) error {
+				_, err := tx.CreateBouquet(Bouquet{Name: "Orphans", ProviderID: provider.ID + 1})
+				return err
+			}, func(err error) bool {
+				var refErr *ReferenceError
+				return errors.As(err, &refErr)
+			}},
+		}
+		for _, test := range tests {
+			err := store.Update(func(tx Tx) error {
+				channel, err := tx.CreateChannel(Channel{Name: "BBC One", Manifest: "https://example.com/one.mpd", KeyKid: "key:kid", State: ChannelStopped})
+				if err != nil {
+					return err
+				}
+				if _, err := tx.CreateBouquet(Bouquet{Name: "Basics", ProviderID: provider.ID, ChannelIDs: []int{channel.ID}}); err != nil {
+					return err
+				}
+				renamed := provider
+				renamed.Name = "BBC Worldwide"
+				if err := tx.UpdateProvider(renamed); err != nil {
+					return err
+				}
+				return test.fail(tx)
+			})
+			if !test.check(err) {
+				t.Errorf("%s: Update returned %v", test.name, err)
+			}
+			if channels := store.GetAllChannels(); len(channels) != 0 {
+				t.Errorf("%s: channels kept: %+v", test.name, channels)
+			}
+			if bouquets := store.GetAllBouquets(); len(bouquets) != 0 {
+				t.Errorf("%s: bouquets kept: %+v", test.name, bouquets)
+			}
+			if stored, _ := store.GetProvider(provider.ID); stored.Name != "BBC" || stored.Version != provider.Version {
+				t.Errorf("%s: provider kept as %q, version %d", test.name, stored.Name, stored.Version)
+			}
+		}
+		select {
+		case event := <-events:
+			t.Errorf("event published for a rolled back change: %+v", event)
+		default:
+		}
+
+		if fileStore, ok := store.(*MemoryStore); ok && fileStore.dataFile != "" {
+			reloaded, err := NewFileStore(fileStore.dataFile)
+			if err != nil {
+				t.Fatalf("reloading: %v", err)
+			}
+			if len(reloaded.GetAllChannels()) != 0 || len(reloaded.GetAllBouquets()) != 0 || len(reloaded.GetAllProviders()) != 1 {
+				t.Errorf("data file holds %d channels, %d bouquets, %d providers; want 0, 0, 1",
+					len(reloaded.GetAllChannels()), len(reloaded.GetAllBouquets()), len(reloaded.GetAllProviders()))
+			}
+		}
+	})
+}
+
+// TestViewIsConsistent checks that the reads of a view are not affected by a
+// change committed while it runs
+func TestViewIsConsistent(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, store Store) {
+		viewer, ok := store.(interface{ view(fn func(tx Tx)) })
+		if !ok {
+			t.Fatalf("%T has no view", store)
+		}
+		done := make(chan error, 1)
+		viewer.view(func(tx Tx) {
+			before := len(tx.GetAllChannels())
+			go func() {
+				_, err := store.CreateChannel(Channel{Name: "BBC One", Manifest: "https://example.com/one.mpd", KeyKid: "key:kid", State: ChannelStopped})
+				done <- err
+			}()
+			select {
+			case err := <-done:
+				done <- err
+			case <-time.After(50 * time.Millisecond):
+			}
+			if after := len(tx.GetAllChannels()); after != before {
+				t.Errorf("view saw %d channels, then %d", before, after)
+			}
+		})
+		if err := <-done; err != nil {
+			t.Fatalf("CreateChannel: %v", err)
+		}
+		if channels := store.GetAllChannels(); len(channels) != 1 {
+			t.Errorf("%d channels after the view, want 1", len(channels))
+		}
+	})
+}
+
+// TestUpdateCopiesChangedMaps checks that a memory store transaction copies
+// the entity maps it writes to only, and leaves the others shared
+func TestUpdateCopiesChangedMaps(t *testing.T) {
+	store := NewMemoryStore()
+	if _, err := store.CreateProvider(Provider{Name: "BBC", Active: true}); err != nil {
+		t.Fatalf("CreateProvider: %v", err)
+	}
+	providers, channels := reflect.ValueOf(store.data.providers).Pointer(), reflect.ValueOf(store.data.channels).Pointer()
+
+	err := store.Update(func(tx Tx) error {
+		if _, err := tx.CreateChannel(Channel{Name: "BBC One", Manifest: "https://example.com/one.mpd", KeyKid: "key:kid", State: ChannelStopped}); err != nil {
+			return err
+		}
+		_, err := tx.CreateChannel(Channel{Name: "BBC Two", Manifest: "https://example.com/two.mpd", KeyKid: "key:kid", State: ChannelStopped})
+		return err
+	})
+	if err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+	if reflect.ValueOf(store.data.providers).Pointer() != providers {
+		t.Errorf("providers copied by a transaction that only created channels")
+	}
+	if reflect.ValueOf(store.data.channels).Pointer() == channels {
+		t.Errorf("channels written in place")
+	}
+	if len(store.GetAllChannels()) != 2 {
+		t.Errorf("%d channels, want 2", len(store.GetAllChannels()))
+	}
+}
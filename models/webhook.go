@@ -0,0 +1,81 @@
+package models
+
+import (
+	"slices"
+	"time"
+)
+
+// WebhookTestEvent is the name of the event sent by the "send test event"
+// button, which reaches a webhook whatever its event filter
+const WebhookTestEvent = "ping"
+
+// WebhookDeliveryLimit is how many delivery attempts the store keeps for each
+// webhook; older ones are dropped as new ones are added
+const WebhookDeliveryLimit = 100
+
+// WebhookEvents lists the events a webhook can receive. Channels moving to a
+// lifecycle state are named after the state, e.g. "channel.failed"; other
+// changes after the entity and action, e.g. "provider.deleted".
+var WebhookEvents = []string{
+	"channel.starting", "channel.running", "channel.restarting", "channel.stopping", "channel.stopped", "channel.failed",
+	"channel.created", "channel.updated", "channel.deleted",
+	"profile.created", "profile.updated", "profile.deleted",
+	"provider.created", "provider.updated", "provider.deleted",
+	"bouquet.created", "bouquet.updated", "bouquet.deleted",
+}
+
+// Webhook is an endpoint receiving the channel and catalog events as JSON
+// payloads signed with HMAC-SHA256
+type Webhook struct {
+	ID        int       `json:"id"`
+	Name      string    `json:"name"`
+	URL       string    `json:"url"`
+	Secret    string    `json:"-"`      // Key of the signature of the payloads
+	Events    []string  `json:"events"` // Names of the events delivered, from WebhookEvents; empty for all
+	Active    bool      `json:"active"`
+	Version   int       `json:"version"` // Incremented on every write, used to detect concurrent edits
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Wants reports whether the webhook receives the event with the given name
+func (w Webhook) Wants(name string) bool {
+	return w.Active && (len(w.Events) == 0 || w.Lists(name))
+}
+
+// Lists reports whether the event filter of the webhook names the event
+func (w Webhook) Lists(name string) bool {
+	return slices.Contains(w.Events, name)
+}
+
+// WebhookDelivery is an attempt at delivering an event to a webhook
+type WebhookDelivery struct {
+	ID         int           `json:"id"`
+	WebhookID  int           `json:"webhook_id"`
+	DeliveryID string        `json:"delivery_id"` // Shared by the attempts at delivering the same event
+	Event      string        `json:"event"`
+	Attempt    int           `json:"attempt"`     // From 1
+	StatusCode int           `json:"status_code"` // 0 when no response was received
+	Error      string        `json:"error"`       // Empty when the event was delivered
+	Duration   time.Duration `json:"duration"`
+	CreatedAt  time.Time     `json:"created_at"`
+}
+
+// Delivered reports whether the attempt succeeded
+func (d WebhookDelivery) Delivered() bool {
+	return d.Error == ""
+}
+
+// WebhookEventName returns the name webhooks know event by, or "" for the
+// events they do not receive, such as changes to users
+func WebhookEventName(event Event) string {
+	switch event.Entity {
+	case EntityChannel, EntityProfile, EntityProvider, EntityBouquet:
+	default:
+		return ""
+	}
+	if channel, ok := event.After.(Channel); ok && event.Action == EventStateChanged {
+		return "channel." + string(channel.State)
+	}
+	return string(event.Entity) + "." + string(event.Action)
+}
@@ -0,0 +1,111 @@
+// Package ports allocates the remux ports of channels from a configured range.
+// A channel keeps the port it was given, stored in the channel, so it gets the
+// same one each time it starts, including after a restart of the server.
+package ports
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+
+	"fuzzy/models"
+)
+
+// ErrExhausted is returned when no port of the range can be allocated
+var ErrExhausted = errors.New("no free remux port")
+
+// ErrTaken is returned by Confirm when the port allocated to a channel can no
+// longer be used
+var ErrTaken = errors.New("remux port taken")
+
+// Allocator chooses the remux port of a channel when it starts
+type Allocator struct {
+	min, max int
+	reserved map[int]bool   // Ports never allocated, such as the panel's own
+	bindable func(int) bool // Whether nothing else listens on a port
+}
+
+// New creates an allocator for the ports from min to max included, never
+// allocating the reserved ones
+func New(min, max int, reserved ...int) *Allocator {
+	a := &Allocator{
+		min:      min,
+		max:      max,
+		reserved: make(map[int]bool),
+		bindable: bindable,
+	}
+	for _, port := range reserved {
+		a.reserved[port] = true
+	}
+	return a
+}
+
+// Allocate returns the port channel should start on, given every channel.
+// The channel keeps its port when it is still usable. Otherwise it gets the
+// lowest port no other channel holds, and as a last resort a port released
+// by a stopped channel, which then gets another one when it starts again.
+// Ports that something else listens on are skipped.
+//
+// Probing whether a port is free can be slow, so Allocate is meant to be
+// called before the transaction starting the channel, which then checks the
+// port with Confirm.
+func (a *Allocator) Allocate(channel models.Channel, channels []models.Channel) (int, error) {
+	running := make(map[int]bool)
+	held := make(map[int]bool)
+	for _, other := range channels {
+		if other.ID == channel.ID || other.RemuxPort == 0 {
+			continue
+		}
+		held[other.RemuxPort] = true
+		if other.Running {
+			running[other.RemuxPort] = true
+		}
+	}
+
+	if a.usable(channel.RemuxPort) && !running[channel.RemuxPort] && a.bindable(channel.RemuxPort) {
+		return channel.RemuxPort, nil
+	}
+	for port := a.min; port <= a.max; port++ {
+		if a.usable(port) && !held[port] && a.bindable(port) {
+			return port, nil
+		}
+	}
+	for port := a.min; port <= a.max; port++ {
+		if a.usable(port) && !running[port] && a.bindable(port) {
+			return port, nil
+		}
+	}
+	return 0, fmt.Errorf("%w: every port from %d to %d is used by a running channel or another program", ErrExhausted, a.min, a.max)
+}
+
+// Confirm checks that port, allocated to channel, is still in the range and
+// not used by another running channel, given every channel. Unlike Allocate,
+// it does not probe the port, so it can run within a transaction.
+func (a *Allocator) Confirm(port int, channel models.Channel, channels []models.Channel) error {
+	if !a.usable(port) {
+		return fmt.Errorf("%w: port %d is outside the range from %d to %d", ErrTaken, port, a.min, a.max)
+	}
+	for _, other := range channels {
+		if other.ID != channel.ID && other.Running && other.RemuxPort == port {
+			return fmt.Errorf("%w: port %d is used by channel %d", ErrTaken, port, other.ID)
+		}
+	}
+	return nil
+}
+
+// usable reports whether port belongs to the range and is not reserved
+func (a *Allocator) usable(port int) bool {
+	return port >= a.min && port <= a.max && !a.reserved[port]
+}
+
+// bindable reports whether port can be listened on, which fails when another
+// program already uses it
+func bindable(port int) bool {
+	listener, err := net.Listen("tcp", ":"+strconv.Itoa(port))
+	if err != nil {
+		return false
+	}
+	listener.Close()
+	return true
+}
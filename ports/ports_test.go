@@ -0,0 +1,121 @@
+package ports
+
+import (
+	"errors"
+	"net"
+	"testing"
+
+	"fuzzy/models"
+)
+
+// newTestAllocator returns an allocator for which the ports in bound are
+// listened on by another program
+func newTestAllocator(min, max int, bound []int, reserved ...int) *Allocator {
+	a := New(min, max, reserved...)
+	a.bindable = func(port int) bool {
+		for _, b := range bound {
+			if port == b {
+				return false
+			}
+		}
+		return true
+	}
+	return a
+}
+
+func running(id, port int) models.Channel {
+	return models.Channel{ID: id, RemuxPort: port, Running: true, State: models.ChannelRunning}
+}
+
+func stopped(id, port int) models.Channel {
+	return models.Channel{ID: id, RemuxPort: port, State: models.ChannelStopped}
+}
+
+// TestAllocate checks the port chosen for a channel starting among others
+func TestAllocate(t *testing.T) {
+	tests := []struct {
+		name     string
+		min, max int
+		reserved []int
+		bound    []int // Ports another program listens on
+		channel  models.Channel
+		others   []models.Channel
+		want     int
+	}{
+		{"lowest of the range", 8000, 8999, nil, nil, stopped(1, 0), nil, 8000},
+		{"skips held ports", 8000, 8999, nil, nil, stopped(1, 0), []models.Channel{running(2, 8000), stopped(3, 8001)}, 8002},
+		{"keeps its port", 8000, 8999, nil, nil, stopped(1, 8005), []models.Channel{running(2, 8000)}, 8005},
+		{"own port out of range", 8000, 8999, nil, nil, stopped(1, 7000), nil, 8000},
+		{"own port taken by a running channel", 8000, 8999, nil, nil, stopped(1, 8000), []models.Channel{running(2, 8000)}, 8001},
+		{"own port bound by another program", 8000, 8999, nil, []int{8005}, stopped(1, 8005), nil, 8000},
+		{"skips bound ports", 8000, 8999, nil, []int{8000, 8001}, stopped(1, 0), nil, 8002},
+		{"skips reserved ports", 8000, 8999, []int{8000}, nil, stopped(1, 0), nil, 8001},
+		{"reuses a stopped channel's port", 8000, 8001, nil, nil, stopped(1, 0), []models.Channel{stopped(2, 8000), running(3, 8001)}, 8000},
+		{"reuses a deleted channel's port", 8000, 8001, nil, nil, stopped(1, 0), []models.Channel{running(3, 8001)}, 8000},
+	}
+	for _, test := range tests {
+		a := newTestAllocator(test.min, test.max, test.bound, test.reserved...)
+		port, err := a.Allocate(test.channel, append(test.others, test.channel))
+		if err != nil || port != test.want {
+			t.Errorf("%s: port %d, %v, want %d", test.name, port, err, test.want)
+		}
+	}
+}
+
+// TestAllocateExhausted checks that a range whose ports are all running,
+// reserved or bound fails with ErrExhausted
+func TestAllocateExhausted(t *testing.T) {
+	a := newTestAllocator(8000, 8002, []int{8002}, 8001)
+	channel := stopped(1, 0)
+	_, err := a.Allocate(channel, []models.Channel{channel, running(2, 8000)})
+	if !errors.Is(err, ErrExhausted) {
+		t.Errorf("Allocate: %v, want ErrExhausted", err)
+	}
+}
+
+// TestAllocateSkipsListeningPort checks the default probe against a port this
+// test listens on
+func TestAllocateSkipsListeningPort(t *testing.T) {
+	listener, err := net.Listen("tcp", ":0")
+	if err != nil {
+		t.Fatalf("listening: %v", err)
+	}
+	defer listener.Close()
+	port := listener.Addr().(*net.TCPAddr).Port
+
+	a := New(port, port)
+	if _, err := a.Allocate(stopped(1, port), nil); !errors.Is(err, ErrExhausted) {
+		t.Errorf("Allocate with its only port in use: %v, want ErrExhausted", err)
+	}
+
+	listener.Close()
+	if got, err := a.Allocate(stopped(1, port), nil); err != nil || got != port {
+		t.Errorf("Allocate once the port is free: %d, %v, want %d", got, err, port)
+	}
+}
+
+// TestConfirm checks that an allocated port is refused once it is out of the
+// range or used by another running channel, without probing it
+func TestConfirm(t *testing.T) {
+	a := newTestAllocator(8000, 8999, []int{8000}, 8999)
+	channel := stopped(1, 0)
+	tests := []struct {
+		name   string
+		port   int
+		others []models.Channel
+		want   error
+	}{
+		{"free", 8000, nil, nil},
+		{"held by a stopped channel", 8000, []models.Channel{stopped(2, 8000)}, nil},
+		{"held by itself", 8000, []models.Channel{running(1, 8000)}, nil},
+		{"running channel", 8000, []models.Channel{running(2, 8000)}, ErrTaken},
+		{"reserved", 8999, nil, ErrTaken},
+		{"out of range", 9000, nil, ErrTaken},
+	}
+	for _, test := range tests {
+		err := a.Confirm(test.port, channel, test.others)
+		if !errors.Is(err, test.want) || (test.want == nil && err != nil) {
+			t.Errorf("%s: %v, want %v", test.name, err, test.want)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+// Live updates of the channels and providers pages.
+//
+// The page listens to /events: "channel" events carry the status of a channel,
+// which is patched into its cards, and "change" events report other changes
+// to the catalog, for which the page offers to reload. Start and Stop go
+// through the API so the page is not reloaded; the stream then reports the
+// new state.
+(function () {
+    const notice = document.getElementById('live-notice');
+    const errorBox = document.getElementById('live-error');
+    const watched = notice ? notice.dataset.entities.split(' ') : [];
+
+    function cards(id) {
+        return document.querySelectorAll('.channel-card[data-channel-id="' + id + '"]');
+    }
+
+    // Patches the cards of a channel with its status
+    function updateChannel(status) {
+        cards(status.id).forEach(card => {
+            const badge = card.querySelector('[data-channel-status]');
+            if (badge) {
+                badge.className = 'channel-status status-' + status.state;
+                badge.textContent = status.label;
+                badge.title = status.last_error;
+            }
+            card.querySelectorAll('[data-channel-field]').forEach(field => {
+                const value = status[field.dataset.channelField];
+                field.hidden = !value;
+                const text = field.querySelector('.channel-info-value');
+                if (text) {
+                    text.textContent = value || '';
+                }
+            });
+            card.querySelectorAll('[data-channel-action]').forEach(form => {
+                form.hidden = !status['can_' + form.dataset.channelAction];
+            });
+        });
+    }
+
+    function showError(message) {
+        if (!errorBox) {
+            return;
+        }
+        errorBox.textContent = message;
+        errorBox.hidden = !message;
+    }
+
+    const events = new EventSource('/events');
+    events.addEventListener('channel', event => updateChannel(JSON.parse(event.data)));
+    events.addEventListener('change', event => {
+        const change = JSON.parse(event.data);
+        if (change.entity === 'channel' && change.action === 'deleted') {
+            cards(change.id).forEach(card => card.remove());
+            return;
+        }
+        if (notice && watched.includes(change.entity)) {
+            notice.hidden = false;
+        }
+    });
+
+    // Start and stop channels without leaving the page
+    document.querySelectorAll('form[data-channel-action]').forEach(form => {
+        form.addEventListener('submit', async event => {
+            event.preventDefault();
+            const id = form.elements['channel_id'].value;
+            const action = form.dataset.channelAction;
+            const button = form.querySelector('button');
+            button.disabled = true;
+            showError('');
+            try {
+                const response = await fetch('/api/v1/channels/' + id + '/' + action, {
+                    method: 'POST',
+                    headers: { 'X-CSRF-Token': form.elements['csrf_token'].value },
+                    credentials: 'same-origin',
+                });
+                if (!response.ok) {
+                    const body = await response.json().catch(() => null);
+                    showError(body && body.error ? body.error.message : 'Failed to ' + action + ' the channel');
+                }
+            } catch (err) {
+                showError('Failed to ' + action + ' the channel: ' + err.message);
+            } finally {
+                button.disabled = false;
+            }
+        });
+    });
+})();
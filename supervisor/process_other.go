@@ -0,0 +1,18 @@
+//go:build !unix
+
+package supervisor
+
+import "os/exec"
+
+// isolate does nothing where process groups are not supported
+func isolate(cmd *exec.Cmd) {}
+
+// interrupt kills the process, which cannot be asked to exit here
+func interrupt(cmd *exec.Cmd) error {
+	return cmd.Process.Kill()
+}
+
+// kill kills the process
+func kill(cmd *exec.Cmd) error {
+	return cmd.Process.Kill()
+}
@@ -0,0 +1,391 @@
+// Package supervisor runs the external process of every started channel. It
+// restarts a process that exits on its own, with a growing delay, stops it on
+// request or at shutdown, and moves the channel through its lifecycle states
+// as it does. Starts and stops are attributed to whoever asked for them;
+// restarts and shutdown to the server.
+package supervisor
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"fuzzy/models"
+	"fuzzy/ports"
+)
+
+// ErrNoCommand is returned when starting a channel while no command is configured
+var ErrNoCommand = errors.New("no channel command is configured")
+
+// ErrClosed is returned when starting a channel after Close
+var ErrClosed = errors.New("the supervisor is shut down")
+
+// Options configures a Supervisor
+type Options struct {
+	// Command is the command line run for each channel. It is split on
+	// spaces, then placeholders such as {manifest} or {port} are replaced in
+	// each argument, so a value containing spaces stays a single argument.
+	Command string
+	// LogDir receives the output of each process, appended to channel-<id>.log
+	LogDir string
+	// RestartDelay is the delay before restarting a process that exited on its
+	// own. It doubles after each exit up to MaxRestartDelay, and is reset once a
+	// process has run for MaxRestartDelay.
+	RestartDelay    time.Duration
+	MaxRestartDelay time.Duration
+	// StopTimeout is how long a process has to exit after SIGTERM before it
+	// is killed
+	StopTimeout time.Duration
+	// Ports allocates the remux port of each channel when it starts
+	Ports *ports.Allocator
+}
+
+// Supervisor keeps one process running for each started channel
+type Supervisor struct {
+	store   models.Store
+	options Options
+
+	mutex     sync.Mutex
+	processes map[int]*process // By channel ID
+	closed    bool
+}
+
+// process is the supervision of one started channel
+type process struct {
+	channelID int
+	port      int
+	started   chan struct{} // Closed once Start is done launching the process
+	failed    bool          // Whether the process could not be launched; set before started is closed
+	stop      chan struct{} // Closed to stop the channel
+	done      chan struct{} // Closed once the process has exited for good
+}
+
+// New creates a supervisor for the channels of store. No process survives
+// the server, so channels left started are marked failed, and those being
+// stopped are marked stopped.
+func New(store models.Store, options Options) *Supervisor {
+	for _, channel := range store.GetAllChannels() {
+		if !channel.State.Active() {
+			continue
+		}
+		state, reason := models.ChannelFailed, "the server stopped while the channel was "+string(channel.State)
+		if channel.State == models.ChannelStopping {
+			state, reason = models.ChannelStopped, ""
+		}
+		log.Printf("Channel %d was %s when the server stopped; marking it %s", channel.ID, channel.State, state)
+		if err := store.SetChannelState(channel.ID, state, 0, reason); err != nil {
+			log.Printf("Error resetting channel %d: %v", channel.ID, err)
+		}
+	}
+	return &Supervisor{
+		store:     store,
+		options:   options,
+		processes: make(map[int]*process),
+	}
+}
+
+// Start starts the channel and its process for actor, returning its remux
+// port. The channel is marked failed when its process cannot be started.
+// Starting a channel that is already started, or being started, fails with a
+// *models.TransitionError.
+func (s *Supervisor) Start(channelID int, actor models.Actor) (int, error) {
+	// Reserve the channel, then launch it without holding the lock, so a slow
+	// start holds up neither the other channels nor Stop and Close, which wait
+	// for the launch of the channels they stop
+	s.mutex.Lock()
+	if s.closed {
+		s.mutex.Unlock()
+		return 0, ErrClosed
+	}
+	if strings.TrimSpace(s.options.Command) == "" {
+		s.mutex.Unlock()
+		return 0, ErrNoCommand
+	}
+	if _, started := s.processes[channelID]; started {
+		s.mutex.Unlock()
+		channel, _ := s.store.GetChannel(channelID)
+		from := channel.State
+		if from.CanBecome(models.ChannelStarting) {
+			from = models.ChannelStarting // Its start is still in progress
+		}
+		return 0, &models.TransitionError{ID: channelID, From: from, To: models.ChannelStarting}
+	}
+	p := &process{
+		channelID: channelID,
+		started:   make(chan struct{}),
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+	s.processes[channelID] = p
+	s.mutex.Unlock()
+
+	err := s.launch(p, s.store.As(actor))
+	if err != nil {
+		p.failed = true
+		s.forget(p)
+	}
+	close(p.started)
+	if err != nil {
+		return 0, err
+	}
+	return p.port, nil
+}
+
+// launch moves the channel of p to starting through store and starts its
+// process, marking the channel failed when the process cannot be started
+func (s *Supervisor) launch(p *process, store models.Store) error {
+	// Allocate the port before the transaction starting the channel, since
+	// probing ports would hold up every other write, then confirm it within
+	// the transaction in case the channels changed in between
+	channel, exists := s.store.GetChannel(p.channelID)
+	if !exists {
+		return models.ErrNotFound
+	}
+	if err := models.CheckTransition(channel, models.ChannelStarting); err != nil {
+		return err
+	}
+	port, err := s.options.Ports.Allocate(channel, s.store.GetAllChannels())
+	if err != nil {
+		return err
+	}
+	err = store.Update(func(tx models.Tx) error {
+		channel, exists := tx.GetChannel(p.channelID)
+		if !exists {
+			return models.ErrNotFound
+		}
+		if err := models.CheckTransition(channel, models.ChannelStarting); err != nil {
+			return err
+		}
+		if err := s.options.Ports.Confirm(port, channel, tx.GetAllChannels()); err != nil {
+			return err
+		}
+		return tx.StartChannel(p.channelID, port)
+	})
+	if err != nil {
+		return err
+	}
+	p.port = port
+	cmd, err := s.spawn(p)
+	if err != nil {
+		s.setState(store, p, models.ChannelFailed, 0, err.Error())
+		return err
+	}
+	s.setState(store, p, models.ChannelRunning, cmd.Process.Pid, "")
+
+	go s.supervise(p, cmd)
+	return nil
+}
+
+// Stop stops the process of the channel for actor, waiting for it to exit,
+// then the channel itself. Stopping a failed channel marks it stopped;
+// stopping a stopped channel fails with a *models.TransitionError.
+func (s *Supervisor) Stop(channelID int, actor models.Actor) error {
+	store := s.store.As(actor)
+	s.mutex.Lock()
+	p, running := s.processes[channelID]
+	s.mutex.Unlock()
+	if running {
+		<-p.started // Let a start in progress finish first
+	}
+
+	s.mutex.Lock()
+	running = running && s.processes[channelID] == p
+	if running {
+		if err := store.SetChannelState(channelID, models.ChannelStopping, 0, ""); err != nil {
+			s.mutex.Unlock()
+			return err
+		}
+		delete(s.processes, channelID)
+	}
+	s.mutex.Unlock()
+
+	if running {
+		close(p.stop)
+		<-p.done
+	}
+	return store.SetChannelState(channelID, models.ChannelStopped, 0, "")
+}
+
+// Close stops every process and their channels. Channels cannot be started
+// afterwards.
+func (s *Supervisor) Close() {
+	s.mutex.Lock()
+	s.closed = true
+	processes := s.processes
+	s.processes = make(map[int]*process)
+	s.mutex.Unlock()
+
+	// Let the starts in progress finish, then signal every process first, so
+	// they shut down together
+	for _, p := range processes {
+		<-p.started
+	}
+	for _, p := range processes {
+		if !p.failed {
+			s.setState(s.store, p, models.ChannelStopping, 0, "")
+			close(p.stop)
+		}
+	}
+	for _, p := range processes {
+		if !p.failed {
+			<-p.done
+			s.setState(s.store, p, models.ChannelStopped, 0, "")
+		}
+	}
+}
+
+// supervise waits for the process of p to exit and restarts it until p is stopped
+func (s *Supervisor) supervise(p *process, cmd *exec.Cmd) {
+	defer close(p.done)
+
+	delay := s.options.RestartDelay
+	for {
+		started := time.Now()
+		exited := make(chan error, 1)
+		go func() { exited <- cmd.Wait() }()
+
+		var reason string
+		select {
+		case <-p.stop:
+			s.terminate(p, cmd, exited)
+			return
+		case err := <-exited:
+			reason = "process exited"
+			if err != nil {
+				reason += ": " + err.Error()
+			}
+		}
+
+		if time.Since(started) >= s.options.MaxRestartDelay {
+			delay = s.options.RestartDelay
+		}
+		log.Printf("Channel %d %s; restarting in %s", p.channelID, reason, delay)
+		// Fails when the channel is being stopped, which the wait below notices
+		if errors.Is(s.store.SetChannelState(p.channelID, models.ChannelRestarting, 0, reason), models.ErrNotFound) {
+			s.forget(p)
+			return
+		}
+
+		// Restart the process, waiting longer after each failure
+		for {
+			select {
+			case <-p.stop:
+				return
+			case <-time.After(delay):
+			}
+			delay = min(delay*2, s.options.MaxRestartDelay)
+
+			var err error
+			if cmd, err = s.spawn(p); err == nil {
+				break
+			}
+			if errors.Is(err, models.ErrNotFound) {
+				s.forget(p)
+				return
+			}
+			log.Printf("Channel %d: %v; retrying in %s", p.channelID, err, delay)
+			s.store.SetChannelState(p.channelID, models.ChannelRestarting, 0, err.Error())
+		}
+		s.store.SetChannelState(p.channelID, models.ChannelRunning, cmd.Process.Pid, "")
+	}
+}
+
+// setState moves the channel of p to state through store, logging a failure
+func (s *Supervisor) setState(store models.Store, p *process, state models.ChannelState, pid int, lastError string) {
+	if err := store.SetChannelState(p.channelID, state, pid, lastError); err != nil {
+		log.Printf("Error moving channel %d to %s: %v", p.channelID, state, err)
+	}
+}
+
+// spawn starts the process of p with the current settings of its channel
+func (s *Supervisor) spawn(p *process) (*exec.Cmd, error) {
+	channel, exists := s.store.GetChannel(p.channelID)
+	if !exists {
+		return nil, models.ErrNotFound
+	}
+	args := s.commandLine(channel, p.port)
+	if len(args) == 0 {
+		return nil, ErrNoCommand
+	}
+
+	if err := os.MkdirAll(s.options.LogDir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create process log directory: %v", err)
+	}
+	output, err := os.OpenFile(filepath.Join(s.options.LogDir, fmt.Sprintf("channel-%d.log", p.channelID)),
+		os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open process log: %v", err)
+	}
+	// The process keeps its own handle on the log
+	defer output.Close()
+
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Stdout = output
+	cmd.Stderr = output
+	isolate(cmd)
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start process: %v", err)
+	}
+
+	log.Printf("Channel %d process started with PID %d on port %d", p.channelID, cmd.Process.Pid, p.port)
+	return cmd, nil
+}
+
+// terminate asks the process to exit with SIGTERM, and kills it when it does
+// not within the stop timeout
+func (s *Supervisor) terminate(p *process, cmd *exec.Cmd, exited <-chan error) {
+	if err := interrupt(cmd); err != nil {
+		kill(cmd)
+	}
+	select {
+	case <-exited:
+	case <-time.After(s.options.StopTimeout):
+		log.Printf("Channel %d process did not exit within %s; killing it", p.channelID, s.options.StopTimeout)
+		kill(cmd)
+		<-exited
+	}
+	log.Printf("Channel %d process stopped", p.channelID)
+}
+
+// forget drops p once its channel no longer exists
+func (s *Supervisor) forget(p *process) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.processes[p.channelID] == p {
+		delete(s.processes, p.channelID)
+	}
+}
+
+// commandLine returns the arguments of the command for channel, with the
+// placeholders replaced by its settings, completed from its profile
+func (s *Supervisor) commandLine(channel models.Channel, port int) []string {
+	settings := models.ResolveSettings(s.store, channel)
+	placeholders := strings.NewReplacer(
+		"{id}", strconv.Itoa(channel.ID),
+		"{name}", channel.Name,
+		"{manifest}", channel.Manifest,
+		"{key_kid}", channel.KeyKid,
+		"{port}", strconv.Itoa(port),
+		"{video_codec}", string(settings.VideoCodec),
+		"{audio_codec}", string(settings.AudioCodec),
+		"{resolution}", settings.Resolution.String(),
+		"{width}", strconv.Itoa(settings.Resolution.Width),
+		"{height}", strconv.Itoa(settings.Resolution.Height),
+		"{video_bitrate}", settings.VideoBitrate.String(),
+		"{audio_bitrate}", settings.AudioBitrate.String(),
+		"{quality}", channel.Quality,
+	)
+
+	args := strings.Fields(s.options.Command)
+	for i, arg := range args {
+		args[i] = placeholders.Replace(arg)
+	}
+	return args
+}
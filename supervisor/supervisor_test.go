@@ -0,0 +1,249 @@
+//go:build unix
+
+package supervisor
+
+import (
+	"errors"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"fuzzy/models"
+	"fuzzy/ports"
+)
+
+// newTestSupervisor returns a supervisor running command, with short delays,
+// and the store of its stopped channel
+func newTestSupervisor(t *testing.T, store models.Store, command string) (*Supervisor, models.Channel) {
+	t.Helper()
+	channel, err := store.CreateChannel(models.Channel{Name: "BBC One", Manifest: "https://example.com/one.mpd", KeyKid: "key:kid", State: models.ChannelStopped})
+	if err != nil {
+		t.Fatalf("CreateChannel: %v", err)
+	}
+	s := New(store, Options{
+		Command:         command,
+		LogDir:          t.TempDir(),
+		RestartDelay:    20 * time.Millisecond,
+		MaxRestartDelay: 80 * time.Millisecond,
+		StopTimeout:     time.Second,
+		Ports:           ports.New(20000, 20999),
+	})
+	t.Cleanup(s.Close)
+	return s, channel
+}
+
+// alive reports whether the process pid exists and has not been waited for
+func alive(pid int) bool {
+	return pid > 0 && syscall.Kill(pid, 0) == nil
+}
+
+// TestStartStop checks that a started channel runs its process until it is
+// stopped, and that starting and stopping twice fail
+func TestStartStop(t *testing.T) {
+	store := models.NewMemoryStore()
+	s, channel := newTestSupervisor(t, store, "sleep 60")
+	actor := models.Actor{UserID: 1, Username: "alice"}
+
+	port, err := s.Start(channel.ID, actor)
+	if err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	running, _ := store.GetChannel(channel.ID)
+	if running.State != models.ChannelRunning || running.RemuxPort != port || !alive(running.PID) {
+		t.Fatalf("started channel: %s on port %d with PID %d, want running on %d", running.State, running.RemuxPort, running.PID, port)
+	}
+	var transition *models.TransitionError
+	if _, err := s.Start(channel.ID, actor); !errors.As(err, &transition) {
+		t.Errorf("starting a running channel: %v, want a *models.TransitionError", err)
+	}
+
+	if err := s.Stop(channel.ID, actor); err != nil {
+		t.Fatalf("Stop: %v", err)
+	}
+	stopped, _ := store.GetChannel(channel.ID)
+	if stopped.State != models.ChannelStopped || stopped.PID != 0 || stopped.RemuxPort != port {
+		t.Errorf("stopped channel: %s with PID %d on port %d", stopped.State, stopped.PID, stopped.RemuxPort)
+	}
+	if alive(running.PID) {
+		t.Errorf("process %d still running", running.PID)
+	}
+	if err := s.Stop(channel.ID, actor); !errors.As(err, &transition) {
+		t.Errorf("stopping a stopped channel: %v, want a *models.TransitionError", err)
+	}
+}
+
+// TestRestartBackoff checks that a process exiting on its own is restarted,
+// waiting twice as long each time up to MaxRestartDelay
+func TestRestartBackoff(t *testing.T) {
+	store := models.NewMemoryStore()
+	s, channel := newTestSupervisor(t, store, "sleep 0")
+	events, unsubscribe := store.Subscribe(100)
+	defer unsubscribe()
+
+	if _, err := s.Start(channel.ID, models.Actor{}); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	var launches []time.Time
+	timeout := time.After(5 * time.Second)
+	for len(launches) < 5 {
+		select {
+		case event := <-events:
+			after, _ := event.After.(models.Channel)
+			switch after.State {
+			case models.ChannelRunning:
+				launches = append(launches, event.Time)
+			case models.ChannelRestarting:
+				if !strings.HasPrefix(after.LastError, "process exited") {
+					t.Errorf("restarting with reason %q", after.LastError)
+				}
+			}
+		case <-timeout:
+			t.Fatalf("%d launches within 5s, want 5", len(launches))
+		}
+	}
+	if err := s.Stop(channel.ID, models.Actor{}); err != nil {
+		t.Fatalf("Stop: %v", err)
+	}
+
+	delays := []time.Duration{20 * time.Millisecond, 40 * time.Millisecond, 80 * time.Millisecond, 80 * time.Millisecond}
+	for i, delay := range delays {
+		if waited := launches[i+1].Sub(launches[i]); waited < delay {
+			t.Errorf("restart %d after %s, want at least %s", i+1, waited, delay)
+		}
+	}
+	if stopped, _ := store.GetChannel(channel.ID); stopped.State != models.ChannelStopped {
+		t.Errorf("channel %s after Stop, want stopped", stopped.State)
+	}
+}
+
+// TestFailedSpawn checks that a channel whose process cannot be started is
+// marked failed, and can be started again or stopped
+func TestFailedSpawn(t *testing.T) {
+	store := models.NewMemoryStore()
+	s, channel := newTestSupervisor(t, store, "/nonexistent/remux {port}")
+
+	if _, err := s.Start(channel.ID, models.Actor{}); err == nil {
+		t.Fatalf("Start succeeded without a command to run")
+	}
+	failed, _ := store.GetChannel(channel.ID)
+	if failed.State != models.ChannelFailed || failed.PID != 0 || !strings.Contains(failed.LastError, "failed to start process") {
+		t.Errorf("channel: %s with PID %d, error %q; want failed", failed.State, failed.PID, failed.LastError)
+	}
+
+	// The failed start leaves nothing behind
+	if _, err := s.Start(channel.ID, models.Actor{}); err == nil || errors.As(err, new(*models.TransitionError)) {
+		t.Errorf("starting the failed channel again: %v, want the spawn error", err)
+	}
+	if err := s.Stop(channel.ID, models.Actor{}); err != nil {
+		t.Errorf("stopping the failed channel: %v", err)
+	}
+	if stopped, _ := store.GetChannel(channel.ID); stopped.State != models.ChannelStopped {
+		t.Errorf("channel %s after Stop, want stopped", stopped.State)
+	}
+}
+
+// TestClose checks that Close stops every channel and refuses to start more
+func TestClose(t *testing.T) {
+	store := models.NewMemoryStore()
+	s, channel := newTestSupervisor(t, store, "sleep 60")
+	other, err := store.CreateChannel(models.Channel{Name: "BBC Two", Manifest: "https://example.com/two.mpd", KeyKid: "key:kid", State: models.ChannelStopped})
+	if err != nil {
+		t.Fatalf("CreateChannel: %v", err)
+	}
+	var pids []int
+	for _, id := range []int{channel.ID, other.ID} {
+		if _, err := s.Start(id, models.Actor{}); err != nil {
+			t.Fatalf("Start: %v", err)
+		}
+		started, _ := store.GetChannel(id)
+		pids = append(pids, started.PID)
+	}
+
+	s.Close()
+	for i, id := range []int{channel.ID, other.ID} {
+		if stopped, _ := store.GetChannel(id); stopped.State != models.ChannelStopped || alive(pids[i]) {
+			t.Errorf("channel %d after Close: %s, process alive %v", id, stopped.State, alive(pids[i]))
+		}
+	}
+	if _, err := s.Start(channel.ID, models.Actor{}); !errors.Is(err, ErrClosed) {
+		t.Errorf("Start after Close: %v, want %v", err, ErrClosed)
+	}
+}
+
+// gatedStore is a store whose Update waits for a value on gate, to hold up
+// the start of a channel
+type gatedStore struct {
+	models.Store
+	gate chan struct{}
+}
+
+func (s gatedStore) As(actor models.Actor) models.Store {
+	return gatedStore{s.Store.As(actor), s.gate}
+}
+
+func (s gatedStore) Update(fn func(models.Tx) error) error {
+	<-s.gate
+	return s.Store.Update(fn)
+}
+
+// TestSlowStart checks that a start in progress holds up neither the other
+// channels nor Stop, and that Close waits for it before stopping its channel
+func TestSlowStart(t *testing.T) {
+	store := gatedStore{models.NewMemoryStore(), make(chan struct{}, 1)}
+	s, channel := newTestSupervisor(t, store, "sleep 60")
+	slow, err := store.CreateChannel(models.Channel{Name: "BBC Two", Manifest: "https://example.com/two.mpd", KeyKid: "key:kid", State: models.ChannelStopped})
+	if err != nil {
+		t.Fatalf("CreateChannel: %v", err)
+	}
+	store.gate <- struct{}{}
+	if _, err := s.Start(channel.ID, models.Actor{}); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+
+	started := make(chan error, 1)
+	go func() {
+		_, err := s.Start(slow.ID, models.Actor{})
+		started <- err
+	}()
+	// Wait for the slow start to reserve its channel
+	for reserved := false; !reserved; time.Sleep(time.Millisecond) {
+		s.mutex.Lock()
+		_, reserved = s.processes[slow.ID]
+		s.mutex.Unlock()
+	}
+	var transition *models.TransitionError
+	if _, err := s.Start(slow.ID, models.Actor{}); !errors.As(err, &transition) || transition.From != models.ChannelStarting {
+		t.Errorf("starting a channel being started: %v, want a *models.TransitionError from starting", err)
+	}
+
+	stopped := make(chan error, 1)
+	go func() { stopped <- s.Stop(channel.ID, models.Actor{}) }()
+	select {
+	case err := <-stopped:
+		if err != nil {
+			t.Errorf("Stop: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Stop held up by the start of another channel")
+	}
+
+	closed := make(chan struct{})
+	go func() {
+		s.Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+		t.Fatalf("Close returned before the start in progress finished")
+	case <-time.After(50 * time.Millisecond):
+	}
+	store.gate <- struct{}{}
+	if err := <-started; err != nil {
+		t.Errorf("slow Start: %v", err)
+	}
+	<-closed
+	if got, _ := store.GetChannel(slow.ID); got.State != models.ChannelStopped {
+		t.Errorf("slowly started channel after Close: %s, want stopped", got.State)
+	}
+}
@@ -0,0 +1,118 @@
+package validation
+
+import (
+	"net/mail"
+	"slices"
+	"strings"
+
+	"fuzzy/models"
+)
+
+// Channel trims the fields of a channel about to be saved and checks them.
+// A channel with a profile keeps its empty encoding settings unset, to use
+// those of the profile. Without a profile, empty settings are taken from
+// existing, the stored channel being updated, or set to the defaults for a
+// new channel. Codecs and qualities are stored in their listed case.
+func Channel(channel *models.Channel, existing models.Channel) Errors {
+	var errs Errors
+	errs.required(&channel.Name, "name", "Channel name is required")
+	errs.required(&channel.Manifest, "manifest", "Channel manifest URL is required")
+	if channel.Manifest != "" {
+		if err := HTTPURL(channel.Manifest); err != nil {
+			errs.Add("manifest", "Channel manifest URL "+err.Error())
+		}
+	}
+	errs.required(&channel.KeyKid, "key_kid", "Channel Key:Kid is required")
+
+	if channel.ProfileID == 0 {
+		defaults(&channel.MediaSettings, existing.MediaSettings)
+	}
+	errs.settings(&channel.MediaSettings)
+	trim(&channel.Quality)
+	setting(&channel.Quality, existing.Quality, DefaultQuality)
+	choice(&errs, &channel.Quality, Qualities, "quality", "Quality")
+	return errs
+}
+
+// Profile trims the fields of an encoding profile about to be saved and
+// checks them. Empty encoding settings are taken from existing, the stored
+// profile being updated, or set to the defaults for a new profile, so every
+// channel using the profile has a complete set.
+func Profile(profile *models.EncodingProfile, existing models.EncodingProfile) Errors {
+	var errs Errors
+	errs.required(&profile.Name, "name", "Profile name is required")
+	profile.Description = strings.TrimSpace(profile.Description)
+
+	defaults(&profile.MediaSettings, existing.MediaSettings)
+	errs.settings(&profile.MediaSettings)
+	return errs
+}
+
+// Provider trims the fields of a provider about to be saved and checks them
+func Provider(provider *models.Provider) Errors {
+	var errs Errors
+	errs.required(&provider.Name, "name", "Provider name is required")
+	provider.Description = strings.TrimSpace(provider.Description)
+	provider.URL = strings.TrimSpace(provider.URL)
+	provider.APIKey = strings.TrimSpace(provider.APIKey)
+
+	if provider.URL != "" {
+		if err := HTTPURL(provider.URL); err != nil {
+			errs.Add("url", "Provider URL "+err.Error())
+		}
+	}
+	return errs
+}
+
+// Bouquet trims the fields of a bouquet about to be saved and checks them.
+// Its provider and channels are checked by the store.
+func Bouquet(bouquet *models.Bouquet) Errors {
+	var errs Errors
+	errs.required(&bouquet.Name, "name", "Bouquet name is required")
+	bouquet.Description = strings.TrimSpace(bouquet.Description)
+	return errs
+}
+
+// User trims the fields of a user about to be saved and checks them. An
+// empty role is set to viewer. Whether the username is taken and whether the
+// password is set are left to the caller.
+func User(user *models.User) Errors {
+	var errs Errors
+	errs.required(&user.Username, "username", "Username is required")
+	user.Email = strings.TrimSpace(user.Email)
+	user.Role = strings.TrimSpace(user.Role)
+
+	if user.Email != "" {
+		if address, err := mail.ParseAddress(user.Email); err != nil || address.Address != user.Email {
+			errs.Add("email", "Email address is invalid")
+		}
+	}
+	if user.Role == "" {
+		user.Role = models.RoleViewer // Default role
+	}
+	if !models.ValidRole(user.Role) {
+		errs.Add("role", "Invalid role")
+	}
+	return errs
+}
+
+// Webhook trims the fields of a webhook about to be saved and checks them.
+// Whether the secret is set is left to the caller.
+func Webhook(webhook *models.Webhook) Errors {
+	var errs Errors
+	errs.required(&webhook.Name, "name", "Webhook name is required")
+	errs.required(&webhook.URL, "url", "Webhook URL is required")
+	if webhook.URL != "" {
+		if err := HTTPURL(webhook.URL); err != nil {
+			errs.Add("url", "Webhook URL "+err.Error())
+		}
+	}
+	webhook.Secret = strings.TrimSpace(webhook.Secret)
+	for _, event := range webhook.Events {
+		if !slices.Contains(models.WebhookEvents, event) {
+			errs.Add("events", "Unknown webhook event: "+event)
+			break
+		}
+	}
+	return errs
+}
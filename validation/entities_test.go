@@ -0,0 +1,182 @@
+package validation
+
+import (
+	"slices"
+	"testing"
+
+	"fuzzy/models"
+)
+
+// fields returns the names of the invalid fields in errs, in order
+func fields(errs Errors) []string {
+	var names []string
+	for _, fieldErr := range errs {
+		names = append(names, fieldErr.Field)
+	}
+	return names
+}
+
+// validChannel returns a new channel that passes validation
+func validChannel() models.Channel {
+	return models.Channel{Name: "BBC One", Manifest: "https://example.com/one.mpd", KeyKid: "key:kid"}
+}
+
+// TestChannel checks the fields reported for channels with invalid values
+func TestChannel(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(*models.Channel)
+		want   []string
+	}{
+		{"valid", func(*models.Channel) {}, nil},
+		{"blank", func(c *models.Channel) { *c = models.Channel{Name: " ", KeyKid: "\t"} }, []string{"name", "manifest", "key_kid"}},
+		{"relative manifest", func(c *models.Channel) { c.Manifest = "/one.mpd" }, []string{"manifest"}},
+		{"ftp manifest", func(c *models.Channel) { c.Manifest = "ftp://example.com/one.mpd" }, []string{"manifest"}},
+		{"unknown codecs", func(c *models.Channel) { c.VideoCodec, c.AudioCodec = "mpeg2", "opus" }, []string{"video_codec", "audio_codec"}},
+		{"odd resolution", func(c *models.Channel) { c.Resolution = models.Resolution{Width: 1921, Height: 1080} }, []string{"resolution"}},
+		{"resolution too large", func(c *models.Channel) { c.Resolution = models.Resolution{Width: 15360, Height: 8640} }, []string{"resolution"}},
+		{"video bitrate too low", func(c *models.Channel) { c.VideoBitrate = 50 * models.Kbps }, []string{"video_bitrate"}},
+		{"video bitrate too high", func(c *models.Channel) { c.VideoBitrate = 200 * models.Mbps }, []string{"video_bitrate"}},
+		{"audio bitrate out of range", func(c *models.Channel) { c.AudioBitrate = 8 * models.Kbps }, []string{"audio_bitrate"}},
+		{"unknown quality", func(c *models.Channel) { c.Quality = "Best" }, []string{"quality"}},
+		{"unknown codec with a profile", func(c *models.Channel) { c.ProfileID, c.VideoCodec = 1, "mpeg2" }, []string{"video_codec"}},
+	}
+	for _, test := range tests {
+		channel := validChannel()
+		test.change(&channel)
+		errs := Channel(&channel, models.Channel{})
+		if got := fields(errs); !slices.Equal(got, test.want) {
+			t.Errorf("%s: fields %v, want %v", test.name, got, test.want)
+		}
+		for _, fieldErr := range errs {
+			if fieldErr.Message == "" {
+				t.Errorf("%s: no message for %s", test.name, fieldErr.Field)
+			}
+		}
+	}
+}
+
+// TestChannelDefaults checks the settings filled in for a new channel, an
+// updated channel and a channel using a profile
+func TestChannelDefaults(t *testing.T) {
+	defaultSettings := models.MediaSettings{
+		VideoCodec:   DefaultVideoCodec,
+		AudioCodec:   DefaultAudioCodec,
+		Resolution:   DefaultResolution,
+		VideoBitrate: DefaultVideoBitrate,
+		AudioBitrate: DefaultAudioBitrate,
+	}
+	stored := validChannel()
+	stored.MediaSettings = models.MediaSettings{
+		VideoCodec:   models.VideoCodecAV1,
+		AudioCodec:   models.AudioCodecMP3,
+		Resolution:   models.Resolution720p,
+		VideoBitrate: 2500 * models.Kbps,
+		AudioBitrate: 96 * models.Kbps,
+	}
+	stored.Quality = "High"
+
+	tests := []struct {
+		name     string
+		change   func(*models.Channel)
+		existing models.Channel
+		want     models.MediaSettings
+		quality  string
+	}{
+		{"new", func(*models.Channel) {}, models.Channel{}, defaultSettings, DefaultQuality},
+		{"updated", func(*models.Channel) {}, stored, stored.MediaSettings, "High"},
+		{"updated with a new codec", func(c *models.Channel) { c.VideoCodec = " vp9 " }, stored,
+			models.MediaSettings{VideoCodec: models.VideoCodecVP9, AudioCodec: models.AudioCodecMP3, Resolution: models.Resolution720p, VideoBitrate: 2500 * models.Kbps, AudioBitrate: 96 * models.Kbps}, "High"},
+		{"with a profile", func(c *models.Channel) { c.ProfileID = 1 }, models.Channel{}, models.MediaSettings{}, DefaultQuality},
+		{"with a profile and a codec", func(c *models.Channel) { c.ProfileID, c.AudioCodec = 1, "ac3" }, stored, models.MediaSettings{AudioCodec: models.AudioCodecAC3}, "High"},
+		{"quality in another case", func(c *models.Channel) { c.Quality = " ultra " }, models.Channel{}, defaultSettings, "Ultra"},
+	}
+	for _, test := range tests {
+		channel := validChannel()
+		test.change(&channel)
+		if errs := Channel(&channel, test.existing); len(errs) > 0 {
+			t.Errorf("%s: %v", test.name, errs)
+			continue
+		}
+		if channel.MediaSettings != test.want || channel.Quality != test.quality {
+			t.Errorf("%s: settings %+v, quality %q; want %+v, %q", test.name, channel.MediaSettings, channel.Quality, test.want, test.quality)
+		}
+	}
+
+	// A profile gets the same defaults as a new channel
+	profile := models.EncodingProfile{Name: "Standard"}
+	if errs := Profile(&profile, models.EncodingProfile{}); len(errs) > 0 || profile.MediaSettings != defaultSettings {
+		t.Errorf("new profile: settings %+v, %v; want %+v", profile.MediaSettings, errs, defaultSettings)
+	}
+}
+
+// TestProvider checks the fields reported for providers and the trimming of
+// the valid ones
+func TestProvider(t *testing.T) {
+	tests := []struct {
+		name     string
+		provider models.Provider
+		want     []string
+	}{
+		{"valid", models.Provider{Name: "BBC", URL: "https://bbc.co.uk"}, nil},
+		{"no URL", models.Provider{Name: "BBC"}, nil},
+		{"blank name", models.Provider{Name: "  "}, []string{"name"}},
+		{"relative URL", models.Provider{Name: "BBC", URL: "bbc.co.uk"}, []string{"url"}},
+		{"javascript URL", models.Provider{Name: "BBC", URL: "javascript:alert(1)"}, []string{"url"}},
+		{"blank name and bad URL", models.Provider{URL: "mailto:ops@bbc.co.uk"}, []string{"name", "url"}},
+	}
+	for _, test := range tests {
+		if got := fields(Provider(&test.provider)); !slices.Equal(got, test.want) {
+			t.Errorf("%s: fields %v, want %v", test.name, got, test.want)
+		}
+	}
+
+	provider := models.Provider{Name: " BBC ", Description: " UK ", URL: " https://bbc.co.uk ", APIKey: " key "}
+	if errs := Provider(&provider); len(errs) > 0 {
+		t.Fatalf("Provider: %v", errs)
+	}
+	if provider.Name != "BBC" || provider.Description != "UK" || provider.URL != "https://bbc.co.uk" || provider.APIKey != "key" {
+		t.Errorf("provider not trimmed: %+v", provider)
+	}
+}
+
+// TestBouquet checks the fields reported for bouquets
+func TestBouquet(t *testing.T) {
+	tests := []struct {
+		name    string
+		bouquet models.Bouquet
+		want    []string
+	}{
+		{"valid", models.Bouquet{Name: "Basics", ProviderID: 1}, nil},
+		{"blank name", models.Bouquet{Name: " \n"}, []string{"name"}},
+		// Left to the store
+		{"no provider", models.Bouquet{Name: "Basics"}, nil},
+	}
+	for _, test := range tests {
+		if got := fields(Bouquet(&test.bouquet)); !slices.Equal(got, test.want) {
+			t.Errorf("%s: fields %v, want %v", test.name, got, test.want)
+		}
+	}
+
+	bouquet := models.Bouquet{Name: " Basics ", Description: " News "}
+	if errs := Bouquet(&bouquet); len(errs) > 0 || bouquet.Name != "Basics" || bouquet.Description != "News" {
+		t.Errorf("bouquet: %+v, %v", bouquet, errs)
+	}
+}
+
+// TestErrorsMap checks that the first message of a field is kept
+func TestErrorsMap(t *testing.T) {
+	var errs Errors
+	if errs.Map() != nil {
+		t.Errorf("Map of no errors: %v, want nil", errs.Map())
+	}
+	errs.Add("name", "first")
+	errs.Add("url", "other")
+	errs.Add("name", "second")
+	if got := errs.Map(); len(got) != 2 || got["name"] != "first" || got["url"] != "other" {
+		t.Errorf("Map: %v", got)
+	}
+	if got := errs.Error(); got != "first; other; second" {
+		t.Errorf("Error: %q", got)
+	}
+}
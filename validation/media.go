@@ -0,0 +1,148 @@
+package validation
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"fuzzy/models"
+)
+
+// Qualities lists the allowed quality levels of a channel, in the case they
+// are stored in
+var Qualities = []string{"Low", "Medium", "High", "Ultra"}
+
+// Encoding settings of a new channel that leaves them empty
+const (
+	DefaultVideoCodec   = models.VideoCodecH264
+	DefaultAudioCodec   = models.AudioCodecAAC
+	DefaultVideoBitrate = 5000 * models.Kbps
+	DefaultAudioBitrate = 128 * models.Kbps
+	DefaultQuality      = "Medium"
+)
+
+// DefaultResolution is the resolution of a new channel that leaves it empty
+var DefaultResolution = models.Resolution1080p
+
+// Accepted bitrate ranges
+const (
+	MinVideoBitrate = 100 * models.Kbps
+	MaxVideoBitrate = 100 * models.Mbps
+	MinAudioBitrate = 16 * models.Kbps
+	MaxAudioBitrate = 1024 * models.Kbps
+)
+
+// MaxResolution is the largest accepted frame size
+var MaxResolution = models.Resolution{Width: 7680, Height: 4320}
+
+// Settings parses the resolution and bitrates of a channel or profile
+// entered as text, as in the forms. Empty values are left unset, for Channel
+// or Profile to fill in.
+func Settings(settings *models.MediaSettings, resolution, videoBitrate, audioBitrate string) Errors {
+	var errs Errors
+	var err error
+	if settings.Resolution, err = models.ParseResolution(resolution); err != nil {
+		errs.Add("resolution", "Resolution: "+err.Error())
+	}
+	if settings.VideoBitrate, err = models.ParseBitrate(videoBitrate); err != nil {
+		errs.Add("video_bitrate", "Video bitrate: "+err.Error())
+	}
+	if settings.AudioBitrate, err = models.ParseBitrate(audioBitrate); err != nil {
+		errs.Add("audio_bitrate", "Audio bitrate: "+err.Error())
+	}
+	return errs
+}
+
+// HTTPURL checks that value is an absolute http or https URL, as channel
+// manifests and provider URLs must be
+func HTTPURL(value string) error {
+	parsed, err := url.Parse(value)
+	if err != nil || parsed.Host == "" {
+		return errors.New("must be an absolute URL")
+	}
+	if parsed.Scheme != "http" && parsed.Scheme != "https" {
+		return errors.New("must be an http or https URL")
+	}
+	return nil
+}
+
+// defaults fills in the encoding settings left unset, from current or else
+// from the defaults
+func defaults(settings *models.MediaSettings, current models.MediaSettings) {
+	trim(&settings.VideoCodec)
+	trim(&settings.AudioCodec)
+	setting(&settings.VideoCodec, current.VideoCodec, DefaultVideoCodec)
+	setting(&settings.AudioCodec, current.AudioCodec, DefaultAudioCodec)
+	setting(&settings.Resolution, current.Resolution, DefaultResolution)
+	setting(&settings.VideoBitrate, current.VideoBitrate, DefaultVideoBitrate)
+	setting(&settings.AudioBitrate, current.AudioBitrate, DefaultAudioBitrate)
+}
+
+// settings checks the encoding settings that are set
+func (e *Errors) settings(settings *models.MediaSettings) {
+	trim(&settings.VideoCodec)
+	trim(&settings.AudioCodec)
+	if settings.VideoCodec != "" {
+		choice(e, &settings.VideoCodec, models.VideoCodecs, "video_codec", "Video codec")
+	}
+	if settings.AudioCodec != "" {
+		choice(e, &settings.AudioCodec, models.AudioCodecs, "audio_codec", "Audio codec")
+	}
+	if !settings.Resolution.IsZero() {
+		e.resolution(settings.Resolution)
+	}
+	if settings.VideoBitrate != 0 {
+		e.bitrate(settings.VideoBitrate, MinVideoBitrate, MaxVideoBitrate, "video_bitrate", "Video bitrate")
+	}
+	if settings.AudioBitrate != 0 {
+		e.bitrate(settings.AudioBitrate, MinAudioBitrate, MaxAudioBitrate, "audio_bitrate", "Audio bitrate")
+	}
+}
+
+// setting fills in an encoding setting left unset, from current (the stored
+// value of an updated channel) or else from fallback
+func setting[T comparable](value *T, current, fallback T) {
+	var unset T
+	if *value == unset {
+		*value = current
+	}
+	if *value == unset {
+		*value = fallback
+	}
+}
+
+// trim removes the spaces around a text setting
+func trim[T ~string](value *T) {
+	*value = T(strings.TrimSpace(string(*value)))
+}
+
+// choice checks that value is one of allowed and stores it in canonical case
+func choice[T ~string](e *Errors, value *T, allowed []T, field, label string) {
+	names := make([]string, len(allowed))
+	for i, candidate := range allowed {
+		if strings.EqualFold(string(*value), string(candidate)) {
+			*value = candidate
+			return
+		}
+		names[i] = string(candidate)
+	}
+	e.Add(field, fmt.Sprintf("%s must be one of %s", label, strings.Join(names, ", ")))
+}
+
+// bitrate checks that value is within range
+func (e *Errors) bitrate(value, minimum, maximum models.Bitrate, field, label string) {
+	if value < minimum || value > maximum {
+		e.Add(field, fmt.Sprintf("%s must be between %s and %s", label, minimum, maximum))
+	}
+}
+
+// resolution checks that value is an even frame size no larger than
+// MaxResolution, as encoders require
+func (e *Errors) resolution(value models.Resolution) {
+	if value.Width > MaxResolution.Width || value.Height > MaxResolution.Height {
+		e.Add("resolution", fmt.Sprintf("Resolution must be at most %s", MaxResolution))
+	} else if value.Width%2 != 0 || value.Height%2 != 0 {
+		e.Add("resolution", "Resolution width and height must be even")
+	}
+}
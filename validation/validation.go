@@ -0,0 +1,54 @@
+// Package validation checks entities before they are saved, so the HTML
+// forms and the JSON API apply the same rules, defaults and messages.
+package validation
+
+import (
+	"strings"
+)
+
+// FieldError describes why the value of a field is invalid
+type FieldError struct {
+	Field   string `json:"field"` // JSON name of the field, which is also its form field name
+	Message string `json:"message"`
+}
+
+// Errors lists the invalid fields of an entity. An empty list means the
+// entity is valid.
+type Errors []FieldError
+
+// Add records that field is invalid
+func (e *Errors) Add(field, message string) {
+	*e = append(*e, FieldError{Field: field, Message: message})
+}
+
+// Error joins the messages of every invalid field
+func (e Errors) Error() string {
+	messages := make([]string, len(e))
+	for i, fieldErr := range e {
+		messages[i] = fieldErr.Message
+	}
+	return strings.Join(messages, "; ")
+}
+
+// Map returns the message of each invalid field by field name, keeping the
+// first message of a field reported twice
+func (e Errors) Map() map[string]string {
+	if len(e) == 0 {
+		return nil
+	}
+	fields := make(map[string]string, len(e))
+	for _, fieldErr := range e {
+		if _, exists := fields[fieldErr.Field]; !exists {
+			fields[fieldErr.Field] = fieldErr.Message
+		}
+	}
+	return fields
+}
+
+// required trims a text field and reports it when it is empty
+func (e *Errors) required(value *string, field, message string) {
+	*value = strings.TrimSpace(*value)
+	if *value == "" {
+		e.Add(field, message)
+	}
+}
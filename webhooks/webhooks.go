@@ -0,0 +1,292 @@
+// Package webhooks delivers the channel and catalog events of the store to the
+// configured webhooks, as JSON payloads signed with HMAC-SHA256. A failed
+// delivery is retried with a delay that doubles after each attempt, and every
+// attempt is recorded in the delivery log of the webhook.
+package webhooks
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"fuzzy/models"
+)
+
+// Headers sent with every delivery
+const (
+	HeaderEvent     = "X-Fuzzy-Event"         // Name of the event, e.g. "channel.failed"
+	HeaderDelivery  = "X-Fuzzy-Delivery"      // ID of the delivery, the same for each attempt
+	HeaderSignature = "X-Fuzzy-Signature-256" // "sha256=" and the HMAC-SHA256 of the body
+)
+
+// eventBuffer is how many store events the dispatcher may fall behind by
+const eventBuffer = 256
+
+// responseLimit is how much of a response body is read before it is dropped
+const responseLimit = 64 << 10
+
+// Options configures a Dispatcher
+type Options struct {
+	// Timeout bounds each attempt, from connecting to reading the response
+	Timeout time.Duration
+	// MaxAttempts is how many times an event is sent before giving up
+	MaxAttempts int
+	// RetryDelay is the delay before the second attempt, doubled before each
+	// of the following ones
+	RetryDelay time.Duration
+}
+
+// Payload is the JSON body posted to webhooks
+type Payload struct {
+	ID       string            `json:"id"`    // Same as the X-Fuzzy-Delivery header
+	Event    string            `json:"event"` // Same as the X-Fuzzy-Event header
+	Time     time.Time         `json:"time"`  // When the change was made
+	Actor    models.Actor      `json:"actor"`
+	Entity   models.EntityKind `json:"entity,omitempty"`
+	EntityID int               `json:"entity_id,omitempty"`
+	Before   any               `json:"before,omitempty"` // The entity before the change, as the API returns it less its credentials
+	After    any               `json:"after,omitempty"`  // The entity after the change, likewise
+}
+
+// Dispatcher delivers the events of a store to its webhooks
+type Dispatcher struct {
+	store   models.Store
+	options Options
+	client  *http.Client
+	after   func(time.Duration) <-chan time.Time // Waits between attempts; time.After but in tests
+
+	stopping  chan struct{} // Closed by Close
+	closeOnce sync.Once
+	wait      sync.WaitGroup // Listener and deliveries in progress
+}
+
+// New creates a dispatcher delivering the events of store from now on
+func New(store models.Store, options Options) *Dispatcher {
+	d := &Dispatcher{
+		store:   store,
+		options: options,
+		client: &http.Client{
+			Timeout: options.Timeout,
+			// A redirect is reported as a failure rather than followed, since
+			// following it would turn the POST into a GET
+			CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+		},
+		after:    time.After,
+		stopping: make(chan struct{}),
+	}
+	events, unsubscribe := store.Subscribe(eventBuffer)
+	d.wait.Add(1)
+	go d.listen(events, unsubscribe)
+	return d
+}
+
+// Close stops listening to the store. Events already published get one
+// attempt, waited for, but are not retried.
+func (d *Dispatcher) Close() {
+	d.closeOnce.Do(func() { close(d.stopping) })
+	d.wait.Wait()
+}
+
+// SendTest sends a test event to the webhook once, whatever its event filter
+// and even when it is disabled, and returns the attempt, also recorded in its
+// delivery log
+func (d *Dispatcher) SendTest(webhookID int, actor models.Actor) (models.WebhookDelivery, error) {
+	webhook, exists := d.store.GetWebhook(webhookID)
+	if !exists {
+		return models.WebhookDelivery{}, models.ErrNotFound
+	}
+	payload := Payload{ID: newDeliveryID(), Event: models.WebhookTestEvent, Time: time.Now(), Actor: actor}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return models.WebhookDelivery{}, err
+	}
+	return d.attempt(webhook, payload, body, 1), nil
+}
+
+// listen starts the deliveries of each event until the dispatcher is closed
+func (d *Dispatcher) listen(events <-chan models.Event, unsubscribe func()) {
+	defer d.wait.Done()
+	for {
+		select {
+		case <-d.stopping:
+			unsubscribe()
+			for event := range events {
+				d.dispatch(event)
+			}
+			return
+		case event, open := <-events:
+			if !open {
+				log.Printf("Webhooks fell behind the changes to the store; some events were not delivered")
+				events, unsubscribe = d.store.Subscribe(eventBuffer)
+				continue
+			}
+			d.dispatch(event)
+		}
+	}
+}
+
+// dispatch starts delivering event to each webhook wanting it
+func (d *Dispatcher) dispatch(event models.Event) {
+	name := models.WebhookEventName(event)
+	if name == "" {
+		return
+	}
+	for _, webhook := range d.store.GetAllWebhooks() {
+		if !webhook.Wants(name) {
+			continue
+		}
+		payload := Payload{
+			ID:       newDeliveryID(),
+			Event:    name,
+			Time:     event.Time,
+			Actor:    event.Actor,
+			Entity:   event.Entity,
+			EntityID: event.ID,
+			Before:   redact(event.Before),
+			After:    redact(event.After),
+		}
+		d.wait.Add(1)
+		go d.deliver(webhook.ID, payload)
+	}
+}
+
+// redact returns a copy of entity without the credentials it holds: the API
+// key of a provider and the Key:Kid of a channel, including the channels of a
+// bouquet. Webhooks are outside the panel, and only need to know what changed.
+func redact(entity any) any {
+	switch entity := entity.(type) {
+	case models.Provider:
+		entity.APIKey = ""
+		return entity
+	case models.Channel:
+		entity.KeyKid = ""
+		return entity
+	case models.Bouquet:
+		entity.Channels = slices.Clone(entity.Channels)
+		for i := range entity.Channels {
+			entity.Channels[i].KeyKid = ""
+		}
+		return entity
+	}
+	return entity
+}
+
+// deliver sends payload to the webhook until it is accepted, retrying with a
+// growing delay up to the configured number of attempts
+func (d *Dispatcher) deliver(webhookID int, payload Payload) {
+	defer d.wait.Done()
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		log.Printf("Error encoding %s event for webhook %d: %v", payload.Event, webhookID, err)
+		return
+	}
+	delay := d.options.RetryDelay
+	for attempt := 1; ; attempt++ {
+		// Read the webhook again before each attempt, to use its current URL
+		// and secret and to give up once it is deleted or disabled
+		webhook, exists := d.store.GetWebhook(webhookID)
+		if !exists || !webhook.Active {
+			return
+		}
+		delivery := d.attempt(webhook, payload, body, attempt)
+		if delivery.Delivered() {
+			return
+		}
+		if attempt >= d.options.MaxAttempts {
+			log.Printf("Giving up delivering %s to webhook %d after %d attempts: %s", payload.Event, webhookID, attempt, delivery.Error)
+			return
+		}
+
+		select {
+		case <-d.stopping:
+			return
+		case <-d.after(delay):
+		}
+		delay *= 2
+	}
+}
+
+// attempt posts body to the webhook once and records the outcome in its
+// delivery log
+func (d *Dispatcher) attempt(webhook models.Webhook, payload Payload, body []byte, attempt int) models.WebhookDelivery {
+	delivery := models.WebhookDelivery{
+		WebhookID:  webhook.ID,
+		DeliveryID: payload.ID,
+		Event:      payload.Event,
+		Attempt:    attempt,
+		CreatedAt:  time.Now(),
+	}
+	delivery.StatusCode, delivery.Error = d.post(webhook, payload, body)
+	delivery.Duration = time.Since(delivery.CreatedAt)
+
+	if err := d.store.AddWebhookDelivery(delivery); err != nil {
+		log.Printf("Error recording delivery to webhook %d: %v", webhook.ID, err)
+	}
+	return delivery
+}
+
+// post sends the request and returns the status code of the response, 0 when
+// there is none, and an error message unless it is a 2xx
+func (d *Dispatcher) post(webhook models.Webhook, payload Payload, body []byte) (int, string) {
+	req, err := http.NewRequest(http.MethodPost, webhook.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err.Error()
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "Fuzzy-Webhooks")
+	req.Header.Set(HeaderEvent, payload.Event)
+	req.Header.Set(HeaderDelivery, payload.ID)
+	req.Header.Set(HeaderSignature, Signature(webhook.Secret, body))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, err.Error()
+	}
+	defer resp.Body.Close()
+	// Reading the body lets the connection be reused
+	io.Copy(io.Discard, io.LimitReader(resp.Body, responseLimit))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Sprintf("unexpected response %s", resp.Status)
+	}
+	return resp.StatusCode, ""
+}
+
+// Signature returns the value of the X-Fuzzy-Signature-256 header for body
+// signed with secret. Receivers compute it again over the body they got and
+// compare the two with hmac.Equal.
+func Signature(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// NewSecret generates a random webhook secret
+func NewSecret() (string, error) {
+	raw := make([]byte, 24)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(raw), nil
+}
+
+// newDeliveryID generates the ID of a delivery
+func newDeliveryID() string {
+	raw := make([]byte, 16)
+	if _, err := rand.Read(raw); err != nil {
+		// Only used to tell deliveries apart
+		return fmt.Sprintf("%x", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(raw)
+}
@@ -0,0 +1,348 @@
+package webhooks
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"fuzzy/models"
+)
+
+const testSecret = "receiver-secret"
+
+// receivedRequest is a request that reached a receiver
+type receivedRequest struct {
+	Path      string
+	Event     string
+	Delivery  string
+	Signature string
+	Body      []byte
+}
+
+// receiver is a local webhook endpoint recording what it receives. It answers
+// with the status codes of responses in turn, then 200.
+type receiver struct {
+	*httptest.Server
+	mutex     sync.Mutex
+	requests  []receivedRequest
+	responses []int
+}
+
+func newReceiver(t *testing.T, responses ...int) *receiver {
+	t.Helper()
+	r := &receiver{responses: responses}
+	r.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, _ := io.ReadAll(req.Body)
+		r.mutex.Lock()
+		defer r.mutex.Unlock()
+		r.requests = append(r.requests, receivedRequest{
+			Path:      req.URL.Path,
+			Event:     req.Header.Get(HeaderEvent),
+			Delivery:  req.Header.Get(HeaderDelivery),
+			Signature: req.Header.Get(HeaderSignature),
+			Body:      body,
+		})
+		if req.URL.Path == "/moved" {
+			http.Redirect(w, req, "/elsewhere", http.StatusFound)
+			return
+		}
+		status := http.StatusOK
+		if len(r.responses) > 0 {
+			status, r.responses = r.responses[0], r.responses[1:]
+		}
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(r.Close)
+	return r
+}
+
+// received returns the requests received so far
+func (r *receiver) received() []receivedRequest {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return append([]receivedRequest(nil), r.requests...)
+}
+
+// newTestDispatcher returns a dispatcher whose waits between attempts end at
+// once, recording the delays asked for in delays
+func newTestDispatcher(t *testing.T, store models.Store, maxAttempts int, delays *[]time.Duration) *Dispatcher {
+	t.Helper()
+	d := New(store, Options{Timeout: 5 * time.Second, MaxAttempts: maxAttempts, RetryDelay: time.Second})
+	var mutex sync.Mutex
+	d.after = func(delay time.Duration) <-chan time.Time {
+		mutex.Lock()
+		defer mutex.Unlock()
+		if delays != nil {
+			*delays = append(*delays, delay)
+		}
+		fired := make(chan time.Time, 1)
+		fired <- time.Now()
+		return fired
+	}
+	t.Cleanup(d.Close)
+	return d
+}
+
+func createWebhook(t *testing.T, store models.Store, webhook models.Webhook) models.Webhook {
+	t.Helper()
+	if webhook.Secret == "" {
+		webhook.Secret = testSecret
+	}
+	created, err := store.CreateWebhook(webhook)
+	if err != nil {
+		t.Fatalf("creating webhook %q: %v", webhook.Name, err)
+	}
+	return created
+}
+
+// waitForDeliveries waits until the delivery log of the webhook holds count
+// attempts, and returns it
+func waitForDeliveries(t *testing.T, store models.Store, webhookID, count int) []models.WebhookDelivery {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		deliveries := store.GetWebhookDeliveries(webhookID)
+		if len(deliveries) >= count {
+			return deliveries
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("webhook %d: %d deliveries, want %d", webhookID, len(deliveries), count)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// checkSignature checks the signature header of a request against the body,
+// computed independently of Signature
+func checkSignature(t *testing.T, req receivedRequest, secret string) {
+	t.Helper()
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(req.Body)
+	want := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+	if !hmac.Equal([]byte(req.Signature), []byte(want)) {
+		t.Errorf("%s: signature %q, want %q", req.Event, req.Signature, want)
+	}
+}
+
+// TestDeliverySignedAndFiltered checks that each webhook gets the events of
+// its filter, signed with its secret, and nothing when it is disabled
+func TestDeliverySignedAndFiltered(t *testing.T) {
+	store := models.NewMemoryStore()
+	target := newReceiver(t)
+	hook := createWebhook(t, store, models.Webhook{Name: "filtered", URL: target.URL, Events: []string{"provider.created", "channel.created"}, Active: true})
+	other := newReceiver(t)
+	createWebhook(t, store, models.Webhook{Name: "other events", URL: other.URL, Events: []string{"profile.deleted"}, Active: true})
+	createWebhook(t, store, models.Webhook{Name: "disabled", URL: other.URL, Active: false})
+	d := newTestDispatcher(t, store, 1, nil)
+
+	admin := models.Actor{UserID: 1, Username: "admin"}
+	as := store.As(admin)
+	provider, err := as.CreateProvider(models.Provider{Name: "BBC", APIKey: "provider-api-key"})
+	if err != nil {
+		t.Fatalf("creating provider: %v", err)
+	}
+	if _, err := as.CreateProfile(models.EncodingProfile{Name: "HD"}); err != nil {
+		t.Fatalf("creating profile: %v", err)
+	}
+	if _, err := as.CreateChannel(models.Channel{Name: "BBC One", Manifest: "https://example.com/m.mpd", KeyKid: "channel-key:kid"}); err != nil {
+		t.Fatalf("creating channel: %v", err)
+	}
+	waitForDeliveries(t, store, hook.ID, 2)
+	d.Close()
+
+	requests := make(map[string]receivedRequest)
+	for _, req := range target.received() {
+		requests[req.Event] = req
+	}
+	if len(requests) != 2 {
+		t.Fatalf("events %v, want provider.created and channel.created", requests)
+	}
+	for _, req := range requests {
+		checkSignature(t, req, testSecret)
+		var payload Payload
+		if err := json.Unmarshal(req.Body, &payload); err != nil {
+			t.Fatalf("decoding payload: %v", err)
+		}
+		if payload.Event != req.Event || payload.ID != req.Delivery || payload.Actor != admin {
+			t.Errorf("payload %+v does not match headers %s %s", payload, req.Event, req.Delivery)
+		}
+		for _, credential := range []string{"provider-api-key", "channel-key"} {
+			if strings.Contains(string(req.Body), credential) {
+				t.Errorf("%s payload contains %q: %s", req.Event, credential, req.Body)
+			}
+		}
+	}
+
+	var providerEvent struct {
+		EntityID int `json:"entity_id"`
+		After    struct {
+			Name   string `json:"name"`
+			APIKey string `json:"api_key"`
+		} `json:"after"`
+	}
+	if err := json.Unmarshal(requests["provider.created"].Body, &providerEvent); err != nil {
+		t.Fatalf("decoding provider payload: %v", err)
+	}
+	if providerEvent.EntityID != provider.ID || providerEvent.After.Name != "BBC" || providerEvent.After.APIKey != "" {
+		t.Errorf("provider payload %+v", providerEvent)
+	}
+	if _, received := requests["channel.created"]; !received {
+		t.Errorf("channel.created not received")
+	}
+
+	if got := other.received(); len(got) != 0 {
+		t.Errorf("webhooks not wanting the events received %d requests", len(got))
+	}
+}
+
+// TestDeliveryRetries checks that a failed delivery is attempted again with a
+// doubling delay, as the same delivery, until it succeeds or runs out of
+// attempts
+func TestDeliveryRetries(t *testing.T) {
+	tests := []struct {
+		name        string
+		responses   []int
+		maxAttempts int
+		want        []int // Status codes of the attempts
+		wantDelays  []time.Duration
+	}{
+		{"succeeds on third attempt", []int{503, 500}, 5, []int{503, 500, 200}, []time.Duration{time.Second, 2 * time.Second}},
+		{"gives up", []int{500, 500, 500, 500, 500}, 4, []int{500, 500, 500, 500}, []time.Duration{time.Second, 2 * time.Second, 4 * time.Second}},
+		{"first attempt", nil, 3, []int{200}, nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			store := models.NewMemoryStore()
+			target := newReceiver(t, test.responses...)
+			hook := createWebhook(t, store, models.Webhook{Name: "retried", URL: target.URL, Active: true})
+			var delays []time.Duration
+			d := newTestDispatcher(t, store, test.maxAttempts, &delays)
+
+			if _, err := store.CreateProvider(models.Provider{Name: "BBC"}); err != nil {
+				t.Fatalf("creating provider: %v", err)
+			}
+			waitForDeliveries(t, store, hook.ID, len(test.want))
+			d.Close()
+
+			deliveries := store.GetWebhookDeliveries(hook.ID)
+			if len(deliveries) != len(test.want) {
+				t.Fatalf("%d attempts, want %d", len(deliveries), len(test.want))
+			}
+			for i, status := range test.want {
+				delivery := deliveries[len(deliveries)-1-i] // Newest first
+				if delivery.Attempt != i+1 || delivery.StatusCode != status || delivery.Delivered() != (status == 200) {
+					t.Errorf("attempt %d: %+v, want status %d", i+1, delivery, status)
+				}
+				if delivery.DeliveryID != deliveries[0].DeliveryID {
+					t.Errorf("attempt %d has delivery ID %s, want %s", i+1, delivery.DeliveryID, deliveries[0].DeliveryID)
+				}
+			}
+			if len(delays) != len(test.wantDelays) {
+				t.Fatalf("delays %v, want %v", delays, test.wantDelays)
+			}
+			for i := range delays {
+				if delays[i] != test.wantDelays[i] {
+					t.Errorf("delays %v, want %v", delays, test.wantDelays)
+				}
+			}
+			for _, req := range target.received() {
+				checkSignature(t, req, testSecret)
+			}
+		})
+	}
+}
+
+// TestRedirectNotFollowed checks that a redirect fails the attempt instead of
+// being followed
+func TestRedirectNotFollowed(t *testing.T) {
+	store := models.NewMemoryStore()
+	target := newReceiver(t)
+	hook := createWebhook(t, store, models.Webhook{Name: "moved", URL: target.URL + "/moved", Active: true})
+	d := newTestDispatcher(t, store, 1, nil)
+
+	delivery, err := d.SendTest(hook.ID, models.Actor{})
+	if err != nil {
+		t.Fatalf("SendTest: %v", err)
+	}
+	if delivery.Delivered() || delivery.StatusCode != http.StatusFound {
+		t.Errorf("delivery %+v, want a failed attempt with status 302", delivery)
+	}
+	for _, req := range target.received() {
+		if req.Path != "/moved" {
+			t.Errorf("redirect to %s followed", req.Path)
+		}
+	}
+}
+
+// TestDeliveryLogTrimmed checks that only the last WebhookDeliveryLimit
+// attempts are kept
+func TestDeliveryLogTrimmed(t *testing.T) {
+	store := models.NewMemoryStore()
+	target := newReceiver(t)
+	hook := createWebhook(t, store, models.Webhook{Name: "busy", URL: target.URL, Active: true})
+	d := newTestDispatcher(t, store, 1, nil)
+
+	var last models.WebhookDelivery
+	for range models.WebhookDeliveryLimit + 5 {
+		delivery, err := d.SendTest(hook.ID, models.Actor{})
+		if err != nil {
+			t.Fatalf("SendTest: %v", err)
+		}
+		last = delivery
+	}
+
+	deliveries := store.GetWebhookDeliveries(hook.ID)
+	if len(deliveries) != models.WebhookDeliveryLimit {
+		t.Fatalf("%d deliveries kept, want %d", len(deliveries), models.WebhookDeliveryLimit)
+	}
+	if deliveries[0].DeliveryID != last.DeliveryID {
+		t.Errorf("newest delivery %s, want %s", deliveries[0].DeliveryID, last.DeliveryID)
+	}
+}
+
+// TestSendTest checks that the test event reaches a webhook whatever its
+// filter and active flag, and is recorded in its delivery log
+func TestSendTest(t *testing.T) {
+	store := models.NewMemoryStore()
+	target := newReceiver(t)
+	hook := createWebhook(t, store, models.Webhook{Name: "quiet", URL: target.URL, Events: []string{"channel.failed"}, Active: false})
+	d := newTestDispatcher(t, store, 3, nil)
+
+	admin := models.Actor{UserID: 1, Username: "admin"}
+	delivery, err := d.SendTest(hook.ID, admin)
+	if err != nil {
+		t.Fatalf("SendTest: %v", err)
+	}
+	if !delivery.Delivered() || delivery.Event != models.WebhookTestEvent || delivery.StatusCode != http.StatusOK || delivery.Attempt != 1 {
+		t.Errorf("delivery %+v", delivery)
+	}
+
+	requests := target.received()
+	if len(requests) != 1 {
+		t.Fatalf("%d requests, want 1", len(requests))
+	}
+	checkSignature(t, requests[0], testSecret)
+	var payload Payload
+	if err := json.Unmarshal(requests[0].Body, &payload); err != nil {
+		t.Fatalf("decoding payload: %v", err)
+	}
+	if payload.Event != models.WebhookTestEvent || payload.Actor != admin || payload.ID != delivery.DeliveryID {
+		t.Errorf("payload %+v", payload)
+	}
+	if logged := store.GetWebhookDeliveries(hook.ID); len(logged) != 1 || logged[0].DeliveryID != delivery.DeliveryID {
+		t.Errorf("delivery log %+v", logged)
+	}
+
+	if _, err := d.SendTest(hook.ID+1, admin); !errors.Is(err, models.ErrNotFound) {
+		t.Errorf("SendTest to a missing webhook: %v, want ErrNotFound", err)
+	}
+}